@@ -1,11 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/BinLe1988/multi-agent-chatter/configs"
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/lockout"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/notify"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
 	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +24,65 @@ import (
 	"gorm.io/gorm"
 )
 
+// 登录防护，默认使用内存计数存储
+var loginGuard = newLoginGuard(lockout.NewMemoryStore(), lockout.DefaultPolicy())
+
+// dummyPasswordHash 账号不存在时用于比对的哈希，与真实账号一样耗时，避免通过响应时间判断账号是否存在
+var dummyPasswordHash = []byte("$2a$10$QXTAHHHjbyBxTqEQMGMMsuh3Qa6Q7x.yaVM853CtF6KdCoKckQFrG")
+
+// InitLoginGuard 根据配置初始化登录防护
+func InitLoginGuard(cfg *configs.Config, store lockout.AttemptStore) {
+	login := cfg.Security.Login
+	loginGuard = newLoginGuard(store, lockout.Policy{
+		AccountMaxAttempts: login.MaxAttempts,
+		IPMaxAttempts:      login.IPMaxAttempts,
+		FreeAttempts:       login.FreeAttempts,
+		BackoffBase:        login.BackoffBase,
+		LockoutDuration:    login.LockoutDuration,
+		MaxLockout:         login.MaxLockout,
+		Window:             login.Window,
+	})
+}
+
+func newLoginGuard(store lockout.AttemptStore, policy lockout.Policy) *lockout.Guard {
+	guard := lockout.NewGuard(store, policy)
+	guard.OnLockout(recordLockout)
+	return guard
+}
+
+// recordLockout 记录锁定审计并通知账号所有者
+func recordLockout(ctx context.Context, event lockout.LockoutEvent) {
+	audit := models.LockoutAudit{
+		Scope:       string(event.Scope),
+		Account:     event.Account,
+		IP:          event.IP,
+		Lockouts:    event.Lockouts,
+		LockedUntil: event.LockedUntil,
+	}
+
+	var user models.User
+	if event.Account != "" && database.DB.Where("email = ?", event.Account).First(&user).Error == nil {
+		audit.UserID = &user.ID
+	}
+
+	if err := database.DB.Create(&audit).Error; err != nil {
+		log.Printf("Failed to record lockout audit for %s: %v", event.Account, err)
+	}
+
+	// 仅账号维度的锁定通知用户，IP维度的锁定可能由他人触发
+	if audit.UserID != nil && event.Scope == lockout.ScopeAccount {
+		notify.Send(ctx, user.ID, notify.Notification{
+			Type:  notify.TypeAccountLocked,
+			Title: "账号已被临时锁定",
+			Body:  fmt.Sprintf("由于多次登录失败，您的账号已被锁定至 %s。如非本人操作，请尽快修改密码。", event.LockedUntil.Format("2006-01-02 15:04:05")),
+			Data: map[string]interface{}{
+				"ip":          event.IP,
+				"lockedUntil": event.LockedUntil,
+			},
+		})
+	}
+}
+
 // Login 用户登录
 func Login(c *gin.Context) {
 	var req models.CredentialRequest
@@ -21,28 +91,64 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+
+	// 检查账号和IP是否处于退避或锁定状态，并占用一次尝试
+	decision, err := loginGuard.Attempt(ctx, req.Email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if !decision.Allowed {
+		retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      "Too many failed login attempts, please try again later",
+			"retryAfter": retryAfter,
+		})
+		return
+	}
+
 	var user models.User
 	result := database.DB.Where("email = ?", req.Email).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
-			return
-		}
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// 验证密码
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-	if err != nil {
+	// 账号不存在与密码错误计入同一计数，返回相同错误，避免暴露账号是否存在
+	hash := []byte(user.Password)
+	known := result.Error == nil && len(hash) > 0
+	if !known {
+		hash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || !known {
+		if _, err := loginGuard.Fail(ctx, req.Email, ip); err != nil {
+			log.Printf("Failed to record login failure for %s: %v", req.Email, err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
+	if err := loginGuard.Succeed(ctx, req.Email, ip); err != nil {
+		log.Printf("Failed to reset login attempts for %s: %v", req.Email, err)
+	}
+
+	if user.IsBanned(time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       "Account is banned",
+			"bannedUntil": user.BannedUntil,
+		})
+		return
+	}
+
 	// 更新最后登录时间
 	now := time.Now()
+	if err := database.DB.Model(&user).Update("last_login", now).Error; err != nil {
+		log.Printf("Failed to update last login for user %d: %v", user.ID, err)
+	}
 	user.LastLogin = &now
-	database.DB.Save(&user)
 
 	// 生成JWT令牌
 	token, err := utils.GenerateToken(user.ID)
@@ -90,7 +196,21 @@ func Register(c *gin.Context) {
 		JoinDate: time.Now(),
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := credits.RecordOpening(tx, &user, models.CreditSignupBonus); err != nil {
+			return err
+		}
+		_, err := referrals.Register(tx, &user, req.ReferralCode, c.ClientIP(), req.DeviceID)
+		return err
+	})
+	if errors.Is(err, referrals.ErrInvalidCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral code"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -111,14 +231,26 @@ func Logout(c *gin.Context) {
 
 // GetCurrentUser 获取当前用户信息
 func GetCurrentUser(c *gin.Context) {
-	user, exists := c.Get("user")
+	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
-	userObj := user.(models.User)
+	// 重新读取以返回最新的积分和订阅状态
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"user": (&userObj).ToResponse(),
+		"user": user.ToResponse(),
 	})
 }
+
+// GetJWKS 公开用于验证令牌的公钥，供内部服务校验令牌
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.JWKS())
+}
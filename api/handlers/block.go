@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlockUser 屏蔽用户，屏蔽后双方不能互发消息，也不会再被匹配，好友关系同时解除
+func BlockUser(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.BlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 陌生人只能通过会话屏蔽，列表中显示其化名
+	targetID := req.UserID
+	label := ""
+	if req.SessionID != 0 {
+		session, peer, err := loadPeerSession(userID.(uint), req.SessionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found or unauthorized"})
+			return
+		}
+		targetID = peer.UserID
+		if session.Type == models.SessionStranger {
+			meta, _ := session.ParseStrangerMeta()
+			label = meta.PeerAlias.Name
+			// 屏蔽陌生人时同时结束当前会话，会话已结束时忽略
+			endStrangerSession(userID.(uint), session.ID)
+		}
+	}
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userId or sessionId is required"})
+		return
+	}
+	if targetID == userID.(uint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot block yourself"})
+		return
+	}
+
+	var target models.User
+	if err := database.DB.First(&target, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if label == "" {
+		label = target.Username
+	}
+
+	block := models.UserBlock{
+		BlockerID: userID.(uint),
+		BlockedID: targetID,
+		Label:     label,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+			return err
+		}
+		return removeFriendship(tx, userID.(uint), targetID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已屏蔽该用户",
+	})
+}
+
+// GetBlockedUsers 获取屏蔽列表
+func GetBlockedUsers(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var blocks []models.UserBlock
+	if err := database.DB.Where("blocker_id = ?", userID).Order("created_at DESC").Find(&blocks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blocked users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+	})
+}
+
+// UnblockUser 取消屏蔽
+func UnblockUser(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	blockID, err := strconv.ParseUint(c.Param("blockId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block ID"})
+		return
+	}
+
+	// 唯一索引不区分软删除，因此直接硬删除
+	result := database.DB.Unscoped().Where("id = ? AND blocker_id = ?", blockID, userID).Delete(&models.UserBlock{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已取消屏蔽",
+	})
+}
+
+// ReportUser 举报会话中的对方，举报进入审核队列
+func ReportUser(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.CreateReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, peer, err := loadPeerSession(userID.(uint), req.SessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found or unauthorized"})
+		return
+	}
+
+	// 证据消息必须是对方在该会话中发送的消息
+	evidence := uniqueIDs(req.MessageIDs)
+	if len(evidence) > 0 {
+		var count int64
+		database.DB.Model(&models.ChatMessage{}).
+			Where("id IN ? AND session_id = ? AND user_id = ?", evidence, req.SessionID, peer.UserID).
+			Count(&count)
+		if int(count) != len(evidence) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Evidence messages must be messages sent by the reported user in this session"})
+			return
+		}
+	}
+
+	// 同一会话只保留一个待处理的举报
+	var open int64
+	database.DB.Model(&models.Report{}).
+		Where("reporter_id = ? AND session_id = ? AND status = ?", userID, req.SessionID, models.ReportOpen).
+		Count(&open)
+	if open > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reported this chat"})
+		return
+	}
+
+	report := models.Report{
+		ReporterID:         userID.(uint),
+		ReportedUserID:     peer.UserID,
+		SessionID:          req.SessionID,
+		Category:           req.Category,
+		Description:        req.Description,
+		EvidenceMessageIDs: evidence,
+		Status:             models.ReportOpen,
+	}
+	if err := database.DB.Create(&report).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit report"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "举报已提交，我们会尽快处理",
+		"reportId": report.ID,
+	})
+}
+
+// loadPeerSession 加载用户自己的会话及对方一侧的会话
+func loadPeerSession(userID, sessionID uint) (*models.ChatSession, *models.ChatSession, error) {
+	var session models.ChatSession
+	if err := database.DB.Where("id = ? AND user_id = ? AND peer_session_id <> 0", sessionID, userID).First(&session).Error; err != nil {
+		return nil, nil, err
+	}
+	// 对方可能已删除会话，仍需能够屏蔽和举报
+	var peer models.ChatSession
+	if err := database.DB.Unscoped().First(&peer, session.PeerSessionID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &session, &peer, nil
+}
+
+// isBlocked 判断两个用户之间是否存在任一方向的屏蔽
+func isBlocked(db *gorm.DB, userID, otherID uint) bool {
+	var count int64
+	db.Model(&models.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID, otherID, otherID, userID).
+		Count(&count)
+	return count > 0
+}
+
+// uniqueIDs 去除重复的ID，保留原有顺序
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminListPlans 获取订阅计划的全部版本，包括未生效和已下架的版本
+func AdminListPlans(c *gin.Context) {
+	var plans []models.SubscriptionPlan
+	if err := database.DB.Order("type, version DESC").Find(&plans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch plans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"plans": plans,
+	})
+}
+
+// AdminCreatePlan 新增订阅计划版本，调整价格时使用
+func AdminCreatePlan(c *gin.Context) {
+	var req models.PlanVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := catalog.CreatePlan(database.DB, req)
+	if err != nil {
+		respondCatalogError(c, err, "Plan version not found")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"plan": plan,
+	})
+}
+
+// AdminUpdatePlan 修改订阅计划版本，已生效或已售出的版本只能修改展示信息和结束时间
+func AdminUpdatePlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("planId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	var req models.PlanVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := catalog.UpdatePlan(database.DB, uint(id), req)
+	if err != nil {
+		respondCatalogError(c, err, "Plan version not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"plan": plan,
+	})
+}
+
+// AdminDeletePlan 删除未售出的订阅计划版本
+func AdminDeletePlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("planId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	if err := catalog.DeletePlan(database.DB, uint(id)); err != nil {
+		respondCatalogError(c, err, "Plan version not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan version deleted",
+	})
+}
+
+// AdminListPackages 获取充值套餐的全部版本
+func AdminListPackages(c *gin.Context) {
+	var packages []models.RechargePackage
+	if err := database.DB.Order("code, version DESC").Find(&packages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch packages"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"packages": packages,
+	})
+}
+
+// AdminCreatePackage 新增充值套餐版本
+func AdminCreatePackage(c *gin.Context) {
+	var req models.PackageVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pkg, err := catalog.CreatePackage(database.DB, req)
+	if err != nil {
+		respondCatalogError(c, err, "Package version not found")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"package": pkg,
+	})
+}
+
+// AdminUpdatePackage 修改充值套餐版本
+func AdminUpdatePackage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("packageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID"})
+		return
+	}
+
+	var req models.PackageVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pkg, err := catalog.UpdatePackage(database.DB, uint(id), req)
+	if err != nil {
+		respondCatalogError(c, err, "Package version not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"package": pkg,
+	})
+}
+
+// AdminDeletePackage 删除未售出的充值套餐版本
+func AdminDeletePackage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("packageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID"})
+		return
+	}
+
+	if err := catalog.DeletePackage(database.DB, uint(id)); err != nil {
+		respondCatalogError(c, err, "Package version not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Package version deleted",
+	})
+}
+
+// respondCatalogError 目录操作失败的应答
+func respondCatalogError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	case errors.Is(err, catalog.ErrVersionLocked), errors.Is(err, catalog.ErrVersionInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, catalog.ErrInvalidPeriod), errors.Is(err, money.ErrInvalidCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update catalog"})
+	}
+}
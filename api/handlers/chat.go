@@ -1,17 +1,61 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/BinLe1988/multi-agent-chatter/configs"
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
 	"github.com/BinLe1988/multi-agent-chatter/pkg/ai"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/entitlements"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/pricing"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// aiPricing AI消息价格表，未初始化时每条消息消耗1积分
+var aiPricing = pricing.NewTable(configs.PricingConfig{}, "")
+
+// InitPricing 初始化AI消息价格表
+func InitPricing(table *pricing.Table) {
+	aiPricing = table
+}
+
+var (
+	errNotStrangerSession = errors.New("not a stranger session")
+	errConversationEnded  = errors.New("conversation has ended")
+)
+
+// releaseReservation 未能生成回复时退回预扣的积分
+// 退回失败的预留由启动时的ReleaseStale兜底
+func releaseReservation(reservation *models.CreditReservation) {
+	if err := credits.Release(database.DB, reservation); err != nil {
+		log.Printf("Failed to release credit reservation %d: %v", reservation.ID, err)
+	}
+}
+
+// chatAllowed 检查当前用户能否与其他用户聊天，被暂停时返回403
+func chatAllowed(c *gin.Context) bool {
+	value, _ := c.Get("user")
+	user, _ := value.(models.User)
+	if user.CanChat(time.Now()) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":          "Your chat privileges are suspended",
+		"suspendedUntil": user.SuspendedUntil,
+	})
+	return false
+}
+
 // CreateChatSession 创建聊天会话
 func CreateChatSession(c *gin.Context) {
 	userID, _ := c.Get("userID")
@@ -22,6 +66,42 @@ func CreateChatSession(c *gin.Context) {
 		return
 	}
 
+	// 陌生人和好友会话只能通过匹配和好友请求创建
+	if session.Type == models.SessionStranger || session.Type == models.SessionDirect {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session type"})
+		return
+	}
+
+	// AI会话的模型和群聊的人数受订阅计划限制
+	plan := contextUser(c).SubType
+	switch session.Type {
+	case models.SessionAI:
+		meta, err := session.ParseAIMeta()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session meta"})
+			return
+		}
+		if _, err := aiModelFor(plan, meta.Model); err != nil {
+			respondUpgradeRequired(c, err)
+			return
+		}
+	case models.SessionGroup:
+		meta, err := session.ParseGroupMeta()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session meta"})
+			return
+		}
+		if meta.MaxMembers == 0 {
+			meta.MaxMembers = entitlements.Default().For(plan).MaxGroupSize
+		}
+		if err := entitlements.Default().CheckGroupSize(plan, meta.MaxMembers); err != nil {
+			respondUpgradeRequired(c, err)
+			return
+		}
+		data, _ := json.Marshal(meta)
+		session.Meta = string(data)
+	}
+
 	session.UserID = userID.(uint)
 	session.LastActive = time.Now()
 
@@ -46,6 +126,10 @@ func GetChatSessions(c *gin.Context) {
 		return
 	}
 
+	for i := range sessions {
+		sessions[i].RedactMeta()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"sessions": sessions,
 	})
@@ -84,6 +168,20 @@ func GetChatMessages(c *gin.Context) {
 	var count int64
 	database.DB.Model(&models.ChatMessage{}).Where("session_id = ?", sessionID).Count(&count)
 
+	// 陌生人会话只返回化名，不暴露对方的真实身份
+	if session.Type == models.SessionStranger {
+		meta, _ := session.ParseStrangerMeta()
+		responses := make([]models.ChatResponse, 0, len(messages))
+		for i := range messages {
+			responses = append(responses, messages[i].ToStrangerResponse(userID.(uint), meta))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"total":    count,
+			"messages": responses,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"total":    count,
 		"messages": messages,
@@ -93,7 +191,6 @@ func GetChatMessages(c *gin.Context) {
 // SendChatMessage 发送聊天消息
 func SendChatMessage(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	user, _ := c.Get("user")
 
 	var req models.ChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -108,19 +205,62 @@ func SendChatMessage(c *gin.Context) {
 		return
 	}
 
-	// 扣除积分（仅AI聊天）
+	// 已结束的会话只读
+	if session.EndedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This conversation has ended"})
+		return
+	}
+
+	// AI聊天按预估的最大消耗预检余额，余额不足时不调用上游
+	var aiMeta models.AIMeta
+	var aiModel string
+	var estimatedCost int
 	if session.Type == models.SessionAI {
-		// 检查积分是否足够
-		currentUser := user.(models.User)
-		if currentUser.Credits < 1 {
-			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient credits"})
+		// 降级后仍按新计划限制模型，语音消息需要转文字功能
+		plan := contextUser(c).SubType
+		if req.Type == models.MessageVoice {
+			if err := entitlements.Default().CheckFeature(plan, entitlements.FeatureVoiceTranscription); err != nil {
+				respondUpgradeRequired(c, err)
+				return
+			}
+		}
+		aiMeta, _ = session.ParseAIMeta()
+		model, err := aiModelFor(plan, aiMeta.Model)
+		if err != nil {
+			respondUpgradeRequired(c, err)
 			return
 		}
+		aiModel = model
+		estimatedCost = aiPricing.Estimate(aiModel, aiMeta.Agent,
+			ai.EstimatePromptTokens(req.Message), ai.MaxCompletionTokens())
 
-		// 扣除积分
-		currentUser.Credits--
-		if err := database.DB.Save(&currentUser).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credits"})
+		var currentUser models.User
+		if err := database.DB.Select("id", "credits").First(&currentUser, userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+			return
+		}
+		if currentUser.Credits < estimatedCost {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient credits", "estimatedCost": estimatedCost})
+			return
+		}
+	}
+
+	// 陌生人和好友会话需要对方会话仍然存在，好友会话还要求仍是好友
+	var peer models.ChatSession
+	if session.Type == models.SessionStranger || session.Type == models.SessionDirect {
+		if !chatAllowed(c) {
+			return
+		}
+		if session.PeerSessionID == 0 || database.DB.First(&peer, session.PeerSessionID).Error != nil {
+			c.JSON(http.StatusGone, gin.H{"error": "The other user has left this chat"})
+			return
+		}
+		if isBlocked(database.DB, userID.(uint), peer.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot message this user"})
+			return
+		}
+		if session.Type == models.SessionDirect && !areFriends(database.DB, userID.(uint), peer.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are no longer friends with this user"})
 			return
 		}
 	}
@@ -134,7 +274,44 @@ func SendChatMessage(c *gin.Context) {
 		Content:   req.Message,
 	}
 
-	if err := database.DB.Create(&userMessage).Error; err != nil {
+	var reservation *models.CreditReservation
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&userMessage).Error; err != nil {
+			return err
+		}
+
+		// AI聊天预扣积分，与消息一起提交，生成回复后再结算
+		if session.Type == models.SessionAI {
+			var err error
+			reservation, err = credits.Reserve(tx, credits.Entry{
+				UserID:  userID.(uint),
+				Amount:  estimatedCost,
+				Reason:  models.CreditChatUsage,
+				RefType: models.CreditRefMessage,
+				RefID:   userMessage.ID,
+			})
+			return err
+		}
+		if peer.ID == 0 {
+			return nil
+		}
+
+		// 同步写入对方会话并更新双方的最后活动时间
+		peerMessage := userMessage
+		peerMessage.ID = 0
+		peerMessage.SessionID = peer.ID
+		if err := tx.Create(&peerMessage).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.ChatSession{}).
+			Where("id IN ?", []uint{session.ID, peer.ID}).
+			Update("last_active", time.Now()).Error
+	})
+	if errors.Is(err, credits.ErrInsufficientCredits) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient credits", "estimatedCost": estimatedCost})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send chat message"})
 		return
 	}
@@ -146,25 +323,43 @@ func SendChatMessage(c *gin.Context) {
 		database.DB.Save(&session)
 
 		// 调用AI服务获取回复
-		aiResponse, err := ai.GenerateResponse(req.Message, session.Meta)
+		reply, err := ai.GenerateResponse(req.Message, ai.Options{Model: aiModel})
 		if err != nil {
+			releaseReservation(reservation)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate AI response"})
 			return
 		}
 
-		// 保存AI回复
+		// 按实际用量结算，消耗记录在回复的元数据中
+		usage := models.AIUsage{
+			Model:            reply.Model,
+			Agent:            aiMeta.Agent,
+			PromptTokens:     reply.Usage.PromptTokens,
+			CompletionTokens: reply.Usage.CompletionTokens,
+			EstimatedCost:    estimatedCost,
+		}
 		aiMessage := models.ChatMessage{
 			SessionID: req.SessionID,
 			UserID:    0, // AI消息没有用户ID
 			SenderID:  "ai",
 			Type:      models.MessageText,
-			Content:   aiResponse,
+			Content:   reply.Content,
 		}
-
-		if err := database.DB.Create(&aiMessage).Error; err != nil {
+		// 回复已生成，先结算再保存；结算失败时保留预扣的积分，不能退回
+		cost := aiPricing.Cost(aiModel, aiMeta.Agent, usage.PromptTokens, usage.CompletionTokens)
+		if err := credits.Commit(database.DB, reservation, cost); err != nil {
+			log.Printf("Failed to commit credit reservation %d: %v", reservation.ID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save AI response"})
 			return
 		}
+		usage.Cost = reservation.Charged
+		if metadata, err := json.Marshal(usage); err == nil {
+			aiMessage.Metadata = string(metadata)
+		}
+		// 积分已结算，保存失败时仍返回回复
+		if err := database.DB.Create(&aiMessage).Error; err != nil {
+			log.Printf("Failed to save AI response for session %d: %v", req.SessionID, err)
+		}
 
 		// 返回AI回复和用户消息
 		c.JSON(http.StatusOK, gin.H{
@@ -174,11 +369,19 @@ func SendChatMessage(c *gin.Context) {
 		return
 	}
 
-	// 如果是陌生人匹配聊天，通知对方
-	if session.Type == models.SessionStranger {
+	// 如果是陌生人或好友聊天，通知对方
+	if peer.ID != 0 {
 		// 这里应该通过WebSocket通知对方有新消息
 		// TODO: 实现WebSocket通知逻辑
 
+		if session.Type == models.SessionStranger {
+			meta, _ := session.ParseStrangerMeta()
+			c.JSON(http.StatusOK, gin.H{
+				"message": userMessage.ToStrangerResponse(userID.(uint), meta),
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": userMessage,
 		})
@@ -190,3 +393,94 @@ func SendChatMessage(c *gin.Context) {
 		"message": userMessage,
 	})
 }
+
+// EndConversation 结束陌生人会话，结束后双方都只能查看历史消息
+func EndConversation(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := endStrangerSession(userID.(uint), uint(sessionID))
+	if !respondEndError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "聊天已结束",
+		"sessionId": session.ID,
+		"endedAt":   session.EndedAt,
+	})
+}
+
+// endStrangerSession 同时结束双方的陌生人会话，并在双方会话中添加系统消息
+// 会话已结束时返回会话和errConversationEnded
+func endStrangerSession(userID, sessionID uint) (*models.ChatSession, error) {
+	var session models.ChatSession
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+			return err
+		}
+		if session.Type != models.SessionStranger {
+			return errNotStrangerSession
+		}
+
+		// 按ID顺序锁定双方会话，避免双方同时结束时重复处理或死锁
+		ids := []uint{session.ID}
+		if session.PeerSessionID != 0 {
+			ids = append(ids, session.PeerSessionID)
+		}
+		var locked []models.ChatSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", ids).Order("id").Find(&locked).Error; err != nil {
+			return err
+		}
+		for _, s := range locked {
+			if s.ID == session.ID {
+				session = s
+			}
+		}
+		if session.EndedAt != nil {
+			return errConversationEnded
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.ChatSession{}).
+			Where("id IN ? AND ended_at IS NULL", ids).
+			Updates(map[string]interface{}{"ended_at": &now, "last_active": now}).Error; err != nil {
+			return err
+		}
+		session.EndedAt = &now
+
+		if err := addSystemMessage(tx, "您已结束本次聊天", session.ID); err != nil {
+			return err
+		}
+		if session.PeerSessionID != 0 {
+			return addSystemMessage(tx, "对方已结束聊天", session.PeerSessionID)
+		}
+		return nil
+	})
+	if err != nil {
+		return &session, err
+	}
+	return &session, nil
+}
+
+// respondEndError 根据结束会话的错误返回响应，没有错误时返回true
+func respondEndError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found or unauthorized"})
+	case errors.Is(err, errNotStrangerSession):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only stranger chats can be ended"})
+	case errors.Is(err, errConversationEnded):
+		c.JSON(http.StatusConflict, gin.H{"error": "This conversation has already ended"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end conversation"})
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conversationRouter 注册会话相关的路由，当前用户取自X-User-ID请求头并从数据库加载
+func conversationRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		id, _ := strconv.ParseUint(c.GetHeader("X-User-ID"), 10, 32)
+		var user models.User
+		database.DB.First(&user, id)
+		c.Set("userID", uint(id))
+		c.Set("user", user)
+	})
+	router.POST("/chat/messages", SendChatMessage)
+	router.POST("/chat/sessions/:sessionId/end", EndConversation)
+	router.POST("/matching/skip/:sessionId", SkipConversation)
+	return router
+}
+
+func TestEndAndSkipStrangerConversation(t *testing.T) {
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	queue := waitingUsers
+	waitingUsers = &matchQueue{waiting: make(map[uint]*models.MatchingRequest)}
+	t.Cleanup(func() { waitingUsers = queue })
+
+	users := []models.User{
+		{Username: "erin", Email: "erin@example.com"},
+		{Username: "frank", Email: "frank@example.com"},
+	}
+	require.NoError(t, db.Create(&users).Error)
+	erin, frank := users[0].ID, users[1].ID
+
+	erinReq := &models.MatchingRequest{Interests: []string{"music"}}
+	frankReq := &models.MatchingRequest{Interests: []string{"hiking"}, Gender: "female"}
+	erinSession, frankSession, err := createStrangerSessions(erin, erinReq, frank, frankReq)
+	require.NoError(t, err)
+	router := conversationRouter()
+
+	w := serveAs(router, erin, fmt.Sprintf("/chat/sessions/%d/end", erinSession.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveAs(router, frank, fmt.Sprintf("/chat/sessions/%d/end", frankSession.ID), nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "the partner's side ends too")
+
+	// 双方各收到一条系统消息
+	for _, s := range []struct {
+		id      uint
+		content string
+	}{{erinSession.ID, "您已结束本次聊天"}, {frankSession.ID, "对方已结束聊天"}} {
+		var session models.ChatSession
+		require.NoError(t, db.First(&session, s.id).Error)
+		assert.NotNil(t, session.EndedAt)
+
+		var last models.ChatMessage
+		require.NoError(t, db.Where("session_id = ?", s.id).Order("id DESC").First(&last).Error)
+		assert.Equal(t, "system", last.SenderID)
+		assert.Equal(t, s.content, last.Content)
+	}
+
+	// 结束后的会话只读
+	w = serveAs(router, frank, "/chat/messages", models.ChatRequest{SessionID: frankSession.ID, Message: "还在吗？"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 对方已结束时同样可以跳过，并以原匹配条件重新排队
+	w = serveAs(router, frank, fmt.Sprintf("/matching/skip/%d", frankSession.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"matched":false`)
+	require.True(t, waitingUsers.contains(frank))
+	assert.Equal(t, frankReq, waitingUsers.waiting[frank])
+
+	// 刚结束的一对用户处于冷却期，不会再次配对
+	w = serveAs(router, erin, fmt.Sprintf("/matching/skip/%d", erinSession.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"matched":false`)
+	assert.True(t, waitingUsers.contains(erin))
+	assert.True(t, waitingUsers.contains(frank))
+	assert.Equal(t, erinReq, waitingUsers.waiting[erin])
+
+	// 被暂停的用户不能跳过
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", erin).Update("banned_at", db.NowFunc()).Error)
+	w = serveAs(router, erin, fmt.Sprintf("/matching/skip/%d", erinSession.ID), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
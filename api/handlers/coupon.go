@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/coupons"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminListCoupons 获取全部优惠券
+func AdminListCoupons(c *gin.Context) {
+	var list []models.Coupon
+	if err := database.DB.Order("created_at DESC").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"coupons": list,
+	})
+}
+
+// AdminCreateCoupon 新增优惠券
+func AdminCreateCoupon(c *gin.Context) {
+	var req models.CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	coupon, err := coupons.Create(database.DB, req)
+	if err != nil {
+		respondCouponAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"coupon": coupon,
+	})
+}
+
+// AdminUpdateCoupon 修改优惠券，已核销的优惠券只能调整有效期、次数和适用范围
+func AdminUpdateCoupon(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("couponId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	var req models.CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	coupon, err := coupons.Update(database.DB, uint(id), req)
+	if err != nil {
+		respondCouponAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"coupon": coupon,
+	})
+}
+
+// AdminDeleteCoupon 删除未核销的优惠券
+func AdminDeleteCoupon(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("couponId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	if err := coupons.Delete(database.DB, uint(id)); err != nil {
+		respondCouponAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Coupon deleted",
+	})
+}
+
+// AdminGetCouponRedemptions 获取优惠券的核销记录
+func AdminGetCouponRedemptions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("couponId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	var redemptions []models.CouponRedemption
+	if err := database.DB.Where("coupon_id = ?", id).Order("created_at DESC").Find(&redemptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch redemptions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"redemptions": redemptions,
+	})
+}
+
+// respondCouponError 下单时优惠券不可用的应答，不是优惠券错误时返回false
+func respondCouponError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, coupons.ErrNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon code"})
+	case errors.Is(err, coupons.ErrInactive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon is not active"})
+	case errors.Is(err, coupons.ErrExhausted), errors.Is(err, coupons.ErrUserLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon usage limit reached"})
+	case errors.Is(err, coupons.ErrNotApplicable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon does not apply to this order"})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondCouponAdminError 优惠券管理操作失败的应答
+func respondCouponAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+	case errors.Is(err, coupons.ErrDuplicateCode), errors.Is(err, coupons.ErrInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, coupons.ErrInvalidValue), errors.Is(err, coupons.ErrInvalidPeriod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetCreditHistory 获取积分流水，可按变动原因筛选
+func GetCreditHistory(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := database.DB.Model(&models.CreditTransaction{}).Where("user_id = ?", userID)
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+
+	var count int64
+	query.Count(&count)
+
+	var records []models.CreditTransaction
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&records).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load credit history"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Select("id", "credits").First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+
+	transactions := make([]models.CreditTransactionResponse, len(records))
+	for i := range records {
+		transactions[i] = records[i].ToResponse()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":      user.Credits,
+		"total":        count,
+		"page":         page,
+		"pageSize":     pageSize,
+		"transactions": transactions,
+	})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/entitlements"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetEntitlements 获取当前订阅计划的功能权限和今日用量
+func GetEntitlements(c *gin.Context) {
+	user := contextUser(c)
+	now := time.Now()
+
+	usage := make(map[entitlements.Feature]int)
+	for _, feature := range []entitlements.Feature{entitlements.FeatureDailyMessages, entitlements.FeatureDailyMatches} {
+		used, err := entitlements.DailyUsage(database.DB, user.ID, feature, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		usage[feature] = used
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan":         user.SubType,
+		"entitlements": entitlements.Default().For(user.SubType),
+		"usage":        usage,
+	})
+}
+
+// contextUser 认证中间件写入上下文的用户
+func contextUser(c *gin.Context) models.User {
+	value, _ := c.Get("user")
+	user, _ := value.(models.User)
+	return user
+}
+
+// aiModelFor 解析AI会话使用的模型并检查订阅计划
+// 未指定模型或模型不在价格表中时，若默认模型不在计划内则改用计划允许的第一个模型
+func aiModelFor(plan models.SubscriptionType, requested string) (string, error) {
+	table := entitlements.Default()
+	model := aiPricing.Model(requested)
+	if table.For(plan).AllowsModel(model) {
+		return model, nil
+	}
+	if !strings.EqualFold(model, requested) {
+		for _, m := range table.For(plan).Models {
+			if strings.EqualFold(aiPricing.Model(m), m) {
+				return m, nil
+			}
+		}
+	}
+	return "", table.CheckModel(plan, model)
+}
+
+// respondUpgradeRequired 订阅计划不满足时返回403及能解除限制的计划，其他错误返回500
+func respondUpgradeRequired(c *gin.Context, err error) {
+	var upgrade *entitlements.UpgradeError
+	if errors.As(err, &upgrade) {
+		c.JSON(http.StatusForbidden, upgrade.Response())
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check plan limits"})
+}
@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/notify"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/presence"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errFriendRequestHandled = errors.New("friend request already handled")
+
+// SendFriendRequest 在陌生人会话中向对方发起好友请求
+// 如果对方已向自己发起请求，则直接成为好友
+func SendFriendRequest(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	var session models.ChatSession
+	if err := database.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat session not found or unauthorized"})
+		return
+	}
+	if session.Type != models.SessionStranger || session.PeerSessionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Friend requests can only be sent from a stranger chat"})
+		return
+	}
+
+	var peer models.ChatSession
+	if err := database.DB.First(&peer, session.PeerSessionID).Error; err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "The other user has left this chat"})
+		return
+	}
+
+	if areFriends(database.DB, userID.(uint), peer.UserID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already friends"})
+		return
+	}
+
+	// 对方已发起请求，视为双方同意
+	var incoming models.FriendRequest
+	database.DB.Where("from_user_id = ? AND to_user_id = ? AND status = ?", peer.UserID, userID, models.FriendRequestPending).
+		Order("id DESC").Limit(1).Find(&incoming)
+	if incoming.ID != 0 {
+		friendship, err := acceptFriendRequest(incoming.ID, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept friend request"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "你们已成为好友",
+			"friend":    true,
+			"sessionId": friendship.SessionID,
+		})
+		return
+	}
+
+	// 同一会话中只能发起一次请求，避免被拒绝后反复骚扰
+	var existing int64
+	database.DB.Model(&models.FriendRequest{}).
+		Where("from_user_id = ? AND session_id = ?", userID, session.ID).
+		Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Friend request already sent"})
+		return
+	}
+
+	request := models.FriendRequest{
+		FromUserID:  userID.(uint),
+		ToUserID:    peer.UserID,
+		SessionID:   session.ID,
+		ToSessionID: peer.ID,
+		Status:      models.FriendRequestPending,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&request).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ChatMessage{
+			SessionID: peer.ID,
+			SenderID:  "system",
+			Type:      models.MessageText,
+			Content:   "对方希望添加您为好友",
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send friend request"})
+		return
+	}
+
+	notify.Send(c.Request.Context(), peer.UserID, notify.Notification{
+		Type:  notify.TypeFriendRequest,
+		Title: "新的好友请求",
+		Body:  "正在与您聊天的陌生人希望添加您为好友",
+		Data: map[string]interface{}{
+			"requestId": request.ID,
+			"sessionId": peer.ID,
+		},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "好友请求已发送",
+		"friend":    false,
+		"requestId": request.ID,
+	})
+}
+
+// GetFriendRequests 获取收到和发出的好友请求
+func GetFriendRequests(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	query := database.DB.Where("from_user_id = ? OR to_user_id = ?", userID, userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var requests []models.FriendRequest
+	if err := query.Order("created_at DESC").Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch friend requests"})
+		return
+	}
+
+	responses := make([]models.FriendRequestResponse, 0, len(requests))
+	for _, r := range requests {
+		resp := models.FriendRequestResponse{
+			ID:          r.ID,
+			Direction:   "outgoing",
+			SessionID:   r.SessionID,
+			Status:      r.Status,
+			CreatedAt:   r.CreatedAt,
+			RespondedAt: r.RespondedAt,
+		}
+		if r.ToUserID == userID.(uint) {
+			resp.Direction = "incoming"
+			resp.SessionID = r.ToSessionID
+		}
+		responses = append(responses, resp)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requests": responses,
+	})
+}
+
+// AcceptFriendRequest 接受好友请求，陌生人会话转为长期的好友会话
+func AcceptFriendRequest(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	requestID, err := strconv.ParseUint(c.Param("requestId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	friendship, err := acceptFriendRequest(uint(requestID), userID.(uint))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Friend request not found"})
+		return
+	}
+	if errors.Is(err, errFriendRequestHandled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Friend request already handled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept friend request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "你们已成为好友",
+		"sessionId": friendship.SessionID,
+	})
+}
+
+// DeclineFriendRequest 拒绝好友请求
+func DeclineFriendRequest(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	requestID, err := strconv.ParseUint(c.Param("requestId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	now := time.Now()
+	result := database.DB.Model(&models.FriendRequest{}).
+		Where("id = ? AND to_user_id = ? AND status = ?", requestID, userID, models.FriendRequestPending).
+		Updates(map[string]interface{}{
+			"status":       models.FriendRequestDeclined,
+			"responded_at": &now,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decline friend request"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Friend request not found or already handled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已拒绝好友请求",
+	})
+}
+
+// GetFriends 获取好友列表及在线状态
+func GetFriends(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var rows []struct {
+		FriendID  uint
+		Username  string
+		SessionID uint
+		CreatedAt time.Time
+	}
+	if err := database.DB.Table("friendships").
+		Select("friendships.friend_id, users.username, friendships.session_id, friendships.created_at").
+		Joins("JOIN users ON users.id = friendships.friend_id AND users.deleted_at IS NULL").
+		Where("friendships.user_id = ? AND friendships.deleted_at IS NULL", userID).
+		Order("users.username").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch friends"})
+		return
+	}
+
+	tracker := presence.Default()
+	friends := make([]models.FriendResponse, 0, len(rows))
+	online := 0
+	for _, row := range rows {
+		friend := models.FriendResponse{
+			UserID:    row.FriendID,
+			Username:  row.Username,
+			SessionID: row.SessionID,
+			Online:    tracker.IsOnline(row.FriendID),
+			Since:     row.CreatedAt,
+		}
+		if seen, ok := tracker.LastSeen(row.FriendID); ok {
+			friend.LastSeen = &seen
+		}
+		if friend.Online {
+			online++
+		}
+		friends = append(friends, friend)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"friends": friends,
+		"total":   len(friends),
+		"online":  online,
+	})
+}
+
+// RemoveFriend 删除好友，好友会话保留为只读的历史记录
+func RemoveFriend(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	friendID, err := strconv.ParseUint(c.Param("friendId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend ID"})
+		return
+	}
+
+	if !areFriends(database.DB, userID.(uint), uint(friendID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Friend not found"})
+		return
+	}
+
+	if err := removeFriendship(database.DB, userID.(uint), uint(friendID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove friend"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已删除好友",
+	})
+}
+
+// acceptFriendRequest 接受好友请求：建立双向好友关系，并将双方会话转为好友会话
+// 返回接受方一侧的好友关系
+func acceptFriendRequest(requestID, userID uint) (*models.Friendship, error) {
+	var request models.FriendRequest
+	var friendship models.Friendship
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND to_user_id = ?", requestID, userID).
+			First(&request).Error; err != nil {
+			return err
+		}
+		if request.Status != models.FriendRequestPending {
+			return errFriendRequestHandled
+		}
+
+		now := time.Now()
+		if err := tx.Model(&request).Updates(map[string]interface{}{
+			"status":       models.FriendRequestAccepted,
+			"responded_at": &now,
+		}).Error; err != nil {
+			return err
+		}
+
+		fromSessionID, toSessionID, err := convertToDirectSessions(tx, &request)
+		if err != nil {
+			return err
+		}
+
+		// 双方各一行，已存在的关系（如重复请求）直接覆盖会话
+		pair := []models.Friendship{
+			{UserID: request.FromUserID, FriendID: request.ToUserID, SessionID: fromSessionID},
+			{UserID: request.ToUserID, FriendID: request.FromUserID, SessionID: toSessionID},
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "friend_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"session_id", "updated_at"}),
+		}).Create(&pair).Error; err != nil {
+			return err
+		}
+		friendship = pair[1]
+
+		// 同一对用户之间其他待处理的请求一并完成
+		return tx.Model(&models.FriendRequest{}).
+			Where("status = ? AND ((from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?))",
+				models.FriendRequestPending, request.FromUserID, request.ToUserID, request.ToUserID, request.FromUserID).
+			Updates(map[string]interface{}{
+				"status":       models.FriendRequestAccepted,
+				"responded_at": &now,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notify.Send(context.Background(), request.FromUserID, notify.Notification{
+		Type:  notify.TypeFriendRequestAccepted,
+		Title: "好友请求已通过",
+		Body:  "对方接受了您的好友请求，现在可以随时聊天了",
+		Data: map[string]interface{}{
+			"requestId": request.ID,
+			"sessionId": request.SessionID,
+		},
+	})
+
+	return &friendship, nil
+}
+
+// convertToDirectSessions 将请求双方的陌生人会话转为好友会话
+// 如果原会话已不存在，则为双方新建一对好友会话
+func convertToDirectSessions(tx *gorm.DB, request *models.FriendRequest) (uint, uint, error) {
+	var users []models.User
+	if err := tx.Where("id IN ?", []uint{request.FromUserID, request.ToUserID}).Find(&users).Error; err != nil {
+		return 0, 0, err
+	}
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+
+	var sessions []models.ChatSession
+	if err := tx.Where("id IN ?", []uint{request.SessionID, request.ToSessionID}).Find(&sessions).Error; err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	if len(sessions) == 2 {
+		// 会话标题为对方的用户名
+		for _, s := range sessions {
+			peerID := request.ToUserID
+			if s.UserID == request.ToUserID {
+				peerID = request.FromUserID
+			}
+			if err := tx.Model(&s).Updates(map[string]interface{}{
+				"type":        models.SessionDirect,
+				"title":       names[peerID],
+				"last_active": now,
+				"ended_at":    nil, // 好友会话不会结束
+			}).Error; err != nil {
+				return 0, 0, err
+			}
+		}
+		if err := addSystemMessage(tx, "你们已成为好友，可以随时在这里继续聊天", request.SessionID, request.ToSessionID); err != nil {
+			return 0, 0, err
+		}
+		return request.SessionID, request.ToSessionID, nil
+	}
+
+	fromSession := models.ChatSession{
+		UserID:     request.FromUserID,
+		Type:       models.SessionDirect,
+		Title:      names[request.ToUserID],
+		LastActive: now,
+	}
+	toSession := models.ChatSession{
+		UserID:     request.ToUserID,
+		Type:       models.SessionDirect,
+		Title:      names[request.FromUserID],
+		LastActive: now,
+	}
+	if err := tx.Create(&fromSession).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Create(&toSession).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Model(&fromSession).Update("peer_session_id", toSession.ID).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Model(&toSession).Update("peer_session_id", fromSession.ID).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := addSystemMessage(tx, "你们已成为好友，开始聊天吧！", fromSession.ID, toSession.ID); err != nil {
+		return 0, 0, err
+	}
+	return fromSession.ID, toSession.ID, nil
+}
+
+// addSystemMessage 向多个会话添加同一条系统消息
+func addSystemMessage(tx *gorm.DB, content string, sessionIDs ...uint) error {
+	messages := make([]models.ChatMessage, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		messages = append(messages, models.ChatMessage{
+			SessionID: id,
+			SenderID:  "system",
+			Type:      models.MessageText,
+			Content:   content,
+		})
+	}
+	return tx.Create(&messages).Error
+}
+
+// areFriends 判断两个用户是否为好友
+func areFriends(db *gorm.DB, userID, otherID uint) bool {
+	var count int64
+	db.Model(&models.Friendship{}).Where("user_id = ? AND friend_id = ?", userID, otherID).Count(&count)
+	return count > 0
+}
+
+// removeFriendship 解除两个用户之间的好友关系，并关闭待处理的好友请求
+// 唯一索引不区分软删除，因此直接硬删除
+func removeFriendship(db *gorm.DB, userID, otherID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().
+			Where("(user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)", userID, otherID, otherID, userID).
+			Delete(&models.Friendship{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		return tx.Model(&models.FriendRequest{}).
+			Where("status = ? AND ((from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?))",
+				models.FriendRequestPending, userID, otherID, otherID, userID).
+			Updates(map[string]interface{}{
+				"status":       models.FriendRequestDeclined,
+				"responded_at": &now,
+			}).Error
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// friendRouter 注册好友和屏蔽相关的路由，当前用户取自X-User-ID请求头
+func friendRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		id, _ := strconv.ParseUint(c.GetHeader("X-User-ID"), 10, 32)
+		c.Set("userID", uint(id))
+	})
+	router.POST("/chat/sessions/:sessionId/friend-request", SendFriendRequest)
+	router.POST("/friends/requests/:requestId/accept", AcceptFriendRequest)
+	router.POST("/blocks", BlockUser)
+	return router
+}
+
+func serveAs(router *gin.Engine, userID uint, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", strconv.FormatUint(uint64(userID), 10))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestFriendRequestConvertsSessionAndBlockRemovesFriendship(t *testing.T) {
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	users := []models.User{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob", Email: "bob@example.com"},
+	}
+	require.NoError(t, db.Create(&users).Error)
+	alice, bob := users[0].ID, users[1].ID
+
+	aliceSession, bobSession, err := createStrangerSessions(alice, &models.MatchingRequest{}, bob, &models.MatchingRequest{})
+	require.NoError(t, err)
+	router := friendRouter()
+
+	w := serveAs(router, alice, fmt.Sprintf("/chat/sessions/%d/friend-request", aliceSession.ID), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var sent struct {
+		RequestID uint `json:"requestId"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sent))
+
+	// 同一会话不能重复发起
+	w = serveAs(router, alice, fmt.Sprintf("/chat/sessions/%d/friend-request", aliceSession.ID), nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 只有收到请求的一方可以接受
+	w = serveAs(router, alice, fmt.Sprintf("/friends/requests/%d/accept", sent.RequestID), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveAs(router, bob, fmt.Sprintf("/friends/requests/%d/accept", sent.RequestID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveAs(router, bob, fmt.Sprintf("/friends/requests/%d/accept", sent.RequestID), nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 原陌生人会话转为以对方用户名为标题的好友会话
+	for _, s := range []struct {
+		id    uint
+		title string
+	}{{aliceSession.ID, "bob"}, {bobSession.ID, "alice"}} {
+		var session models.ChatSession
+		require.NoError(t, db.First(&session, s.id).Error)
+		assert.Equal(t, models.SessionDirect, session.Type)
+		assert.Equal(t, s.title, session.Title)
+		assert.Nil(t, session.EndedAt)
+	}
+	assert.True(t, areFriends(db, alice, bob))
+	assert.True(t, areFriends(db, bob, alice))
+
+	// 好友会话不计入陌生人匹配的冷却期
+	partners, _, err := matchablePartners(db, alice, []uint{bob}, time.Now())
+	require.NoError(t, err)
+	assert.True(t, partners[bob])
+
+	// 屏蔽同时解除双向好友关系
+	w = serveAs(router, bob, "/blocks", models.BlockRequest{UserID: alice})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, areFriends(db, alice, bob))
+	assert.False(t, areFriends(db, bob, alice))
+	partners, _, err = matchablePartners(db, alice, []uint{bob}, time.Now())
+	require.NoError(t, err)
+	assert.False(t, partners[bob])
+}
+
+func TestMutualFriendRequestsBecomeFriends(t *testing.T) {
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	users := []models.User{
+		{Username: "carol", Email: "carol@example.com"},
+		{Username: "dave", Email: "dave@example.com"},
+	}
+	require.NoError(t, db.Create(&users).Error)
+	carol, dave := users[0].ID, users[1].ID
+
+	carolSession, daveSession, err := createStrangerSessions(carol, &models.MatchingRequest{}, dave, &models.MatchingRequest{})
+	require.NoError(t, err)
+	router := friendRouter()
+
+	w := serveAs(router, carol, fmt.Sprintf("/chat/sessions/%d/friend-request", carolSession.ID), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// 对方已发起请求时，反向请求直接成为好友
+	w = serveAs(router, dave, fmt.Sprintf("/chat/sessions/%d/friend-request", daveSession.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Friend    bool `json:"friend"`
+		SessionID uint `json:"sessionId"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Friend)
+	assert.Equal(t, daveSession.ID, resp.SessionID)
+	assert.True(t, areFriends(db, carol, dave))
+
+	var pending int64
+	require.NoError(t, db.Model(&models.FriendRequest{}).Where("status = ?", models.FriendRequestPending).Count(&pending).Error)
+	assert.Zero(t, pending)
+}
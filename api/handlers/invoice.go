@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/invoices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetInvoices 获取当前用户的收据和退款凭证，可按订单号筛选
+func GetInvoices(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	query := database.DB.Model(&models.Invoice{}).Where("user_id = ?", userID)
+	if orderNo := c.Query("orderNo"); orderNo != "" {
+		query = query.Where("order_no = ?", orderNo)
+	}
+
+	var count int64
+	var list []models.Invoice
+	if err := query.Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	if err := query.Order("issued_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    count,
+		"page":     page,
+		"pageSize": pageSize,
+		"invoices": list,
+	})
+}
+
+// GetInvoice 获取票据详情
+func GetInvoice(c *gin.Context) {
+	inv, ok := findInvoice(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, inv)
+}
+
+// DownloadInvoice 下载票据，format 为 html（默认）或 pdf
+func DownloadInvoice(c *gin.Context) {
+	inv, ok := findInvoice(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	switch c.DefaultQuery("format", "html") {
+	case "html":
+		if err := invoices.RenderHTML(&buf, inv); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	case "pdf":
+		if err := invoices.RenderPDF(&buf, inv); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+inv.Number+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format"})
+	}
+}
+
+// findInvoice 按路径中的编号查找当前用户的票据，失败时已写入应答
+func findInvoice(c *gin.Context) (*models.Invoice, bool) {
+	userID, _ := c.Get("userID")
+	inv, err := invoices.Find(database.DB, userID.(uint), c.Param("number"))
+	if errors.Is(err, invoices.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+		return nil, false
+	}
+	return inv, true
+}
+
+// GetBillingProfile 获取开票信息
+func GetBillingProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	profile, err := invoices.Profile(database.DB, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch billing profile"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateBillingProfile 修改开票信息，之后开具的票据使用新的信息
+func UpdateBillingProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req models.BillingProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	profile, err := invoices.SaveProfile(database.DB, userID.(uint), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update billing profile"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
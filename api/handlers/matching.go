@@ -1,17 +1,102 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/pseudonym"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// matchQueue 等待匹配的用户队列
+type matchQueue struct {
+	mu      sync.Mutex
+	waiting map[uint]*models.MatchingRequest
+}
+
 // 维护等待匹配的用户队列
-var waitingUsers = make(map[uint]*models.MatchingRequest)
+var waitingUsers = &matchQueue{waiting: make(map[uint]*models.MatchingRequest)}
+
+// 同一对用户再次匹配的冷却时间
+const matchCooldown = 30 * time.Minute
+
+// join 加入等待队列并尝试匹配，匹配成功时双方都会被移出队列
+// 候选用户的屏蔽关系、冷却期和账号状态在锁外批量查询，持锁期间只读写队列；
+// 查询期间新加入的用户在下一轮检查，没有未检查的候选时才加入队列，不会漏掉互相等待的用户
+func (q *matchQueue) join(userID uint, req *models.MatchingRequest) (uint, *models.MatchingRequest, bool) {
+	q.remove(userID)
+
+	eligible := map[uint]bool{}
+	checked := map[uint]bool{}
+	for {
+		q.mu.Lock()
+		for id, other := range q.waiting {
+			if eligible[id] {
+				delete(q.waiting, id)
+				q.mu.Unlock()
+				return id, other, true
+			}
+		}
+		var candidates []uint
+		for id := range q.waiting {
+			if id != userID && !checked[id] {
+				candidates = append(candidates, id)
+			}
+		}
+		if len(candidates) == 0 {
+			q.waiting[userID] = req
+			q.mu.Unlock()
+			return 0, nil, false
+		}
+		q.mu.Unlock()
+
+		partners, unavailable, err := matchablePartners(database.DB, userID, candidates, time.Now())
+		if err != nil {
+			log.Printf("Failed to check matching candidates for user %d: %v", userID, err)
+			q.add(userID, req)
+			return 0, nil, false
+		}
+		for _, id := range candidates {
+			checked[id] = true
+			eligible[id] = partners[id]
+		}
+		// 等待期间被封禁或暂停的用户移出队列
+		for _, id := range unavailable {
+			q.remove(id)
+		}
+	}
+}
+
+// add 将用户放回等待队列，不尝试匹配
+func (q *matchQueue) add(userID uint, req *models.MatchingRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.waiting[userID] = req
+}
+
+// remove 将用户移出等待队列
+func (q *matchQueue) remove(userID uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.waiting, userID)
+}
+
+// contains 判断用户是否在等待队列中
+func (q *matchQueue) contains(userID uint) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.waiting[userID]
+	return ok
+}
 
 // RequestMatching 请求匹配
 func RequestMatching(c *gin.Context) {
@@ -23,92 +108,68 @@ func RequestMatching(c *gin.Context) {
 		return
 	}
 
-	// 将用户添加到等待队列
-	waitingUsers[userID.(uint)] = &req
-
-	// 尝试匹配用户
-	matched := false
-	var matchedUserID uint
-
-	for id := range waitingUsers {
-		if id == userID.(uint) {
-			continue // 跳过自己
-		}
-
-		// 简单匹配逻辑，可以根据兴趣等条件进行更复杂的匹配
-		// 这里仅作为示例
-		matched = true
-		matchedUserID = id
-		delete(waitingUsers, id) // 从等待队列中移除
-		break
+	// 被暂停的用户不能参与匹配
+	if !chatAllowed(c) {
+		return
 	}
 
-	if matched {
-		// 创建聊天会话
-		session := models.ChatSession{
-			UserID:     userID.(uint),
-			Type:       models.SessionStranger,
-			Title:      "陌生人聊天",
-			LastActive: time.Now(),
-			Meta:       `{"matchedUserId": ` + string(matchedUserID) + `}`,
-		}
-
-		if err := database.DB.Create(&session).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session"})
-			return
-		}
-
-		// 创建对方的会话
-		matchedSession := models.ChatSession{
-			UserID:     matchedUserID,
-			Type:       models.SessionStranger,
-			Title:      "陌生人聊天",
-			LastActive: time.Now(),
-			Meta:       `{"matchedUserId": ` + string(userID.(uint)) + `}`,
-		}
-
-		if err := database.DB.Create(&matchedSession).Error; err != nil {
-			// 如果创建失败，删除之前创建的会话
-			database.DB.Delete(&session)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create matched chat session"})
-			return
-		}
-
-		// 发送系统消息
-		welcomeMsg := "您已与一位陌生人匹配成功，开始聊天吧！"
+	joinMatching(c, userID.(uint), &req)
+}
 
-		// 在两个会话中都添加系统消息
-		systemMessage1 := models.ChatMessage{
-			SessionID: session.ID,
-			SenderID:  "system",
-			Type:      models.MessageText,
-			Content:   welcomeMsg,
-		}
+// SkipConversation 结束当前的陌生人会话，并以相同的匹配条件重新进入匹配队列
+func SkipConversation(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
 
-		systemMessage2 := models.ChatMessage{
-			SessionID: matchedSession.ID,
-			SenderID:  "system",
-			Type:      models.MessageText,
-			Content:   welcomeMsg,
-		}
+	if !chatAllowed(c) {
+		return
+	}
 
-		database.DB.Create(&systemMessage1)
-		database.DB.Create(&systemMessage2)
+	// 对方已先结束会话时同样可以跳过
+	session, err := endStrangerSession(userID.(uint), uint(sessionID))
+	if !errors.Is(err, errConversationEnded) && !respondEndError(c, err) {
+		return
+	}
 
-		// 从等待队列中移除自己
-		delete(waitingUsers, userID.(uint))
+	meta, _ := session.ParseStrangerMeta()
+	req := meta.Request
+	if req == nil {
+		req = &models.MatchingRequest{}
+	}
+	joinMatching(c, userID.(uint), req)
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"matched":   true,
-			"sessionId": session.ID,
-			"message":   "匹配成功，可以开始聊天了！",
-		})
-	} else {
+// joinMatching 加入匹配队列并返回匹配结果
+func joinMatching(c *gin.Context, userID uint, req *models.MatchingRequest) {
+	matchedUserID, matchedReq, matched := waitingUsers.join(userID, req)
+	if !matched {
 		c.JSON(http.StatusOK, gin.H{
 			"matched": false,
 			"message": "已加入匹配队列，请耐心等待...",
 		})
+		return
+	}
+
+	session, _, err := createStrangerSessions(userID, req, matchedUserID, matchedReq)
+	if err != nil {
+		// 对方重新回到队列中继续等待
+		waitingUsers.add(matchedUserID, matchedReq)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session"})
+		return
 	}
+
+	meta, _ := session.ParseStrangerMeta()
+	c.JSON(http.StatusOK, gin.H{
+		"matched":   true,
+		"sessionId": session.ID,
+		"alias":     meta.Alias,
+		"partner":   meta.PeerAlias,
+		"message":   "匹配成功，可以开始聊天了！",
+	})
 }
 
 // CancelMatching 取消匹配
@@ -116,7 +177,7 @@ func CancelMatching(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
 	// 从等待队列中移除用户
-	delete(waitingUsers, userID.(uint))
+	waitingUsers.remove(userID.(uint))
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "匹配已取消",
@@ -128,9 +189,7 @@ func GetMatchingStatus(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
 	// 检查用户是否在等待队列中
-	_, isWaiting := waitingUsers[userID.(uint)]
-
-	if isWaiting {
+	if waitingUsers.contains(userID.(uint)) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "waiting",
 			"message": "正在等待匹配...",
@@ -138,9 +197,9 @@ func GetMatchingStatus(c *gin.Context) {
 		return
 	}
 
-	// 检查是否已经匹配成功（查找最近的陌生人聊天会话）
+	// 检查是否已经匹配成功（查找最近仍在进行的陌生人聊天会话）
 	var session models.ChatSession
-	err := database.DB.Where("user_id = ? AND type = ? AND created_at > ?",
+	err := database.DB.Where("user_id = ? AND type = ? AND ended_at IS NULL AND created_at > ?",
 		userID, models.SessionStranger, time.Now().Add(-5*time.Minute)).
 		Order("created_at DESC").
 		First(&session).Error
@@ -159,3 +218,101 @@ func GetMatchingStatus(c *gin.Context) {
 		"message": "当前未在匹配中",
 	})
 }
+
+// createStrangerSessions 为匹配成功的双方各创建一个陌生人会话，并互相关联
+// 各自的匹配条件也记录在会话中，跳过时以相同条件重新匹配
+func createStrangerSessions(userID uint, req *models.MatchingRequest, matchedUserID uint, matchedReq *models.MatchingRequest) (*models.ChatSession, *models.ChatSession, error) {
+	// 双方在本次会话中各自使用随机化名，会话中不记录对方的真实身份
+	alias, matchedAlias := pseudonym.Pair()
+	newSession := func(owner uint, own, peer models.Pseudonym, req *models.MatchingRequest) (*models.ChatSession, error) {
+		meta, err := json.Marshal(models.StrangerMeta{Alias: own, PeerAlias: peer, Request: req})
+		if err != nil {
+			return nil, err
+		}
+		return &models.ChatSession{
+			UserID:     owner,
+			Type:       models.SessionStranger,
+			Title:      peer.Name,
+			LastActive: time.Now(),
+			Meta:       string(meta),
+		}, nil
+	}
+
+	session, err := newSession(userID, alias, matchedAlias, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	matchedSession, err := newSession(matchedUserID, matchedAlias, alias, matchedReq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(matchedSession).Error; err != nil {
+			return err
+		}
+
+		// 关联双方会话，消息会同步写入对方会话
+		if err := tx.Model(session).Update("peer_session_id", matchedSession.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(matchedSession).Update("peer_session_id", session.ID).Error; err != nil {
+			return err
+		}
+
+		// 在两个会话中都添加系统消息
+		return addSystemMessage(tx, "您已与一位陌生人匹配成功，开始聊天吧！", session.ID, matchedSession.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, matchedSession, nil
+}
+
+// matchablePartners 从候选用户中找出可以与用户匹配的用户
+// 排除互相屏蔽、冷却期内匹配过的用户，以及已被封禁、暂停或注销的用户，后者同时作为unavailable返回
+// 冷却只统计陌生人会话，已成为好友的会话类型为direct，不计入冷却
+func matchablePartners(db *gorm.DB, userID uint, candidates []uint, now time.Time) (map[uint]bool, []uint, error) {
+	var users []models.User
+	if err := db.Select("id", "banned_at", "banned_until", "suspended_until").
+		Where("id IN ?", candidates).Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+	partners := make(map[uint]bool, len(users))
+	for i := range users {
+		partners[users[i].ID] = users[i].CanChat(now)
+	}
+	var unavailable []uint
+	for _, id := range candidates {
+		if !partners[id] {
+			unavailable = append(unavailable, id)
+		}
+	}
+
+	var blocks []models.UserBlock
+	if err := db.Where("(blocker_id = ? AND blocked_id IN ?) OR (blocked_id = ? AND blocker_id IN ?)",
+		userID, candidates, userID, candidates).Find(&blocks).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, block := range blocks {
+		delete(partners, block.BlockerID)
+		delete(partners, block.BlockedID)
+	}
+
+	var recent []uint
+	if err := db.Table("chat_sessions AS s").
+		Joins("JOIN chat_sessions AS p ON p.id = s.peer_session_id").
+		Where("s.user_id = ? AND p.user_id IN ? AND s.type = ? AND s.created_at > ? AND s.deleted_at IS NULL",
+			userID, candidates, models.SessionStranger, now.Add(-matchCooldown)).
+		Distinct().Pluck("p.user_id", &recent).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, id := range recent {
+		delete(partners, id)
+	}
+	return partners, unavailable, nil
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchQueueSkipsUnavailablePartners(t *testing.T) {
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	now := time.Now()
+	later := now.Add(time.Hour)
+	users := []models.User{
+		{Username: "seeker", Email: "seeker@example.com"},
+		{Username: "suspended", Email: "suspended@example.com"},
+		{Username: "banned", Email: "banned@example.com"},
+		{Username: "blocker", Email: "blocker@example.com"},
+		{Username: "recent", Email: "recent@example.com"},
+		{Username: "partner", Email: "partner@example.com"},
+	}
+	require.NoError(t, db.Create(&users).Error)
+	seeker, suspended, banned, blocker, recent, partner := users[0].ID, users[1].ID, users[2].ID, users[3].ID, users[4].ID, users[5].ID
+
+	q := &matchQueue{waiting: make(map[uint]*models.MatchingRequest)}
+	for _, id := range []uint{suspended, banned, blocker, recent} {
+		q.add(id, &models.MatchingRequest{})
+	}
+
+	// 排队之后才被暂停或封禁的用户在配对前重新检查
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", suspended).Update("suspended_until", later).Error)
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", banned).Update("banned_at", now).Error)
+	require.NoError(t, db.Create(&models.UserBlock{BlockerID: blocker, BlockedID: seeker}).Error)
+	_, _, err := createStrangerSessions(seeker, &models.MatchingRequest{}, recent, &models.MatchingRequest{})
+	require.NoError(t, err)
+
+	_, _, matched := q.join(seeker, &models.MatchingRequest{})
+	assert.False(t, matched)
+	assert.True(t, q.contains(seeker))
+	assert.False(t, q.contains(suspended), "suspended users leave the queue")
+	assert.False(t, q.contains(banned), "banned users leave the queue")
+	assert.True(t, q.contains(blocker))
+	assert.True(t, q.contains(recent))
+
+	id, _, matched := q.join(partner, &models.MatchingRequest{})
+	require.True(t, matched)
+	assert.Contains(t, []uint{seeker, blocker, recent}, id)
+	assert.False(t, q.contains(partner))
+	assert.False(t, q.contains(id))
+}
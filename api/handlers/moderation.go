@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/notify"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errReportClosed = errors.New("report already closed")
+
+// 审核查看举报时附带的上下文消息数
+const reportContextMessages = 50
+
+// GetModerationReports 获取审核队列，默认按提交时间从早到晚返回待处理举报
+func GetModerationReports(c *gin.Context) {
+	status := c.DefaultQuery("status", string(models.ReportOpen))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	query := database.DB.Model(&models.Report{}).Where("status = ?", status)
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var reports []models.Report
+	if err := query.Order("created_at ASC").Limit(limit).Offset(offset).Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"reports": reports,
+	})
+}
+
+// GetModerationReport 获取举报详情及上下文：证据消息、会话记录和被举报用户的处罚历史
+func GetModerationReport(c *gin.Context) {
+	reportID, err := strconv.ParseUint(c.Param("reportId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	var report models.Report
+	if err := database.DB.First(&report, reportID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+
+	var evidence []models.ChatMessage
+	if len(report.EvidenceMessageIDs) > 0 {
+		database.DB.Unscoped().Where("id IN ?", report.EvidenceMessageIDs).Order("created_at").Find(&evidence)
+	}
+
+	// 截至举报时的最近消息，按时间正序返回
+	var transcript []models.ChatMessage
+	database.DB.Unscoped().
+		Where("session_id = ? AND created_at <= ?", report.SessionID, report.CreatedAt).
+		Order("created_at DESC").
+		Limit(reportContextMessages).
+		Find(&transcript)
+	for i, j := 0, len(transcript)-1; i < j; i, j = i+1, j-1 {
+		transcript[i], transcript[j] = transcript[j], transcript[i]
+	}
+
+	var reported models.User
+	database.DB.Unscoped().First(&reported, report.ReportedUserID)
+
+	var sanctions []models.UserSanction
+	database.DB.Where("user_id = ?", report.ReportedUserID).Order("created_at DESC").Find(&sanctions)
+
+	var reportCount int64
+	database.DB.Model(&models.Report{}).Where("reported_user_id = ?", report.ReportedUserID).Count(&reportCount)
+
+	c.JSON(http.StatusOK, gin.H{
+		"report":     report,
+		"evidence":   evidence,
+		"transcript": transcript,
+		"reportedUser": gin.H{
+			"id":             reported.ID,
+			"username":       reported.Username,
+			"role":           reported.Role,
+			"joinDate":       reported.JoinDate,
+			"suspendedUntil": reported.SuspendedUntil,
+			"bannedAt":       reported.BannedAt,
+			"bannedUntil":    reported.BannedUntil,
+			"reportCount":    reportCount,
+		},
+		"sanctions": sanctions,
+	})
+}
+
+// ActOnReport 处理举报：警告、暂停、封禁或驳回
+func ActOnReport(c *gin.Context) {
+	moderatorID, _ := c.Get("userID")
+	reportID, err := strconv.ParseUint(c.Param("reportId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	var req models.ModerationActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Action == string(models.SanctionSuspend) && req.DurationHours <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "durationHours is required for suspension"})
+		return
+	}
+
+	var report models.Report
+	var sanction *models.UserSanction
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&report, reportID).Error; err != nil {
+			return err
+		}
+		if report.Status != models.ReportOpen {
+			return errReportClosed
+		}
+
+		now := time.Now()
+		modID := moderatorID.(uint)
+		report.ModeratorID = &modID
+		report.Resolution = req.Reason
+		report.ResolvedAt = &now
+		report.Status = models.ReportResolved
+		if req.Action == "dismiss" {
+			report.Status = models.ReportDismissed
+			return tx.Save(&report).Error
+		}
+		if err := tx.Save(&report).Error; err != nil {
+			return err
+		}
+
+		sanction = &models.UserSanction{
+			UserID:      report.ReportedUserID,
+			ModeratorID: modID,
+			ReportID:    &report.ID,
+			Action:      models.SanctionAction(req.Action),
+			Reason:      req.Reason,
+		}
+		return applySanction(tx, sanction, req.DurationHours)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
+	if errors.Is(err, errReportClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Report has already been handled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle report"})
+		return
+	}
+
+	if sanction != nil {
+		notifySanction(c.Request.Context(), sanction)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"report":   report,
+		"sanction": sanction,
+	})
+}
+
+// GetUserSanctions 获取用户的处罚记录
+func GetUserSanctions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var sanctions []models.UserSanction
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&sanctions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sanctions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sanctions": sanctions,
+	})
+}
+
+// RevokeSanction 撤销处罚，用户状态根据剩余有效处罚重新计算
+func RevokeSanction(c *gin.Context) {
+	sanctionID, err := strconv.ParseUint(c.Param("sanctionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sanction ID"})
+		return
+	}
+
+	var sanction models.UserSanction
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sanction, sanctionID).Error; err != nil {
+			return err
+		}
+		if sanction.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		sanction.RevokedAt = &now
+		if err := tx.Save(&sanction).Error; err != nil {
+			return err
+		}
+		return refreshSanctionState(tx, sanction.UserID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sanction not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sanction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Sanction revoked",
+		"sanction": sanction,
+	})
+}
+
+// applySanction 保存处罚并更新用户的处罚状态
+func applySanction(tx *gorm.DB, sanction *models.UserSanction, durationHours int) error {
+	if sanction.Action != models.SanctionWarn && durationHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(durationHours) * time.Hour)
+		sanction.ExpiresAt = &expiresAt
+	}
+	if err := tx.Create(sanction).Error; err != nil {
+		return err
+	}
+	if sanction.Action == models.SanctionWarn {
+		return nil
+	}
+
+	// 被暂停或封禁的用户不再留在匹配队列中
+	waitingUsers.remove(sanction.UserID)
+	return refreshSanctionState(tx, sanction.UserID)
+}
+
+// refreshSanctionState 根据有效处罚重新计算用户的暂停和封禁状态
+func refreshSanctionState(tx *gorm.DB, userID uint) error {
+	var sanctions []models.UserSanction
+	if err := tx.Where("user_id = ? AND revoked_at IS NULL AND action IN ?", userID,
+		[]models.SanctionAction{models.SanctionSuspend, models.SanctionBan}).Find(&sanctions).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var suspendedUntil, bannedAt, bannedUntil *time.Time
+	permanent := false
+	for i := range sanctions {
+		s := &sanctions[i]
+		if !s.Active(now) {
+			continue
+		}
+		switch s.Action {
+		case models.SanctionSuspend:
+			if suspendedUntil == nil || s.ExpiresAt.After(*suspendedUntil) {
+				suspendedUntil = s.ExpiresAt
+			}
+		case models.SanctionBan:
+			if bannedAt == nil || s.CreatedAt.Before(*bannedAt) {
+				bannedAt = &s.CreatedAt
+			}
+			if s.ExpiresAt == nil {
+				permanent = true
+			} else if bannedUntil == nil || s.ExpiresAt.After(*bannedUntil) {
+				bannedUntil = s.ExpiresAt
+			}
+		}
+	}
+	if permanent {
+		bannedUntil = nil
+	}
+
+	return tx.Model(&models.User{Model: gorm.Model{ID: userID}}).Updates(map[string]interface{}{
+		"suspended_until": suspendedUntil,
+		"banned_at":       bannedAt,
+		"banned_until":    bannedUntil,
+	}).Error
+}
+
+// notifySanction 通知用户收到的处罚
+func notifySanction(ctx context.Context, sanction *models.UserSanction) {
+	n := notify.Notification{
+		Type: notify.TypeModerationAction,
+		Data: map[string]interface{}{
+			"action":    sanction.Action,
+			"reason":    sanction.Reason,
+			"expiresAt": sanction.ExpiresAt,
+		},
+	}
+
+	until := "永久"
+	if sanction.ExpiresAt != nil {
+		until = sanction.ExpiresAt.Format("2006-01-02 15:04:05")
+	}
+	switch sanction.Action {
+	case models.SanctionWarn:
+		n.Title = "社区规范警告"
+		n.Body = "您的行为违反了社区规范，请注意文明聊天。原因：" + sanction.Reason
+	case models.SanctionSuspend:
+		n.Title = "聊天功能已被暂停"
+		n.Body = fmt.Sprintf("由于违反社区规范，您的匹配和聊天功能已被暂停至 %s。原因：%s", until, sanction.Reason)
+	case models.SanctionBan:
+		n.Title = "账号已被封禁"
+		n.Body = fmt.Sprintf("由于违反社区规范，您的账号已被封禁，截止时间：%s。原因：%s", until, sanction.Reason)
+	}
+	notify.Send(ctx, sanction.UserID, n)
+}
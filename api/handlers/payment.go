@@ -1,22 +1,48 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/coupons"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
+// 计费服务，负责支付下单和履约
+var billingService *billing.Service
+
+// customCreditPrice 自定义金额充值时每个积分的价格：10元 = 100积分
+const customCreditPrice = money.Amount(10)
+
+// InitBillingService 设置计费服务
+func InitBillingService(svc *billing.Service) {
+	billingService = svc
+}
+
 // GetRechargePackages 获取充值套餐
 func GetRechargePackages(c *gin.Context) {
-	packages := models.GetDefaultRechargePackages()
+	packages, err := catalog.ActivePackages(database.DB, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recharge packages"})
+		return
+	}
+
+	responses := make([]models.RechargePackageResponse, len(packages))
+	for i := range packages {
+		responses[i] = packages[i].ToResponse()
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"packages": packages,
+		"packages": responses,
 	})
 }
 
@@ -32,69 +58,73 @@ func CreateRechargeOrder(c *gin.Context) {
 
 	// 计算充值积分和金额
 	var credits int
-	var amount float64
+	var amount money.Amount
+	currency := money.DefaultCurrency
+	var packageVersionID uint
 
 	if req.PackageID != "" {
-		// 套餐充值
-		packages := models.GetDefaultRechargePackages()
-		var selectedPackage *models.RechargePackage
-
-		for _, pkg := range packages {
-			if pkg.ID == req.PackageID {
-				selectedPackage = &pkg
-				break
-			}
-		}
-
-		if selectedPackage == nil {
+		// 套餐充值，按当前生效的版本定价
+		selectedPackage, err := catalog.ActivePackage(database.DB, req.PackageID, time.Now())
+		if errors.Is(err, catalog.ErrNotFound) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID"})
 			return
 		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recharge package"})
+			return
+		}
 
 		credits = selectedPackage.Credits
 		amount = selectedPackage.Price
+		currency = selectedPackage.Currency
+		packageVersionID = selectedPackage.ID
 	} else if req.CustomAmount > 0 {
 		// 自定义充值金额
 		credits = req.CustomAmount
-		// 计算价格：10元 = 100积分
-		amount = float64(credits) / 100 * 10
+		var err error
+		if amount, err = customCreditPrice.Times(int64(credits)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom amount"})
+			return
+		}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Either package ID or custom amount is required"})
 		return
 	}
 
-	// 创建订单
-	orderNo := "R" + time.Now().Format("20060102") + uuid.New().String()[:8]
 	payment := models.Payment{
-		UserID:  userID.(uint),
-		OrderNo: orderNo,
-		Amount:  amount,
-		Credits: credits,
-		Method:  req.Method,
-		Status:  models.PaymentPending,
+		UserID:           userID.(uint),
+		Amount:           amount,
+		Currency:         currency,
+		Credits:          credits,
+		Method:           req.Method,
+		Purpose:          models.PurposeRecharge,
+		PackageVersionID: packageVersionID,
 	}
 
-	if err := database.DB.Create(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment order"})
-		return
+	// 应用优惠券，创建订单时占用使用次数，支付完成时核销
+	if req.CouponCode != "" {
+		if err := coupons.Apply(database.DB, req.CouponCode, &payment, req.PackageID, time.Now()); err != nil {
+			if !respondCouponError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply coupon"})
+			}
+			return
+		}
 	}
 
-	// 这里应该调用实际的支付网关API获取支付链接或二维码
-	// 这里仅作为演示，返回模拟数据
-	paymentURL := "https://example.com/pay/" + orderNo
-	paymentQR := "data:image/png;base64,..."
+	// 创建订单并在支付网关下单，获取支付链接或二维码
+	result, err := billingService.CreatePayment(c.Request.Context(), &payment, c.ClientIP())
+	if err != nil {
+		if !respondCouponError(c, err) {
+			respondPaymentError(c, err)
+		}
+		return
+	}
 
+	resp := payment.ToResponse()
+	resp.PaymentURL = result.PaymentURL
+	resp.PaymentQR = result.QRCode
 	c.JSON(http.StatusOK, gin.H{
-		"payment": models.PaymentResponse{
-			OrderNo:    payment.OrderNo,
-			Amount:     payment.Amount,
-			Credits:    payment.Credits,
-			Method:     payment.Method,
-			Status:     payment.Status,
-			PaymentURL: paymentURL,
-			PaymentQR:  paymentQR,
-			CreatedAt:  payment.CreatedAt,
-		},
+		"payment": resp,
 	})
 }
 
@@ -102,69 +132,79 @@ func CreateRechargeOrder(c *gin.Context) {
 func CheckPaymentStatus(c *gin.Context) {
 	orderNo := c.Param("orderNo")
 
+	userID, _ := c.Get("userID")
+
 	var payment models.Payment
-	if err := database.DB.Where("order_no = ?", orderNo).First(&payment).Error; err != nil {
+	if err := database.DB.Where("order_no = ? AND user_id = ?", orderNo, userID).First(&payment).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payment order not found"})
 		return
 	}
 
+	// 回调可能丢失或延迟，待支付订单主动向网关查询
+	if payment.Status == models.PaymentPending {
+		billingService.RefreshPending(c.Request.Context(), &payment)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": payment.Status,
 	})
 }
 
-// HandlePaymentCallback 处理支付回调
-func HandlePaymentCallback(c *gin.Context) {
-	// 支付网关的回调通常会包含订单号和支付状态等信息
-	orderNo := c.PostForm("order_no")
-	status := c.PostForm("status")
-	transactionID := c.PostForm("transaction_id")
-
-	var payment models.Payment
-	if err := database.DB.Where("order_no = ?", orderNo).First(&payment).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment order not found"})
+// HandleGatewayCallback 处理支付网关的异步回调，按网关要求的格式应答
+func HandleGatewayCallback(c *gin.Context) {
+	gateway, err := billingService.Gateway(models.PaymentMethod(c.Param("method")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unsupported payment method"})
 		return
 	}
 
-	// 验证回调的合法性（实际应用中应该验证签名等）
-
-	if status == "success" {
-		now := time.Now()
-		payment.Status = models.PaymentCompleted
-		payment.CompletedAt = &now
-		payment.TransactionID = transactionID
-
-		// 更新用户积分
-		var user models.User
-		if err := database.DB.First(&user, payment.UserID).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
-			return
-		}
-
-		user.Credits += payment.Credits
+	result, err := gateway.VerifyCallback(c.Request)
+	if err != nil {
+		log.Printf("Rejected %s callback: %v", gateway.Method(), err)
+		gateway.Acknowledge(c.Writer, err)
+		return
+	}
 
-		// 使用事务确保数据一致性
-		tx := database.DB.Begin()
-		if err := tx.Save(&payment).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+	// 退款结果通知
+	if result.Refund != nil {
+		if err := billingService.ApplyRefundCallback(gateway.Method(), result.Refund); err != nil {
+			log.Printf("Failed to apply %s refund callback for %s: %v", gateway.Method(), result.Refund.RefundNo, err)
+			gateway.Acknowledge(c.Writer, err)
 			return
 		}
+		gateway.Acknowledge(c.Writer, nil)
+		return
+	}
 
-		if err := tx.Save(&user).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user credits"})
-			return
-		}
+	var payment models.Payment
+	if err := database.DB.Where("order_no = ? AND method = ?", result.OrderNo, gateway.Method()).First(&payment).Error; err != nil {
+		gateway.Acknowledge(c.Writer, errors.New("payment order not found"))
+		return
+	}
 
-		tx.Commit()
-	} else {
-		payment.Status = models.PaymentFailed
-		database.DB.Save(&payment)
+	if err := billingService.ApplyTradeResult(&payment, result); err != nil {
+		log.Printf("Failed to apply %s callback for %s: %v", gateway.Method(), payment.OrderNo, err)
+		gateway.Acknowledge(c.Writer, err)
+		return
 	}
+	gateway.Acknowledge(c.Writer, nil)
+}
 
-	// 返回给支付网关的响应
-	c.String(http.StatusOK, "success")
+// respondPaymentError 下单失败的应答，不支持的支付方式为请求错误，其余为网关错误
+func respondPaymentError(c *gin.Context, err error) {
+	if errors.Is(err, payment.ErrUnsupportedMethod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment method"})
+		return
+	}
+	if errors.Is(err, billing.ErrInvalidAmount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order amount"})
+		return
+	}
+	if errors.Is(err, billing.ErrGatewayOrder) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create order at payment gateway"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment order"})
 }
 
 // GetPaymentHistory 获取支付历史
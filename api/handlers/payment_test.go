@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forgeMockCallback 以公开的占位密钥签名一条支付成功的模拟网关回调
+func forgeMockCallback(t *testing.T, order *models.Payment) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(payment.MockOrder{
+		OrderNo:  order.OrderNo,
+		Method:   string(order.Method),
+		Amount:   int64(order.Amount),
+		Currency: payment.DefaultCurrency,
+		Status:   payment.TradeSuccess,
+	})
+	require.NoError(t, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments/callback/"+string(order.Method), bytes.NewReader(body))
+	req.Header.Set(payment.MockTimestampHeader, timestamp)
+	req.Header.Set(payment.MockSignatureHeader, payment.SignMock("mock-secret", timestamp, body))
+	return req
+}
+
+func TestForgedMockCallbackRejectedOutsideDevMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	order := &models.Payment{UserID: 1, OrderNo: "R-forged", Method: models.PaymentAlipay, Amount: money.Yuan(50), Status: models.PaymentPending}
+	require.NoError(t, db.Create(order).Error)
+
+	router := gin.New()
+	router.POST("/api/payments/callback/:method", HandleGatewayCallback)
+
+	// 配置文件误开启模拟网关时拒绝启动
+	cfg := &configs.Config{}
+	cfg.Payment.Mock.Enabled = true
+	cfg.Payment.Mock.Secret = "mock-secret"
+	_, err := payment.NewRegistryFromConfig(cfg)
+	require.ErrorIs(t, err, payment.ErrMockNotAllowed)
+
+	// 未启用模拟网关时，没有商户配置的支付方式不接受任何回调
+	cfg.Payment.Mock.Enabled = false
+	registry, err := payment.NewRegistryFromConfig(cfg)
+	require.NoError(t, err)
+	require.NoError(t, registry.CheckProduction(false))
+	InitBillingService(billing.NewService(db, registry, billing.Config{}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, forgeMockCallback(t, order))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 开发模式下模拟网关也只认配置的密钥
+	cfg.Server.DevMode = true
+	cfg.Payment.Mock.Enabled = true
+	cfg.Payment.Mock.Secret = "a-long-random-mock-secret"
+	registry, err = payment.NewRegistryFromConfig(cfg)
+	require.NoError(t, err)
+	InitBillingService(billing.NewService(db, registry, billing.Config{}))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, forgeMockCallback(t, order))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var stored models.Payment
+	require.NoError(t, db.First(&stored, order.ID).Error)
+	assert.Equal(t, models.PaymentPending, stored.Status)
+}
+
+func TestCustomRechargeAmountIsBounded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	database.DB = db
+	t.Cleanup(func() { database.DB = nil })
+
+	cfg := &configs.Config{}
+	cfg.Server.DevMode = true
+	cfg.Payment.Mock.Enabled = true
+	cfg.Payment.Mock.Secret = "a-long-random-mock-secret"
+	registry, err := payment.NewRegistryFromConfig(cfg)
+	require.NoError(t, err)
+	InitBillingService(billing.NewService(db, registry, billing.Config{}))
+
+	router := friendRouter()
+	router.POST("/payments/recharge", CreateRechargeOrder)
+
+	// 超大的积分数在乘以单价时会溢出为负数或极小的金额
+	for _, amount := range []int64{922337203685477581, 1844674407370955162, 1000001} {
+		w := serveAs(router, 1, "/payments/recharge", gin.H{"customAmount": amount, "method": "alipay"})
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%d: %s", amount, w.Body.String())
+	}
+	var count int64
+	require.NoError(t, db.Model(&models.Payment{}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	// 模拟网关未配置地址，下单失败，但订单按单价计算金额
+	serveAs(router, 1, "/payments/recharge", gin.H{"customAmount": 100, "method": "alipay"})
+	var order models.Payment
+	require.NoError(t, db.First(&order).Error)
+	assert.Equal(t, money.Yuan(10), order.Amount)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/privacy"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 个人数据导出与账号注销服务
+var privacyService *privacy.Service
+
+// InitPrivacyService 设置隐私服务
+func InitPrivacyService(svc *privacy.Service) {
+	privacyService = svc
+}
+
+// RequestDataExport 申请导出个人数据
+func RequestDataExport(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	export, err := privacyService.RequestExport(userID.(uint))
+	if err != nil {
+		if errors.Is(err, privacy.ErrExportInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": "An export is already in progress"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create data export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"export":  export,
+		"message": "数据导出已开始，完成后可下载",
+	})
+}
+
+// GetDataExports 获取数据导出记录
+func GetDataExports(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var exports []models.DataExport
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(20).Find(&exports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exports": exports,
+	})
+}
+
+// DownloadDataExport 下载导出的数据文件
+func DownloadDataExport(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	exportID, err := strconv.ParseUint(c.Param("exportId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, rc, err := privacyService.OpenExport(c.Request.Context(), userID.(uint), uint(exportID))
+	if err != nil {
+		if errors.Is(err, privacy.ErrExportNotReady) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready or has expired", "status": export.Status})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Data export not found"})
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="data-export-%d.zip"`, export.ID))
+	if export.Size > 0 {
+		c.Header("Content-Length", strconv.FormatInt(export.Size, 10))
+	}
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, rc)
+}
+
+// DeleteAccount 申请注销账号，宽限期内可撤销
+func DeleteAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.AccountDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 需要再次确认密码
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+	if !utils.CheckPasswordHash(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	updated, err := privacyService.ScheduleDeletion(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "账号将在宽限期结束后注销，期间登录可撤销",
+		"scheduledAt": updated.DeletionScheduledAt,
+	})
+}
+
+// CancelAccountDeletion 撤销账号注销
+func CancelAccountDeletion(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	user, err := privacyService.CancelDeletion(userID.(uint))
+	if err != nil {
+		if errors.Is(err, privacy.ErrDeletionNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "No pending account deletion"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "账号注销已撤销",
+		"user":    user.ToResponse(),
+	})
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/avatar"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 对象存储，用于头像等用户上传文件
+var blobStore storage.BlobStore
+
+// InitBlobStore 设置对象存储
+func InitBlobStore(store storage.BlobStore) {
+	blobStore = store
+}
+
+// 最小注册年龄
+const minAge = 13
+
+// GetUserProfile 获取当前用户资料
+func GetUserProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+
+	profile, err := loadUserProfile(database.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profile": profileResponse(&user, profile),
+	})
+}
+
+// UpdateUserProfile 更新用户资料
+func UpdateUserProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 解析并校验生日
+	var birthday *time.Time
+	if req.Birthday != nil && *req.Birthday != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", *req.Birthday, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Birthday must be in YYYY-MM-DD format"})
+			return
+		}
+		probe := models.UserProfile{Birthday: &parsed}
+		if age := probe.Age(time.Now()); age < minAge || age > 120 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Age must be between %d and 120", minAge)})
+			return
+		}
+		birthday = &parsed
+	}
+
+	// 检查用户名是否已存在
+	if req.Username != nil {
+		var count int64
+		database.DB.Model(&models.User{}).Where("username = ? AND id != ?", *req.Username, userID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+	}
+
+	var user models.User
+	var profile *models.UserProfile
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if req.Username != nil && *req.Username != user.Username {
+			if err := tx.Model(&user).Update("username", *req.Username).Error; err != nil {
+				return err
+			}
+			user.Username = *req.Username
+		}
+
+		var err error
+		profile, err = loadUserProfile(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if req.DisplayName != nil {
+			profile.DisplayName = strings.TrimSpace(*req.DisplayName)
+		}
+		if req.Bio != nil {
+			profile.Bio = strings.TrimSpace(*req.Bio)
+		}
+		if req.Birthday != nil {
+			profile.Birthday = birthday
+		}
+		if req.Gender != nil {
+			profile.Gender = *req.Gender
+		}
+		if req.Location != nil {
+			profile.Location = strings.TrimSpace(*req.Location)
+		}
+		if req.Languages != nil {
+			profile.PreferredLanguages = normalizeTerms(*req.Languages)
+		}
+
+		if err := tx.Omit("Interests", "Tags").Save(profile).Error; err != nil {
+			return err
+		}
+
+		if req.Interests != nil {
+			interests, err := findOrCreateInterests(tx, normalizeTerms(*req.Interests))
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(profile).Association("Interests").Replace(interests); err != nil {
+				return err
+			}
+			profile.Interests = interests
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Profile updated successfully",
+		"user":    user.ToResponse(),
+		"profile": profileResponse(&user, profile),
+	})
+}
+
+// UploadAvatar 上传头像
+func UploadAvatar(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	if blobStore == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "File storage is not configured"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatar.MaxUploadSize+1<<20)
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar file is required"})
+		return
+	}
+	if fileHeader.Size > avatar.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar file is too large"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read avatar file"})
+		return
+	}
+	defer file.Close()
+
+	variants, err := avatar.Process(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar file is too large"})
+		case errors.Is(err, avatar.ErrInvalidDimensions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Avatar must be between %dx%d and %dx%d pixels",
+				avatar.MinDimension, avatar.MinDimension, avatar.MaxDimension, avatar.MaxDimension)})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar must be a JPEG, PNG or GIF image"})
+		}
+		return
+	}
+
+	// 每次上传使用新的键，避免客户端缓存旧头像
+	ctx := c.Request.Context()
+	key := fmt.Sprintf("avatars/%d/%s", userID.(uint), uuid.New().String()[:8])
+	for _, v := range variants {
+		if _, err := blobStore.Put(ctx, avatarVariantKey(key, v.Size), bytes.NewReader(v.Data), v.ContentType); err != nil {
+			log.Printf("Failed to store avatar for user %d: %v", userID.(uint), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store avatar"})
+			return
+		}
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+	profile, err := loadUserProfile(database.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+		return
+	}
+
+	oldKey := profile.AvatarKey
+	profile.AvatarKey = key
+	if err := database.DB.Omit("Interests", "Tags").Save(profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	// 删除旧头像
+	if oldKey != "" {
+		for _, size := range avatar.StandardSizes {
+			if err := blobStore.Delete(ctx, avatarVariantKey(oldKey, size)); err != nil {
+				log.Printf("Failed to delete old avatar %s: %v", oldKey, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Avatar uploaded successfully",
+		"avatars": avatarURLs(key),
+	})
+}
+
+// loadUserProfile 加载用户画像，不存在时返回未保存的新画像
+func loadUserProfile(db *gorm.DB, userID uint) (*models.UserProfile, error) {
+	var profile models.UserProfile
+	err := db.Preload("Interests").Where("user_id = ?", userID).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.UserProfile{UserID: userID, LastActive: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// findOrCreateInterests 按名称查找兴趣，不存在时创建
+func findOrCreateInterests(tx *gorm.DB, names []string) ([]models.Interest, error) {
+	interests := make([]models.Interest, 0, len(names))
+	for _, name := range names {
+		interest := models.Interest{Name: name, Score: 1}
+		if err := tx.Where("name = ?", name).FirstOrCreate(&interest).Error; err != nil {
+			return nil, err
+		}
+		interests = append(interests, interest)
+	}
+	return interests, nil
+}
+
+// normalizeTerms 去除空白、转为小写并去重
+func normalizeTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := make([]string, 0, len(terms))
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		result = append(result, term)
+	}
+	return result
+}
+
+func profileResponse(user *models.User, profile *models.UserProfile) models.ProfileResponse {
+	resp := profile.ToResponse(user, time.Now())
+	if profile.AvatarKey != "" {
+		resp.Avatars = avatarURLs(profile.AvatarKey)
+	}
+	return resp
+}
+
+func avatarVariantKey(key string, size int) string {
+	return key + "_" + strconv.Itoa(size) + ".jpg"
+}
+
+func avatarURLs(key string) map[string]string {
+	if blobStore == nil {
+		return nil
+	}
+	urls := make(map[string]string, len(avatar.StandardSizes))
+	for _, size := range avatar.StandardSizes {
+		urls[strconv.Itoa(size)] = blobStore.URL(avatarVariantKey(key, size))
+	}
+	return urls
+}
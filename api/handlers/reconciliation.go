@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGetReconciliationReports 获取对账报告，可按日期和支付方式筛选
+func AdminGetReconciliationReports(c *gin.Context) {
+	query := database.DB.Order("date DESC, method")
+	if date := c.Query("date"); date != "" {
+		query = query.Where("date = ?", date)
+	}
+	if method := c.Query("method"); method != "" {
+		query = query.Where("method = ?", method)
+	}
+
+	var reports []models.ReconciliationReport
+	if err := query.Limit(100).Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reconciliation reports"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+	})
+}
+
+// AdminRunReconciliation 重新核对指定日期和支付方式的对账单，覆盖已有报告
+func AdminRunReconciliation(c *gin.Context) {
+	var req struct {
+		Date   string               `json:"date" binding:"required"`
+		Method models.PaymentMethod `json:"method" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date"})
+		return
+	}
+
+	report, err := billingService.Reconcile(c.Request.Context(), req.Method, date)
+	switch {
+	case errors.Is(err, payment.ErrUnsupportedMethod), errors.Is(err, billing.ErrNoSettlement):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment method does not provide settlement files"})
+		return
+	case errors.Is(err, payment.ErrSettlementNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": "Settlement file is not ready yet"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reconcile with payment gateway"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 邀请奖励配置，用于生成邀请链接和展示奖励上限
+var referralConfig referrals.Config
+
+// InitReferrals 设置邀请奖励配置
+func InitReferrals(cfg referrals.Config) {
+	referralConfig = cfg
+}
+
+// GetReferralStats 获取当前用户的邀请码、邀请链接和邀请统计
+func GetReferralStats(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	stats, err := referrals.Stats(database.DB, referralConfig, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral stats"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
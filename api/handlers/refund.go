@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SupportGetPayment 客服查看支付订单及其退款记录
+func SupportGetPayment(c *gin.Context) {
+	var payment models.Payment
+	if err := database.DB.Where("order_no = ?", c.Param("orderNo")).First(&payment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment order not found"})
+		return
+	}
+
+	var refunds []models.Refund
+	if err := database.DB.Where("payment_id = ?", payment.ID).Order("created_at DESC").Find(&refunds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment": payment,
+		"refunds": refunds,
+	})
+}
+
+// SupportRefundPayment 对充值订单全额或部分退款，网关异步处理时返回202
+func SupportRefundPayment(c *gin.Context) {
+	operatorID, _ := c.Get("userID")
+
+	var req models.RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refund, err := billingService.Refund(c.Request.Context(), c.Param("orderNo"), req, operatorID.(uint))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment order not found"})
+		return
+	case errors.Is(err, billing.ErrNotRefundable), errors.Is(err, billing.ErrRefundTooLarge):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, billing.ErrGatewayRefund):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway rejected the refund", "refund": refund})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+
+	status := http.StatusOK
+	if refund.Status == models.RefundPending {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, gin.H{
+		"refund": refund,
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 每个用户可持有的有效令牌上限
+const maxAccessTokens = 20
+
+// CreateAccessToken 创建个人访问令牌，明文只在创建时返回一次
+func CreateAccessToken(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req models.CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 校验权限范围
+	valid := make(map[string]bool, len(models.AllScopes))
+	for _, s := range models.AllScopes {
+		valid[s] = true
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool, len(req.Scopes))
+	for _, s := range req.Scopes {
+		if !valid[s] {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       "Invalid scope: " + s,
+				"validScopes": models.AllScopes,
+			})
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Count(&count)
+	if count >= maxAccessTokens {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many active access tokens"})
+		return
+	}
+
+	raw, prefix, hash, err := utils.GenerateAccessToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		return
+	}
+
+	token := models.PersonalAccessToken{
+		UserID:    userID.(uint),
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		TokenHash: hash,
+		Scopes:    scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := database.DB.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":       token,
+		"accessToken": raw,
+		"message":     "请立即保存令牌，它只会显示这一次",
+	})
+}
+
+// GetAccessTokens 获取个人访问令牌列表
+func GetAccessTokens(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+// RevokeAccessToken 撤销个人访问令牌
+func RevokeAccessToken(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	tokenID, err := strconv.ParseUint(c.Param("tokenId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	var token models.PersonalAccessToken
+	if err := database.DB.Where("id = ? AND user_id = ?", tokenID, userID).First(&token).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access token not found"})
+		return
+	}
+
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		if err := database.DB.Save(&token).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Access token revoked",
+		"token":   token,
+	})
+}
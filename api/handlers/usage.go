@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用量统计服务，查询按天物化的汇总表
+var usageService *usage.Service
+
+// InitUsageService 设置用量统计服务
+func InitUsageService(svc *usage.Service) {
+	usageService = svc
+}
+
+// GetUsage 获取当前用户在日期区间内的AI消耗明细和积分收支
+// from、to 格式为 2006-01-02，groupBy 为 day、agent、model 或 session，format 为 json（默认）或 csv
+func GetUsage(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	report, err := usageService.Report(userID.(uint), c.Query("from"), c.Query("to"), c.Query("groupBy"))
+	if errors.Is(err, usage.ErrInvalidRange) || errors.Is(err, usage.ErrInvalidGroup) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, report)
+	case "csv":
+		var buf bytes.Buffer
+		if err := usage.WriteCSV(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export usage"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="usage-`+report.From+`-`+report.To+`.csv"`)
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format"})
+	}
+}
@@ -1,16 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
 
 	"github.com/gin-gonic/gin"
 )
 
-// UpdateSubscription 更新用户订阅
+// UpdateSubscription 订阅付费计划，首次订阅和升级需要支付，降级立即生效
 func UpdateSubscription(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
@@ -20,92 +23,80 @@ func UpdateSubscription(c *gin.Context) {
 		return
 	}
 
-	// 获取订阅计划
-	plans := models.GetSubscriptionPlans()
-	plan, exists := plans[string(req.Type)]
-	if !exists {
+	checkout, err := billingService.Subscribe(c.Request.Context(), userID.(uint), req.Type, req.Method, req.CouponCode, c.ClientIP())
+	switch {
+	case errors.Is(err, billing.ErrInvalidPlan):
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription type"})
 		return
-	}
-
-	// 获取用户
-	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+	case errors.Is(err, billing.ErrAlreadySubscribed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Already subscribed to this plan"})
+		return
+	case errors.Is(err, billing.ErrPastDue):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription renewal is past due, pay the renewal before changing plans"})
+		return
+	case err != nil:
+		if !respondCouponError(c, err) {
+			respondPaymentError(c, err)
+		}
 		return
 	}
 
-	// 设置订阅过期时间
-	expiresAt := time.Now().AddDate(0, 1, 0) // 1个月后
-
-	// 更新用户订阅
-	user.SubType = req.Type
-	user.SubExpiresAt = &expiresAt
-	user.SubAutoRenew = true
+	resp := gin.H{"subscription": checkout.Subscription}
+	if checkout.Payment != nil {
+		payment := checkout.Payment.ToResponse()
+		payment.PaymentURL = checkout.Order.PaymentURL
+		payment.PaymentQR = checkout.Order.QRCode
+		resp["payment"] = payment
+	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	// 增加积分
-	user.Credits += plan.CreditsPerMonth
+// GetCurrentSubscription 获取当前订阅
+func GetCurrentSubscription(c *gin.Context) {
+	userID, _ := c.Get("userID")
 
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+	sub, err := billingService.Current(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load subscription"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Subscription updated successfully",
-		"user":    user.ToResponse(),
-	})
-}
-
-// GetSubscriptionPlans 获取订阅计划
-func GetSubscriptionPlans(c *gin.Context) {
-	plans := models.GetSubscriptionPlans()
 	c.JSON(http.StatusOK, gin.H{
-		"plans": plans,
+		"subscription": sub,
 	})
 }
 
-// UpdateUserProfile 更新用户资料
-func UpdateUserProfile(c *gin.Context) {
+// CancelSubscription 关闭自动续费，当前周期结束后回到免费版
+func CancelSubscription(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
-	var update struct {
-		Username string `json:"username"`
-	}
-
-	if err := c.ShouldBindJSON(&update); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	sub, err := billingService.Cancel(userID.(uint))
+	if errors.Is(err, billing.ErrNoSubscription) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active subscription"})
 		return
 	}
-
-	// 检查用户名是否已存在
-	if update.Username != "" {
-		var count int64
-		database.DB.Model(&models.User{}).Where("username = ? AND id != ?", update.Username, userID).Count(&count)
-		if count > 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
-			return
-		}
-	}
-
-	// 更新用户资料
-	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel subscription"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Subscription canceled",
+		"subscription": sub,
+	})
+}
 
-	if update.Username != "" {
-		user.Username = update.Username
-	}
-
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+// GetSubscriptionPlans 获取订阅计划
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := catalog.ActivePlans(database.DB, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load subscription plans"})
 		return
 	}
 
+	responses := make(map[string]models.SubscriptionPlanResponse, len(plans))
+	for i := range plans {
+		responses[string(plans[i].Type)] = plans[i].ToResponse()
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Profile updated successfully",
-		"user":    user.ToResponse(),
+		"plans": responses,
 	})
 }
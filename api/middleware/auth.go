@@ -3,15 +3,24 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/database"
 	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/presence"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usercache"
 	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Auth 验证JWT令牌中间件
+// 认证方式
+const (
+	AuthMethodJWT   = "jwt"
+	AuthMethodToken = "token"
+)
+
+// Auth 验证JWT令牌或个人访问令牌中间件
 func Auth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -29,24 +38,125 @@ func Auth() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := utils.ParseToken(parts[1])
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
+		var userID uint
+		if utils.IsAccessToken(parts[1]) {
+			token, ok := lookupAccessToken(parts[1])
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked access token"})
+				c.Abort()
+				return
+			}
+			userID = token.UserID
+			c.Set("authMethod", AuthMethodToken)
+			c.Set("tokenScopes", token.Scopes)
+		} else {
+			claims, err := utils.ParseToken(parts[1])
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
+			userID = claims.UserID
+			c.Set("authMethod", AuthMethodJWT)
+		}
+
+		// 优先从缓存读取，用户数据变更时缓存会被失效
+		cache := usercache.Default()
+		user, ok := cache.Get(userID)
+		if !ok {
+			if err := database.DB.First(&user, userID).Error; err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+				c.Abort()
+				return
+			}
+			cache.Set(user)
 		}
 
-		var user models.User
-		if err := database.DB.First(&user, claims.UserID).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		// 封禁中的账号不能访问任何接口
+		if user.IsBanned(time.Now()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
 			c.Abort()
 			return
 		}
 
 		// 将用户ID和用户信息存储在上下文中
-		c.Set("userID", claims.UserID)
+		// 上下文中的用户可能略有滞后，积分等字段需在事务中重新读取
+		c.Set("userID", userID)
 		c.Set("user", user)
 
+		// 记录活跃时间，用于好友在线状态
+		presence.Default().Touch(userID)
+
 		c.Next()
 	}
 }
+
+// RequireScope 要求个人访问令牌拥有指定权限，JWT登录会话不受限制
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != AuthMethodToken {
+			c.Next()
+			return
+		}
+
+		scopes, _ := c.Get("tokenScopes")
+		list, _ := scopes.([]string)
+		for _, s := range list {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "Access token lacks required scope",
+			"requiredScope": scope,
+		})
+		c.Abort()
+	}
+}
+
+// SessionOnly 仅允许登录会话访问，个人访问令牌不能管理令牌或账号
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == AuthMethodToken {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an access token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRole 要求当前用户拥有指定角色
+func RequireRole(role models.UserRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("user")
+		user, ok := value.(models.User)
+		if !ok || !user.HasRole(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// lookupAccessToken 根据明文令牌查找可用的个人访问令牌
+func lookupAccessToken(raw string) (*models.PersonalAccessToken, bool) {
+	var token models.PersonalAccessToken
+	if err := database.DB.Where("token_hash = ?", utils.HashAccessToken(raw)).First(&token).Error; err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	if !token.Active(now) {
+		return nil, false
+	}
+
+	// 最近使用时间精确到分钟即可，避免每次请求都写库
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		database.DB.Model(&token).UpdateColumn("last_used_at", now)
+	}
+	return &token, true
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/entitlements"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DailyQuota 要求当前用户今日的用量未超过订阅计划的上限
+func DailyQuota(feature entitlements.Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("user")
+		user, _ := value.(models.User)
+		err := entitlements.Default().CheckDailyUsage(database.DB, &user, feature, time.Now())
+		if !abortUpgrade(c, err) {
+			c.Next()
+		}
+	}
+}
+
+// abortUpgrade 权限不足或检查失败时中止请求
+func abortUpgrade(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var upgrade *entitlements.UpgradeError
+	if errors.As(err, &upgrade) {
+		c.AbortWithStatusJSON(http.StatusForbidden, upgrade.Response())
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check plan limits"})
+	return true
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"github.com/BinLe1988/multi-agent-chatter/api/handlers"
 	"github.com/BinLe1988/multi-agent-chatter/api/middleware"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/entitlements"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -19,6 +21,9 @@ func SetupRouter(router *gin.Engine) {
 
 	router.Use(cors.New(config))
 
+	// 令牌验证公钥
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// 公共API
 	public := router.Group("/api")
 	{
@@ -26,38 +31,135 @@ func SetupRouter(router *gin.Engine) {
 		public.POST("/auth/login", handlers.Login)
 		public.POST("/auth/register", handlers.Register)
 
-		// 支付回调
-		public.POST("/payments/callback", handlers.HandlePaymentCallback)
+		// 支付回调，由各网关实现验证签名
+		public.POST("/payments/callback/:method", handlers.HandleGatewayCallback)
 	}
 
-	// 需要认证的API
+	// 需要认证的API，登录会话和个人访问令牌均可访问
+	// 个人访问令牌只能访问声明了权限范围的接口
 	authorized := router.Group("/api")
 	authorized.Use(middleware.Auth())
+
+	chatRead := middleware.RequireScope(models.ScopeChatRead)
+	chatWrite := middleware.RequireScope(models.ScopeChatWrite)
+	paymentsRead := middleware.RequireScope(models.ScopePaymentsRead)
+	paymentsWrite := middleware.RequireScope(models.ScopePaymentsWrite)
+	profileRead := middleware.RequireScope(models.ScopeProfileRead)
+	profileWrite := middleware.RequireScope(models.ScopeProfileWrite)
+	messageQuota := middleware.DailyQuota(entitlements.FeatureDailyMessages)
+	matchQuota := middleware.DailyQuota(entitlements.FeatureDailyMatches)
 	{
 		// 用户相关
-		authorized.GET("/user", handlers.GetCurrentUser)
-		authorized.PUT("/user/profile", handlers.UpdateUserProfile)
-		authorized.POST("/auth/logout", handlers.Logout)
+		authorized.GET("/user", profileRead, handlers.GetCurrentUser)
+		authorized.GET("/user/profile", profileRead, handlers.GetUserProfile)
+		authorized.PUT("/user/profile", profileWrite, handlers.UpdateUserProfile)
+		authorized.POST("/user/avatar", profileWrite, handlers.UploadAvatar)
 
 		// 订阅相关
-		authorized.GET("/subscriptions", handlers.GetSubscriptionPlans)
-		authorized.POST("/subscriptions", handlers.UpdateSubscription)
+		authorized.GET("/subscriptions", paymentsRead, handlers.GetSubscriptionPlans)
+		authorized.POST("/subscriptions", paymentsWrite, handlers.UpdateSubscription)
+		authorized.GET("/subscriptions/current", paymentsRead, handlers.GetCurrentSubscription)
+		authorized.POST("/subscriptions/cancel", paymentsWrite, handlers.CancelSubscription)
+		authorized.GET("/subscriptions/entitlements", paymentsRead, handlers.GetEntitlements)
 
 		// 充值相关
-		authorized.GET("/recharge/packages", handlers.GetRechargePackages)
-		authorized.POST("/recharge", handlers.CreateRechargeOrder)
-		authorized.GET("/payments", handlers.GetPaymentHistory)
-		authorized.GET("/payments/:orderNo", handlers.CheckPaymentStatus)
+		authorized.GET("/recharge/packages", paymentsRead, handlers.GetRechargePackages)
+		authorized.POST("/recharge", paymentsWrite, handlers.CreateRechargeOrder)
+		authorized.GET("/payments", paymentsRead, handlers.GetPaymentHistory)
+		authorized.GET("/payments/:orderNo", paymentsRead, handlers.CheckPaymentStatus)
+		authorized.GET("/credits/history", paymentsRead, handlers.GetCreditHistory)
+		authorized.GET("/usage", paymentsRead, handlers.GetUsage)
+
+		// 票据和开票信息
+		authorized.GET("/invoices", paymentsRead, handlers.GetInvoices)
+		authorized.GET("/invoices/:number", paymentsRead, handlers.GetInvoice)
+		authorized.GET("/invoices/:number/download", paymentsRead, handlers.DownloadInvoice)
+		authorized.GET("/billing/profile", paymentsRead, handlers.GetBillingProfile)
+		authorized.PUT("/billing/profile", paymentsWrite, handlers.UpdateBillingProfile)
+
+		// 邀请
+		authorized.GET("/referrals", paymentsRead, handlers.GetReferralStats)
 
 		// 聊天相关
-		authorized.GET("/chat/sessions", handlers.GetChatSessions)
-		authorized.POST("/chat/sessions", handlers.CreateChatSession)
-		authorized.GET("/chat/sessions/:sessionId/messages", handlers.GetChatMessages)
-		authorized.POST("/chat/messages", handlers.SendChatMessage)
+		authorized.GET("/chat/sessions", chatRead, handlers.GetChatSessions)
+		authorized.POST("/chat/sessions", chatWrite, handlers.CreateChatSession)
+		authorized.GET("/chat/sessions/:sessionId/messages", chatRead, handlers.GetChatMessages)
+		authorized.POST("/chat/messages", chatWrite, messageQuota, handlers.SendChatMessage)
+		authorized.POST("/chat/sessions/:sessionId/end", chatWrite, handlers.EndConversation)
 
 		// 匹配相关
-		authorized.POST("/matching", handlers.RequestMatching)
-		authorized.GET("/matching/status", handlers.GetMatchingStatus)
-		authorized.DELETE("/matching", handlers.CancelMatching)
+		authorized.POST("/matching", chatWrite, matchQuota, handlers.RequestMatching)
+		authorized.GET("/matching/status", chatRead, handlers.GetMatchingStatus)
+		authorized.DELETE("/matching", chatWrite, handlers.CancelMatching)
+		authorized.POST("/matching/skip/:sessionId", chatWrite, matchQuota, handlers.SkipConversation)
+
+		// 好友相关
+		authorized.POST("/chat/sessions/:sessionId/friend-request", chatWrite, handlers.SendFriendRequest)
+		authorized.GET("/friends", chatRead, handlers.GetFriends)
+		authorized.DELETE("/friends/:friendId", chatWrite, handlers.RemoveFriend)
+		authorized.GET("/friends/requests", chatRead, handlers.GetFriendRequests)
+		authorized.POST("/friends/requests/:requestId/accept", chatWrite, handlers.AcceptFriendRequest)
+		authorized.POST("/friends/requests/:requestId/decline", chatWrite, handlers.DeclineFriendRequest)
+
+		// 屏蔽与举报
+		authorized.GET("/blocks", chatRead, handlers.GetBlockedUsers)
+		authorized.POST("/blocks", chatWrite, handlers.BlockUser)
+		authorized.DELETE("/blocks/:blockId", chatWrite, handlers.UnblockUser)
+		authorized.POST("/reports", chatWrite, handlers.ReportUser)
+	}
+
+	// 仅限登录会话的API：账号安全相关操作不允许使用个人访问令牌
+	session := authorized.Group("", middleware.SessionOnly())
+	{
+		session.POST("/auth/logout", handlers.Logout)
+
+		// 个人访问令牌
+		session.GET("/tokens", handlers.GetAccessTokens)
+		session.POST("/tokens", handlers.CreateAccessToken)
+		session.DELETE("/tokens/:tokenId", handlers.RevokeAccessToken)
+
+		// 个人数据与账号注销
+		session.POST("/user/exports", handlers.RequestDataExport)
+		session.GET("/user/exports", handlers.GetDataExports)
+		session.GET("/user/exports/:exportId/download", handlers.DownloadDataExport)
+		session.DELETE("/user", handlers.DeleteAccount)
+		session.POST("/user/deletion/cancel", handlers.CancelAccountDeletion)
+	}
+
+	// 内容审核，仅限审核员的登录会话
+	moderation := session.Group("/moderation", middleware.RequireRole(models.RoleModerator))
+	{
+		moderation.GET("/reports", handlers.GetModerationReports)
+		moderation.GET("/reports/:reportId", handlers.GetModerationReport)
+		moderation.POST("/reports/:reportId/actions", handlers.ActOnReport)
+		moderation.GET("/users/:userId/sanctions", handlers.GetUserSanctions)
+		moderation.DELETE("/sanctions/:sanctionId", handlers.RevokeSanction)
+	}
+
+	// 客服处理订单退款，仅限客服的登录会话
+	support := session.Group("/support", middleware.RequireRole(models.RoleSupport))
+	{
+		support.GET("/payments/:orderNo", handlers.SupportGetPayment)
+		support.POST("/payments/:orderNo/refunds", handlers.SupportRefundPayment)
+	}
+
+	// 商品目录、优惠券和对账管理，仅限管理员的登录会话
+	admin := session.Group("/admin", middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("/plans", handlers.AdminListPlans)
+		admin.POST("/plans", handlers.AdminCreatePlan)
+		admin.PUT("/plans/:planId", handlers.AdminUpdatePlan)
+		admin.DELETE("/plans/:planId", handlers.AdminDeletePlan)
+		admin.GET("/packages", handlers.AdminListPackages)
+		admin.POST("/packages", handlers.AdminCreatePackage)
+		admin.PUT("/packages/:packageId", handlers.AdminUpdatePackage)
+		admin.DELETE("/packages/:packageId", handlers.AdminDeletePackage)
+		admin.GET("/coupons", handlers.AdminListCoupons)
+		admin.POST("/coupons", handlers.AdminCreateCoupon)
+		admin.PUT("/coupons/:couponId", handlers.AdminUpdateCoupon)
+		admin.DELETE("/coupons/:couponId", handlers.AdminDeleteCoupon)
+		admin.GET("/coupons/:couponId/redemptions", handlers.AdminGetCouponRedemptions)
+		admin.GET("/reconciliation", handlers.AdminGetReconciliationReports)
+		admin.POST("/reconciliation", handlers.AdminRunReconciliation)
 	}
 }
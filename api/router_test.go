@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// accessToken 为用户创建拥有指定权限的个人访问令牌，返回明文
+func accessToken(t *testing.T, userID uint, scopes ...string) string {
+	t.Helper()
+	raw, prefix, hash, err := utils.GenerateAccessToken()
+	require.NoError(t, err)
+	require.NoError(t, database.DB.Create(&models.PersonalAccessToken{
+		UserID: userID, Name: "cli", Prefix: prefix, TokenHash: hash, Scopes: scopes,
+	}).Error)
+	return raw
+}
+
+func TestAccessTokenScopesAndSessionOnlyRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	database.DB = testdb.Open(t)
+	t.Cleanup(func() { database.DB = nil })
+
+	cfg := &configs.Config{}
+	cfg.Server.DevMode = true
+	cfg.JWT.Secret = "router-test-secret-0123456789abcdef"
+	cfg.JWT.ExpiresIn = 1
+	require.NoError(t, utils.InitJWT(cfg))
+
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, database.DB.Create(&user).Error)
+	jwt, err := utils.GenerateToken(user.ID)
+	require.NoError(t, err)
+	readOnly := accessToken(t, user.ID, models.ScopeChatRead, models.ScopeProfileRead)
+	writer := accessToken(t, user.ID, models.ScopeChatWrite)
+
+	router := gin.New()
+	SetupRouter(router)
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 缺少chat:write的令牌不能发送消息，拥有该权限的令牌和登录会话进入处理函数
+	w := do(http.MethodPost, "/api/chat/messages", readOnly)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), models.ScopeChatWrite)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/chat/messages", writer).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/chat/messages", jwt).Code)
+
+	// 令牌按权限读取资料，但不能管理令牌或注销账号
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user", readOnly).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user", writer).Code)
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/tokens"},
+		{http.MethodPost, "/api/tokens"},
+		{http.MethodDelete, "/api/user"},
+	} {
+		w := do(route.method, route.path, readOnly)
+		assert.Equal(t, http.StatusForbidden, w.Code, route.path)
+		assert.Contains(t, w.Body.String(), "access token", route.path)
+	}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/tokens", jwt).Code)
+
+	// 撤销后的令牌在检查权限之前即被拒绝
+	require.NoError(t, database.DB.Model(&models.PersonalAccessToken{}).Where("token_hash = ?", utils.HashAccessToken(readOnly)).
+		Update("revoked_at", time.Now()).Error)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user", readOnly).Code)
+}
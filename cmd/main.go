@@ -2,10 +2,30 @@ package main
 
 import (
 	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/api"
+	"github.com/BinLe1988/multi-agent-chatter/api/handlers"
 	"github.com/BinLe1988/multi-agent-chatter/configs"
 	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/ai"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/billing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/entitlements"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/invoices"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/lockout"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/pricing"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/privacy"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/storage"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usage"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usercache"
 	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -19,7 +39,19 @@ func main() {
 	}
 
 	// 初始化JWT
-	utils.InitJWT(cfg)
+	if err := utils.InitJWT(cfg); err != nil {
+		log.Fatalf("Failed to initialize JWT: %v", err)
+	}
+
+	// 初始化AI服务和消息价格表
+	ai.InitConfig(cfg)
+	handlers.InitPricing(pricing.NewTable(cfg.Pricing, cfg.AI.Model))
+
+	// 初始化订阅计划的功能权限
+	entitlements.Init(cfg.Entitlements)
+
+	// 初始化认证用户缓存
+	usercache.Init(cfg.Cache.UserTTL, cfg.Cache.UserMaxEntries)
 
 	// 初始化数据库连接
 	if err := database.Initialize(cfg); err != nil {
@@ -27,15 +59,149 @@ func main() {
 	}
 	defer database.Close()
 
+	// 退回上次运行中断时未结算的积分预留，其他实例正在处理的请求的预留不受影响
+	if released, err := credits.ReleaseStale(database.DB, time.Now().Add(-credits.ReservationTTL)); err != nil {
+		log.Printf("Failed to release stale credit reservations: %v", err)
+	} else if released > 0 {
+		log.Printf("Released %d stale credit reservations", released)
+	}
+
+	// 核对积分余额与流水，补记账本上线前的历史余额
+	if adjusted, err := credits.ReconcileAll(database.DB); err != nil {
+		log.Printf("Failed to reconcile credit ledger: %v", err)
+	} else if adjusted > 0 {
+		log.Printf("Reconciled credit ledger for %d users", adjusted)
+	}
+
+	// 首次启动时写入默认的订阅计划和充值套餐
+	if err := catalog.Seed(database.DB); err != nil {
+		log.Fatalf("Failed to seed catalog: %v", err)
+	}
+
+	// 初始化登录防护
+	handlers.InitLoginGuard(cfg, lockout.NewMemoryStore())
+
+	// 初始化文件存储
+	store, err := storage.NewLocalStore(cfg.Storage.Local.Root, cfg.Storage.Local.BaseURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	handlers.InitBlobStore(store)
+
+	// 数据导出写入私有存储，只能通过鉴权的下载接口读取
+	privateRoot := cfg.Storage.Private.Root
+	if privateRoot == "" {
+		privateRoot = "./data/private"
+	}
+	if within(privateRoot, store.Root()) {
+		log.Fatalf("storage.private.root must not be inside the public storage root %s", store.Root())
+	}
+	privateStore, err := storage.NewLocalStore(privateRoot, "")
+	if err != nil {
+		log.Fatalf("Failed to initialize private storage: %v", err)
+	}
+
+	// 邀请奖励在支付履约时判定，由续费任务在冻结期结束后入账
+	referralMinAmount, err := money.Parse(cfg.Referral.MinAmount)
+	if cfg.Referral.MinAmount != "" && err != nil {
+		log.Fatalf("Invalid referral.min_amount %q: %v", cfg.Referral.MinAmount, err)
+	}
+	referralConfig := referrals.Config{
+		ReferrerCredits: cfg.Referral.ReferrerCredits,
+		InviteeCredits:  cfg.Referral.InviteeCredits,
+		HoldPeriod:      cfg.Referral.HoldPeriod,
+		MaxPerReferrer:  cfg.Referral.MaxPerReferrer,
+		MinAmount:       referralMinAmount,
+		LinkBase:        cfg.Referral.LinkBase,
+	}
+	handlers.InitReferrals(referralConfig)
+
+	// 初始化支付网关，启动订阅续费和对账任务
+	paymentGateways, err := payment.NewRegistryFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize payment gateways: %v", err)
+	}
+	if err := paymentGateways.CheckProduction(cfg.Server.DevMode); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	for _, method := range paymentGateways.WithoutSettlement() {
+		log.Printf("Warning: %s gateway does not provide settlement files, daily reconciliation will skip it", method)
+	}
+	billingService := billing.NewService(database.DB, paymentGateways, billing.Config{
+		OrderTimeout:    cfg.Payment.OrderTimeout,
+		QueryAfter:      cfg.Billing.QueryAfter,
+		RenewBefore:     cfg.Billing.RenewBefore,
+		RetrySchedule:   cfg.Billing.RetrySchedule,
+		Interval:        cfg.Billing.WorkerInterval,
+		RefundShortfall: cfg.Billing.RefundShortfall,
+		Invoice: invoices.Config{
+			Prefix:           cfg.Billing.Invoice.Prefix,
+			CreditNotePrefix: cfg.Billing.Invoice.CreditNotePrefix,
+			SellerName:       cfg.Billing.Invoice.SellerName,
+			SellerTaxID:      cfg.Billing.Invoice.SellerTaxID,
+			SellerAddress:    cfg.Billing.Invoice.SellerAddress,
+			TaxName:          cfg.Billing.Invoice.TaxName,
+			TaxRate:          int(math.Round(cfg.Billing.Invoice.TaxRate * 100)),
+		},
+		Referral: referralConfig,
+	})
+	handlers.InitBillingService(billingService)
+	billingService.Start()
+	defer billingService.Stop()
+
+	// 启动个人数据导出与账号注销服务
+	privacyService := privacy.NewService(database.DB, privateStore, privacy.Config{
+		GracePeriod: cfg.Privacy.DeletionGracePeriod,
+		ExportTTL:   cfg.Privacy.ExportTTL,
+		Interval:    cfg.Privacy.WorkerInterval,
+	})
+	handlers.InitPrivacyService(privacyService)
+	privacyService.Start()
+	defer privacyService.Stop()
+
+	// 启动用量汇总任务
+	usageLocation := time.Local
+	if cfg.Usage.Timezone != "" {
+		if usageLocation, err = time.LoadLocation(cfg.Usage.Timezone); err != nil {
+			log.Fatalf("Failed to load usage timezone: %v", err)
+		}
+	}
+	usageService := usage.NewService(database.DB, usage.Config{
+		Location: usageLocation,
+		Interval: cfg.Usage.WorkerInterval,
+	})
+	handlers.InitUsageService(usageService)
+	usageService.Start()
+	defer usageService.Stop()
+
 	// 创建Gin实例
 	router := gin.Default()
 
 	// 设置路由
 	api.SetupRouter(router)
 
+	// 本地存储的文件由本服务直接提供访问
+	if strings.HasPrefix(cfg.Storage.Local.BaseURL, "/") {
+		router.Static(cfg.Storage.Local.BaseURL, store.Root())
+	}
+
 	// 启动服务器
 	log.Printf("Server starting on port %s", cfg.Server.Port)
 	if err := router.Run(":" + cfg.Server.Port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// within dir 是否为 root 或位于 root 之下
+func within(dir, root string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,54 @@
+// mockpay 本地模拟支付网关
+//
+// 与业务服务配置 payment.mock 配合使用：
+//
+//	go run ./cmd/mockpay -addr :8090 -secret <payment.mock.secret> -scenario success -delay 2s
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment/mockserver"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	baseURL := flag.String("base-url", "", "public base URL used in pay links, defaults to http://localhost<addr>")
+	secret := flag.String("secret", "", "shared signing secret, must match payment.mock.secret")
+	scenario := flag.String("scenario", string(mockserver.ScenarioSuccess), "default scenario: success, fail, timeout or manual")
+	delay := flag.Duration("delay", 2*time.Second, "delay before success or fail callbacks")
+	timeout := flag.Duration("order-timeout", 30*time.Minute, "payment deadline for orders without an expiry")
+	refundDelay := flag.Duration("refund-delay", 0, "complete refunds asynchronously after this delay, 0 settles them immediately")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("-secret is required and must match payment.mock.secret")
+	}
+
+	if *baseURL == "" {
+		host := *addr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		*baseURL = "http://" + host
+	}
+
+	server := mockserver.New(mockserver.Config{
+		Secret:       *secret,
+		BaseURL:      *baseURL,
+		Scenario:     mockserver.Scenario(*scenario),
+		Delay:        *delay,
+		OrderTimeout: *timeout,
+		RefundDelay:  *refundDelay,
+	})
+	defer server.Close()
+
+	log.Printf("Mock payment gateway listening on %s (scenario: %s)", *addr, *scenario)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatalf("Failed to start mock payment gateway: %v", err)
+	}
+}
@@ -1,12 +1,16 @@
 package configs
 
 import (
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Server struct {
-		Port string `mapstructure:"port"`
+		Port    string `mapstructure:"port"`
+		DevMode bool   `mapstructure:"dev_mode"` // 开发模式，允许使用占位密钥等不安全配置
 	} `mapstructure:"server"`
 
 	Database struct {
@@ -19,14 +23,166 @@ type Config struct {
 	} `mapstructure:"database"`
 
 	JWT struct {
-		Secret    string `mapstructure:"secret"`
-		ExpiresIn int    `mapstructure:"expires_in"` // 过期时间（小时）
+		Secret     string   `mapstructure:"secret"`      // 旧版HS256密钥，kid为default
+		ExpiresIn  int      `mapstructure:"expires_in"`  // 过期时间（小时）
+		SigningKey string   `mapstructure:"signing_key"` // 当前签名密钥的kid
+		Keys       []JWTKey `mapstructure:"keys"`        // 签名及验证密钥，轮换期间保留旧密钥用于验证
 	} `mapstructure:"jwt"`
 
 	AI struct {
-		APIKey string `mapstructure:"api_key"`
-		Model  string `mapstructure:"model"`
+		APIKey              string `mapstructure:"api_key"`
+		Model               string `mapstructure:"model"`
+		MaxCompletionTokens int    `mapstructure:"max_completion_tokens"` // 单条回复的最大token数
 	} `mapstructure:"ai"`
+
+	Pricing PricingConfig `mapstructure:"pricing"`
+
+	Entitlements map[string]EntitlementConfig `mapstructure:"entitlements"` // 按订阅计划配置，未配置的计划使用默认值
+
+	Storage struct {
+		Local struct {
+			Root    string `mapstructure:"root"`     // 本地存储目录
+			BaseURL string `mapstructure:"base_url"` // 对外访问前缀
+		} `mapstructure:"local"`
+		Private struct {
+			Root string `mapstructure:"root"` // 不对外提供访问的存储目录，存放数据导出等私有文件
+		} `mapstructure:"private"`
+	} `mapstructure:"storage"`
+
+	Privacy struct {
+		DeletionGracePeriod time.Duration `mapstructure:"deletion_grace_period"` // 注销宽限期
+		ExportTTL           time.Duration `mapstructure:"export_ttl"`            // 导出文件保留时长
+		WorkerInterval      time.Duration `mapstructure:"worker_interval"`       // 后台任务执行间隔
+	} `mapstructure:"privacy"`
+
+	Cache struct {
+		UserTTL        time.Duration `mapstructure:"user_ttl"`         // 认证用户缓存时长
+		UserMaxEntries int           `mapstructure:"user_max_entries"` // 认证用户缓存容量
+	} `mapstructure:"cache"`
+
+	Payment PaymentConfig `mapstructure:"payment"`
+
+	Billing struct {
+		RenewBefore    time.Duration   `mapstructure:"renew_before"`    // 到期前多久发起自动续费
+		RetrySchedule  []time.Duration `mapstructure:"retry_schedule"`  // 续费失败后的重试间隔，用完后订阅结束
+		WorkerInterval time.Duration   `mapstructure:"worker_interval"` // 续费和对账任务执行间隔
+		QueryAfter     time.Duration   `mapstructure:"query_after"`     // 下单后多久未收到回调时主动查询网关
+		// 退款时积分已消耗的处理方式：debt 余额扣为负数，waive 只扣回剩余余额
+		RefundShortfall string `mapstructure:"refund_shortfall"`
+		// 票据的编号前缀、销售方信息和税率，价格为含税价
+		Invoice struct {
+			Prefix           string  `mapstructure:"prefix"`
+			CreditNotePrefix string  `mapstructure:"credit_note_prefix"`
+			SellerName       string  `mapstructure:"seller_name"`
+			SellerTaxID      string  `mapstructure:"seller_tax_id"`
+			SellerAddress    string  `mapstructure:"seller_address"`
+			TaxName          string  `mapstructure:"tax_name"`
+			TaxRate          float64 `mapstructure:"tax_rate"` // 百分比，如6表示6%
+		} `mapstructure:"invoice"`
+	} `mapstructure:"billing"`
+
+	Referral struct {
+		ReferrerCredits int           `mapstructure:"referrer_credits"` // 邀请人获得的积分
+		InviteeCredits  int           `mapstructure:"invitee_credits"`  // 被邀请人获得的积分
+		HoldPeriod      time.Duration `mapstructure:"hold_period"`      // 奖励冻结期，冻结期内首次支付退款则奖励作废
+		MaxPerReferrer  int           `mapstructure:"max_per_referrer"` // 每个邀请人可获得奖励的次数上限，0为不限
+		MinAmount       string        `mapstructure:"min_amount"`       // 首次支付的最低实付金额，如"10.00"
+		LinkBase        string        `mapstructure:"link_base"`        // 邀请链接前缀，邀请码拼接在其后
+	} `mapstructure:"referral"`
+
+	Usage struct {
+		Timezone       string        `mapstructure:"timezone"`        // 按该时区的自然日汇总用量，为空时使用本地时区
+		WorkerInterval time.Duration `mapstructure:"worker_interval"` // 用量汇总任务执行间隔
+	} `mapstructure:"usage"`
+
+	Security struct {
+		Login struct {
+			MaxAttempts     int           `mapstructure:"max_attempts"`     // 单账号触发锁定的失败次数
+			IPMaxAttempts   int           `mapstructure:"ip_max_attempts"`  // 单IP触发锁定的失败次数
+			FreeAttempts    int           `mapstructure:"free_attempts"`    // 不触发退避的失败次数
+			BackoffBase     time.Duration `mapstructure:"backoff_base"`     // 退避基础时长
+			LockoutDuration time.Duration `mapstructure:"lockout_duration"` // 首次锁定时长
+			MaxLockout      time.Duration `mapstructure:"max_lockout"`      // 最长锁定时长
+			Window          time.Duration `mapstructure:"window"`           // 失败计数窗口
+		} `mapstructure:"login"`
+	} `mapstructure:"security"`
+}
+
+// PaymentConfig 支付网关配置
+// 未配置商户信息的支付方式在启用模拟网关时由模拟网关处理
+type PaymentConfig struct {
+	NotifyBaseURL string        `mapstructure:"notify_base_url"` // 支付回调地址前缀，需要公网可访问
+	ReturnURL     string        `mapstructure:"return_url"`      // 支付完成后的跳转页面
+	OrderTimeout  time.Duration `mapstructure:"order_timeout"`   // 订单支付超时时间
+
+	Mock struct {
+		Enabled bool   `mapstructure:"enabled"`
+		BaseURL string `mapstructure:"base_url"` // 模拟网关服务地址
+		Secret  string `mapstructure:"secret"`   // 与模拟网关共享的签名密钥
+	} `mapstructure:"mock"`
+
+	Alipay struct {
+		AppID               string `mapstructure:"app_id"`
+		PrivateKeyFile      string `mapstructure:"private_key_file"`       // 应用私钥
+		AlipayPublicKeyFile string `mapstructure:"alipay_public_key_file"` // 支付宝公钥
+		GatewayURL          string `mapstructure:"gateway_url"`
+	} `mapstructure:"alipay"`
+
+	Wechat struct {
+		MchID            string `mapstructure:"mch_id"`
+		AppID            string `mapstructure:"app_id"`
+		SerialNo         string `mapstructure:"serial_no"`          // 商户证书序列号
+		PrivateKeyFile   string `mapstructure:"private_key_file"`   // 商户私钥
+		PlatformCertFile string `mapstructure:"platform_cert_file"` // 微信支付平台证书
+		APIv3Key         string `mapstructure:"api_v3_key"`
+		BaseURL          string `mapstructure:"base_url"`
+	} `mapstructure:"wechat"`
+
+	UnionPay struct {
+		MerID          string `mapstructure:"mer_id"`
+		CertID         string `mapstructure:"cert_id"`          // 签名证书序列号
+		PrivateKeyFile string `mapstructure:"private_key_file"` // 商户签名私钥
+		PublicKeyFile  string `mapstructure:"public_key_file"`  // 银联验签公钥
+		BaseURL        string `mapstructure:"base_url"`
+	} `mapstructure:"unionpay"`
+
+	CreditCard struct {
+		SecretKey     string `mapstructure:"secret_key"`     // 收单机构API密钥
+		WebhookSecret string `mapstructure:"webhook_secret"` // 回调签名密钥
+		BaseURL       string `mapstructure:"base_url"`
+	} `mapstructure:"creditcard"`
+}
+
+// PricingConfig AI消息计价配置，按智能体、模型、默认价格的顺序匹配
+type PricingConfig struct {
+	Default ModelPrice            `mapstructure:"default"`
+	Models  map[string]ModelPrice `mapstructure:"models"`
+	Agents  map[string]ModelPrice `mapstructure:"agents"`
+}
+
+// ModelPrice 每千token消耗的积分
+type ModelPrice struct {
+	PromptPer1K     float64 `mapstructure:"prompt_per_1k"`
+	CompletionPer1K float64 `mapstructure:"completion_per_1k"`
+	Minimum         int     `mapstructure:"minimum"` // 每条消息的最低消耗
+}
+
+// EntitlementConfig 订阅计划的功能权限和用量限制
+type EntitlementConfig struct {
+	Models             []string `mapstructure:"models"`              // 可使用的AI模型，为空时可使用价格表中的全部模型
+	MaxGroupSize       int      `mapstructure:"max_group_size"`      // 可创建群聊的人数上限，为0时不能创建群聊
+	DailyMessages      int      `mapstructure:"daily_messages"`      // 每日发送消息数上限，为0时不限制
+	DailyMatches       int      `mapstructure:"daily_matches"`       // 每日随机匹配次数上限，为0时不限制
+	VoiceTranscription bool     `mapstructure:"voice_transcription"` // 能否向AI发送语音消息
+}
+
+// JWTKey JWT密钥配置
+type JWTKey struct {
+	ID             string `mapstructure:"kid"`
+	Algorithm      string `mapstructure:"alg"`              // HS256、RS256 或 EdDSA
+	Secret         string `mapstructure:"secret"`           // HS256密钥
+	PrivateKeyFile string `mapstructure:"private_key_file"` // PEM私钥，用于签名
+	PublicKeyFile  string `mapstructure:"public_key_file"`  // PEM公钥，仅用于验证
 }
 
 // Load 加载配置
@@ -36,6 +192,11 @@ func Load() (*Config, error) {
 	viper.AddConfigPath("./configs")
 	viper.AddConfigPath(".")
 
+	// 支持通过环境变量覆盖配置，如 CHATTER_JWT_SECRET
+	viper.SetEnvPrefix("chatter")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usercache"
+
+	"gorm.io/gorm"
+)
+
+// registerUserCacheInvalidation 在用户表发生写操作后使缓存失效
+// 能确定主键时只失效对应用户，否则（如按条件批量更新、原生SQL）清空整个缓存；
+// 事务中的写操作在提交后才失效，避免提交前的并发请求把旧数据重新写入缓存
+func registerUserCacheInvalidation(db *gorm.DB) error {
+	db.ConnPool = &cachePool{ConnPool: db.ConnPool}
+	db.Statement.ConnPool = db.ConnPool
+
+	callbacks := []struct {
+		processor interface {
+			Register(name string, fn func(*gorm.DB)) error
+		}
+		after string
+	}{
+		{db.Callback().Create().After("gorm:create"), "create"},
+		{db.Callback().Update().After("gorm:update"), "update"},
+		{db.Callback().Delete().After("gorm:delete"), "delete"},
+	}
+	for _, cb := range callbacks {
+		if err := cb.processor.Register("usercache:invalidate_"+cb.after, invalidateUserCache); err != nil {
+			return err
+		}
+	}
+
+	return db.Callback().Raw().After("gorm:raw").Register("usercache:invalidate_raw", func(tx *gorm.DB) {
+		if strings.Contains(strings.ToLower(tx.Statement.SQL.String()), "users") {
+			afterCommit(tx).flush()
+		}
+	})
+}
+
+// cachePool 包装连接池，开启的事务记录需要失效的用户，提交后再失效
+type cachePool struct {
+	gorm.ConnPool
+}
+
+// BeginTx 实现gorm.ConnPoolBeginner
+func (p *cachePool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	beginner, ok := p.ConnPool.(gorm.TxBeginner)
+	if !ok {
+		return nil, gorm.ErrInvalidTransaction
+	}
+	tx, err := beginner.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTx{Tx: tx, pool: p}, nil
+}
+
+// GetDBConn 实现gorm.GetDBConnector
+func (p *cachePool) GetDBConn() (*sql.DB, error) {
+	switch pool := p.ConnPool.(type) {
+	case *sql.DB:
+		return pool, nil
+	case gorm.GetDBConnector:
+		return pool.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// cacheTx 提交后才使缓存失效的事务，回滚时丢弃
+type cacheTx struct {
+	*sql.Tx
+	pool *cachePool
+
+	mu    sync.Mutex
+	ids   []uint
+	flush bool
+}
+
+// Commit 实现gorm.TxCommitter
+func (t *cacheTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	ids, flush := t.ids, t.flush
+	t.mu.Unlock()
+	if flush {
+		usercache.Default().Flush()
+	} else if len(ids) > 0 {
+		usercache.Default().Invalidate(ids...)
+	}
+	return nil
+}
+
+// GetDBConn 实现gorm.GetDBConnector
+func (t *cacheTx) GetDBConn() (*sql.DB, error) {
+	return t.pool.GetDBConn()
+}
+
+// invalidation 一次写操作需要的缓存失效，在事务中时推迟到提交后
+type invalidation struct {
+	tx *cacheTx
+}
+
+func afterCommit(db *gorm.DB) invalidation {
+	tx, _ := db.Statement.ConnPool.(*cacheTx)
+	return invalidation{tx: tx}
+}
+
+func (i invalidation) invalidate(ids ...uint) {
+	if i.tx == nil {
+		usercache.Default().Invalidate(ids...)
+		return
+	}
+	i.tx.mu.Lock()
+	i.tx.ids = append(i.tx.ids, ids...)
+	i.tx.mu.Unlock()
+}
+
+func (i invalidation) flush() {
+	if i.tx == nil {
+		usercache.Default().Flush()
+		return
+	}
+	i.tx.mu.Lock()
+	i.tx.flush = true
+	i.tx.mu.Unlock()
+}
+
+func invalidateUserCache(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "users" {
+		return
+	}
+
+	field := tx.Statement.Schema.PrioritizedPrimaryField
+	rv := tx.Statement.ReflectValue
+	var ids []uint
+
+	collect := func(v reflect.Value) {
+		if field == nil || v.Kind() != reflect.Struct {
+			return
+		}
+		if value, zero := field.ValueOf(tx.Statement.Context, v); !zero {
+			if id, ok := value.(uint); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		collect(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			collect(reflect.Indirect(rv.Index(i)))
+		}
+	}
+
+	// 按条件批量操作时无法确定影响了哪些用户
+	if len(ids) == 0 {
+		afterCommit(tx).flush()
+		return
+	}
+	afterCommit(tx).invalidate(ids...)
+}
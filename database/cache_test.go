@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/api/middleware"
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/database"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/usercache"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestSanctionsReachAuthOnlyAfterCommit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	require.NoError(t, database.RegisterUserCacheInvalidation(db))
+	database.DB = db
+	usercache.Init(time.Hour, 100)
+	t.Cleanup(func() {
+		database.DB = nil
+		usercache.Init(0, 0)
+	})
+
+	cfg := &configs.Config{}
+	cfg.Server.DevMode = true
+	cfg.JWT.Secret = "cache-test-secret-0123456789abcdef"
+	cfg.JWT.ExpiresIn = 1
+	require.NoError(t, utils.InitJWT(cfg))
+
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(&user).Error)
+	token, err := utils.GenerateToken(user.ID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/me", middleware.Auth(), func(c *gin.Context) {
+		u := c.MustGet("user").(models.User)
+		c.JSON(http.StatusOK, gin.H{"canChat": u.CanChat(time.Now())})
+	})
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	require.Equal(t, http.StatusOK, get().Code)
+
+	// 暂停在提交前被并发请求读到旧数据并写回缓存，提交后仍然失效
+	until := time.Now().Add(time.Hour)
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("suspended_until", until).Error; err != nil {
+			return err
+		}
+		w := get()
+		assert.JSONEq(t, `{"canChat":true}`, w.Body.String())
+		return nil
+	}))
+	w := get()
+	assert.JSONEq(t, `{"canChat":false}`, w.Body.String())
+
+	// 回滚的封禁不影响缓存
+	assert.Error(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("banned_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return gorm.ErrInvalidData
+	}))
+	assert.Equal(t, http.StatusOK, get().Code)
+
+	// 按条件批量封禁同样在提交后生效
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("email = ?", user.Email).Update("banned_at", time.Now()).Error; err != nil {
+			return err
+		}
+		assert.Equal(t, http.StatusOK, get().Code)
+		return nil
+	}))
+	assert.Equal(t, http.StatusForbidden, get().Code)
+}
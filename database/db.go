@@ -40,15 +40,25 @@ func Initialize(cfg *configs.Config) error {
 	}
 
 	// 自动迁移数据库表
-	err = DB.AutoMigrate(
-		&models.User{},
-		&models.ChatSession{},
-		&models.Payment{},
-		&models.ChatMessage{},
-	)
+	addingPeriodPaid := DB.Migrator().HasTable(&models.Subscription{}) &&
+		!DB.Migrator().HasColumn(&models.Subscription{}, "period_paid_cents")
+	err = DB.AutoMigrate(Models()...)
 	if err != nil {
 		return err
 	}
+	if err := migrateMoneyColumns(DB); err != nil {
+		return fmt.Errorf("failed to migrate money columns: %w", err)
+	}
+	if addingPeriodPaid {
+		if err := backfillPeriodPaid(DB); err != nil {
+			return fmt.Errorf("failed to backfill subscription period payments: %w", err)
+		}
+	}
+
+	// 用户数据变更时使认证缓存失效
+	if err := registerUserCacheInvalidation(DB); err != nil {
+		return err
+	}
 
 	log.Println("Database connected successfully")
 	return nil
@@ -67,3 +77,42 @@ func Close() {
 		}
 	}
 }
+
+// Models 需要自动迁移的全部数据表
+func Models() []interface{} {
+	return []interface{}{
+		&models.User{},
+		&models.ChatSession{},
+		&models.Payment{},
+		&models.Refund{},
+		&models.ChatMessage{},
+		&models.LockoutAudit{},
+		&models.UserProfile{},
+		&models.Interest{},
+		&models.Tag{},
+		&models.UserBehavior{},
+		&models.DataExport{},
+		&models.PersonalAccessToken{},
+		&models.FriendRequest{},
+		&models.Friendship{},
+		&models.UserBlock{},
+		&models.Report{},
+		&models.UserSanction{},
+		&models.CreditTransaction{},
+		&models.CreditReservation{},
+		&models.Subscription{},
+		&models.SubscriptionPlan{},
+		&models.RechargePackage{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.CouponReservation{},
+		&models.ReconciliationReport{},
+		&models.Invoice{},
+		&models.InvoiceSequence{},
+		&models.BillingProfile{},
+		&models.ReferralCode{},
+		&models.Referral{},
+		&models.UsageRollup{},
+		&models.CreditRollup{},
+	}
+}
@@ -0,0 +1,4 @@
+package database
+
+// RegisterUserCacheInvalidation 供外部测试包为测试数据库注册缓存失效
+var RegisterUserCacheInvalidation = registerUserCacheInvalidation
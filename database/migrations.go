@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+// moneyColumn 金额从浮点元迁移到整数分的列
+type moneyColumn struct {
+	model interface{}
+	from  string // 旧的浮点列，单位：元
+	to    string // 新的整数列，单位：分
+}
+
+// moneyColumns 需要迁移的金额列
+var moneyColumns = []moneyColumn{
+	{&models.Payment{}, "amount", "amount_cents"},
+	{&models.Payment{}, "refunded_amount", "refunded_amount_cents"},
+	{&models.Payment{}, "discount", "discount_cents"},
+	{&models.Refund{}, "amount", "amount_cents"},
+	{&models.RechargePackage{}, "price", "price_cents"},
+	{&models.SubscriptionPlan{}, "price", "price_cents"},
+	{&models.Subscription{}, "proration_credit", "proration_credit_cents"},
+	{&models.CouponRedemption{}, "discount", "discount_cents"},
+	{&models.ReconciliationReport{}, "gateway_amount", "gateway_amount_cents"},
+	{&models.ReconciliationReport{}, "local_amount", "local_amount_cents"},
+}
+
+// migrateMoneyColumns 把旧的浮点金额列按四舍五入换算为分写入新列后删除旧列，需在AutoMigrate之后执行
+// 旧列不存在时跳过；换算只依赖旧列，中途失败后可以重复执行
+func migrateMoneyColumns(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, c := range moneyColumns {
+		if !migrator.HasColumn(c.model, c.from) {
+			continue
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(c.model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		if err := db.Exec(fmt.Sprintf("UPDATE %s SET %s = ROUND(COALESCE(%s, 0) * 100)", table, c.to, c.from)).Error; err != nil {
+			return err
+		}
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, c.from)).Error; err != nil {
+			return err
+		}
+		log.Printf("Migrated %s.%s to %s", table, c.from, c.to)
+	}
+
+	// 立减券的金额原来保存在value列，迁移后value清零
+	return db.Model(&models.Coupon{}).Where("kind = ? AND value > 0", models.CouponAmountOff).
+		Updates(map[string]interface{}{
+			"amount_off_cents": gorm.Expr("ROUND(value * 100)"),
+			"value":            0,
+		}).Error
+}
+
+// backfillPeriodPaid 新增本周期实付金额列时，未结束的订阅按签约版本的价格补齐，与之前按全价折算降级的行为一致
+func backfillPeriodPaid(db *gorm.DB) error {
+	return db.Model(&models.Subscription{}).
+		Where("status IN ?", []models.SubscriptionStatus{models.SubscriptionActive, models.SubscriptionPastDue}).
+		Update("period_paid_cents", gorm.Expr(
+			"COALESCE((SELECT price_cents FROM subscription_plans WHERE subscription_plans.id = subscriptions.plan_version_id), 0)")).Error
+}
@@ -0,0 +1,25 @@
+// Package testdb 测试用的临时SQLite数据库
+package testdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/BinLe1988/multi-agent-chatter/database"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open 创建迁移了全部数据表的临时SQLite数据库，测试结束后自动删除
+// 写事务立即加锁并等待忙锁，并发测试中的条件更新按顺序执行
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=10000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(database.Models()...))
+	return db
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"gorm.io/gorm"
 	"time"
 )
@@ -21,17 +22,96 @@ const (
 	SessionAI       SessionType = "ai"
 	SessionStranger SessionType = "stranger"
 	SessionGroup    SessionType = "group"
+	SessionDirect   SessionType = "direct" // 好友之间的长期会话
 )
 
 // ChatSession 聊天会话
 type ChatSession struct {
 	gorm.Model
-	UserID    uint        `gorm:"not null" json:"userId"`
-	Type      SessionType `gorm:"size:20;not null" json:"type"`
-	Title     string      `gorm:"size:100" json:"title"`
-	LastActive time.Time   `json:"lastActive"`
-	Meta      string      `gorm:"type:json" json:"meta"` // 存储元数据，比如AI会话的模型、参数等
-	Messages  []ChatMessage `gorm:"foreignKey:SessionID" json:"-"`
+	UserID        uint          `gorm:"not null" json:"userId"`
+	Type          SessionType   `gorm:"size:20;not null" json:"type"`
+	Title         string        `gorm:"size:100" json:"title"`
+	LastActive    time.Time     `json:"lastActive"`
+	Meta          string        `gorm:"type:json" json:"meta"` // 存储元数据，比如AI会话的模型、参数等
+	PeerSessionID uint          `gorm:"index" json:"-"`        // 陌生人/好友会话中对方的会话ID，消息会同步写入对方会话
+	EndedAt       *time.Time    `json:"endedAt"`               // 陌生人会话结束时间，结束后双方只读
+	Messages      []ChatMessage `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+// Pseudonym 陌生人会话中随机分配的化名，每个会话各不相同
+type Pseudonym struct {
+	Handle string `json:"handle"` // 会话内的发送者标识
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"` // 匿名头像标识
+	Color  string `json:"color"`
+}
+
+// StrangerMeta 陌生人会话的元数据，只包含化名，不包含对方的真实身份
+type StrangerMeta struct {
+	Alias     Pseudonym        `json:"alias"`             // 自己的化名
+	PeerAlias Pseudonym        `json:"peerAlias"`         // 对方的化名
+	Request   *MatchingRequest `json:"request,omitempty"` // 匹配时使用的条件，跳过时复用
+}
+
+// ParseStrangerMeta 解析陌生人会话的元数据
+func (s *ChatSession) ParseStrangerMeta() (StrangerMeta, error) {
+	var meta StrangerMeta
+	if s.Meta == "" {
+		return meta, nil
+	}
+	err := json.Unmarshal([]byte(s.Meta), &meta)
+	return meta, err
+}
+
+// AIMeta AI会话的元数据
+type AIMeta struct {
+	Agent string `json:"agent,omitempty"` // 智能体标识，可按智能体单独计价
+	Model string `json:"model,omitempty"` // 只能选择价格表中的模型
+}
+
+// ParseAIMeta 解析AI会话的元数据
+func (s *ChatSession) ParseAIMeta() (AIMeta, error) {
+	var meta AIMeta
+	if s.Meta == "" {
+		return meta, nil
+	}
+	err := json.Unmarshal([]byte(s.Meta), &meta)
+	return meta, err
+}
+
+// GroupMeta 群聊会话的元数据
+type GroupMeta struct {
+	MaxMembers int `json:"maxMembers"` // 人数上限，受创建者订阅计划限制
+}
+
+// ParseGroupMeta 解析群聊会话的元数据
+func (s *ChatSession) ParseGroupMeta() (GroupMeta, error) {
+	var meta GroupMeta
+	if s.Meta == "" {
+		return meta, nil
+	}
+	err := json.Unmarshal([]byte(s.Meta), &meta)
+	return meta, err
+}
+
+// AIUsage AI回复的用量和消耗的积分，记录在回复消息的元数据中
+type AIUsage struct {
+	Model            string `json:"model"`
+	Agent            string `json:"agent,omitempty"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	EstimatedCost    int    `json:"estimatedCost"` // 调用前预扣的积分
+	Cost             int    `json:"cost"`          // 实际消耗的积分
+}
+
+// RedactMeta 陌生人会话的元数据只保留化名，去除旧数据中可能存在的对方身份信息
+func (s *ChatSession) RedactMeta() {
+	if s.Type != SessionStranger {
+		return
+	}
+	meta, _ := s.ParseStrangerMeta()
+	data, _ := json.Marshal(meta)
+	s.Meta = string(data)
 }
 
 // ChatMessage 聊天消息
@@ -52,15 +132,17 @@ type ChatRequest struct {
 	Type      MessageType `json:"type" default:"text"`
 }
 
-// ChatResponse 聊天响应
+// ChatResponse 聊天响应，陌生人会话的消息以此返回，不包含发送者的真实身份
 type ChatResponse struct {
-	ID        uint      `json:"id"`
-	SessionID uint      `json:"sessionId"`
-	SenderID  string    `json:"senderId"`
-	Content   string    `json:"content"`
+	ID        uint        `json:"id"`
+	SessionID uint        `json:"sessionId"`
+	SenderID  string      `json:"senderId"`
+	Content   string      `json:"content"`
 	Type      MessageType `json:"type"`
-	CreatedAt time.Time `json:"createdAt"`
+	CreatedAt time.Time   `json:"createdAt"`
 	Metadata  interface{} `json:"metadata,omitempty"`
+	Sender    *Pseudonym  `json:"sender,omitempty"` // 陌生人会话中发送者的化名
+	IsOwn     bool        `json:"isOwn"`
 }
 
 // MatchingRequest 匹配请求
@@ -69,3 +151,38 @@ type MatchingRequest struct {
 	AgeRange  [2]int   `json:"ageRange"`
 	Gender    string   `json:"gender"`
 }
+
+// ToStrangerResponse 以化名的形式转换陌生人会话中的消息
+func (m *ChatMessage) ToStrangerResponse(viewerID uint, meta StrangerMeta) ChatResponse {
+	resp := ChatResponse{
+		ID:        m.ID,
+		SessionID: m.SessionID,
+		SenderID:  m.SenderID,
+		Content:   m.Content,
+		Type:      m.Type,
+		CreatedAt: m.CreatedAt,
+	}
+	if m.Metadata != "" && json.Valid([]byte(m.Metadata)) {
+		resp.Metadata = json.RawMessage(m.Metadata)
+	}
+
+	// 用户消息的发送者替换为化名，系统消息保持不变
+	if m.UserID != 0 {
+		alias := meta.PeerAlias
+		if m.UserID == viewerID {
+			alias = meta.Alias
+			resp.IsOwn = true
+		}
+		if alias.Handle == "" {
+			alias.Handle = "stranger"
+			if resp.IsOwn {
+				alias.Handle = "me"
+			}
+		}
+		resp.SenderID = alias.Handle
+		resp.Sender = &alias
+	} else if m.SenderID != "system" {
+		resp.SenderID = "stranger"
+	}
+	return resp
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// CouponKind 优惠券类型
+type CouponKind string
+
+const (
+	CouponPercentOff   CouponKind = "percent_off"   // 按百分比折扣，Value为折扣百分比，最多两位小数
+	CouponAmountOff    CouponKind = "amount_off"    // 立减金额，AmountOff为减免的金额
+	CouponBonusCredits CouponKind = "bonus_credits" // 赠送积分，Value为赠送的积分数
+	CouponTrialMonths  CouponKind = "trial_months"  // 免费试用订阅，Value为试用月数
+)
+
+// Coupon 优惠券或推广码
+// Plans和Packages都为空时适用于所有订单；否则只适用于列出的订阅计划或充值套餐
+type Coupon struct {
+	gorm.Model
+	Code           string             `gorm:"size:40;not null;uniqueIndex" json:"code"`
+	Kind           CouponKind         `gorm:"size:20;not null" json:"kind"`
+	Value          float64            `gorm:"not null;default:0" json:"value"`                             // 折扣百分比、赠送积分或试用月数
+	AmountOff      money.Amount       `gorm:"column:amount_off_cents;not null;default:0" json:"amountOff"` // 立减金额
+	ActiveFrom     time.Time          `gorm:"not null" json:"activeFrom"`
+	ActiveUntil    *time.Time         `json:"activeUntil,omitempty"`
+	MaxRedemptions int                `gorm:"not null;default:0" json:"maxRedemptions"` // 总次数上限，0为不限
+	PerUserLimit   int                `gorm:"not null;default:0" json:"perUserLimit"`   // 每个用户的次数上限，0为不限
+	Plans          []SubscriptionType `gorm:"type:json;serializer:json" json:"plans"`
+	Packages       []string           `gorm:"type:json;serializer:json" json:"packages"`
+	Redeemed       int                `gorm:"not null;default:0" json:"redeemed"` // 已核销次数
+	Reserved       int                `gorm:"not null;default:0" json:"reserved"` // 未支付订单占用的次数
+}
+
+// ActiveAt 优惠券在指定时间是否处于有效期
+func (c *Coupon) ActiveAt(t time.Time) bool {
+	return !c.ActiveFrom.After(t) && (c.ActiveUntil == nil || c.ActiveUntil.After(t))
+}
+
+// CouponReservation 未支付订单对优惠券使用次数的占用，下单时写入，支付完成转为核销记录，订单失败时删除
+type CouponReservation struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	CouponID  uint      `gorm:"not null;index:idx_coupon_reservation_user" json:"couponId"`
+	UserID    uint      `gorm:"not null;index:idx_coupon_reservation_user" json:"userId"`
+	PaymentID uint      `gorm:"not null;uniqueIndex" json:"paymentId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CouponRedemption 优惠券核销记录，订单支付完成时写入
+type CouponRedemption struct {
+	gorm.Model
+	CouponID     uint         `gorm:"not null;index" json:"couponId"`
+	UserID       uint         `gorm:"not null;index" json:"userId"`
+	PaymentID    uint         `gorm:"not null;uniqueIndex" json:"paymentId"`
+	Discount     money.Amount `gorm:"column:discount_cents;not null;default:0" json:"discount"`
+	BonusCredits int          `gorm:"not null;default:0" json:"bonusCredits"`
+	TrialMonths  int          `gorm:"not null;default:0" json:"trialMonths"`
+}
+
+// CouponRequest 新增或修改优惠券的请求
+type CouponRequest struct {
+	Code           string             `json:"code" binding:"required,max=40"`
+	Kind           CouponKind         `json:"kind" binding:"required,oneof=percent_off amount_off bonus_credits trial_months"`
+	Value          float64            `json:"value" binding:"min=0"`
+	AmountOff      money.Amount       `json:"amountOff" binding:"min=0"`
+	ActiveFrom     *time.Time         `json:"activeFrom"` // 为空时立即生效
+	ActiveUntil    *time.Time         `json:"activeUntil"`
+	MaxRedemptions int                `json:"maxRedemptions" binding:"min=0"`
+	PerUserLimit   int                `json:"perUserLimit" binding:"min=0"`
+	Plans          []SubscriptionType `json:"plans"`
+	Packages       []string           `json:"packages"`
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreditReason 积分变动原因
+type CreditReason string
+
+const (
+	CreditSignupBonus    CreditReason = "signup_bonus"    // 注册赠送
+	CreditRecharge       CreditReason = "recharge"        // 充值到账
+	CreditSubscription   CreditReason = "subscription"    // 订阅赠送
+	CreditChatUsage      CreditReason = "chat_usage"      // AI聊天消耗
+	CreditReleased       CreditReason = "released"        // 预留未使用部分退回
+	CreditAdjustment     CreditReason = "adjustment"      // 对账调整
+	CreditAccountDeleted CreditReason = "account_deleted" // 注销账号清零
+	CreditRefund         CreditReason = "refund"          // 退款收回
+	CreditPromotion      CreditReason = "promotion"       // 优惠券赠送
+	CreditReferral       CreditReason = "referral"        // 邀请奖励
+)
+
+// CreditRefType 积分流水关联的业务对象类型
+type CreditRefType string
+
+const (
+	CreditRefPayment      CreditRefType = "payment"
+	CreditRefMessage      CreditRefType = "message"
+	CreditRefSubscription CreditRefType = "subscription"
+	CreditRefRefund       CreditRefType = "refund"
+	CreditRefReferral     CreditRefType = "referral"
+)
+
+// CreditTransaction 积分流水，只追加不修改
+// 用户的积分余额等于其全部流水金额之和，每条流水记录变动后的余额
+type CreditTransaction struct {
+	gorm.Model
+	UserID       uint          `gorm:"not null;index" json:"-"`
+	Amount       int           `gorm:"not null" json:"amount"` // 正数为收入，负数为支出
+	BalanceAfter int           `gorm:"not null" json:"balanceAfter"`
+	Reason       CreditReason  `gorm:"size:30;not null;index" json:"reason"`
+	RefType      CreditRefType `gorm:"size:20;index:idx_credit_ref" json:"refType,omitempty"`
+	RefID        uint          `gorm:"index:idx_credit_ref" json:"refId,omitempty"`
+	Note         string        `gorm:"size:255" json:"note,omitempty"`
+}
+
+// ReservationStatus 积分预留状态
+type ReservationStatus string
+
+const (
+	ReservationHeld      ReservationStatus = "held"      // 已预扣，等待结算
+	ReservationCommitted ReservationStatus = "committed" // 已按实际消耗结算
+	ReservationReleased  ReservationStatus = "released"  // 已全额退回
+)
+
+// CreditReservation 积分预留，调用上游前预扣，完成后按实际消耗结算或全额退回
+type CreditReservation struct {
+	gorm.Model
+	UserID  uint              `gorm:"not null;index"`
+	Amount  int               `gorm:"not null"`           // 预扣数量
+	Charged int               `gorm:"not null;default:0"` // 结算后的实际消耗
+	Status  ReservationStatus `gorm:"size:20;not null;index"`
+	Reason  CreditReason      `gorm:"size:30;not null"`
+	RefType CreditRefType     `gorm:"size:20"`
+	RefID   uint
+}
+
+// CreditTransactionResponse 积分流水响应
+type CreditTransactionResponse struct {
+	ID           uint          `json:"id"`
+	Amount       int           `json:"amount"`
+	BalanceAfter int           `json:"balanceAfter"`
+	Reason       CreditReason  `json:"reason"`
+	RefType      CreditRefType `json:"refType,omitempty"`
+	RefID        uint          `json:"refId,omitempty"`
+	Note         string        `json:"note,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt"`
+}
+
+// ToResponse 转换为响应格式
+func (t *CreditTransaction) ToResponse() CreditTransactionResponse {
+	return CreditTransactionResponse{
+		ID:           t.ID,
+		Amount:       t.Amount,
+		BalanceAfter: t.BalanceAfter,
+		Reason:       t.Reason,
+		RefType:      t.RefType,
+		RefID:        t.RefID,
+		Note:         t.Note,
+		CreatedAt:    t.CreatedAt,
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 好友请求状态
+type FriendRequestStatus string
+
+const (
+	FriendRequestPending  FriendRequestStatus = "pending"
+	FriendRequestAccepted FriendRequestStatus = "accepted"
+	FriendRequestDeclined FriendRequestStatus = "declined"
+)
+
+// FriendRequest 在陌生人会话中发起的好友请求
+type FriendRequest struct {
+	gorm.Model
+	FromUserID  uint                `gorm:"not null;index" json:"-"`
+	ToUserID    uint                `gorm:"not null;index" json:"-"`
+	SessionID   uint                `gorm:"not null" json:"-"` // 发起方的陌生人会话
+	ToSessionID uint                `gorm:"not null" json:"-"` // 接收方的陌生人会话
+	Status      FriendRequestStatus `gorm:"size:20;not null;default:'pending'" json:"status"`
+	RespondedAt *time.Time          `json:"respondedAt"`
+}
+
+// Friendship 好友关系，每段关系双方各一行
+type Friendship struct {
+	gorm.Model
+	UserID    uint `gorm:"not null;uniqueIndex:idx_friendship" json:"userId"`
+	FriendID  uint `gorm:"not null;uniqueIndex:idx_friendship" json:"friendId"`
+	SessionID uint `gorm:"not null" json:"sessionId"` // UserID一方的好友会话
+}
+
+// FriendResponse 好友列表项
+type FriendResponse struct {
+	UserID    uint       `json:"userId"`
+	Username  string     `json:"username"`
+	SessionID uint       `json:"sessionId"`
+	Online    bool       `json:"online"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	Since     time.Time  `json:"since"`
+}
+
+// FriendRequestResponse 好友请求列表项，会话ID为查看者自己一方的会话
+type FriendRequestResponse struct {
+	ID          uint                `json:"id"`
+	Direction   string              `json:"direction"` // incoming 或 outgoing
+	SessionID   uint                `json:"sessionId"`
+	Status      FriendRequestStatus `json:"status"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	RespondedAt *time.Time          `json:"respondedAt,omitempty"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// InvoiceKind 票据类型
+type InvoiceKind string
+
+const (
+	InvoiceReceipt    InvoiceKind = "invoice"     // 支付完成时开具
+	InvoiceCreditNote InvoiceKind = "credit_note" // 退款成功时开具，金额为负数
+)
+
+// InvoiceLine 票据明细行，金额含税
+type InvoiceLine struct {
+	Description string       `json:"description"`
+	Quantity    int          `json:"quantity"`
+	UnitPrice   money.Amount `json:"unitPrice"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// Invoice 支付完成或退款成功时开具的票据，开具后不再修改
+// 编号按系列和年份连续递增；销售方和购买方信息为开具时的快照
+type Invoice struct {
+	gorm.Model
+	Number    string      `gorm:"size:30;not null;unique" json:"number"`
+	Kind      InvoiceKind `gorm:"size:20;not null" json:"kind"`
+	UserID    uint        `gorm:"not null;index" json:"userId"`
+	PaymentID uint        `gorm:"not null;uniqueIndex:idx_invoice_source" json:"paymentId"`
+	RefundID  uint        `gorm:"not null;default:0;uniqueIndex:idx_invoice_source" json:"refundId,omitempty"` // 发票为0
+	OrderNo   string      `gorm:"size:50;not null" json:"orderNo"`
+	RefundNo  string      `gorm:"size:50" json:"refundNo,omitempty"`
+	IssuedAt  time.Time   `gorm:"not null;index" json:"issuedAt"`
+
+	Currency  money.Currency `gorm:"size:3;not null;default:'CNY'" json:"currency"`
+	Subtotal  money.Amount   `gorm:"column:subtotal_cents;not null;default:0" json:"subtotal"` // 不含税金额
+	TaxName   string         `gorm:"size:20" json:"taxName,omitempty"`
+	TaxRate   int            `gorm:"not null;default:0" json:"taxRate"` // 税率，单位为万分之一
+	TaxAmount money.Amount   `gorm:"column:tax_amount_cents;not null;default:0" json:"taxAmount"`
+	Total     money.Amount   `gorm:"column:total_cents;not null;default:0" json:"total"` // 含税合计，等于明细金额之和
+	Lines     []InvoiceLine  `gorm:"type:json;serializer:json" json:"lines"`
+
+	SellerName    string `gorm:"size:100" json:"sellerName"`
+	SellerTaxID   string `gorm:"size:50" json:"sellerTaxId,omitempty"`
+	SellerAddress string `gorm:"size:255" json:"sellerAddress,omitempty"`
+	BuyerName     string `gorm:"size:100" json:"buyerName"`
+	BuyerEmail    string `gorm:"size:100" json:"buyerEmail"`
+	BuyerTaxID    string `gorm:"size:50" json:"buyerTaxId,omitempty"`
+	BuyerAddress  string `gorm:"size:255" json:"buyerAddress,omitempty"`
+}
+
+// InvoiceSequence 票据编号系列的最后一个序号，系列由前缀和年份组成
+type InvoiceSequence struct {
+	Series string `gorm:"primaryKey;size:30"`
+	Last   int    `gorm:"not null;default:0"`
+}
+
+// BillingProfile 用户的开票信息，开具票据时作为购买方信息
+type BillingProfile struct {
+	gorm.Model
+	UserID  uint   `gorm:"not null;uniqueIndex" json:"userId"`
+	Name    string `gorm:"size:100" json:"name"`  // 个人姓名或公司名称，为空时使用用户名
+	Email   string `gorm:"size:100" json:"email"` // 为空时使用账号邮箱
+	TaxID   string `gorm:"size:50" json:"taxId"`
+	Address string `gorm:"size:255" json:"address"`
+}
+
+// BillingProfileRequest 修改开票信息的请求
+type BillingProfileRequest struct {
+	Name    string `json:"name" binding:"max=100"`
+	Email   string `json:"email" binding:"omitempty,email,max=100"`
+	TaxID   string `json:"taxId" binding:"max=50"`
+	Address string `json:"address" binding:"max=255"`
+}
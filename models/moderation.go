@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserBlock 用户屏蔽关系，屏蔽后双方不能互发消息，也不会再被匹配
+type UserBlock struct {
+	gorm.Model
+	BlockerID uint   `gorm:"not null;uniqueIndex:idx_user_block" json:"-"`
+	BlockedID uint   `gorm:"not null;uniqueIndex:idx_user_block;index" json:"-"`
+	Label     string `gorm:"size:100" json:"label"` // 屏蔽时对方的显示名称，陌生人为化名
+}
+
+// BlockRequest 屏蔽请求，陌生人通过会话ID指定，好友可直接使用用户ID
+type BlockRequest struct {
+	UserID    uint `json:"userId"`
+	SessionID uint `json:"sessionId"`
+}
+
+// 举报类别
+type ReportCategory string
+
+const (
+	ReportHarassment    ReportCategory = "harassment"
+	ReportSpam          ReportCategory = "spam"
+	ReportInappropriate ReportCategory = "inappropriate"
+	ReportUnderage      ReportCategory = "underage"
+	ReportOther         ReportCategory = "other"
+)
+
+// 举报状态
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+// Report 用户举报，进入审核队列
+type Report struct {
+	gorm.Model
+	ReporterID         uint           `gorm:"not null;index" json:"reporterId"`
+	ReportedUserID     uint           `gorm:"not null;index" json:"reportedUserId"`
+	SessionID          uint           `gorm:"not null" json:"sessionId"` // 举报人一方的会话
+	Category           ReportCategory `gorm:"size:20;not null" json:"category"`
+	Description        string         `gorm:"size:1000" json:"description"`
+	EvidenceMessageIDs []uint         `gorm:"serializer:json" json:"evidenceMessageIds"`
+	Status             ReportStatus   `gorm:"size:20;not null;default:'open';index" json:"status"`
+	ModeratorID        *uint          `json:"moderatorId"`
+	Resolution         string         `gorm:"size:500" json:"resolution"`
+	ResolvedAt         *time.Time     `json:"resolvedAt"`
+}
+
+// CreateReportRequest 举报请求
+type CreateReportRequest struct {
+	SessionID   uint           `json:"sessionId" binding:"required"`
+	Category    ReportCategory `json:"category" binding:"required,oneof=harassment spam inappropriate underage other"`
+	Description string         `json:"description" binding:"max=1000"`
+	MessageIDs  []uint         `json:"messageIds" binding:"max=50"`
+}
+
+// 处罚类型
+type SanctionAction string
+
+const (
+	SanctionWarn    SanctionAction = "warn"
+	SanctionSuspend SanctionAction = "suspend"
+	SanctionBan     SanctionAction = "ban"
+)
+
+// UserSanction 审核处罚记录
+type UserSanction struct {
+	gorm.Model
+	UserID      uint           `gorm:"not null;index" json:"userId"`
+	ModeratorID uint           `gorm:"not null" json:"moderatorId"`
+	ReportID    *uint          `gorm:"index" json:"reportId"`
+	Action      SanctionAction `gorm:"size:20;not null" json:"action"`
+	Reason      string         `gorm:"size:500" json:"reason"`
+	ExpiresAt   *time.Time     `json:"expiresAt"` // 为空表示永久有效（仅封禁）
+	RevokedAt   *time.Time     `json:"revokedAt"`
+}
+
+// Active 判断处罚当前是否有效
+func (s *UserSanction) Active(now time.Time) bool {
+	if s.RevokedAt != nil || s.Action == SanctionWarn {
+		return false
+	}
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// ModerationActionRequest 审核处理请求
+// dismiss 表示驳回举报，不处罚
+type ModerationActionRequest struct {
+	Action        string `json:"action" binding:"required,oneof=warn suspend ban dismiss"`
+	Reason        string `json:"reason" binding:"max=500"`
+	DurationHours int    `json:"durationHours" binding:"min=0"` // 封禁为0时表示永久
+}
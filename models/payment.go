@@ -1,8 +1,11 @@
 package models
 
 import (
-	"gorm.io/gorm"
 	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
 )
 
 // 支付状态
@@ -15,6 +18,37 @@ const (
 	PaymentRefunded  PaymentStatus = "refunded"
 )
 
+// paymentTransitions 允许的支付状态流转
+// 已失败的订单仍可转为完成：超时关闭后网关才确认到账时以网关为准
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentPending:   {PaymentCompleted, PaymentFailed},
+	PaymentFailed:    {PaymentCompleted},
+	PaymentCompleted: {PaymentRefunded},
+}
+
+// CanTransitionTo 是否允许从当前状态流转到目标状态
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentStatusesBefore 可以流转到目标状态的所有状态
+func PaymentStatusesBefore(next PaymentStatus) []PaymentStatus {
+	var statuses []PaymentStatus
+	for from, targets := range paymentTransitions {
+		for _, to := range targets {
+			if to == next {
+				statuses = append(statuses, from)
+			}
+		}
+	}
+	return statuses
+}
+
 // 支付方式
 type PaymentMethod string
 
@@ -25,53 +59,180 @@ const (
 	PaymentCreditCard PaymentMethod = "creditcard"
 )
 
+// PaymentPurpose 支付用途，决定支付完成后如何履约
+type PaymentPurpose string
+
+const (
+	PurposeRecharge  PaymentPurpose = "recharge"  // 积分充值
+	PurposeSubscribe PaymentPurpose = "subscribe" // 首次订阅
+	PurposeRenewal   PaymentPurpose = "renewal"   // 订阅续费
+	PurposeUpgrade   PaymentPurpose = "upgrade"   // 周期内升级，按剩余天数补差价
+)
+
 // Payment 支付记录
 type Payment struct {
 	gorm.Model
-	UserID        uint          `gorm:"not null" json:"userId"`
-	OrderNo       string        `gorm:"size:50;not null;unique" json:"orderNo"`
-	Amount        float64       `gorm:"not null" json:"amount"`
-	Credits       int           `gorm:"not null" json:"credits"`
-	Method        PaymentMethod `gorm:"size:20;not null" json:"method"`
-	Status        PaymentStatus `gorm:"size:20;not null;default:'pending'" json:"status"`
-	CompletedAt   *time.Time    `json:"completedAt"`
-	TransactionID string        `gorm:"size:100" json:"transactionId"`
+	UserID         uint             `gorm:"not null" json:"userId"`
+	OrderNo        string           `gorm:"size:50;not null;unique" json:"orderNo"`
+	Amount         money.Amount     `gorm:"column:amount_cents;not null;default:0" json:"amount"`
+	Currency       money.Currency   `gorm:"size:3;not null;default:'CNY'" json:"currency"`
+	Credits        int              `gorm:"not null" json:"credits"`
+	Method         PaymentMethod    `gorm:"size:20;not null" json:"method"`
+	Status         PaymentStatus    `gorm:"size:20;not null;default:'pending'" json:"status"`
+	CompletedAt    *time.Time       `json:"completedAt"`
+	TransactionID  string           `gorm:"size:100" json:"transactionId"`
+	GatewayOrderID string           `gorm:"size:100" json:"-"` // 网关侧订单标识，查询和退款时使用
+	Purpose        PaymentPurpose   `gorm:"size:20;not null;default:'recharge'" json:"purpose"`
+	SubscriptionID uint             `gorm:"index" json:"subscriptionId,omitempty"`
+	Plan           SubscriptionType `gorm:"size:20" json:"plan,omitempty"`
+	// 下单时的计划或套餐版本，价格以该版本为准
+	PlanVersionID    uint `gorm:"index" json:"planVersionId,omitempty"`
+	PackageVersionID uint `gorm:"index" json:"packageVersionId,omitempty"`
+	// 已完成退款的金额，全额退款后订单转为已退款
+	RefundedAmount money.Amount `gorm:"column:refunded_amount_cents;not null;default:0" json:"refundedAmount"`
+	// 下单时使用的优惠券，支付完成后核销；Amount为优惠后的金额
+	CouponID     uint         `gorm:"index" json:"couponId,omitempty"`
+	Discount     money.Amount `gorm:"column:discount_cents;not null;default:0" json:"discount"`
+	BonusCredits int          `gorm:"not null;default:0" json:"bonusCredits"`
+	TrialMonths  int          `gorm:"not null;default:0" json:"trialMonths"` // 试用月数，订阅按该月数生效
 }
 
-// RechargePackage 充值套餐
+// ToResponse 转换为响应格式，支付链接由调用方填充
+func (p *Payment) ToResponse() PaymentResponse {
+	resp := PaymentResponse{
+		OrderNo:      p.OrderNo,
+		Amount:       p.Amount,
+		Currency:     p.Currency,
+		Credits:      p.Credits,
+		Discount:     p.Discount,
+		BonusCredits: p.BonusCredits,
+		TrialMonths:  p.TrialMonths,
+		Method:       p.Method,
+		Status:       p.Status,
+		CreatedAt:    p.CreatedAt,
+		CompletedAt:  p.CompletedAt,
+	}
+	if p.Discount > 0 {
+		original := p.Amount + p.Discount
+		resp.OriginalAmount = &original
+	}
+	return resp
+}
+
+// RefundStatus 退款状态
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"   // 已提交网关，等待结果
+	RefundSucceeded RefundStatus = "succeeded" // 已退款，积分已收回
+	RefundFailed    RefundStatus = "failed"
+)
+
+// 积分已消耗、不足以收回时的处理方式
+const (
+	ShortfallDebt  = "debt"  // 照常扣回，余额为负，充值后先抵扣欠款
+	ShortfallWaive = "waive" // 只扣回剩余余额，差额记录在退款中不再追讨
+)
+
+// Refund 退款记录，一笔支付可以多次部分退款
+type Refund struct {
+	gorm.Model
+	PaymentID       uint         `gorm:"not null;index" json:"paymentId"`
+	UserID          uint         `gorm:"not null;index" json:"userId"`
+	RefundNo        string       `gorm:"size:50;not null;unique" json:"refundNo"`
+	Amount          money.Amount `gorm:"column:amount_cents;not null;default:0" json:"amount"`
+	Credits         int          `gorm:"not null" json:"credits"` // 按退款比例应收回的积分
+	ClawedBack      int          `gorm:"not null;default:0" json:"clawedBack"`
+	Shortfall       int          `gorm:"not null;default:0" json:"shortfall"` // 退款时余额不足的部分
+	ShortfallPolicy string       `gorm:"size:10" json:"shortfallPolicy,omitempty"`
+	Reason          string       `gorm:"size:255" json:"reason"`
+	Status          RefundStatus `gorm:"size:20;not null;index" json:"status"`
+	GatewayRefundID string       `gorm:"size:100" json:"-"`
+	OperatorID      uint         `json:"operatorId"` // 发起退款的客服或管理员
+	CompletedAt     *time.Time   `json:"completedAt"`
+}
+
+// RefundRequest 退款请求
+type RefundRequest struct {
+	Amount money.Amount `json:"amount" binding:"min=0"` // 为0时退还剩余的全部金额
+	Reason string       `json:"reason" binding:"required,max=255"`
+}
+
+// RechargePackage 充值套餐的一个版本，规则与订阅计划版本相同
 type RechargePackage struct {
-	ID       string  `json:"id"`
-	Credits  int     `json:"credits"`
-	Price    float64 `json:"price"`
-	Discount int     `json:"discount"`
+	gorm.Model
+	Code        string         `gorm:"size:20;not null;uniqueIndex:idx_package_version" json:"code"`
+	Version     int            `gorm:"not null;uniqueIndex:idx_package_version" json:"version"`
+	Credits     int            `gorm:"not null" json:"credits"`
+	Price       money.Amount   `gorm:"column:price_cents;not null;default:0" json:"price"`
+	Currency    money.Currency `gorm:"size:3;not null;default:'CNY'" json:"currency"`
+	Discount    int            `gorm:"not null;default:0" json:"discount"`
+	ActiveFrom  time.Time      `gorm:"not null;index" json:"activeFrom"`
+	ActiveUntil *time.Time     `json:"activeUntil,omitempty"`
+}
+
+// ActiveAt 版本在指定时间是否处于生效期
+func (p *RechargePackage) ActiveAt(t time.Time) bool {
+	return !p.ActiveFrom.After(t) && (p.ActiveUntil == nil || p.ActiveUntil.After(t))
+}
+
+// RechargePackageResponse 充值套餐响应
+type RechargePackageResponse struct {
+	ID        string         `json:"id"`
+	VersionID uint           `json:"versionId"`
+	Version   int            `json:"version"`
+	Credits   int            `json:"credits"`
+	Price     money.Amount   `json:"price"`
+	Currency  money.Currency `json:"currency"`
+	Discount  int            `json:"discount"`
+}
+
+// ToResponse 转换为响应格式
+func (p *RechargePackage) ToResponse() RechargePackageResponse {
+	return RechargePackageResponse{
+		ID:        p.Code,
+		VersionID: p.ID,
+		Version:   p.Version,
+		Credits:   p.Credits,
+		Price:     p.Price,
+		Currency:  p.Currency,
+		Discount:  p.Discount,
+	}
+}
+
+// PackageVersionRequest 新增或修改充值套餐版本的请求
+type PackageVersionRequest struct {
+	Code        string         `json:"code" binding:"required"`
+	Credits     int            `json:"credits" binding:"required,min=1"`
+	Price       money.Amount   `json:"price" binding:"required,gt=0"`
+	Currency    money.Currency `json:"currency"` // 为空时使用默认币种
+	Discount    int            `json:"discount" binding:"min=0,max=100"`
+	ActiveFrom  *time.Time     `json:"activeFrom"` // 为空时立即生效
+	ActiveUntil *time.Time     `json:"activeUntil"`
 }
 
 // RechargeRequest 充值请求
 type RechargeRequest struct {
 	PackageID    string        `json:"packageId"`
-	CustomAmount int           `json:"customAmount"`
+	CustomAmount int           `json:"customAmount" binding:"min=0,max=1000000"` // 自定义充值的积分数
 	Method       PaymentMethod `json:"method" binding:"required"`
+	CouponCode   string        `json:"couponCode"`
 }
 
-// PaymentResponse 支付响应
+// PaymentResponse 支付响应，使用优惠券时Amount为优惠后的应付金额
 type PaymentResponse struct {
-	OrderNo     string        `json:"orderNo"`
-	Amount      float64       `json:"amount"`
-	Credits     int           `json:"credits"`
-	Method      PaymentMethod `json:"method"`
-	Status      PaymentStatus `json:"status"`
-	PaymentURL  string        `json:"paymentUrl,omitempty"`
-	PaymentQR   string        `json:"paymentQr,omitempty"`
-	CreatedAt   time.Time     `json:"createdAt"`
-	CompletedAt *time.Time    `json:"completedAt,omitempty"`
-}
-
-// GetDefaultRechargePackages 获取默认套餐
-func GetDefaultRechargePackages() []RechargePackage {
-	return []RechargePackage{
-		{ID: "small", Credits: 100, Price: 10, Discount: 0},
-		{ID: "medium", Credits: 500, Price: 45, Discount: 10},
-		{ID: "large", Credits: 1200, Price: 100, Discount: 15},
-		{ID: "xlarge", Credits: 3000, Price: 230, Discount: 20},
-	}
+	OrderNo        string         `json:"orderNo"`
+	Amount         money.Amount   `json:"amount"`
+	Currency       money.Currency `json:"currency"`
+	OriginalAmount *money.Amount  `json:"originalAmount,omitempty"`
+	Discount       money.Amount   `json:"discount,omitempty"`
+	Credits        int            `json:"credits"`
+	BonusCredits   int            `json:"bonusCredits,omitempty"`
+	TrialMonths    int            `json:"trialMonths,omitempty"`
+	Method         PaymentMethod  `json:"method"`
+	Status         PaymentStatus  `json:"status"`
+	PaymentURL     string         `json:"paymentUrl,omitempty"`
+	PaymentQR      string         `json:"paymentQr,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	CompletedAt    *time.Time     `json:"completedAt,omitempty"`
 }
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 数据导出状态
+type ExportStatus string
+
+const (
+	ExportPending    ExportStatus = "pending"
+	ExportProcessing ExportStatus = "processing"
+	ExportReady      ExportStatus = "ready"
+	ExportFailed     ExportStatus = "failed"
+	ExportExpired    ExportStatus = "expired"
+)
+
+// DataExport 个人数据导出任务
+type DataExport struct {
+	gorm.Model
+	UserID      uint         `gorm:"not null;index" json:"userId"`
+	Status      ExportStatus `gorm:"size:20;not null;default:'pending'" json:"status"`
+	FileKey     string       `gorm:"size:255" json:"-"` // 导出文件在对象存储中的键
+	Size        int64        `json:"size"`
+	Error       string       `gorm:"size:255" json:"error,omitempty"`
+	CompletedAt *time.Time   `json:"completedAt"`
+	ExpiresAt   *time.Time   `json:"expiresAt"` // 下载链接过期时间
+}
+
+// AccountDeletionRequest 注销账号请求
+type AccountDeletionRequest struct {
+	Password string `json:"password" binding:"required"`
+}
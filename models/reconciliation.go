@@ -0,0 +1,42 @@
+package models
+
+import (
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// DiscrepancyKind 对账差异类型
+type DiscrepancyKind string
+
+const (
+	DiscrepancyUnknownOrder     DiscrepancyKind = "unknown_order"      // 网关有交易，本地没有对应订单或退款
+	DiscrepancyMissingAtGateway DiscrepancyKind = "missing_at_gateway" // 本地已完成，网关对账单中没有
+	DiscrepancyAmountMismatch   DiscrepancyKind = "amount_mismatch"    // 金额或币种不一致
+	DiscrepancyStatusMismatch   DiscrepancyKind = "status_mismatch"    // 网关已成功，本地未完成
+)
+
+// Discrepancy 对账差异，状态不一致的订单按状态机修正后Fixed为true
+type Discrepancy struct {
+	Kind          DiscrepancyKind `json:"kind"`
+	OrderNo       string          `json:"orderNo"`
+	RefundNo      string          `json:"refundNo,omitempty"`
+	LocalStatus   string          `json:"localStatus,omitempty"`
+	LocalAmount   money.Amount    `json:"localAmount"`
+	GatewayAmount money.Amount    `json:"gatewayAmount"`
+	Fixed         bool            `json:"fixed"`
+	Note          string          `json:"note,omitempty"`
+}
+
+// ReconciliationReport 每个支付方式每天一份的对账报告
+type ReconciliationReport struct {
+	gorm.Model
+	Date          string        `gorm:"size:10;not null;uniqueIndex:idx_reconciliation_day" json:"date"` // 2006-01-02
+	Method        PaymentMethod `gorm:"size:20;not null;uniqueIndex:idx_reconciliation_day" json:"method"`
+	GatewayCount  int           `gorm:"not null" json:"gatewayCount"`
+	GatewayAmount money.Amount  `gorm:"column:gateway_amount_cents;not null;default:0" json:"gatewayAmount"` // 支付减去退款的净额
+	LocalCount    int           `gorm:"not null" json:"localCount"`
+	LocalAmount   money.Amount  `gorm:"column:local_amount_cents;not null;default:0" json:"localAmount"`
+	Fixed         int           `gorm:"not null;default:0" json:"fixed"`
+	Discrepancies []Discrepancy `gorm:"type:json;serializer:json" json:"discrepancies"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReferralStatus 邀请状态
+type ReferralStatus string
+
+const (
+	ReferralPending   ReferralStatus = "pending"   // 已注册，等待被邀请人首次支付
+	ReferralHeld      ReferralStatus = "held"      // 首次支付已完成，奖励处于冻结期
+	ReferralRewarded  ReferralStatus = "rewarded"  // 冻结期结束，奖励已入账
+	ReferralRejected  ReferralStatus = "rejected"  // 未通过风控或超出邀请人的奖励上限，不发放奖励
+	ReferralCancelled ReferralStatus = "cancelled" // 冻结期内首次支付被退款，奖励作废
+)
+
+// 邀请不发放奖励的原因
+const (
+	ReferralReasonSameIP     = "same_ip"     // 与邀请人或该邀请人的其他被邀请人注册IP相同
+	ReferralReasonSameDevice = "same_device" // 与邀请人或该邀请人的其他被邀请人设备相同
+	ReferralReasonUnknownIP  = "unknown_ip"  // 注册IP未知，无法核验
+	ReferralReasonCapReached = "cap_reached" // 邀请人获得奖励的次数已达上限
+	ReferralReasonRefunded   = "refunded"    // 首次支付在冻结期内退款
+	ReferralReasonDeleted    = "deleted"     // 邀请人或被邀请人在奖励入账前注销账号
+)
+
+// ReferralCode 用户的邀请码，首次查询或注册时生成
+// 同时记录用户注册时的IP和设备，用于识别自己邀请自己
+type ReferralCode struct {
+	UserID    uint      `gorm:"primaryKey;autoIncrement:false" json:"userId"`
+	Code      string    `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	SignupIP  string    `gorm:"size:64" json:"-"`
+	DeviceID  string    `gorm:"size:100" json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Referral 一次邀请，被邀请人使用邀请码注册时创建
+// 被邀请人首次支付完成后双方获得奖励，奖励经过冻结期后才写入积分流水
+type Referral struct {
+	gorm.Model
+	ReferrerID     uint           `gorm:"not null;index" json:"referrerId"`
+	InviteeID      uint           `gorm:"not null;uniqueIndex" json:"inviteeId"`
+	Code           string         `gorm:"size:20;not null" json:"code"`
+	SignupIP       string         `gorm:"size:64;index" json:"-"`
+	DeviceID       string         `gorm:"size:100;index" json:"-"`
+	Status         ReferralStatus `gorm:"size:20;not null;index" json:"status"`
+	Reason         string         `gorm:"size:30" json:"reason,omitempty"`          // 拒绝或作废的原因
+	PaymentID      uint           `gorm:"index" json:"paymentId,omitempty"`         // 被邀请人的首次支付
+	ReferrerReward int            `gorm:"not null;default:0" json:"referrerReward"` // 邀请人获得的积分
+	InviteeReward  int            `gorm:"not null;default:0" json:"inviteeReward"`  // 被邀请人获得的积分
+	QualifiedAt    *time.Time     `json:"qualifiedAt,omitempty"`
+	AvailableAt    *time.Time     `gorm:"index" json:"availableAt,omitempty"` // 冻结期结束时间
+	RewardedAt     *time.Time     `json:"rewardedAt,omitempty"`
+}
+
+// ReferralStats 邀请人的邀请统计
+type ReferralStats struct {
+	Code      string `json:"code"`
+	Link      string `json:"link,omitempty"`
+	Invited   int64  `json:"invited"`   // 使用邀请码注册的人数
+	Pending   int64  `json:"pending"`   // 尚未完成首次支付
+	Held      int64  `json:"held"`      // 奖励冻结中
+	Rewarded  int64  `json:"rewarded"`  // 奖励已入账
+	Rejected  int64  `json:"rejected"`  // 未通过风控或超出上限
+	Cancelled int64  `json:"cancelled"` // 首次支付退款
+	// HeldCredits 冻结中的奖励积分，冻结期结束后入账
+	HeldCredits   int `json:"heldCredits"`
+	EarnedCredits int `json:"earnedCredits"` // 已入账的奖励积分
+	MaxRewards    int `json:"maxRewards"`    // 可获得奖励的邀请人数上限，0为不限
+}
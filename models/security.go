@@ -0,0 +1,17 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// LockoutAudit 登录锁定审计记录
+type LockoutAudit struct {
+	gorm.Model
+	UserID      *uint     `gorm:"index" json:"userId"`           // 账号存在时记录用户ID
+	Scope       string    `gorm:"size:20;not null" json:"scope"` // account 或 ip
+	Account     string    `gorm:"size:100;index" json:"account"`
+	IP          string    `gorm:"size:64;index" json:"ip"`
+	Lockouts    int       `json:"lockouts"` // 第几次锁定
+	LockedUntil time.Time `json:"lockedUntil"`
+}
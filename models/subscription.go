@@ -1,49 +1,108 @@
 package models
 
-// SubscriptionPlan 订阅计划
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// SubscriptionStatus 订阅状态
+type SubscriptionStatus string
+
+const (
+	SubscriptionPending SubscriptionStatus = "pending"  // 等待首次支付
+	SubscriptionActive  SubscriptionStatus = "active"   // 生效中，关闭自动续费后仍生效到周期结束
+	SubscriptionPastDue SubscriptionStatus = "past_due" // 续费失败，按重试计划继续扣款，期间保留权益
+	SubscriptionExpired SubscriptionStatus = "expired"  // 已结束，用户回到免费版
+)
+
+// Subscription 用户的付费订阅，每个用户同时最多有一个未结束的订阅
+type Subscription struct {
+	gorm.Model
+	UserID          uint               `gorm:"not null;index" json:"-"`
+	Plan            SubscriptionType   `gorm:"size:20;not null" json:"plan"`
+	Status          SubscriptionStatus `gorm:"size:20;not null;index" json:"status"`
+	PlanVersionID   uint               `gorm:"index" json:"planVersionId"` // 按该版本的价格计费，续费时切换到当时的生效版本
+	PaymentMethod   PaymentMethod      `gorm:"size:20" json:"paymentMethod"`
+	PeriodStart     *time.Time         `json:"periodStart"`
+	PeriodEnd       *time.Time         `gorm:"index" json:"periodEnd"`
+	AutoRenew       bool               `gorm:"not null;default:false" json:"autoRenew"`
+	ProrationCredit money.Amount       `gorm:"column:proration_credit_cents;not null;default:0" json:"prorationCredit"` // 降级折算的金额，抵扣下次续费，不超过当前计划的价格
+	PeriodPaid      money.Amount       `gorm:"column:period_paid_cents;not null;default:0" json:"-"`                    // 本周期实际支付且尚未折算的金额，降级折算不超过该金额
+	RenewalAttempts int                `gorm:"not null;default:0" json:"renewalAttempts"`                               // 本周期续费失败的次数
+	NextRetryAt     *time.Time         `json:"nextRetryAt,omitempty"`
+	CanceledAt      *time.Time         `json:"canceledAt,omitempty"`
+	EndedAt         *time.Time         `json:"endedAt,omitempty"`
+}
+
+// IsCurrent 订阅是否仍在生效，续费失败重试期间也视为生效
+func (s *Subscription) IsCurrent() bool {
+	return s.Status == SubscriptionActive || s.Status == SubscriptionPastDue
+}
+
+// SubscriptionPlan 订阅计划的一个版本
+// 调整价格或积分时新增版本，已生效或已售出的版本不再修改；同一计划取生效期内版本号最大的版本
 type SubscriptionPlan struct {
-    Type           SubscriptionType `json:"type"`
-    Name           string           `json:"name"`
-    Price          float64          `json:"price"`
-    CreditsPerMonth int              `json:"creditsPerMonth"`
-    Features       []string         `json:"features"`
+	gorm.Model
+	Type            SubscriptionType `gorm:"size:20;not null;uniqueIndex:idx_plan_version" json:"type"`
+	Version         int              `gorm:"not null;uniqueIndex:idx_plan_version" json:"version"`
+	Name            string           `gorm:"size:50;not null" json:"name"`
+	Price           money.Amount     `gorm:"column:price_cents;not null;default:0" json:"price"`
+	Currency        money.Currency   `gorm:"size:3;not null;default:'CNY'" json:"currency"`
+	CreditsPerMonth int              `gorm:"not null" json:"creditsPerMonth"`
+	Features        []string         `gorm:"type:json;serializer:json" json:"features"`
+	ActiveFrom      time.Time        `gorm:"not null;index" json:"activeFrom"`
+	ActiveUntil     *time.Time       `json:"activeUntil,omitempty"` // 为空表示长期有效
+}
+
+// ActiveAt 版本在指定时间是否处于生效期
+func (p *SubscriptionPlan) ActiveAt(t time.Time) bool {
+	return !p.ActiveFrom.After(t) && (p.ActiveUntil == nil || p.ActiveUntil.After(t))
+}
+
+// SubscriptionPlanResponse 订阅计划响应
+type SubscriptionPlanResponse struct {
+	Type            SubscriptionType `json:"type"`
+	VersionID       uint             `json:"versionId"`
+	Version         int              `json:"version"`
+	Name            string           `json:"name"`
+	Price           money.Amount     `json:"price"`
+	Currency        money.Currency   `json:"currency"`
+	CreditsPerMonth int              `json:"creditsPerMonth"`
+	Features        []string         `json:"features"`
+}
+
+// ToResponse 转换为响应格式
+func (p *SubscriptionPlan) ToResponse() SubscriptionPlanResponse {
+	return SubscriptionPlanResponse{
+		Type:            p.Type,
+		VersionID:       p.ID,
+		Version:         p.Version,
+		Name:            p.Name,
+		Price:           p.Price,
+		Currency:        p.Currency,
+		CreditsPerMonth: p.CreditsPerMonth,
+		Features:        p.Features,
+	}
+}
+
+// PlanVersionRequest 新增或修改订阅计划版本的请求
+type PlanVersionRequest struct {
+	Type            SubscriptionType `json:"type" binding:"required"`
+	Name            string           `json:"name" binding:"required"`
+	Price           money.Amount     `json:"price" binding:"min=0"`
+	Currency        money.Currency   `json:"currency"` // 为空时使用默认币种
+	CreditsPerMonth int              `json:"creditsPerMonth" binding:"min=0"`
+	Features        []string         `json:"features"`
+	ActiveFrom      *time.Time       `json:"activeFrom"` // 为空时立即生效
+	ActiveUntil     *time.Time       `json:"activeUntil"`
 }
 
 // SubscriptionRequest 订阅请求
 type SubscriptionRequest struct {
-    Type SubscriptionType `json:"type" binding:"required"`
-}
-
-// GetSubscriptionPlans 获取所有订阅计划
-func GetSubscriptionPlans() map[string]SubscriptionPlan {
-    return map[string]SubscriptionPlan{
-        "free": {
-            Type:           SubscriptionFree,
-            Name:           "免费版",
-            Price:          0,
-            CreditsPerMonth: 100,
-            Features:       []string{"基础聊天功能", "随机匹配", "基本AI助手"},
-        },
-        "basic": {
-            Type:           SubscriptionBasic,
-            Name:           "基础版",
-            Price:          19.9,
-            CreditsPerMonth: 500,
-            Features:       []string{"所有免费功能", "专业AI助手", "无广告体验", "优先匹配"},
-        },
-        "premium": {
-            Type:           SubscriptionPremium,
-            Name:           "高级版",
-            Price:          39.9,
-            CreditsPerMonth: 1200,
-            Features:       []string{"所有基础功能", "专属聊天定制", "创建聊天室", "语音转文字"},
-        },
-        "unlimited": {
-            Type:           SubscriptionUnlimited,
-            Name:           "无限版",
-            Price:          99.9,
-            CreditsPerMonth: 3000,
-            Features:       []string{"所有高级功能", "无限AI助手使用", "VIP客户支持", "专属定制服务"},
-        },
-    }
+	Type       SubscriptionType `json:"type" binding:"required"`
+	Method     PaymentMethod    `json:"method"`
+	CouponCode string           `json:"couponCode"` // 仅首次订阅可用
 }
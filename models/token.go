@@ -0,0 +1,64 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 个人访问令牌权限范围
+const (
+	ScopeChatRead      = "chat:read"
+	ScopeChatWrite     = "chat:write"
+	ScopePaymentsRead  = "payments:read"
+	ScopePaymentsWrite = "payments:write"
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+)
+
+// AllScopes 所有可授予的权限范围
+var AllScopes = []string{
+	ScopeChatRead,
+	ScopeChatWrite,
+	ScopePaymentsRead,
+	ScopePaymentsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+}
+
+// PersonalAccessToken 个人访问令牌，供脚本和集成调用API
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"userId"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Prefix     string     `gorm:"size:20;not null" json:"prefix"`        // 令牌前几位，便于用户识别
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"` // 令牌的SHA-256哈希，明文不落库
+	Scopes     []string   `gorm:"type:json;serializer:json" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// Active 令牌是否可用
+func (t *PersonalAccessToken) Active(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// HasScope 令牌是否拥有指定权限
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTokenRequest 创建个人访问令牌请求
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,required"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"omitempty,min=1,max=365"` // 为空表示永不过期
+}
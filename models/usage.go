@@ -0,0 +1,60 @@
+package models
+
+// UsageRollup 按天物化的AI消耗，每个用户每天按会话、智能体和模型各一行
+// 由AI回复元数据中的实际用量汇总而来，日期为配置时区的自然日
+type UsageRollup struct {
+	ID               uint   `gorm:"primaryKey" json:"-"`
+	UserID           uint   `gorm:"not null;uniqueIndex:idx_usage_rollup,priority:1" json:"-"`
+	Day              string `gorm:"size:10;not null;uniqueIndex:idx_usage_rollup,priority:2;index" json:"day"` // 2006-01-02
+	SessionID        uint   `gorm:"not null;uniqueIndex:idx_usage_rollup,priority:3" json:"sessionId"`
+	Agent            string `gorm:"size:50;not null;default:'';uniqueIndex:idx_usage_rollup,priority:4" json:"agent"`
+	Model            string `gorm:"size:100;not null;default:'';uniqueIndex:idx_usage_rollup,priority:5" json:"model"`
+	Messages         int    `gorm:"not null;default:0" json:"messages"` // AI回复条数
+	PromptTokens     int    `gorm:"not null;default:0" json:"promptTokens"`
+	CompletionTokens int    `gorm:"not null;default:0" json:"completionTokens"`
+	Credits          int    `gorm:"not null;default:0" json:"credits"` // 实际消耗的积分
+}
+
+// CreditRollup 按天物化的积分流水，每个用户每天每种变动原因一行
+type CreditRollup struct {
+	ID      uint         `gorm:"primaryKey" json:"-"`
+	UserID  uint         `gorm:"not null;uniqueIndex:idx_credit_rollup,priority:1" json:"-"`
+	Day     string       `gorm:"size:10;not null;uniqueIndex:idx_credit_rollup,priority:2;index" json:"day"`
+	Reason  CreditReason `gorm:"size:30;not null;uniqueIndex:idx_credit_rollup,priority:3" json:"reason"`
+	Entries int          `gorm:"not null;default:0" json:"entries"`
+	Credits int          `gorm:"not null;default:0" json:"credits"` // 流水金额之和，支出为负数
+}
+
+// UsageRow 用量明细的一行，Key为日期、智能体、模型或会话ID
+type UsageRow struct {
+	Key              string `json:"key"`
+	Label            string `json:"label,omitempty"` // 会话标题
+	Messages         int    `json:"messages"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	Credits          int    `json:"credits"`
+}
+
+// CreditFlow 一种变动原因在统计区间内的积分合计
+type CreditFlow struct {
+	Reason  CreditReason `json:"reason"`
+	Entries int          `json:"entries"`
+	Credits int          `json:"credits"`
+}
+
+// UsageReport 统计区间内的AI消耗明细和积分收支
+type UsageReport struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	GroupBy string `json:"groupBy"`
+	// AI消耗合计
+	Messages         int `json:"messages"`
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	AICredits        int `json:"aiCredits"`
+	// 订阅赠送和充值到账的积分
+	SubscriptionCredits int          `json:"subscriptionCredits"`
+	RechargeCredits     int          `json:"rechargeCredits"`
+	Breakdown           []UsageRow   `json:"breakdown"`
+	Flows               []CreditFlow `json:"flows"` // 按变动原因汇总的全部积分收支
+}
@@ -15,6 +15,16 @@ const (
 	SubscriptionUnlimited SubscriptionType = "unlimited"
 )
 
+// 用户角色
+type UserRole string
+
+const (
+	RoleUser      UserRole = "user"
+	RoleModerator UserRole = "moderator"
+	RoleSupport   UserRole = "support" // 客服，可以处理退款
+	RoleAdmin     UserRole = "admin"
+)
+
 // User 用户模型
 type User struct {
 	gorm.Model
@@ -27,6 +37,17 @@ type User struct {
 	SubAutoRenew bool            `gorm:"default:false" json:"subAutoRenew"`
 	JoinDate     time.Time       `json:"joinDate"`
 	LastLogin    *time.Time      `json:"lastLogin"`
+	Role         UserRole        `gorm:"size:20;not null;default:'user'" json:"role"`
+
+	// 处罚状态，由审核操作根据有效处罚记录维护
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"` // 暂停期间不能匹配和发送消息
+	BannedAt       *time.Time `json:"-"`
+	BannedUntil    *time.Time `json:"-"` // 封禁截止时间，为空表示永久封禁
+
+	// 账号注销
+	DeletionRequestedAt *time.Time `json:"deletionRequestedAt"`
+	DeletionScheduledAt *time.Time `gorm:"index" json:"deletionScheduledAt"` // 宽限期结束时间
+	AnonymizedAt        *time.Time `json:"-"`
 }
 
 // CredentialRequest 用户登录请求
@@ -37,9 +58,11 @@ type CredentialRequest struct {
 
 // RegistrationRequest 用户注册请求
 type RegistrationRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=50"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Username     string `json:"username" binding:"required,min=3,max=50"`
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password" binding:"required,min=6"`
+	ReferralCode string `json:"referralCode" binding:"max=20"` // 邀请码，可选
+	DeviceID     string `json:"deviceId" binding:"max=100"`    // 客户端设备标识，用于邀请风控
 }
 
 // UserResponse 用户响应
@@ -53,7 +76,10 @@ type UserResponse struct {
 		ExpiresAt *time.Time      `json:"expiresAt"`
 		AutoRenew bool            `json:"autoRenew"`
 	} `json:"subscription"`
-	JoinDate time.Time `json:"joinDate"`
+	Role                UserRole   `json:"role"`
+	JoinDate            time.Time  `json:"joinDate"`
+	SuspendedUntil      *time.Time `json:"suspendedUntil,omitempty"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
 }
 
 // ToResponse 转换为响应
@@ -72,6 +98,29 @@ func (u *User) ToResponse() UserResponse {
 			ExpiresAt: u.SubExpiresAt,
 			AutoRenew: u.SubAutoRenew,
 		},
-		JoinDate: u.JoinDate,
+		Role:                u.Role,
+		JoinDate:            u.JoinDate,
+		SuspendedUntil:      u.SuspendedUntil,
+		DeletionScheduledAt: u.DeletionScheduledAt,
 	}
 }
+
+// IsBanned 判断账号是否处于封禁中
+func (u *User) IsBanned(now time.Time) bool {
+	return u.BannedAt != nil && (u.BannedUntil == nil || now.Before(*u.BannedUntil))
+}
+
+// IsSuspended 判断账号是否处于暂停中
+func (u *User) IsSuspended(now time.Time) bool {
+	return u.SuspendedUntil != nil && now.Before(*u.SuspendedUntil)
+}
+
+// CanChat 判断账号当前能否匹配和与其他用户聊天
+func (u *User) CanChat(now time.Time) bool {
+	return !u.IsBanned(now) && !u.IsSuspended(now)
+}
+
+// HasRole 判断用户是否拥有指定角色，管理员拥有全部角色
+func (u *User) HasRole(role UserRole) bool {
+	return u.Role == role || u.Role == RoleAdmin
+}
@@ -11,14 +11,20 @@ type UserProfile struct {
 	Interests []Interest `json:"interests" gorm:"many2many:user_interests"`
 	Tags      []Tag      `json:"tags" gorm:"many2many:user_tags"`
 
+	// 基本资料
+	DisplayName string     `json:"display_name" gorm:"size:50"`
+	Bio         string     `json:"bio" gorm:"size:500"`
+	Birthday    *time.Time `json:"birthday" gorm:"type:date"`
+	AvatarKey   string     `json:"-" gorm:"size:255"` // 头像在对象存储中的键前缀
+
 	// 行为数据
 	LastActive   time.Time `json:"last_active"`
 	LoginCount   int       `json:"login_count"`
 	MessageCount int       `json:"message_count"`
-	ActiveHours  []int     `json:"active_hours" gorm:"type:json"` // 活跃时间段
+	ActiveHours  []int     `json:"active_hours" gorm:"type:json;serializer:json"` // 活跃时间段
 
 	// 偏好设置
-	PreferredLanguages []string `json:"preferred_languages" gorm:"type:json"`
+	PreferredLanguages []string `json:"preferred_languages" gorm:"type:json;serializer:json"`
 	AgeRange           string   `json:"age_range"`
 	Gender             string   `json:"gender"`
 	Location           string   `json:"location"`
@@ -29,10 +35,23 @@ type UserProfile struct {
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
+// Age 根据生日计算年龄，未设置生日时返回0
+func (p *UserProfile) Age(now time.Time) int {
+	if p.Birthday == nil {
+		return 0
+	}
+	b := p.Birthday.In(now.Location())
+	age := now.Year() - b.Year()
+	if now.Month() < b.Month() || (now.Month() == b.Month() && now.Day() < b.Day()) {
+		age--
+	}
+	return age
+}
+
 // Interest 兴趣模型
 type Interest struct {
 	ID    uint    `json:"id" gorm:"primaryKey"`
-	Name  string  `json:"name"`
+	Name  string  `json:"name" gorm:"size:50;uniqueIndex"`
 	Score float64 `json:"score"` // 兴趣强度评分
 }
 
@@ -53,3 +72,58 @@ type UserBehavior struct {
 	Duration  int       `json:"duration"` // 行为持续时间（秒）
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// ProfileUpdateRequest 更新用户资料请求，字段为空表示不修改
+type ProfileUpdateRequest struct {
+	Username    *string   `json:"username" binding:"omitempty,min=3,max=50"`
+	DisplayName *string   `json:"displayName" binding:"omitempty,max=50"`
+	Bio         *string   `json:"bio" binding:"omitempty,max=500"`
+	Birthday    *string   `json:"birthday"` // 格式 2006-01-02，空字符串表示清除
+	Gender      *string   `json:"gender" binding:"omitempty,oneof=male female other"`
+	Location    *string   `json:"location" binding:"omitempty,max=100"`
+	Languages   *[]string `json:"languages" binding:"omitempty,max=10,dive,min=2,max=10"`
+	Interests   *[]string `json:"interests" binding:"omitempty,max=20,dive,min=1,max=30"`
+}
+
+// ProfileResponse 用户资料响应
+type ProfileResponse struct {
+	UserID      uint              `json:"userId"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"displayName"`
+	Bio         string            `json:"bio"`
+	Birthday    string            `json:"birthday,omitempty"`
+	Age         int               `json:"age,omitempty"`
+	Gender      string            `json:"gender"`
+	Location    string            `json:"location"`
+	Languages   []string          `json:"languages"`
+	Interests   []string          `json:"interests"`
+	Avatars     map[string]string `json:"avatars,omitempty"` // 尺寸 -> URL
+}
+
+// ToResponse 转换为响应
+func (p *UserProfile) ToResponse(user *User, now time.Time) ProfileResponse {
+	resp := ProfileResponse{
+		UserID:      user.ID,
+		Username:    user.Username,
+		DisplayName: p.DisplayName,
+		Bio:         p.Bio,
+		Age:         p.Age(now),
+		Gender:      p.Gender,
+		Location:    p.Location,
+		Languages:   p.PreferredLanguages,
+		Interests:   make([]string, 0, len(p.Interests)),
+	}
+	if resp.DisplayName == "" {
+		resp.DisplayName = user.Username
+	}
+	if resp.Languages == nil {
+		resp.Languages = []string{}
+	}
+	if p.Birthday != nil {
+		resp.Birthday = p.Birthday.Format("2006-01-02")
+	}
+	for _, interest := range p.Interests {
+		resp.Interests = append(resp.Interests, interest.Name)
+	}
+	return resp
+}
@@ -5,27 +5,69 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"unicode"
 
 	"github.com/BinLe1988/multi-agent-chatter/configs"
 )
 
 // AI相关配置
 var (
-	apiKey string
-	model  string
+	apiKey    string
+	model     string
+	maxTokens int
 )
 
+// defaultMaxTokens 未配置时单条回复的最大token数
+const defaultMaxTokens = 1024
+
+// systemPrompt 系统提示词
+const systemPrompt = "你是一个智能助手，请简明扼要地回答问题。"
+
 // 初始化AI配置
 func InitConfig(cfg *configs.Config) {
 	ai := cfg.AI
 	apiKey = ai.APIKey
 	model = ai.Model
+	maxTokens = ai.MaxCompletionTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+}
+
+// DefaultModel 未指定模型时使用的模型
+func DefaultModel() string {
+	return model
+}
+
+// MaxCompletionTokens 单条回复的最大token数，也是预估费用时使用的输出token数
+func MaxCompletionTokens() int {
+	return maxTokens
+}
+
+// Options 生成回复的参数
+type Options struct {
+	Model string // 为空时使用默认模型
+}
+
+// Usage token用量
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Reply AI回复及其用量
+type Reply struct {
+	Content string
+	Model   string
+	Usage   Usage
 }
 
 // 请求结构体
 type openAIRequest struct {
-	Model    string    `json:"model"`
-	Messages []message `json:"messages"`
+	Model     string    `json:"model"`
+	Messages  []message `json:"messages"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
 }
 
 type message struct {
@@ -40,36 +82,37 @@ type openAIResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Model string `json:"model"`
+	Usage Usage  `json:"usage"`
 	Error struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-// GenerateResponse 生成AI回复
-func GenerateResponse(userMessage string, sessionContext string) (string, error) {
+// GenerateResponse 生成AI回复，返回上游报告的token用量
+func GenerateResponse(userMessage string, options Options) (*Reply, error) {
+	if options.Model == "" {
+		options.Model = model
+	}
 	if apiKey == "" {
-		return "AI服务未配置，请联系管理员。", nil
+		return &Reply{Content: "AI服务未配置，请联系管理员。", Model: options.Model}, nil
 	}
 
 	// 构建请求
-	messages := []message{
-		{Role: "system", Content: "你是一个智能助手，请简明扼要地回答问题。"},
-		{Role: "user", Content: userMessage},
-	}
-
 	requestBody := openAIRequest{
-		Model:    model,
-		Messages: messages,
+		Model:     options.Model,
+		Messages:  buildMessages(userMessage),
+		MaxTokens: maxTokens,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -78,22 +121,60 @@ func GenerateResponse(userMessage string, sessionContext string) (string, error)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var response openAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if response.Error.Message != "" {
-		return "", errors.New(response.Error.Message)
+		return nil, errors.New(response.Error.Message)
 	}
 
 	if len(response.Choices) == 0 {
-		return "", errors.New("no response from AI")
+		return nil, errors.New("no response from AI")
+	}
+
+	reply := &Reply{
+		Content: response.Choices[0].Message.Content,
+		Model:   response.Model,
+		Usage:   response.Usage,
+	}
+	if reply.Model == "" {
+		reply.Model = options.Model
+	}
+	return reply, nil
+}
+
+// EstimatePromptTokens 估算请求的输入token数，用于调用前预估费用
+func EstimatePromptTokens(userMessage string) int {
+	total := 3 // 回复的起始标记
+	for _, m := range buildMessages(userMessage) {
+		total += 4 + EstimateTokens(m.Content) // 每条消息的角色和分隔标记
 	}
+	return total
+}
 
-	return response.Choices[0].Message.Content, nil
+// EstimateTokens 粗略估算文本的token数
+// 中日韩文字约每字一个token，其他文字约每4个字符一个token，宁多勿少
+func EstimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+func buildMessages(userMessage string) []message {
+	return []message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userMessage},
+	}
 }
@@ -0,0 +1,111 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // 注册GIF解码器
+	"image/jpeg"
+	_ "image/png" // 注册PNG解码器
+	"io"
+	"sort"
+)
+
+// 标准头像尺寸（像素）
+var StandardSizes = []int{64, 128, 256}
+
+const (
+	// MaxUploadSize 上传文件大小上限
+	MaxUploadSize = 5 << 20
+	// MaxDimension 原图边长上限，防止解压炸弹
+	MaxDimension = 4096
+	// MinDimension 原图边长下限
+	MinDimension = 32
+)
+
+var (
+	ErrTooLarge          = errors.New("avatar: file too large")
+	ErrUnsupportedFormat = errors.New("avatar: unsupported image format")
+	ErrInvalidDimensions = errors.New("avatar: invalid image dimensions")
+)
+
+// 允许的图片格式
+var allowedFormats = map[string]bool{
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+}
+
+// Variant 处理后的某个尺寸的头像
+type Variant struct {
+	Size        int
+	Data        []byte
+	ContentType string
+}
+
+// Process 校验上传的图片，居中裁剪为正方形并缩放为标准尺寸
+func Process(r io.Reader) ([]Variant, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	// 先读取头信息校验格式和尺寸，再完整解码
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !allowedFormats[format] {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width < MinDimension || cfg.Height < MinDimension ||
+		cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, ErrInvalidDimensions
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	// 从最大尺寸开始缩放，较小尺寸基于上一次结果缩放以减少计算量
+	sizes := append([]int(nil), StandardSizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	var src image.Image = img
+	rect := cropSquare(img)
+	variants := make([]Variant, 0, len(sizes))
+	for _, size := range sizes {
+		scaled := resize(src, rect, size, size)
+		src, rect = scaled, scaled.Bounds()
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, fmt.Errorf("failed to encode avatar: %v", err)
+		}
+		variants = append(variants, Variant{
+			Size:        size,
+			Data:        buf.Bytes(),
+			ContentType: "image/jpeg",
+		})
+	}
+
+	// 按尺寸升序返回
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].Size < variants[j].Size
+	})
+	return variants, nil
+}
+
+// cropSquare 以中心为基准裁剪为正方形
+func cropSquare(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x0, y0, x0+side, y0+side)
+}
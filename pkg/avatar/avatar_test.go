@@ -0,0 +1,98 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodePNG 生成w×h的PNG，fill决定每个像素的颜色
+func encodePNG(t *testing.T, w, h int, fill func(x, y int) color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, fill(x, y))
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// near 判断JPEG有损压缩后的颜色是否接近期望值
+func near(t *testing.T, want color.RGBA, got color.Color) {
+	t.Helper()
+	r, g, b, _ := got.RGBA()
+	for i, pair := range [][2]int{{int(want.R), int(r >> 8)}, {int(want.G), int(g >> 8)}, {int(want.B), int(b >> 8)}} {
+		assert.InDelta(t, pair[0], pair[1], 24, "channel %d", i)
+	}
+}
+
+func TestProcessCropsCenterAndResizes(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	// 300×200的图左右各50像素为红色，居中裁剪后只剩蓝色
+	data := encodePNG(t, 300, 200, func(x, y int) color.Color {
+		if x < 50 || x >= 250 {
+			return red
+		}
+		return blue
+	})
+
+	variants, err := Process(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, variants, len(StandardSizes))
+	for i, v := range variants {
+		assert.Equal(t, StandardSizes[i], v.Size)
+		assert.Equal(t, "image/jpeg", v.ContentType)
+		img, err := jpeg.Decode(bytes.NewReader(v.Data))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, v.Size, v.Size), img.Bounds())
+		near(t, blue, img.At(0, v.Size/2))
+		near(t, blue, img.At(v.Size-1, v.Size/2))
+	}
+}
+
+func TestProcessFlattensTransparencyOnWhite(t *testing.T) {
+	data := encodePNG(t, 64, 64, func(x, y int) color.Color { return color.NRGBA{} })
+	variants, err := Process(bytes.NewReader(data))
+	require.NoError(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(variants[0].Data))
+	require.NoError(t, err)
+	near(t, color.RGBA{R: 255, G: 255, B: 255}, img.At(32, 32))
+}
+
+func TestProcessRejectsInvalidUploads(t *testing.T) {
+	opaque := func(x, y int) color.Color { return color.Black }
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"text", []byte("not an image"), ErrUnsupportedFormat},
+		{"truncated", encodePNG(t, 64, 64, opaque)[:40], ErrUnsupportedFormat},
+		{"too small", encodePNG(t, MinDimension-1, 64, opaque), ErrInvalidDimensions},
+		{"too wide", encodePNG(t, MaxDimension+1, MinDimension, opaque), ErrInvalidDimensions},
+		{"too large", append(encodePNG(t, 64, 64, opaque), make([]byte, MaxUploadSize)...), ErrTooLarge},
+	} {
+		_, err := Process(bytes.NewReader(tc.data))
+		assert.ErrorIs(t, err, tc.err, tc.name)
+	}
+
+	// 只读取头信息即可拒绝声明了超大尺寸的图片
+	header := encodePNG(t, 1, 1, opaque)
+	bomb := append([]byte(nil), header...)
+	copy(bomb[16:24], []byte{0, 0, 0x4e, 0x20, 0, 0, 0x4e, 0x20}) // IHDR 20000×20000
+	binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+	_, err := Process(bytes.NewReader(bomb))
+	assert.ErrorIs(t, err, ErrInvalidDimensions)
+}
@@ -0,0 +1,52 @@
+package avatar
+
+import (
+	"image"
+	"image/color"
+)
+
+// resize 将src中rect区域缩放到width×height，缩小时使用区域平均，放大时退化为最近邻
+func resize(src image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := rect.Dx(), rect.Dy()
+
+	for y := 0; y < height; y++ {
+		sy0 := rect.Min.Y + y*sh/height
+		sy1 := rect.Min.Y + (y+1)*sh/height
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			sx0 := rect.Min.X + x*sw/width
+			sx1 := rect.Min.X + (x+1)*sw/width
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			// 透明区域与白色背景混合，JPEG不支持透明通道
+			alpha := a / n
+			white := uint64(0xffff) - alpha
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
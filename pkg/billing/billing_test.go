@@ -0,0 +1,735 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/coupons"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGateway 记录下单请求，代扣按预设结果返回，退款同步成功、异步处理或返回refundErr
+// 查询返回trades中预设的结果，对账单为settlement中的CSV内容
+type fakeGateway struct {
+	orders       []payment.OrderRequest
+	charges      []payment.OrderRequest
+	refunds      []payment.RefundRequest
+	decline      bool
+	asyncRefunds bool
+	refundErr    error
+	trades       map[string]*payment.TradeResult
+	settlement   string
+}
+
+func (g *fakeGateway) Method() models.PaymentMethod { return models.PaymentAlipay }
+
+func (g *fakeGateway) CreateOrder(ctx context.Context, req payment.OrderRequest) (*payment.OrderResult, error) {
+	g.orders = append(g.orders, req)
+	return &payment.OrderResult{PaymentURL: "https://pay.example.com/" + req.OrderNo}, nil
+}
+
+func (g *fakeGateway) QueryOrder(ctx context.Context, req payment.QueryRequest) (*payment.TradeResult, error) {
+	if trade, ok := g.trades[req.OrderNo]; ok {
+		return trade, nil
+	}
+	return nil, payment.ErrOrderNotFound
+}
+
+func (g *fakeGateway) Settlement(ctx context.Context, date time.Time) ([]payment.SettlementRecord, error) {
+	if g.settlement == "" {
+		return nil, payment.ErrSettlementNotReady
+	}
+	return payment.ParseSettlementCSV(strings.NewReader(g.settlement))
+}
+
+func (g *fakeGateway) Refund(ctx context.Context, req payment.RefundRequest) (*payment.RefundResult, error) {
+	g.refunds = append(g.refunds, req)
+	if g.refundErr != nil {
+		return nil, g.refundErr
+	}
+	status := payment.TradeSuccess
+	if g.asyncRefunds {
+		status = payment.TradePending
+	}
+	return &payment.RefundResult{RefundNo: req.RefundNo, GatewayRefundID: "GR" + req.RefundNo, Status: status, Amount: req.Amount}, nil
+}
+
+func (g *fakeGateway) VerifyCallback(r *http.Request) (*payment.TradeResult, error) {
+	return nil, payment.ErrInvalidCallback
+}
+
+func (g *fakeGateway) Acknowledge(w http.ResponseWriter, err error) {}
+
+func (g *fakeGateway) ChargeRecurring(ctx context.Context, req payment.OrderRequest) (*payment.TradeResult, error) {
+	g.charges = append(g.charges, req)
+	status := payment.TradeSuccess
+	if g.decline {
+		status = payment.TradeFailed
+	}
+	return &payment.TradeResult{OrderNo: req.OrderNo, Status: status, Amount: req.Amount, Currency: req.Currency}, nil
+}
+
+// testClock 可手动推进的时钟
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestService(t *testing.T) (*Service, *fakeGateway, *testClock, uint) {
+	t.Helper()
+	db := testdb.Open(t)
+	require.NoError(t, catalog.Seed(db))
+
+	user := models.User{Username: "user", Email: "user@example.com", Credits: 100}
+	require.NoError(t, db.Create(&user).Error)
+
+	gateway := &fakeGateway{}
+	registry := payment.NewRegistry()
+	registry.Register(gateway)
+
+	clock := &testClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := NewService(db, registry, Config{
+		RenewBefore:   24 * time.Hour,
+		RetrySchedule: []time.Duration{24 * time.Hour, 72 * time.Hour},
+	})
+	svc.now = clock.now
+	return svc, gateway, clock, user.ID
+}
+
+// subscribeAndPay 订阅并模拟网关回调支付成功
+func subscribeAndPay(t *testing.T, svc *Service, userID uint, plan models.SubscriptionType) *models.Subscription {
+	t.Helper()
+	checkout, err := svc.Subscribe(context.Background(), userID, plan, models.PaymentAlipay, "", "")
+	require.NoError(t, err)
+	require.NotNil(t, checkout.Payment)
+	pay(t, svc, checkout.Payment)
+
+	sub, err := svc.Current(userID)
+	require.NoError(t, err)
+	return sub
+}
+
+func pay(t *testing.T, svc *Service, p *models.Payment) {
+	t.Helper()
+	require.NoError(t, svc.ApplyTradeResult(p, &payment.TradeResult{
+		OrderNo: p.OrderNo, Status: payment.TradeSuccess, Amount: p.Amount, Currency: string(p.Currency),
+	}))
+	require.Equal(t, models.PaymentCompleted, p.Status)
+}
+
+// recharge 充值并模拟网关回调支付成功
+func recharge(t *testing.T, svc *Service, userID uint, amount money.Amount, creditAmount int) *models.Payment {
+	t.Helper()
+	p := &models.Payment{UserID: userID, Amount: amount, Credits: creditAmount, Method: models.PaymentAlipay}
+	_, err := svc.CreatePayment(context.Background(), p, "")
+	require.NoError(t, err)
+	pay(t, svc, p)
+	return p
+}
+
+// spend 消耗积分
+func spend(t *testing.T, svc *Service, userID uint, amount int) {
+	t.Helper()
+	_, err := credits.Apply(svc.db, credits.Entry{UserID: userID, Amount: -amount, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+}
+
+func loadUser(t *testing.T, svc *Service, userID uint) models.User {
+	t.Helper()
+	var user models.User
+	require.NoError(t, svc.db.First(&user, userID).Error)
+	return user
+}
+
+func TestSubscriptionActivatesOnlyAfterPayment(t *testing.T) {
+	svc, gateway, _, userID := newTestService(t)
+
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, models.PaymentAlipay, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionPending, checkout.Subscription.Status)
+	assert.Equal(t, money.MustParse("19.90"), checkout.Payment.Amount)
+	assert.Len(t, gateway.orders, 1)
+	assert.Equal(t, models.SubscriptionFree, loadUser(t, svc, userID).SubType)
+
+	pay(t, svc, checkout.Payment)
+	// 重复回调不会重复发放积分
+	pay(t, svc, checkout.Payment)
+
+	user := loadUser(t, svc, userID)
+	assert.Equal(t, models.SubscriptionBasic, user.SubType)
+	assert.True(t, user.SubAutoRenew)
+	assert.Equal(t, 600, user.Credits)
+
+	_, err = svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, models.PaymentAlipay, "", "")
+	assert.ErrorIs(t, err, ErrAlreadySubscribed)
+}
+
+func TestMidCycleChangesAreProrated(t *testing.T) {
+	svc, _, clock, userID := newTestService(t)
+	sub := subscribeAndPay(t, svc, userID, models.SubscriptionBasic)
+
+	// 周期过半时升级，补一半差价和一半积分差额，支付完成后才切换
+	clock.t = sub.PeriodStart.Add(sub.PeriodEnd.Sub(*sub.PeriodStart) / 2)
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, "", "", "")
+	require.NoError(t, err)
+	require.NotNil(t, checkout.Payment)
+	assert.Equal(t, models.PurposeUpgrade, checkout.Payment.Purpose)
+	assert.Equal(t, money.Yuan(10), checkout.Payment.Amount)
+	assert.Equal(t, 350, checkout.Payment.Credits)
+	assert.Equal(t, models.SubscriptionBasic, loadUser(t, svc, userID).SubType)
+
+	pay(t, svc, checkout.Payment)
+	user := loadUser(t, svc, userID)
+	assert.Equal(t, models.SubscriptionPremium, user.SubType)
+	assert.Equal(t, 950, user.Credits)
+
+	// 降级立即生效，剩余时间的差价抵扣下次续费
+	checkout, err = svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, "", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, checkout.Payment)
+	assert.Equal(t, money.Yuan(10), checkout.Subscription.ProrationCredit)
+	assert.Equal(t, models.SubscriptionBasic, loadUser(t, svc, userID).SubType)
+
+	// 到期续费时扣除折算金额
+	clock.t = sub.PeriodEnd.Add(-time.Hour)
+	svc.RunOnce(context.Background())
+	renewed, err := svc.Current(userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionActive, renewed.Status)
+	assert.Equal(t, money.Zero, renewed.ProrationCredit)
+	assert.True(t, renewed.PeriodStart.Equal(*sub.PeriodEnd))
+
+	var renewal models.Payment
+	require.NoError(t, svc.db.Where("purpose = ?", models.PurposeRenewal).First(&renewal).Error)
+	assert.Equal(t, money.MustParse("9.90"), renewal.Amount)
+}
+
+func TestProrationCreditIsNettedAgainstPaymentsAndCapped(t *testing.T) {
+	svc, _, clock, userID := newTestService(t)
+	sub := subscribeAndPay(t, svc, userID, models.SubscriptionPremium)
+	clock.t = *sub.PeriodStart
+
+	// 周期开始时降级，20元差价折算后不超过基础版的价格
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("19.90"), checkout.Subscription.ProrationCredit)
+
+	// 反复升降级补的差价只能折回到上限为止，折算金额不会继续累积
+	for i := 0; i < 3; i++ {
+		checkout, err = svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, "", "", "")
+		require.NoError(t, err)
+		require.NotNil(t, checkout.Payment)
+		assert.Equal(t, money.Yuan(20), checkout.Payment.Amount)
+		pay(t, svc, checkout.Payment)
+
+		checkout, err = svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, "", "", "")
+		require.NoError(t, err)
+		assert.Equal(t, money.MustParse("19.90"), checkout.Subscription.ProrationCredit)
+	}
+
+	// 续费全部由折算金额抵扣，余额不会累积到下个周期
+	clock.t = sub.PeriodEnd.Add(-time.Hour)
+	svc.RunOnce(context.Background())
+	renewed, err := svc.Current(userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionActive, renewed.Status)
+	assert.Equal(t, money.Zero, renewed.ProrationCredit)
+	assert.Equal(t, money.MustParse("19.90"), renewed.PeriodPaid)
+
+	var renewal models.Payment
+	require.NoError(t, svc.db.Where("purpose = ?", models.PurposeRenewal).First(&renewal).Error)
+	assert.Equal(t, money.Zero, renewal.Amount)
+}
+
+func TestTrialDowngradeEarnsNoProrationCredit(t *testing.T) {
+	svc, _, clock, userID := newTestService(t)
+	_, err := coupons.Create(svc.db, models.CouponRequest{
+		Code: "PREMIUMTRIAL", Kind: models.CouponTrialMonths, Value: 1, ActiveFrom: &clock.t,
+		Plans: []models.SubscriptionType{models.SubscriptionPremium},
+	})
+	require.NoError(t, err)
+	_, err = svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, models.PaymentAlipay, "PREMIUMTRIAL", "")
+	require.NoError(t, err)
+
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionBasic, checkout.Subscription.Plan)
+	assert.Equal(t, money.Zero, checkout.Subscription.ProrationCredit, "nothing was paid for the trial")
+}
+
+func TestLatePaymentDoesNotReviveSupersededSubscription(t *testing.T) {
+	svc, _, _, userID := newTestService(t)
+	first, err := svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, models.PaymentAlipay, "", "")
+	require.NoError(t, err)
+	second, err := svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, models.PaymentAlipay, "", "")
+	require.NoError(t, err)
+
+	// 被新订阅取代的订单仍然到账，但旧订阅不会重新生效
+	pay(t, svc, first.Payment)
+	var stale models.Subscription
+	require.NoError(t, svc.db.First(&stale, first.Subscription.ID).Error)
+	assert.Equal(t, models.SubscriptionExpired, stale.Status)
+
+	pay(t, svc, second.Payment)
+	var current int64
+	svc.db.Model(&models.Subscription{}).Where("user_id = ? AND status = ?", userID, models.SubscriptionActive).Count(&current)
+	assert.Equal(t, int64(1), current)
+	assert.Equal(t, models.SubscriptionPremium, loadUser(t, svc, userID).SubType)
+}
+
+func TestFailedRenewalsRetryThenDowngrade(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	sub := subscribeAndPay(t, svc, userID, models.SubscriptionBasic)
+	gateway.decline = true
+
+	// 到期前一天发起扣款，失败后进入重试
+	clock.t = sub.PeriodEnd.Add(-23 * time.Hour)
+	svc.RunOnce(context.Background())
+	current, err := svc.Current(userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionPastDue, current.Status)
+	assert.Equal(t, 1, current.RenewalAttempts)
+	assert.Equal(t, models.SubscriptionBasic, loadUser(t, svc, userID).SubType, "keeps access while retrying")
+
+	// 未到重试时间不会再次扣款
+	clock.advance(time.Hour)
+	svc.RunOnce(context.Background())
+	assert.Len(t, gateway.charges, 1)
+
+	clock.advance(24 * time.Hour)
+	svc.RunOnce(context.Background())
+	assert.Len(t, gateway.charges, 2)
+
+	// 重试用完后订阅结束，回到免费版
+	clock.advance(72 * time.Hour)
+	svc.RunOnce(context.Background())
+	assert.Len(t, gateway.charges, 3)
+
+	current, err = svc.Current(userID)
+	require.NoError(t, err)
+	assert.Nil(t, current)
+	user := loadUser(t, svc, userID)
+	assert.Equal(t, models.SubscriptionFree, user.SubType)
+	assert.Nil(t, user.SubExpiresAt)
+	assert.False(t, user.SubAutoRenew)
+}
+
+func TestCanceledSubscriptionLapsesAtPeriodEnd(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	sub := subscribeAndPay(t, svc, userID, models.SubscriptionBasic)
+
+	_, err := svc.Cancel(userID)
+	require.NoError(t, err)
+	assert.False(t, loadUser(t, svc, userID).SubAutoRenew)
+
+	clock.t = sub.PeriodEnd.Add(-time.Hour)
+	svc.RunOnce(context.Background())
+	assert.Empty(t, gateway.charges)
+	assert.Equal(t, models.SubscriptionBasic, loadUser(t, svc, userID).SubType)
+
+	clock.advance(2 * time.Hour)
+	svc.RunOnce(context.Background())
+	assert.Equal(t, models.SubscriptionFree, loadUser(t, svc, userID).SubType)
+}
+
+func TestPriceChangeAppliesAtRenewal(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	sub := subscribeAndPay(t, svc, userID, models.SubscriptionBasic)
+	signed := sub.PlanVersionID
+
+	// 周期内涨价，升级差价仍按签约时的价格计算
+	activeFrom := clock.t.Add(time.Hour)
+	raised, err := catalog.CreatePlan(svc.db, models.PlanVersionRequest{
+		Type: models.SubscriptionBasic, Name: "基础版", Price: money.MustParse("29.90"), CreditsPerMonth: 600, ActiveFrom: &activeFrom,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, raised.Version)
+
+	clock.t = sub.PeriodStart.Add(sub.PeriodEnd.Sub(*sub.PeriodStart) / 2)
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, money.Yuan(10), checkout.Payment.Amount)
+	assert.Equal(t, signed, checkout.Subscription.PlanVersionID)
+
+	// 放弃升级，续费时切换到新版本的价格
+	clock.t = sub.PeriodEnd.Add(-time.Hour)
+	svc.RunOnce(context.Background())
+	require.Len(t, gateway.charges, 1)
+	assert.Equal(t, money.MustParse("29.90"), gateway.charges[0].Amount)
+
+	renewed, err := svc.Current(userID)
+	require.NoError(t, err)
+	assert.Equal(t, raised.ID, renewed.PlanVersionID)
+}
+
+func TestRefundsClawBackCreditsAndAllowDebt(t *testing.T) {
+	svc, gateway, _, userID := newTestService(t)
+	user := loadUser(t, svc, userID)
+	require.NoError(t, credits.RecordOpening(svc.db, &user, models.CreditSignupBonus))
+	p := recharge(t, svc, userID, money.Yuan(45), 500)
+	require.Equal(t, 600, loadUser(t, svc, userID).Credits)
+
+	// 部分退款按金额比例收回积分
+	refund, err := svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(9), Reason: "duplicate order"}, 99)
+	require.NoError(t, err)
+	assert.Equal(t, models.RefundSucceeded, refund.Status)
+	assert.Equal(t, 100, refund.ClawedBack)
+	assert.Equal(t, 500, loadUser(t, svc, userID).Credits)
+	require.NoError(t, svc.db.First(p, p.ID).Error)
+	assert.Equal(t, models.PaymentCompleted, p.Status)
+	assert.Equal(t, money.Yuan(9), p.RefundedAmount)
+
+	_, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(40), Reason: "too much"}, 99)
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	// 剩余积分大多已消耗，默认策略下余额扣为负数
+	spend(t, svc, userID, 450)
+	refund, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Reason: "customer request"}, 99)
+	require.NoError(t, err)
+	assert.Equal(t, money.Yuan(36), refund.Amount)
+	assert.Equal(t, 400, refund.Credits)
+	assert.Equal(t, 350, refund.Shortfall)
+	assert.Equal(t, models.ShortfallDebt, refund.ShortfallPolicy)
+	assert.Equal(t, -350, loadUser(t, svc, userID).Credits)
+
+	require.NoError(t, svc.db.First(p, p.ID).Error)
+	assert.Equal(t, models.PaymentRefunded, p.Status)
+	assert.Len(t, gateway.refunds, 2)
+
+	balance, err := credits.Balance(svc.db, userID)
+	require.NoError(t, err)
+	assert.Equal(t, -350, balance, "ledger matches the account balance")
+
+	_, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(1), Reason: "again"}, 99)
+	assert.ErrorIs(t, err, ErrNotRefundable)
+}
+
+func TestAsyncRefundSettlesOnCallbackOnce(t *testing.T) {
+	svc, gateway, _, userID := newTestService(t)
+	svc.config.RefundShortfall = models.ShortfallWaive
+	gateway.asyncRefunds = true
+	p := recharge(t, svc, userID, money.Yuan(10), 100)
+	spend(t, svc, userID, 150)
+
+	refund, err := svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Reason: "not delivered"}, 99)
+	require.NoError(t, err)
+	assert.Equal(t, models.RefundPending, refund.Status)
+	assert.Equal(t, 50, loadUser(t, svc, userID).Credits, "credits stay until the gateway confirms")
+
+	// 处理中的退款占用可退金额
+	_, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(1), Reason: "again"}, 99)
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	result := &payment.RefundResult{RefundNo: refund.RefundNo, Status: payment.TradeSuccess, Amount: money.Yuan(10)}
+	require.NoError(t, svc.ApplyRefundCallback(models.PaymentAlipay, result))
+	require.NoError(t, svc.ApplyRefundCallback(models.PaymentAlipay, result))
+	assert.ErrorIs(t, svc.ApplyRefundCallback(models.PaymentWechat, result), ErrRefundNotFound)
+
+	require.NoError(t, svc.db.First(refund, refund.ID).Error)
+	assert.Equal(t, models.RefundSucceeded, refund.Status)
+	assert.Equal(t, 50, refund.ClawedBack)
+	assert.Equal(t, 50, refund.Shortfall)
+	assert.Equal(t, 0, loadUser(t, svc, userID).Credits, "waived shortfall never makes the balance negative")
+
+	require.NoError(t, svc.db.First(p, p.ID).Error)
+	assert.Equal(t, models.PaymentRefunded, p.Status)
+}
+
+func TestRefundFailsOnlyWhenGatewayRejects(t *testing.T) {
+	svc, gateway, _, userID := newTestService(t)
+	p := recharge(t, svc, userID, money.Yuan(10), 100)
+
+	// 超时后网关可能已受理，退款保持处理中并继续占用可退金额
+	gateway.refundErr = fmt.Errorf("alipay: %w", context.DeadlineExceeded)
+	refund, err := svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(4), Reason: "timeout"}, 99)
+	require.NoError(t, err)
+	assert.Equal(t, models.RefundPending, refund.Status)
+	assert.Equal(t, 200, loadUser(t, svc, userID).Credits)
+	_, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(7), Reason: "again"}, 99)
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	result := &payment.RefundResult{RefundNo: refund.RefundNo, Status: payment.TradeSuccess, Amount: money.Yuan(4)}
+	require.NoError(t, svc.ApplyRefundCallback(models.PaymentAlipay, result))
+	require.NoError(t, svc.db.First(refund, refund.ID).Error)
+	assert.Equal(t, models.RefundSucceeded, refund.Status)
+	assert.Equal(t, 160, loadUser(t, svc, userID).Credits)
+
+	// 网关明确拒绝时退款失败，不占用可退金额
+	gateway.refundErr = fmt.Errorf("%w: alipay: 40004 Business Failed (ACQ.TRADE_HAS_FINISHED)", payment.ErrRejected)
+	refund, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(6), Reason: "rejected"}, 99)
+	assert.ErrorIs(t, err, ErrGatewayRefund)
+	require.NotNil(t, refund)
+	assert.Equal(t, models.RefundFailed, refund.Status)
+	assert.Equal(t, 160, loadUser(t, svc, userID).Credits)
+
+	gateway.refundErr = nil
+	refund, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Reason: "retry"}, 99)
+	require.NoError(t, err)
+	assert.Equal(t, money.Yuan(6), refund.Amount)
+	assert.Equal(t, models.RefundSucceeded, refund.Status)
+}
+
+func TestInvoicesIssuedForPaymentsAndRefunds(t *testing.T) {
+	svc, _, _, userID := newTestService(t)
+	p := recharge(t, svc, userID, money.Yuan(10), 100)
+
+	var receipt models.Invoice
+	require.NoError(t, svc.db.Where("payment_id = ? AND kind = ?", p.ID, models.InvoiceReceipt).First(&receipt).Error)
+	assert.Equal(t, "INV-2026-000001", receipt.Number)
+	assert.Equal(t, money.Yuan(10), receipt.Total)
+	assert.Equal(t, "user", receipt.BuyerName)
+
+	refund, err := svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Amount: money.Yuan(4), Reason: "partial"}, 99)
+	require.NoError(t, err)
+
+	var note models.Invoice
+	require.NoError(t, svc.db.Where("refund_id = ?", refund.ID).First(&note).Error)
+	assert.Equal(t, models.InvoiceCreditNote, note.Kind)
+	assert.Equal(t, "CN-2026-000001", note.Number)
+	assert.Equal(t, -money.Yuan(4), note.Total)
+}
+
+func TestReferralRewardReleasedAfterHoldUnlessRefunded(t *testing.T) {
+	svc, _, clock, referrerID := newTestService(t)
+	svc.config.Referral = referrals.Config{ReferrerCredits: 200, InviteeCredits: 50, HoldPeriod: 48 * time.Hour}
+	code, err := referrals.CodeFor(svc.db, referrerID)
+	require.NoError(t, err)
+
+	invite := func(name, ip string) uint {
+		invitee := models.User{Username: name, Email: name + "@example.com"}
+		require.NoError(t, svc.db.Create(&invitee).Error)
+		_, err := referrals.Register(svc.db, &invitee, code.Code, ip, "")
+		require.NoError(t, err)
+		return invitee.ID
+	}
+	kept := invite("kept", "10.0.0.1")
+	refunded := invite("refunded", "10.0.0.2")
+
+	recharge(t, svc, kept, money.Yuan(10), 100)
+	p := recharge(t, svc, refunded, money.Yuan(10), 100)
+	_, err = svc.Refund(context.Background(), p.OrderNo, models.RefundRequest{Reason: "changed mind"}, 99)
+	require.NoError(t, err)
+
+	// 冻结期内奖励不入账
+	svc.RunOnce(context.Background())
+	assert.Equal(t, 100, loadUser(t, svc, referrerID).Credits)
+	assert.Equal(t, 200, loadUser(t, svc, kept).Credits)
+
+	clock.advance(49 * time.Hour)
+	svc.RunOnce(context.Background())
+	svc.RunOnce(context.Background())
+	assert.Equal(t, 300, loadUser(t, svc, referrerID).Credits, "only the referral that was not refunded pays out, once")
+	assert.Equal(t, 250, loadUser(t, svc, kept).Credits)
+
+	var statuses []models.ReferralStatus
+	require.NoError(t, svc.db.Model(&models.Referral{}).Order("id").Pluck("status", &statuses).Error)
+	assert.Equal(t, []models.ReferralStatus{models.ReferralRewarded, models.ReferralCancelled}, statuses)
+
+	balance, err := credits.Balance(svc.db, referrerID)
+	require.NoError(t, err)
+	assert.Equal(t, 200, balance, "the reward is a ledger entry")
+}
+
+func TestCouponIsRedeemedOnlyWhenPaymentCompletes(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	_, err := coupons.Create(svc.db, models.CouponRequest{
+		Code: "spring20", Kind: models.CouponPercentOff, Value: 20, ActiveFrom: &clock.t, PerUserLimit: 1,
+	})
+	require.NoError(t, err)
+
+	p := &models.Payment{UserID: userID, Amount: money.Yuan(50), Credits: 500, Method: models.PaymentAlipay, Purpose: models.PurposeRecharge}
+	require.NoError(t, coupons.Apply(svc.db, "SPRING20", p, "", clock.now()))
+	_, err = svc.CreatePayment(context.Background(), p, "")
+	require.NoError(t, err)
+	assert.Equal(t, money.Yuan(40), gateway.orders[0].Amount)
+	assert.Equal(t, money.Yuan(50), *p.ToResponse().OriginalAmount)
+
+	// 未支付的订单占用次数但不核销，支付失败后释放
+	var redeemed int64
+	svc.db.Model(&models.CouponRedemption{}).Count(&redeemed)
+	assert.Zero(t, redeemed)
+	other := &models.Payment{UserID: userID, Amount: money.Yuan(50), Credits: 500, Method: models.PaymentAlipay, Purpose: models.PurposeRecharge}
+	assert.ErrorIs(t, coupons.Apply(svc.db, "spring20", other, "", clock.now()), coupons.ErrUserLimit)
+	require.NoError(t, svc.ApplyTradeResult(p, &payment.TradeResult{OrderNo: p.OrderNo, Status: payment.TradeFailed}))
+	require.NoError(t, coupons.Apply(svc.db, "spring20", other, "", clock.now()))
+
+	p = &models.Payment{UserID: userID, Amount: money.Yuan(50), Credits: 500, Method: models.PaymentAlipay, Purpose: models.PurposeRecharge}
+	require.NoError(t, coupons.Apply(svc.db, "SPRING20", p, "", clock.now()))
+	_, err = svc.CreatePayment(context.Background(), p, "")
+	require.NoError(t, err)
+	pay(t, svc, p)
+	pay(t, svc, p)
+	svc.db.Model(&models.CouponRedemption{}).Count(&redeemed)
+	assert.EqualValues(t, 1, redeemed)
+	assert.Equal(t, 600, loadUser(t, svc, userID).Credits)
+
+	next := &models.Payment{UserID: userID, Amount: money.Yuan(50), Credits: 500, Method: models.PaymentAlipay, Purpose: models.PurposeRecharge}
+	assert.ErrorIs(t, coupons.Apply(svc.db, "spring20", next, "", clock.now()), coupons.ErrUserLimit)
+}
+
+func TestOnlyCouponZeroAmountOrdersSkipTheGateway(t *testing.T) {
+	svc, gateway, _, userID := newTestService(t)
+
+	// 负数或没有优惠券的零元订单不能绕过网关直接完成
+	for _, amount := range []money.Amount{-money.Cents(4), 0} {
+		p := &models.Payment{UserID: userID, Amount: amount, Credits: 1 << 30, Method: models.PaymentAlipay}
+		_, err := svc.CreatePayment(context.Background(), p, "")
+		assert.ErrorIs(t, err, ErrInvalidAmount, amount)
+		assert.Zero(t, p.ID, "no order is recorded")
+		_, err = svc.placeOrder(context.Background(), p, "")
+		assert.ErrorIs(t, err, ErrInvalidAmount, amount)
+	}
+	assert.Empty(t, gateway.orders)
+
+	var user models.User
+	require.NoError(t, svc.db.First(&user, userID).Error)
+	assert.Equal(t, 100, user.Credits)
+}
+
+func TestTrialCouponStartsSubscriptionWithoutPayment(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	_, err := coupons.Create(svc.db, models.CouponRequest{
+		Code: "TRIAL3", Kind: models.CouponTrialMonths, Value: 3, ActiveFrom: &clock.t,
+		Plans: []models.SubscriptionType{models.SubscriptionBasic},
+	})
+	require.NoError(t, err)
+
+	_, err = svc.Subscribe(context.Background(), userID, models.SubscriptionPremium, models.PaymentAlipay, "TRIAL3", "")
+	assert.ErrorIs(t, err, coupons.ErrNotApplicable)
+
+	checkout, err := svc.Subscribe(context.Background(), userID, models.SubscriptionBasic, models.PaymentAlipay, "TRIAL3", "")
+	require.NoError(t, err)
+	assert.Empty(t, gateway.orders)
+	assert.Equal(t, models.PaymentCompleted, checkout.Payment.Status)
+	assert.Equal(t, money.Zero, checkout.Payment.Amount)
+	assert.Equal(t, money.MustParse("19.90"), checkout.Payment.Discount)
+
+	sub, err := svc.Current(userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubscriptionActive, sub.Status)
+	assert.True(t, sub.PeriodEnd.Equal(clock.t.AddDate(0, 3, 0)))
+	assert.Equal(t, 100+3*500, loadUser(t, svc, userID).Credits)
+
+	// 试用结束时按原价续费
+	clock.t = sub.PeriodEnd.Add(-time.Hour)
+	svc.RunOnce(context.Background())
+	require.Len(t, gateway.charges, 1)
+	assert.Equal(t, money.MustParse("19.90"), gateway.charges[0].Amount)
+}
+
+func TestStalePendingPaymentsAreQueriedThenExpired(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+
+	lost := &models.Payment{UserID: userID, Amount: money.Yuan(10), Credits: 100, Method: models.PaymentAlipay}
+	_, err := svc.CreatePayment(context.Background(), lost, "")
+	require.NoError(t, err)
+	unpaid := &models.Payment{UserID: userID, Amount: money.Yuan(20), Credits: 200, Method: models.PaymentAlipay}
+	_, err = svc.CreatePayment(context.Background(), unpaid, "")
+	require.NoError(t, err)
+
+	// 回调丢失的订单通过查询补发积分，仍在支付时限内的订单保持待支付
+	gateway.trades = map[string]*payment.TradeResult{
+		lost.OrderNo: {OrderNo: lost.OrderNo, Status: payment.TradeSuccess, Amount: money.Yuan(10), Currency: payment.DefaultCurrency},
+	}
+	clock.advance(10 * time.Minute)
+	svc.ReconcileOnce(context.Background())
+	require.NoError(t, svc.db.First(lost, lost.ID).Error)
+	require.NoError(t, svc.db.First(unpaid, unpaid.ID).Error)
+	assert.Equal(t, models.PaymentCompleted, lost.Status)
+	assert.Equal(t, models.PaymentPending, unpaid.Status)
+	assert.Equal(t, 200, loadUser(t, svc, userID).Credits)
+
+	clock.advance(time.Hour)
+	svc.ReconcileOnce(context.Background())
+	require.NoError(t, svc.db.First(unpaid, unpaid.ID).Error)
+	assert.Equal(t, models.PaymentFailed, unpaid.Status)
+}
+
+func TestPendingRenewalsDoNotStarveStaleOrders(t *testing.T) {
+	svc, _, clock, userID := newTestService(t)
+
+	// 整批的续费订单在重试计划内保持待支付，不能挡住之后超时的充值订单
+	renewals := make([]models.Payment, reconcileBatch)
+	for i := range renewals {
+		renewals[i] = models.Payment{
+			UserID: userID, OrderNo: fmt.Sprintf("S-renewal-%d", i), Amount: money.Yuan(20), Currency: money.CNY,
+			Method: models.PaymentAlipay, Purpose: models.PurposeRenewal, Status: models.PaymentPending,
+		}
+		renewals[i].CreatedAt = clock.t
+	}
+	require.NoError(t, svc.db.CreateInBatches(renewals, 100).Error)
+	clock.advance(time.Minute)
+	stale := &models.Payment{UserID: userID, Amount: money.Yuan(10), Credits: 100, Method: models.PaymentAlipay}
+	_, err := svc.CreatePayment(context.Background(), stale, "")
+	require.NoError(t, err)
+
+	clock.advance(2 * time.Hour)
+	svc.ReconcileOnce(context.Background())
+	require.NoError(t, svc.db.First(stale, stale.ID).Error)
+	assert.Equal(t, models.PaymentFailed, stale.Status)
+
+	var pending int64
+	require.NoError(t, svc.db.Model(&models.Payment{}).Where("purpose = ? AND status = ?", models.PurposeRenewal, models.PaymentPending).Count(&pending).Error)
+	assert.Equal(t, int64(reconcileBatch), pending, "renewals follow the retry schedule")
+}
+
+func TestReconciliationFixesMissedPaymentsAndReportsDiscrepancies(t *testing.T) {
+	svc, gateway, clock, userID := newTestService(t)
+	settled := recharge(t, svc, userID, money.Yuan(10), 100)
+
+	failed := &models.Payment{UserID: userID, Amount: money.Yuan(20), Credits: 200, Method: models.PaymentAlipay}
+	_, err := svc.CreatePayment(context.Background(), failed, "")
+	require.NoError(t, err)
+	require.NoError(t, svc.ApplyTradeResult(failed, &payment.TradeResult{OrderNo: failed.OrderNo, Status: payment.TradeClosed}))
+
+	unsettled := recharge(t, svc, userID, money.Yuan(30), 300)
+
+	paidAt := clock.t.Add(time.Hour).Format(time.RFC3339)
+	gateway.settlement = "type,order_no,transaction_id,refund_no,amount,currency,settled_at\n" +
+		"payment," + settled.OrderNo + ",T1,,1000,CNY," + paidAt + "\n" +
+		"payment," + failed.OrderNo + ",T2,,2000,CNY," + paidAt + "\n" +
+		"payment,R-UNKNOWN,T3,,500,CNY," + paidAt + "\n"
+
+	clock.advance(24 * time.Hour)
+	svc.ReconcileOnce(context.Background())
+	svc.ReconcileOnce(context.Background())
+
+	var reports []models.ReconciliationReport
+	require.NoError(t, svc.db.Find(&reports).Error)
+	require.Len(t, reports, 1)
+	report := reports[0]
+	assert.Equal(t, "2026-01-01", report.Date)
+	assert.Equal(t, 3, report.GatewayCount)
+	assert.Equal(t, money.Yuan(35), report.GatewayAmount)
+	assert.Equal(t, 3, report.LocalCount)
+	assert.Equal(t, money.Yuan(60), report.LocalAmount)
+	assert.Equal(t, 1, report.Fixed)
+
+	kinds := make(map[string]models.DiscrepancyKind)
+	for _, d := range report.Discrepancies {
+		kinds[d.OrderNo] = d.Kind
+	}
+	assert.Equal(t, map[string]models.DiscrepancyKind{
+		failed.OrderNo:    models.DiscrepancyStatusMismatch,
+		"R-UNKNOWN":       models.DiscrepancyUnknownOrder,
+		unsettled.OrderNo: models.DiscrepancyMissingAtGateway,
+	}, kinds)
+
+	// 网关已到账的失败订单按状态机转为完成并补发积分
+	require.NoError(t, svc.db.First(failed, failed.ID).Error)
+	assert.Equal(t, models.PaymentCompleted, failed.Status)
+	assert.Equal(t, 700, loadUser(t, svc, userID).Credits)
+}
@@ -0,0 +1,266 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoSettlement 网关不提供对账单
+var ErrNoSettlement = errors.New("billing: gateway does not provide settlement files")
+
+// reconcileBatch 每次执行最多检查的待支付订单数
+const reconcileBatch = 200
+
+// ReconcileOnce 执行一次对账任务：查询回调可能丢失的待支付订单、关闭超时未支付的订单，
+// 并为尚未对账的支付方式生成前一天的对账报告
+func (s *Service) ReconcileOnce(ctx context.Context) {
+	s.checkPending(ctx)
+
+	yesterday := s.now().AddDate(0, 0, -1)
+	for _, method := range s.gateways.Methods() {
+		var count int64
+		if err := s.db.Model(&models.ReconciliationReport{}).
+			Where("date = ? AND method = ?", yesterday.Format("2006-01-02"), method).
+			Count(&count).Error; err != nil || count > 0 {
+			continue
+		}
+		_, err := s.Reconcile(ctx, method, yesterday)
+		if err != nil && !errors.Is(err, ErrNoSettlement) && !errors.Is(err, payment.ErrSettlementNotReady) {
+			log.Printf("Failed to reconcile %s for %s: %v", method, yesterday.Format("2006-01-02"), err)
+		}
+	}
+}
+
+// checkPending 回调超时未到的待支付订单向网关查询结果，超过支付时限仍未支付的订单按失败关闭
+// 关闭后网关才确认到账的订单仍会通过回调或对账转为完成；续费订单在整个重试计划内保持待支付，
+// 由续费任务在每次重试时查询和关闭，这里在查询中排除，避免积压的续费订单占满每一批
+func (s *Service) checkPending(ctx context.Context) {
+	now := s.now()
+	var stale []models.Payment
+	if err := s.db.Where("status = ? AND purpose <> ? AND created_at <= ?",
+		models.PaymentPending, models.PurposeRenewal, now.Add(-s.config.QueryAfter)).
+		Order("id").Limit(reconcileBatch).Find(&stale).Error; err != nil {
+		log.Printf("Failed to query stale pending payments: %v", err)
+		return
+	}
+
+	deadline := now.Add(-s.config.OrderTimeout - s.config.QueryAfter)
+	for i := range stale {
+		p := &stale[i]
+		s.RefreshPending(ctx, p)
+		if p.Status != models.PaymentPending || p.CreatedAt.After(deadline) {
+			continue
+		}
+		if err := s.transition(p, models.PaymentFailed, map[string]interface{}{}); err != nil {
+			log.Printf("Failed to expire payment %s: %v", p.OrderNo, err)
+		}
+	}
+}
+
+// Reconcile 下载网关指定日期的对账单并与本地记录核对
+// 网关已成功而本地未完成的支付和退款按状态机修正，其余差异只记录；报告按日期和支付方式保存，重复执行时覆盖
+func (s *Service) Reconcile(ctx context.Context, method models.PaymentMethod, date time.Time) (*models.ReconciliationReport, error) {
+	gateway, err := s.gateways.Get(method)
+	if err != nil {
+		return nil, err
+	}
+	settlements, ok := gateway.(payment.SettlementGateway)
+	if !ok {
+		return nil, ErrNoSettlement
+	}
+
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	records, err := settlements.Settlement(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ReconciliationReport{Date: day.Format("2006-01-02"), Method: method}
+	settledOrders := make(map[string]bool)
+	settledRefunds := make(map[string]bool)
+	for _, record := range records {
+		var d *models.Discrepancy
+		switch record.Type {
+		case payment.SettlementPayment:
+			settledOrders[record.OrderNo] = true
+			report.GatewayAmount += record.Amount
+			d, err = s.reconcilePayment(method, record)
+		case payment.SettlementRefund:
+			settledRefunds[record.RefundNo] = true
+			report.GatewayAmount -= record.Amount
+			d, err = s.reconcileRefund(method, record)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.GatewayCount++
+		if d != nil {
+			report.Discrepancies = append(report.Discrepancies, *d)
+		}
+	}
+
+	// 本地当天完成的支付和退款，免支付的订单不经过网关
+	next := day.AddDate(0, 0, 1)
+	var paid []models.Payment
+	if err := s.db.Where("method = ? AND status IN ? AND completed_at >= ? AND completed_at < ? AND amount_cents > ?",
+		method, []models.PaymentStatus{models.PaymentCompleted, models.PaymentRefunded}, day, next, 0).
+		Order("id").Find(&paid).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range paid {
+		report.LocalCount++
+		report.LocalAmount += p.Amount
+		if !settledOrders[p.OrderNo] {
+			report.Discrepancies = append(report.Discrepancies, models.Discrepancy{
+				Kind:        models.DiscrepancyMissingAtGateway,
+				OrderNo:     p.OrderNo,
+				LocalStatus: string(p.Status),
+				LocalAmount: p.Amount,
+			})
+		}
+	}
+
+	var refunds []struct {
+		models.Refund
+		OrderNo string
+	}
+	if err := s.db.Model(&models.Refund{}).Select("refunds.*, payments.order_no").
+		Joins("JOIN payments ON payments.id = refunds.payment_id").
+		Where("payments.method = ? AND refunds.status = ? AND refunds.completed_at >= ? AND refunds.completed_at < ?",
+			method, models.RefundSucceeded, day, next).
+		Order("refunds.id").Scan(&refunds).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range refunds {
+		report.LocalCount++
+		report.LocalAmount -= r.Amount
+		if !settledRefunds[r.RefundNo] {
+			report.Discrepancies = append(report.Discrepancies, models.Discrepancy{
+				Kind:        models.DiscrepancyMissingAtGateway,
+				OrderNo:     r.OrderNo,
+				RefundNo:    r.RefundNo,
+				LocalStatus: string(r.Status),
+				LocalAmount: r.Amount,
+			})
+		}
+	}
+
+	for _, d := range report.Discrepancies {
+		if d.Fixed {
+			report.Fixed++
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("date = ? AND method = ?", report.Date, method).
+			Delete(&models.ReconciliationReport{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(report).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Discrepancies) > report.Fixed {
+		log.Printf("Reconciliation of %s for %s found %d unresolved discrepancies", method, report.Date, len(report.Discrepancies)-report.Fixed)
+	}
+	return report, nil
+}
+
+// reconcilePayment 核对对账单中的一笔支付，本地未完成时按网关结果完成订单
+func (s *Service) reconcilePayment(method models.PaymentMethod, record payment.SettlementRecord) (*models.Discrepancy, error) {
+	var p models.Payment
+	err := s.db.Where("order_no = ? AND method = ?", record.OrderNo, method).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.Discrepancy{
+			Kind:          models.DiscrepancyUnknownOrder,
+			OrderNo:       record.OrderNo,
+			GatewayAmount: record.Amount,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &models.Discrepancy{
+		OrderNo:       p.OrderNo,
+		LocalStatus:   string(p.Status),
+		LocalAmount:   p.Amount,
+		GatewayAmount: record.Amount,
+	}
+	result := &payment.TradeResult{
+		OrderNo:       p.OrderNo,
+		TransactionID: record.TransactionID,
+		Status:        payment.TradeSuccess,
+		Amount:        record.Amount,
+		Currency:      record.Currency,
+		PaidAt:        &record.SettledAt,
+	}
+	if err := result.Matches(p.Amount, string(p.Currency)); err != nil {
+		d.Kind = models.DiscrepancyAmountMismatch
+		d.Note = err.Error()
+		return d, nil
+	}
+	if p.Status == models.PaymentCompleted || p.Status == models.PaymentRefunded {
+		return nil, nil
+	}
+
+	d.Kind = models.DiscrepancyStatusMismatch
+	if err := s.ApplyTradeResult(&p, result); err != nil {
+		d.Note = err.Error()
+		return d, nil
+	}
+	d.Fixed = p.Status == models.PaymentCompleted
+	return d, nil
+}
+
+// reconcileRefund 核对对账单中的一笔退款，本地仍在处理中时按网关结果完成退款
+func (s *Service) reconcileRefund(method models.PaymentMethod, record payment.SettlementRecord) (*models.Discrepancy, error) {
+	var r models.Refund
+	err := s.db.Joins("JOIN payments ON payments.id = refunds.payment_id").
+		Where("refunds.refund_no = ? AND payments.method = ?", record.RefundNo, method).
+		First(&r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.Discrepancy{
+			Kind:          models.DiscrepancyUnknownOrder,
+			OrderNo:       record.OrderNo,
+			RefundNo:      record.RefundNo,
+			GatewayAmount: record.Amount,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &models.Discrepancy{
+		OrderNo:       record.OrderNo,
+		RefundNo:      r.RefundNo,
+		LocalStatus:   string(r.Status),
+		LocalAmount:   r.Amount,
+		GatewayAmount: record.Amount,
+	}
+	if r.Amount != record.Amount {
+		d.Kind = models.DiscrepancyAmountMismatch
+		return d, nil
+	}
+	if r.Status == models.RefundSucceeded {
+		return nil, nil
+	}
+
+	d.Kind = models.DiscrepancyStatusMismatch
+	if err := s.ApplyRefundResult(&r, &payment.RefundResult{RefundNo: r.RefundNo, Status: payment.TradeSuccess, Amount: record.Amount}); err != nil {
+		d.Note = err.Error()
+		return d, nil
+	}
+	d.Fixed = r.Status == models.RefundSucceeded
+	return d, nil
+}
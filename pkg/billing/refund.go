@@ -0,0 +1,271 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/invoices"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/notify"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotRefundable    = errors.New("billing: only completed recharge orders can be refunded")
+	ErrRefundTooLarge   = errors.New("billing: refund exceeds the refundable amount")
+	ErrGatewayRefund    = errors.New("billing: payment gateway rejected the refund")
+	ErrRefundNotFound   = errors.New("billing: refund not found")
+	ErrRefundMismatched = errors.New("billing: refund callback does not match the refund")
+)
+
+// Refund 对已完成的充值订单全额或部分退款，金额为0时退还剩余的全部金额
+// 退款记录先以处理中写入，再向网关申请；网关同步返回结果时立即收回积分，否则等待退款回调
+// 只有网关明确拒绝时退款才标记为失败，超时等结果未知的错误保持处理中，由退款回调或对账确定结果
+func (s *Service) Refund(ctx context.Context, orderNo string, req models.RefundRequest, operatorID uint) (*models.Refund, error) {
+	var p models.Payment
+	var refund models.Refund
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_no = ?", orderNo).First(&p).Error; err != nil {
+			return err
+		}
+		if p.Purpose != models.PurposeRecharge || p.Status != models.PaymentCompleted {
+			return ErrNotRefundable
+		}
+
+		// 处理中和已成功的退款都占用可退金额
+		var committed struct {
+			Amount  money.Amount
+			Credits int
+		}
+		if err := tx.Model(&models.Refund{}).Where("payment_id = ? AND status <> ?", p.ID, models.RefundFailed).
+			Select("COALESCE(SUM(amount_cents), 0) AS amount, COALESCE(SUM(credits), 0) AS credits").
+			Scan(&committed).Error; err != nil {
+			return err
+		}
+		remaining := p.Amount - committed.Amount
+		amount := req.Amount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 || amount > remaining {
+			return ErrRefundTooLarge
+		}
+
+		// 积分（含优惠券赠送的积分）按金额比例收回，最后一笔退款收回剩余的全部积分，避免舍入误差
+		granted := p.Credits + p.BonusCredits
+		refundCredits := granted - committed.Credits
+		if amount < remaining {
+			refundCredits = int(money.Scale(int64(granted), amount.Cents(), p.Amount.Cents(), money.HalfUp))
+		}
+
+		refund = models.Refund{
+			PaymentID:  p.ID,
+			UserID:     p.UserID,
+			RefundNo:   "F" + s.now().Format("20060102") + uuid.New().String()[:8],
+			Amount:     amount,
+			Credits:    refundCredits,
+			Reason:     req.Reason,
+			Status:     models.RefundPending,
+			OperatorID: operatorID,
+		}
+		return tx.Create(&refund).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gateway, err := s.gateways.Get(p.Method)
+	var result *payment.RefundResult
+	if err == nil {
+		result, err = gateway.Refund(ctx, payment.RefundRequest{
+			OrderNo:        p.OrderNo,
+			GatewayOrderID: p.GatewayOrderID,
+			TransactionID:  p.TransactionID,
+			RefundNo:       refund.RefundNo,
+			Amount:         refund.Amount,
+			TotalAmount:    p.Amount,
+			Currency:       string(p.Currency),
+			Reason:         refund.Reason,
+		})
+	}
+	if err != nil && !rejected(err) {
+		// 超时或网络中断时网关可能已受理，保持处理中，由退款回调或对账确定结果
+		log.Printf("Refund %s via %s left pending: %v", refund.RefundNo, p.Method, err)
+		return &refund, nil
+	}
+	if err != nil {
+		log.Printf("Failed to refund %s via %s: %v", refund.RefundNo, p.Method, err)
+		if ferr := s.finishRefund(&refund, models.RefundFailed, ""); ferr != nil {
+			log.Printf("Failed to mark refund %s as failed: %v", refund.RefundNo, ferr)
+		}
+		return &refund, fmt.Errorf("%w: %v", ErrGatewayRefund, err)
+	}
+	if err := s.ApplyRefundResult(&refund, result); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// rejected 网关明确拒绝或未受理退款申请，此时可以安全地把退款标记为失败
+func rejected(err error) bool {
+	return errors.Is(err, payment.ErrRejected) ||
+		errors.Is(err, payment.ErrOrderNotFound) ||
+		errors.Is(err, payment.ErrUnsupportedMethod)
+}
+
+// ApplyRefundResult 把网关同步返回或异步通知的退款结果应用到退款记录，重复的通知只有一次生效
+func (s *Service) ApplyRefundResult(r *models.Refund, result *payment.RefundResult) error {
+	if result.RefundNo != "" && result.RefundNo != r.RefundNo {
+		return ErrRefundMismatched
+	}
+	switch result.Status {
+	case payment.TradeSuccess, payment.TradeRefunded:
+		return s.finishRefund(r, models.RefundSucceeded, result.GatewayRefundID)
+	case payment.TradeFailed, payment.TradeClosed:
+		return s.finishRefund(r, models.RefundFailed, result.GatewayRefundID)
+	}
+	if result.GatewayRefundID != "" && r.GatewayRefundID == "" {
+		r.GatewayRefundID = result.GatewayRefundID
+		return s.db.Model(r).Update("gateway_refund_id", result.GatewayRefundID).Error
+	}
+	return nil
+}
+
+// ApplyRefundCallback 处理网关的退款结果通知，method用于确认通知来自原支付网关
+func (s *Service) ApplyRefundCallback(method models.PaymentMethod, result *payment.RefundResult) error {
+	var r models.Refund
+	err := s.db.Joins("JOIN payments ON payments.id = refunds.payment_id").
+		Where("refunds.refund_no = ? AND payments.method = ?", result.RefundNo, method).
+		First(&r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRefundNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.ApplyRefundResult(&r, result)
+}
+
+// finishRefund 以处理中为条件结束退款，成功时在同一事务中收回积分、累计订单的退款金额、开具退款凭证并作废冻结中的邀请奖励
+func (s *Service) finishRefund(r *models.Refund, next models.RefundStatus, gatewayRefundID string) error {
+	updates := map[string]interface{}{"status": next}
+	if next == models.RefundSucceeded {
+		updates["completed_at"] = s.now()
+	}
+	if gatewayRefundID != "" {
+		updates["gateway_refund_id"] = gatewayRefundID
+	}
+
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Refund{}).Where("id = ? AND status = ?", r.ID, models.RefundPending).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+		if next != models.RefundSucceeded {
+			return nil
+		}
+
+		if err := s.clawBack(tx, r); err != nil {
+			return err
+		}
+		if _, err := invoices.IssueForRefund(tx, s.config.Invoice, r, s.now()); err != nil {
+			return err
+		}
+		if err := referrals.Cancel(tx, r.PaymentID); err != nil {
+			return err
+		}
+		var p models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, r.PaymentID).Error; err != nil {
+			return err
+		}
+		refunded := p.RefundedAmount + r.Amount
+		paymentUpdates := map[string]interface{}{"refunded_amount_cents": refunded}
+		if refunded >= p.Amount && p.Status.CanTransitionTo(models.PaymentRefunded) {
+			paymentUpdates["status"] = models.PaymentRefunded
+		}
+		return tx.Model(&p).Updates(paymentUpdates).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.db.First(r, r.ID).Error; err != nil {
+		return err
+	}
+	if applied && next == models.RefundSucceeded {
+		s.notifyRefunded(r)
+	}
+	return nil
+}
+
+// clawBack 收回退款对应的积分
+// 余额不足时按配置处理：debt 照常扣回使余额为负，waive 只扣回剩余余额；差额都记录在退款中
+func (s *Service) clawBack(tx *gorm.DB, r *models.Refund) error {
+	if r.Credits <= 0 {
+		return nil
+	}
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "credits").First(&user, r.UserID).Error; err != nil {
+		return err
+	}
+
+	amount := r.Credits
+	available := max(user.Credits, 0)
+	if available < amount {
+		r.Shortfall = amount - available
+		r.ShortfallPolicy = s.config.RefundShortfall
+		if s.config.RefundShortfall == models.ShortfallWaive {
+			amount = available
+		}
+	}
+	r.ClawedBack = amount
+
+	if _, err := credits.Apply(tx, credits.Entry{
+		UserID:         r.UserID,
+		Amount:         -amount,
+		Reason:         models.CreditRefund,
+		RefType:        models.CreditRefRefund,
+		RefID:          r.ID,
+		Note:           r.RefundNo,
+		AllowOverdraft: true,
+	}); err != nil {
+		return err
+	}
+	return tx.Model(r).Updates(map[string]interface{}{
+		"clawed_back":      r.ClawedBack,
+		"shortfall":        r.Shortfall,
+		"shortfall_policy": r.ShortfallPolicy,
+	}).Error
+}
+
+// notifyRefunded 通知用户退款已完成
+func (s *Service) notifyRefunded(r *models.Refund) {
+	body := fmt.Sprintf("您的退款%s元已原路退回，收回%d积分", r.Amount, r.ClawedBack)
+	if r.Shortfall > 0 && r.ShortfallPolicy == models.ShortfallDebt {
+		body += fmt.Sprintf("，其中%d积分已消耗，将从下次充值中抵扣", r.Shortfall)
+	}
+	notify.Send(context.Background(), r.UserID, notify.Notification{
+		Type:  notify.TypeRefundSucceeded,
+		Title: "退款已完成",
+		Body:  body,
+		Data: map[string]interface{}{
+			"refundNo":   r.RefundNo,
+			"amount":     r.Amount,
+			"clawedBack": r.ClawedBack,
+			"shortfall":  r.Shortfall,
+		},
+	})
+}
@@ -0,0 +1,241 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/notify"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RunOnce 执行一次续费任务：到期前自动续费、按计划重试失败的续费、结束到期和超时未支付的订阅，发放冻结期结束的邀请奖励
+func (s *Service) RunOnce(ctx context.Context) {
+	now := s.now()
+	current := []models.SubscriptionStatus{models.SubscriptionActive, models.SubscriptionPastDue}
+
+	var due []models.Subscription
+	if err := s.db.Where("status IN ? AND auto_renew = ? AND period_end <= ?", current, true, now.Add(s.config.RenewBefore)).
+		Where("next_retry_at IS NULL OR next_retry_at <= ?", now).
+		Find(&due).Error; err != nil {
+		log.Printf("Failed to query subscriptions due for renewal: %v", err)
+	}
+	for i := range due {
+		if err := s.renew(ctx, &due[i]); err != nil {
+			log.Printf("Failed to renew subscription %d: %v", due[i].ID, err)
+		}
+	}
+
+	var lapsed []models.Subscription
+	if err := s.db.Where("status IN ? AND auto_renew = ? AND period_end <= ?", current, false, now).
+		Find(&lapsed).Error; err != nil {
+		log.Printf("Failed to query lapsed subscriptions: %v", err)
+	}
+	for i := range lapsed {
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			return s.expire(tx, &lapsed[i])
+		}); err != nil {
+			log.Printf("Failed to expire subscription %d: %v", lapsed[i].ID, err)
+			continue
+		}
+		s.notifyExpired(&lapsed[i])
+	}
+
+	// 超时未支付的新订阅直接结束，之后到账的支付不再使其生效
+	if err := s.db.Model(&models.Subscription{}).
+		Where("status = ? AND created_at <= ?", models.SubscriptionPending, now.Add(-s.config.OrderTimeout)).
+		Updates(map[string]interface{}{"status": models.SubscriptionExpired, "ended_at": now}).Error; err != nil {
+		log.Printf("Failed to expire unpaid subscriptions: %v", err)
+	}
+
+	// 冻结期结束的邀请奖励入账
+	if _, err := referrals.ReleaseDue(s.db, now); err != nil {
+		log.Printf("Failed to release referral rewards: %v", err)
+	}
+}
+
+// renew 发起一次续费
+// 支持代扣的网关直接扣款，否则下单后通知用户支付；上一次仍未支付的续费订单在重试时视为失败
+func (s *Service) renew(ctx context.Context, sub *models.Subscription) error {
+	var unpaid []models.Payment
+	if err := s.db.Where("subscription_id = ? AND purpose = ? AND status = ?",
+		sub.ID, models.PurposeRenewal, models.PaymentPending).Find(&unpaid).Error; err != nil {
+		return err
+	}
+	if len(unpaid) > 0 {
+		for i := range unpaid {
+			s.RefreshPending(ctx, &unpaid[i])
+			if unpaid[i].Status != models.PaymentPending {
+				continue
+			}
+			if err := s.transition(&unpaid[i], models.PaymentFailed, map[string]interface{}{}); err != nil {
+				return err
+			}
+		}
+		// 结果已更新续费状态，下次执行时按新的重试计划处理
+		return nil
+	}
+
+	// 以重试计数和时间为条件占用本次续费，多个实例同时执行时只有一个发起扣款
+	now := s.now()
+	res := s.db.Model(&models.Subscription{}).
+		Where("id = ? AND renewal_attempts = ?", sub.ID, sub.RenewalAttempts).
+		Where("next_retry_at IS NULL OR next_retry_at <= ?", now).
+		Update("next_retry_at", now.Add(s.retryDelay(sub.RenewalAttempts)))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil
+	}
+
+	p, err := s.renewalPayment(sub, sub.PaymentMethod)
+	if err != nil {
+		return err
+	}
+	if !p.Amount.IsPositive() {
+		// 降级折算的金额足以抵扣续费
+		p.Amount = 0
+		if err := s.createPayment(s.db, p); err != nil {
+			return err
+		}
+		return s.transition(p, models.PaymentCompleted, map[string]interface{}{"completed_at": now})
+	}
+	if err := s.createPayment(s.db, p); err != nil {
+		return err
+	}
+
+	gateway, err := s.gateways.Get(p.Method)
+	if err != nil {
+		log.Printf("Cannot renew subscription %d: %v", sub.ID, err)
+		return s.transition(p, models.PaymentFailed, map[string]interface{}{})
+	}
+	if recurring, ok := gateway.(payment.RecurringGateway); ok {
+		result, err := recurring.ChargeRecurring(ctx, s.orderRequest(p, ""))
+		if err != nil {
+			log.Printf("Failed to charge renewal %s: %v", p.OrderNo, err)
+			return s.transition(p, models.PaymentFailed, map[string]interface{}{})
+		}
+		return s.ApplyTradeResult(p, result)
+	}
+
+	order, err := s.placeOrder(ctx, p, "")
+	if err != nil {
+		return nil
+	}
+	notify.Send(ctx, sub.UserID, notify.Notification{
+		Type:  notify.TypeRenewalDue,
+		Title: "订阅续费待支付",
+		Body:  fmt.Sprintf("您的订阅将于%s到期，请完成续费支付", sub.PeriodEnd.Format("2006-01-02")),
+		Data: map[string]interface{}{
+			"orderNo":    p.OrderNo,
+			"amount":     p.Amount,
+			"paymentUrl": order.PaymentURL,
+		},
+	})
+	return nil
+}
+
+// renewalPayment 按计划当前的生效版本生成续费订单，降级折算的金额抵扣续费
+// 计划已下架时按签约时的版本续费
+func (s *Service) renewalPayment(sub *models.Subscription, method models.PaymentMethod) (*models.Payment, error) {
+	plan, err := catalog.ActivePlan(s.db, sub.Plan, s.now())
+	if errors.Is(err, catalog.ErrNotFound) {
+		plan, err = s.subscribedPlan(sub)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.Payment{
+		UserID:         sub.UserID,
+		Amount:         plan.Price - money.Min(sub.ProrationCredit, plan.Price),
+		Currency:       plan.Currency,
+		Credits:        plan.CreditsPerMonth,
+		Method:         method,
+		Purpose:        models.PurposeRenewal,
+		SubscriptionID: sub.ID,
+		Plan:           sub.Plan,
+		PlanVersionID:  plan.ID,
+	}, nil
+}
+
+// renewalFailed 续费失败后按重试计划安排下次扣款，重试用完后结束订阅
+func (s *Service) renewalFailed(tx *gorm.DB, p *models.Payment) error {
+	var sub models.Subscription
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, p.SubscriptionID).Error; err != nil {
+		return err
+	}
+	if !sub.IsCurrent() {
+		return nil
+	}
+
+	sub.RenewalAttempts++
+	if sub.RenewalAttempts > len(s.config.RetrySchedule) || !sub.AutoRenew {
+		return s.expire(tx, &sub)
+	}
+	retryAt := s.now().Add(s.config.RetrySchedule[sub.RenewalAttempts-1])
+	sub.Status = models.SubscriptionPastDue
+	sub.NextRetryAt = &retryAt
+	if err := tx.Save(&sub).Error; err != nil {
+		return err
+	}
+	return syncUser(tx, &sub)
+}
+
+// retryDelay 第attempts次失败后到下次尝试的间隔，也是等待用户支付续费订单的时限
+func (s *Service) retryDelay(attempts int) time.Duration {
+	if attempts >= len(s.config.RetrySchedule) {
+		attempts = len(s.config.RetrySchedule) - 1
+	}
+	return s.config.RetrySchedule[attempts]
+}
+
+// notifyPaymentResult 续费失败时通知用户
+func (s *Service) notifyPaymentResult(p *models.Payment) {
+	if p.Purpose != models.PurposeRenewal || p.Status != models.PaymentFailed {
+		return
+	}
+	var sub models.Subscription
+	if err := s.db.First(&sub, p.SubscriptionID).Error; err != nil {
+		return
+	}
+	if sub.Status == models.SubscriptionExpired {
+		s.notifyExpired(&sub)
+		return
+	}
+	if sub.Status != models.SubscriptionPastDue || sub.NextRetryAt == nil {
+		return
+	}
+	notify.Send(context.Background(), sub.UserID, notify.Notification{
+		Type:  notify.TypeRenewalFailed,
+		Title: "订阅续费失败",
+		Body:  fmt.Sprintf("订阅续费扣款失败，将于%s再次尝试，请确认支付方式可用", sub.NextRetryAt.Format("2006-01-02 15:04")),
+		Data: map[string]interface{}{
+			"subscriptionId": sub.ID,
+			"attempts":       sub.RenewalAttempts,
+			"nextRetryAt":    sub.NextRetryAt,
+		},
+	})
+}
+
+// notifyExpired 通知用户订阅已结束
+func (s *Service) notifyExpired(sub *models.Subscription) {
+	notify.Send(context.Background(), sub.UserID, notify.Notification{
+		Type:  notify.TypeSubscriptionExpired,
+		Title: "订阅已结束",
+		Body:  "您的订阅已结束，账号已恢复为免费版",
+		Data: map[string]interface{}{
+			"subscriptionId": sub.ID,
+			"plan":           sub.Plan,
+		},
+	})
+}
@@ -0,0 +1,344 @@
+// Package billing 支付订单的创建与履约，订阅的续费、失败重试和到期降级，以及与网关的对账
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/coupons"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/invoices"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Config 计费服务配置
+type Config struct {
+	OrderTimeout  time.Duration   // 订单支付时限，超时未支付的新订阅随之结束
+	QueryAfter    time.Duration   // 下单后多久仍未收到回调时主动向网关查询
+	RenewBefore   time.Duration   // 到期前多久发起自动续费
+	RetrySchedule []time.Duration // 续费失败后的重试间隔，用完后订阅结束
+	Interval      time.Duration   // 后台任务执行间隔
+	// RefundShortfall 退款时积分已消耗的处理方式，models.ShortfallDebt 或 models.ShortfallWaive
+	RefundShortfall string
+	Invoice         invoices.Config  // 支付完成和退款成功时开具票据
+	Referral        referrals.Config // 被邀请人首次支付后的邀请奖励
+}
+
+// Service 计费服务，支付结果的履约都经过这里
+type Service struct {
+	db       *gorm.DB
+	gateways *payment.Registry
+	config   Config
+	now      func() time.Time
+	stopChan chan struct{}
+}
+
+// NewService 创建计费服务
+func NewService(db *gorm.DB, gateways *payment.Registry, config Config) *Service {
+	if config.OrderTimeout <= 0 {
+		config.OrderTimeout = 30 * time.Minute
+	}
+	if config.QueryAfter <= 0 {
+		config.QueryAfter = 5 * time.Minute
+	}
+	if config.RenewBefore <= 0 {
+		config.RenewBefore = 24 * time.Hour
+	}
+	if len(config.RetrySchedule) == 0 {
+		config.RetrySchedule = []time.Duration{24 * time.Hour, 72 * time.Hour, 168 * time.Hour}
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Minute
+	}
+	if config.RefundShortfall != models.ShortfallWaive {
+		config.RefundShortfall = models.ShortfallDebt
+	}
+	return &Service{
+		db:       db,
+		gateways: gateways,
+		config:   config,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Gateway 获取支付方式对应的网关
+func (s *Service) Gateway(method models.PaymentMethod) (payment.PaymentGateway, error) {
+	return s.gateways.Get(method)
+}
+
+// CreatePayment 创建支付记录并在网关下单，返回支付链接或二维码
+// 网关下单失败时订单标记为失败
+func (s *Service) CreatePayment(ctx context.Context, p *models.Payment, clientIP string) (*payment.OrderResult, error) {
+	if _, err := s.gateways.Get(p.Method); err != nil {
+		return nil, err
+	}
+	if err := s.createPayment(s.db, p); err != nil {
+		return nil, err
+	}
+	return s.placeOrder(ctx, p, clientIP)
+}
+
+// createPayment 补全订单号等字段并写入待支付记录，同时占用订单使用的优惠券
+func (s *Service) createPayment(tx *gorm.DB, p *models.Payment) error {
+	if !payable(p) {
+		return ErrInvalidAmount
+	}
+	if p.Purpose == "" {
+		p.Purpose = models.PurposeRecharge
+	}
+	if p.OrderNo == "" {
+		prefix := "S"
+		if p.Purpose == models.PurposeRecharge {
+			prefix = "R"
+		}
+		p.OrderNo = prefix + s.now().Format("20060102") + uuid.New().String()[:8]
+	}
+	if p.Currency == "" {
+		p.Currency = money.DefaultCurrency
+	}
+	p.Status = models.PaymentPending
+	p.CreatedAt = s.now()
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+		return coupons.Reserve(tx, p)
+	})
+}
+
+// payable 订单金额是否有效：金额必须为正数，只有优惠券或续费折算抵扣全款的订单可以为零
+func payable(p *models.Payment) bool {
+	if p.Amount.IsPositive() {
+		return true
+	}
+	return p.Amount.IsZero() && (p.CouponID != 0 || p.Purpose == models.PurposeRenewal)
+}
+
+// placeOrder 在网关下单，失败时订单按失败处理
+// 使用优惠券后无需支付的订单不经过网关，直接完成
+func (s *Service) placeOrder(ctx context.Context, p *models.Payment, clientIP string) (*payment.OrderResult, error) {
+	if !payable(p) {
+		return nil, ErrInvalidAmount
+	}
+	if p.Amount.IsZero() {
+		if err := s.transition(p, models.PaymentCompleted, map[string]interface{}{"completed_at": s.now()}); err != nil {
+			return nil, err
+		}
+		return &payment.OrderResult{}, nil
+	}
+
+	gateway, err := s.gateways.Get(p.Method)
+	if err == nil {
+		var result *payment.OrderResult
+		if result, err = gateway.CreateOrder(ctx, s.orderRequest(p, clientIP)); err == nil {
+			if result.GatewayOrderID != "" {
+				p.GatewayOrderID = result.GatewayOrderID
+				s.db.Model(p).Update("gateway_order_id", result.GatewayOrderID)
+			}
+			return result, nil
+		}
+	}
+
+	log.Printf("Failed to create %s order %s: %v", p.Method, p.OrderNo, err)
+	if ferr := s.transition(p, models.PaymentFailed, map[string]interface{}{}); ferr != nil {
+		log.Printf("Failed to mark payment %s as failed: %v", p.OrderNo, ferr)
+	}
+	return nil, fmt.Errorf("%w: %v", ErrGatewayOrder, err)
+}
+
+// orderRequest 由支付记录生成网关下单参数
+func (s *Service) orderRequest(p *models.Payment, clientIP string) payment.OrderRequest {
+	subject := strconv.Itoa(p.Credits) + " credits"
+	if p.Purpose != models.PurposeRecharge {
+		subject = fmt.Sprintf("%s subscription (%s)", p.Plan, p.Purpose)
+	}
+	return payment.OrderRequest{
+		OrderNo:   p.OrderNo,
+		Amount:    p.Amount,
+		Currency:  string(p.Currency),
+		Subject:   subject,
+		UserID:    p.UserID,
+		ClientIP:  clientIP,
+		ExpiresAt: p.CreatedAt.Add(s.config.OrderTimeout),
+	}
+}
+
+// ApplyTradeResult 按状态机把网关交易结果应用到支付记录
+// 状态以数据库中的当前值为条件更新，重复或并发的通知只有一次生效，履约在同一事务中完成
+func (s *Service) ApplyTradeResult(p *models.Payment, result *payment.TradeResult) error {
+	if result.OrderNo != p.OrderNo {
+		return payment.ErrInvalidCallback
+	}
+
+	var next models.PaymentStatus
+	switch result.Status {
+	case payment.TradeSuccess:
+		next = models.PaymentCompleted
+	case payment.TradeFailed, payment.TradeClosed:
+		next = models.PaymentFailed
+	default:
+		return nil
+	}
+
+	// 重复通知或不允许的流转直接忽略，应答成功以免网关继续重试
+	if p.Status == next {
+		return nil
+	}
+	if !p.Status.CanTransitionTo(next) {
+		log.Printf("Ignored %s notification for %s payment %s", result.Status, p.Status, p.OrderNo)
+		return nil
+	}
+
+	updates := map[string]interface{}{}
+	if next == models.PaymentCompleted {
+		if err := result.Matches(p.Amount, string(p.Currency)); err != nil {
+			return err
+		}
+		completedAt := s.now()
+		if result.PaidAt != nil {
+			completedAt = *result.PaidAt
+		}
+		updates["completed_at"] = completedAt
+		updates["transaction_id"] = result.TransactionID
+	}
+	return s.transition(p, next, updates)
+}
+
+// transition 以当前状态为条件更新支付记录，成功流转后在同一事务中履约，或释放优惠券并处理续费失败
+func (s *Service) transition(p *models.Payment, next models.PaymentStatus, updates map[string]interface{}) error {
+	updates["status"] = next
+
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Payment{}).
+			Where("id = ? AND status IN ?", p.ID, models.PaymentStatusesBefore(next)).
+			Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		// 已被其他通知处理
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+
+		switch {
+		case next == models.PaymentCompleted:
+			return s.fulfill(tx, p)
+		case next == models.PaymentFailed:
+			if err := coupons.Release(tx, p); err != nil {
+				return err
+			}
+			if p.Purpose == models.PurposeRenewal {
+				return s.renewalFailed(tx, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.db.First(p, p.ID).Error; err != nil {
+		return err
+	}
+	if applied {
+		s.notifyPaymentResult(p)
+	}
+	return nil
+}
+
+// fulfill 支付完成后按用途发放积分或变更订阅，核销订单使用的优惠券并开具收据
+func (s *Service) fulfill(tx *gorm.DB, p *models.Payment) error {
+	if err := coupons.Redeem(tx, p); err != nil {
+		return err
+	}
+	if _, err := invoices.IssueForPayment(tx, s.config.Invoice, p, s.now()); err != nil {
+		return err
+	}
+	if err := referrals.Qualify(tx, s.config.Referral, p, s.now()); err != nil {
+		return err
+	}
+	if p.BonusCredits > 0 {
+		if _, err := credits.Apply(tx, credits.Entry{
+			UserID:  p.UserID,
+			Amount:  p.BonusCredits,
+			Reason:  models.CreditPromotion,
+			RefType: models.CreditRefPayment,
+			RefID:   p.ID,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if p.Purpose == models.PurposeRecharge {
+		_, err := credits.Apply(tx, credits.Entry{
+			UserID:  p.UserID,
+			Amount:  p.Credits,
+			Reason:  models.CreditRecharge,
+			RefType: models.CreditRefPayment,
+			RefID:   p.ID,
+		})
+		return err
+	}
+	return s.fulfillSubscription(tx, p)
+}
+
+// RefreshPending 向网关查询待支付订单并同步结果，回调可能丢失或延迟
+func (s *Service) RefreshPending(ctx context.Context, p *models.Payment) {
+	gateway, err := s.gateways.Get(p.Method)
+	if err != nil {
+		return
+	}
+	result, err := gateway.QueryOrder(ctx, payment.QueryRequest{
+		OrderNo:        p.OrderNo,
+		GatewayOrderID: p.GatewayOrderID,
+	})
+	if err != nil {
+		if !errors.Is(err, payment.ErrOrderNotFound) {
+			log.Printf("Failed to query %s order %s: %v", p.Method, p.OrderNo, err)
+		}
+		return
+	}
+	if err := s.ApplyTradeResult(p, result); err != nil {
+		log.Printf("Failed to apply trade result for %s: %v", p.OrderNo, err)
+	}
+}
+
+// Start 启动续费和对账任务
+func (s *Service) Start() {
+	go s.loop()
+}
+
+// Stop 停止续费和对账任务
+func (s *Service) Stop() {
+	close(s.stopChan)
+}
+
+func (s *Service) loop() {
+	s.RunOnce(context.Background())
+	s.ReconcileOnce(context.Background())
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce(context.Background())
+			s.ReconcileOnce(context.Background())
+		case <-s.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/coupons"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidPlan       = errors.New("billing: invalid subscription plan")
+	ErrAlreadySubscribed = errors.New("billing: already subscribed to this plan")
+	ErrPastDue           = errors.New("billing: subscription renewal is past due")
+	ErrNoSubscription    = errors.New("billing: no active subscription")
+	ErrGatewayOrder      = errors.New("billing: failed to create order at payment gateway")
+	ErrInvalidAmount     = errors.New("billing: order amount must be positive")
+)
+
+// Checkout 订阅操作的结果，需要支付时Payment和Order不为空
+type Checkout struct {
+	Subscription *models.Subscription
+	Payment      *models.Payment
+	Order        *payment.OrderResult
+}
+
+// Current 获取用户未结束的订阅，包括等待首次支付的订阅，没有时返回nil
+func (s *Service) Current(userID uint) (*models.Subscription, error) {
+	return s.findSubscription(s.db, userID, models.SubscriptionPending, models.SubscriptionActive, models.SubscriptionPastDue)
+}
+
+func (s *Service) findSubscription(tx *gorm.DB, userID uint, statuses ...models.SubscriptionStatus) (*models.Subscription, error) {
+	var sub models.Subscription
+	err := tx.Where("user_id = ? AND status IN ?", userID, statuses).Order("id DESC").First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Subscribe 订阅付费计划
+// 没有生效中的订阅时创建待支付的订阅，支付完成后生效；已订阅时按剩余天数折算升级或降级：
+// 升级补差价，支付完成后切换；降级立即切换，差价抵扣下次续费。
+// 已关闭自动续费的订阅再次订阅同一计划时恢复自动续费，续费失败的订阅可以手动支付欠费的续费。
+// 优惠券只能用于首次订阅
+func (s *Service) Subscribe(ctx context.Context, userID uint, planType models.SubscriptionType, method models.PaymentMethod, couponCode, clientIP string) (*Checkout, error) {
+	plan, err := catalog.ActivePlan(s.db, planType, s.now())
+	if errors.Is(err, catalog.ErrNotFound) || (err == nil && plan.Price <= 0) {
+		return nil, ErrInvalidPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.findSubscription(s.db, userID, models.SubscriptionActive, models.SubscriptionPastDue)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return s.startSubscription(ctx, userID, plan, method, couponCode, clientIP)
+	}
+	if couponCode != "" {
+		return nil, coupons.ErrNotApplicable
+	}
+	if method == "" {
+		method = current.PaymentMethod
+	}
+
+	if current.Plan == planType {
+		if current.Status == models.SubscriptionPastDue {
+			return s.payOverdue(ctx, current, method, clientIP)
+		}
+		if current.AutoRenew {
+			return nil, ErrAlreadySubscribed
+		}
+		return s.resume(current, method)
+	}
+	if current.Status == models.SubscriptionPastDue {
+		return nil, ErrPastDue
+	}
+
+	previous, err := s.subscribedPlan(current)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Price > previous.Price {
+		return s.upgrade(ctx, current, previous, plan, method, clientIP)
+	}
+	return s.downgrade(current, previous, plan)
+}
+
+// startSubscription 创建待支付的新订阅，未支付的旧订阅由新订阅取代
+// 免费试用的订单无需支付，订阅立即生效
+func (s *Service) startSubscription(ctx context.Context, userID uint, plan *models.SubscriptionPlan, method models.PaymentMethod, couponCode, clientIP string) (*Checkout, error) {
+	if _, err := s.gateways.Get(method); err != nil {
+		return nil, err
+	}
+
+	sub := &models.Subscription{
+		UserID:        userID,
+		Plan:          plan.Type,
+		PlanVersionID: plan.ID,
+		Status:        models.SubscriptionPending,
+		PaymentMethod: method,
+	}
+	p := &models.Payment{
+		UserID:        userID,
+		Amount:        plan.Price,
+		Currency:      plan.Currency,
+		Credits:       plan.CreditsPerMonth,
+		Method:        method,
+		Purpose:       models.PurposeSubscribe,
+		Plan:          plan.Type,
+		PlanVersionID: plan.ID,
+	}
+	if couponCode != "" {
+		if err := coupons.Apply(s.db, couponCode, p, "", s.now()); err != nil {
+			return nil, err
+		}
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Subscription{}).
+			Where("user_id = ? AND status = ?", userID, models.SubscriptionPending).
+			Updates(map[string]interface{}{"status": models.SubscriptionExpired, "ended_at": s.now()}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(sub).Error; err != nil {
+			return err
+		}
+		p.SubscriptionID = sub.ID
+		return s.createPayment(tx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.placeOrder(ctx, p, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: sub, Payment: p, Order: order}, nil
+}
+
+// payOverdue 续费失败后由用户手动支付续费
+func (s *Service) payOverdue(ctx context.Context, sub *models.Subscription, method models.PaymentMethod, clientIP string) (*Checkout, error) {
+	p, err := s.renewalPayment(sub, method)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.CreatePayment(ctx, p, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: sub, Payment: p, Order: order}, nil
+}
+
+// resume 恢复已关闭的自动续费
+func (s *Service) resume(sub *models.Subscription, method models.PaymentMethod) (*Checkout, error) {
+	if _, err := s.gateways.Get(method); err != nil {
+		return nil, err
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		sub.AutoRenew = true
+		sub.CanceledAt = nil
+		sub.PaymentMethod = method
+		if err := tx.Save(sub).Error; err != nil {
+			return err
+		}
+		return syncUser(tx, sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: sub}, nil
+}
+
+// upgrade 周期内升级，按剩余时间补差价并补发积分，差价不足一分时直接切换
+func (s *Service) upgrade(ctx context.Context, sub *models.Subscription, previous, plan *models.SubscriptionPlan, method models.PaymentMethod, clientIP string) (*Checkout, error) {
+	remaining, total := s.remainingPeriod(sub)
+	p := &models.Payment{
+		UserID:         sub.UserID,
+		Amount:         (plan.Price - previous.Price).MulRatio(remaining, total, money.HalfUp),
+		Credits:        int(money.Scale(int64(plan.CreditsPerMonth-previous.CreditsPerMonth), remaining, total, money.HalfUp)),
+		Currency:       plan.Currency,
+		Method:         method,
+		Purpose:        models.PurposeUpgrade,
+		SubscriptionID: sub.ID,
+		Plan:           plan.Type,
+		PlanVersionID:  plan.ID,
+	}
+	if p.Credits < 0 {
+		p.Credits = 0
+	}
+
+	if !p.Amount.IsPositive() {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			sub.Plan = plan.Type
+			sub.PlanVersionID = plan.ID
+			if err := tx.Save(sub).Error; err != nil {
+				return err
+			}
+			if err := grantCredits(tx, sub, p.Credits); err != nil {
+				return err
+			}
+			return syncUser(tx, sub)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Checkout{Subscription: sub}, nil
+	}
+
+	order, err := s.CreatePayment(ctx, p, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: sub, Payment: p, Order: order}, nil
+}
+
+// downgrade 周期内降级立即生效，剩余时间的差价抵扣下次续费，已发放的积分不收回
+// 折算金额与本周期实际支付的金额（含升级补的差价）相抵，试用、优惠减免和免费切换的部分不折算，
+// 反复升降级累计的折算金额不超过降级后计划的价格
+func (s *Service) downgrade(sub *models.Subscription, previous, plan *models.SubscriptionPlan) (*Checkout, error) {
+	remaining, total := s.remainingPeriod(sub)
+	credit := money.Min((previous.Price-plan.Price).MulRatio(remaining, total, money.HalfUp), sub.PeriodPaid)
+	credit = money.Min(credit, money.Max(0, plan.Price-sub.ProrationCredit))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		sub.Plan = plan.Type
+		sub.PlanVersionID = plan.ID
+		sub.PeriodPaid -= credit
+		sub.ProrationCredit += credit
+		if err := tx.Save(sub).Error; err != nil {
+			return err
+		}
+		return syncUser(tx, sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Subscription: sub}, nil
+}
+
+// Cancel 关闭自动续费，订阅在当前周期结束后终止；续费失败的订阅立即终止
+func (s *Service) Cancel(userID uint) (*models.Subscription, error) {
+	sub, err := s.findSubscription(s.db, userID, models.SubscriptionActive, models.SubscriptionPastDue)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, ErrNoSubscription
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if sub.Status == models.SubscriptionPastDue {
+			return s.expire(tx, sub)
+		}
+		now := s.now()
+		sub.AutoRenew = false
+		sub.CanceledAt = &now
+		sub.NextRetryAt = nil
+		if err := tx.Save(sub).Error; err != nil {
+			return err
+		}
+		return syncUser(tx, sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// fulfillSubscription 订阅相关的支付完成后生效、续期或切换计划，并发放积分
+func (s *Service) fulfillSubscription(tx *gorm.DB, p *models.Payment) error {
+	var sub models.Subscription
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", p.SubscriptionID)
+	if p.Purpose == models.PurposeSubscribe {
+		// 只有仍在等待首次支付的订阅才能生效，超时结束或已被新订阅取代的订阅不会因迟到的支付重新生效
+		query = query.Where("status = ?", models.SubscriptionPending)
+	}
+	err := query.First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && p.Purpose == models.PurposeSubscribe {
+		log.Printf("Subscribe payment %s completed after subscription %d was no longer pending", p.OrderNo, p.SubscriptionID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	switch p.Purpose {
+	case models.PurposeSubscribe:
+		sub.PlanVersionID = p.PlanVersionID
+		// 免费试用的首个周期按试用月数计算
+		end := now.AddDate(0, max(p.TrialMonths, 1), 0)
+		sub.Status = models.SubscriptionActive
+		sub.PeriodStart = &now
+		sub.PeriodEnd = &end
+		sub.AutoRenew = true
+		sub.PaymentMethod = p.Method
+		sub.PeriodPaid = p.Amount
+		sub.EndedAt = nil
+	case models.PurposeRenewal:
+		// 提前续费从当前周期结束时接续，已过期的从现在开始
+		start := now
+		if sub.PeriodEnd != nil && sub.PeriodEnd.After(now) {
+			start = *sub.PeriodEnd
+		}
+		end := start.AddDate(0, 1, 0)
+		plan, err := catalog.PlanVersion(tx, p.PlanVersionID)
+		if err != nil {
+			return err
+		}
+		sub.Plan = p.Plan
+		sub.PlanVersionID = p.PlanVersionID
+		sub.Status = models.SubscriptionActive
+		sub.PeriodStart = &start
+		sub.PeriodEnd = &end
+		// 抵扣续费的折算金额来自此前的实际支付，计入本周期的实付金额
+		used := money.Min(sub.ProrationCredit, money.Max(0, plan.Price-p.Amount))
+		sub.ProrationCredit = money.Min(sub.ProrationCredit-used, plan.Price)
+		sub.PeriodPaid = p.Amount + used
+		sub.RenewalAttempts = 0
+		sub.NextRetryAt = nil
+		sub.EndedAt = nil
+	case models.PurposeUpgrade:
+		if !sub.IsCurrent() {
+			log.Printf("Upgrade payment %s completed after subscription %d ended", p.OrderNo, sub.ID)
+			return nil
+		}
+		sub.Plan = p.Plan
+		sub.PlanVersionID = p.PlanVersionID
+		sub.PeriodPaid += p.Amount
+	}
+
+	if err := tx.Save(&sub).Error; err != nil {
+		return err
+	}
+	if err := grantCredits(tx, &sub, p.Credits); err != nil {
+		return err
+	}
+	return syncUser(tx, &sub)
+}
+
+// subscribedPlan 订阅签约时的计划版本，早于版本化目录的订阅使用当前生效版本
+func (s *Service) subscribedPlan(sub *models.Subscription) (*models.SubscriptionPlan, error) {
+	if sub.PlanVersionID != 0 {
+		return catalog.PlanVersion(s.db, sub.PlanVersionID)
+	}
+	return catalog.ActivePlan(s.db, sub.Plan, s.now())
+}
+
+// expire 结束订阅，用户回到免费版
+func (s *Service) expire(tx *gorm.DB, sub *models.Subscription) error {
+	now := s.now()
+	sub.Status = models.SubscriptionExpired
+	sub.AutoRenew = false
+	sub.NextRetryAt = nil
+	sub.EndedAt = &now
+	if err := tx.Save(sub).Error; err != nil {
+		return err
+	}
+	return syncUser(tx, sub)
+}
+
+// remainingPeriod 当前周期的剩余时长和总时长，以纳秒计，用于按比例折算；没有有效周期时剩余为0
+func (s *Service) remainingPeriod(sub *models.Subscription) (remaining, total int64) {
+	if sub.PeriodStart == nil || sub.PeriodEnd == nil || !sub.PeriodEnd.After(*sub.PeriodStart) {
+		return 0, 1
+	}
+	total = int64(sub.PeriodEnd.Sub(*sub.PeriodStart))
+	remaining = int64(sub.PeriodEnd.Sub(s.now()))
+	return min(max(remaining, 0), total), total
+}
+
+// grantCredits 发放订阅积分
+func grantCredits(tx *gorm.DB, sub *models.Subscription, amount int) error {
+	_, err := credits.Apply(tx, credits.Entry{
+		UserID:  sub.UserID,
+		Amount:  amount,
+		Reason:  models.CreditSubscription,
+		RefType: models.CreditRefSubscription,
+		RefID:   sub.ID,
+		Note:    string(sub.Plan),
+	})
+	return err
+}
+
+// syncUser 把订阅状态同步到用户的订阅字段，订阅结束时回到免费版
+func syncUser(tx *gorm.DB, sub *models.Subscription) error {
+	updates := map[string]interface{}{
+		"sub_type":       models.SubscriptionFree,
+		"sub_expires_at": nil,
+		"sub_auto_renew": false,
+	}
+	if sub.IsCurrent() {
+		updates["sub_type"] = sub.Plan
+		updates["sub_expires_at"] = sub.PeriodEnd
+		updates["sub_auto_renew"] = sub.AutoRenew
+	}
+	return tx.Model(&models.User{Model: gorm.Model{ID: sub.UserID}}).Updates(updates).Error
+}
@@ -0,0 +1,237 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+// CreatePlan 新增计划版本，版本号自动递增，未指定生效时间时立即生效
+func CreatePlan(db *gorm.DB, req models.PlanVersionRequest) (*models.SubscriptionPlan, error) {
+	currency, err := money.ParseCurrency(string(req.Currency))
+	if err != nil {
+		return nil, err
+	}
+	plan := &models.SubscriptionPlan{
+		Type:            req.Type,
+		Name:            req.Name,
+		Price:           req.Price,
+		Currency:        currency,
+		CreditsPerMonth: req.CreditsPerMonth,
+		Features:        req.Features,
+		ActiveFrom:      activeFrom(req.ActiveFrom),
+		ActiveUntil:     req.ActiveUntil,
+	}
+	if err := validPeriod(plan.ActiveFrom, plan.ActiveUntil); err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.SubscriptionPlan{}).Where("type = ?", plan.Type).
+			Select("COALESCE(MAX(version), 0) + 1").Scan(&plan.Version).Error; err != nil {
+			return err
+		}
+		return tx.Create(plan).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// UpdatePlan 修改计划版本
+// 名称、特性和结束时间随时可以修改；价格、币种、积分和开始时间只能在版本生效前且未售出时修改
+func UpdatePlan(db *gorm.DB, id uint, req models.PlanVersionRequest) (*models.SubscriptionPlan, error) {
+	currency, err := money.ParseCurrency(string(req.Currency))
+	if err != nil {
+		return nil, err
+	}
+	var plan models.SubscriptionPlan
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&plan, id).Error; err != nil {
+			return err
+		}
+		from := plan.ActiveFrom
+		if req.ActiveFrom != nil {
+			from = *req.ActiveFrom
+		}
+		if req.Type != plan.Type || req.Price != plan.Price || currency != plan.Currency ||
+			req.CreditsPerMonth != plan.CreditsPerMonth || !from.Equal(plan.ActiveFrom) {
+			locked, err := planLocked(tx, &plan)
+			if err != nil {
+				return err
+			}
+			if locked || req.Type != plan.Type {
+				return ErrVersionLocked
+			}
+		}
+		if err := validPeriod(from, req.ActiveUntil); err != nil {
+			return err
+		}
+
+		plan.Name = req.Name
+		plan.Price = req.Price
+		plan.Currency = currency
+		plan.CreditsPerMonth = req.CreditsPerMonth
+		plan.Features = req.Features
+		plan.ActiveFrom = from
+		plan.ActiveUntil = req.ActiveUntil
+		return tx.Save(&plan).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// DeletePlan 删除未被引用的计划版本，已售出的版本只能通过设置结束时间下架
+func DeletePlan(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var plan models.SubscriptionPlan
+		if err := tx.First(&plan, id).Error; err != nil {
+			return err
+		}
+		sold, err := planSold(tx, plan.ID)
+		if err != nil {
+			return err
+		}
+		if sold {
+			return ErrVersionInUse
+		}
+		return tx.Delete(&plan).Error
+	})
+}
+
+// CreatePackage 新增套餐版本，版本号自动递增，未指定生效时间时立即生效
+func CreatePackage(db *gorm.DB, req models.PackageVersionRequest) (*models.RechargePackage, error) {
+	currency, err := money.ParseCurrency(string(req.Currency))
+	if err != nil {
+		return nil, err
+	}
+	pkg := &models.RechargePackage{
+		Code:        req.Code,
+		Credits:     req.Credits,
+		Price:       req.Price,
+		Currency:    currency,
+		Discount:    req.Discount,
+		ActiveFrom:  activeFrom(req.ActiveFrom),
+		ActiveUntil: req.ActiveUntil,
+	}
+	if err := validPeriod(pkg.ActiveFrom, pkg.ActiveUntil); err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.RechargePackage{}).Where("code = ?", pkg.Code).
+			Select("COALESCE(MAX(version), 0) + 1").Scan(&pkg.Version).Error; err != nil {
+			return err
+		}
+		return tx.Create(pkg).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// UpdatePackage 修改套餐版本，规则与UpdatePlan相同
+func UpdatePackage(db *gorm.DB, id uint, req models.PackageVersionRequest) (*models.RechargePackage, error) {
+	currency, err := money.ParseCurrency(string(req.Currency))
+	if err != nil {
+		return nil, err
+	}
+	var pkg models.RechargePackage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&pkg, id).Error; err != nil {
+			return err
+		}
+		from := pkg.ActiveFrom
+		if req.ActiveFrom != nil {
+			from = *req.ActiveFrom
+		}
+		if req.Code != pkg.Code || req.Price != pkg.Price || currency != pkg.Currency || req.Credits != pkg.Credits ||
+			req.Discount != pkg.Discount || !from.Equal(pkg.ActiveFrom) {
+			locked, err := packageLocked(tx, &pkg)
+			if err != nil {
+				return err
+			}
+			if locked || req.Code != pkg.Code {
+				return ErrVersionLocked
+			}
+		}
+		if err := validPeriod(from, req.ActiveUntil); err != nil {
+			return err
+		}
+
+		pkg.Credits = req.Credits
+		pkg.Price = req.Price
+		pkg.Currency = currency
+		pkg.Discount = req.Discount
+		pkg.ActiveFrom = from
+		pkg.ActiveUntil = req.ActiveUntil
+		return tx.Save(&pkg).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// DeletePackage 删除未被引用的套餐版本
+func DeletePackage(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var pkg models.RechargePackage
+		if err := tx.First(&pkg, id).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Payment{}).Where("package_version_id = ?", pkg.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrVersionInUse
+		}
+		return tx.Delete(&pkg).Error
+	})
+}
+
+// planLocked 版本已生效或已售出
+func planLocked(tx *gorm.DB, plan *models.SubscriptionPlan) (bool, error) {
+	if !plan.ActiveFrom.After(time.Now()) {
+		return true, nil
+	}
+	return planSold(tx, plan.ID)
+}
+
+// planSold 版本是否被订单或订阅引用
+func planSold(tx *gorm.DB, id uint) (bool, error) {
+	var count int64
+	if err := tx.Model(&models.Payment{}).Where("plan_version_id = ?", id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	err := tx.Model(&models.Subscription{}).Where("plan_version_id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// packageLocked 版本已生效或已售出
+func packageLocked(tx *gorm.DB, pkg *models.RechargePackage) (bool, error) {
+	if !pkg.ActiveFrom.After(time.Now()) {
+		return true, nil
+	}
+	var count int64
+	err := tx.Model(&models.Payment{}).Where("package_version_id = ?", pkg.ID).Count(&count).Error
+	return count > 0, err
+}
+
+func activeFrom(from *time.Time) time.Time {
+	if from == nil {
+		return time.Now()
+	}
+	return *from
+}
@@ -0,0 +1,135 @@
+// Package catalog 订阅计划和充值套餐的版本化目录
+//
+// 每次调整价格或积分都新增一个版本，旧版本保留，已下的订单和订阅记录其版本，
+// 因此老用户在续费前一直按签约时的价格计费。
+package catalog
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound      = errors.New("catalog: no active version")
+	ErrVersionLocked = errors.New("catalog: version is already active or sold, create a new version instead")
+	ErrVersionInUse  = errors.New("catalog: version is referenced by orders or subscriptions")
+	ErrInvalidPeriod = errors.New("catalog: active_until must be after active_from")
+)
+
+// Seed 目录为空时写入默认的计划和套餐作为第一个版本
+func Seed(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.SubscriptionPlan{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			plans := DefaultPlans()
+			if err := tx.Create(&plans).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Model(&models.RechargePackage{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			packages := DefaultPackages()
+			return tx.Create(&packages).Error
+		}
+		return nil
+	})
+}
+
+// ActivePlans 指定时间每个计划的生效版本，按价格排序
+// 多个版本同时生效时与ActivePlan一致取最新版本
+func ActivePlans(db *gorm.DB, at time.Time) ([]models.SubscriptionPlan, error) {
+	var versions []models.SubscriptionPlan
+	if err := activeAt(db, at).Order("type, version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	seen := make(map[models.SubscriptionType]bool)
+	plans := versions[:0]
+	for _, v := range versions {
+		if !seen[v.Type] {
+			seen[v.Type] = true
+			plans = append(plans, v)
+		}
+	}
+	sort.SliceStable(plans, func(i, j int) bool { return plans[i].Price < plans[j].Price })
+	return plans, nil
+}
+
+// ActivePlan 指定时间计划的生效版本
+func ActivePlan(db *gorm.DB, planType models.SubscriptionType, at time.Time) (*models.SubscriptionPlan, error) {
+	var plan models.SubscriptionPlan
+	err := activeAt(db, at).Where("type = ?", planType).Order("version DESC").First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// PlanVersion 按ID获取计划版本，包括已过期和已删除的版本
+func PlanVersion(db *gorm.DB, id uint) (*models.SubscriptionPlan, error) {
+	var plan models.SubscriptionPlan
+	if err := db.Unscoped().First(&plan, id).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ActivePackages 指定时间每个套餐的生效版本，按价格排序
+// 多个版本同时生效时与ActivePackage一致取最新版本
+func ActivePackages(db *gorm.DB, at time.Time) ([]models.RechargePackage, error) {
+	var versions []models.RechargePackage
+	if err := activeAt(db, at).Order("code, version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	packages := versions[:0]
+	for _, v := range versions {
+		if !seen[v.Code] {
+			seen[v.Code] = true
+			packages = append(packages, v)
+		}
+	}
+	sort.SliceStable(packages, func(i, j int) bool { return packages[i].Price < packages[j].Price })
+	return packages, nil
+}
+
+// ActivePackage 指定时间套餐的生效版本
+func ActivePackage(db *gorm.DB, code string, at time.Time) (*models.RechargePackage, error) {
+	var pkg models.RechargePackage
+	err := activeAt(db, at).Where("code = ?", code).Order("version DESC").First(&pkg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// activeAt 生效期包含指定时间的版本
+func activeAt(db *gorm.DB, at time.Time) *gorm.DB {
+	return db.Where("active_from <= ? AND (active_until IS NULL OR active_until > ?)", at, at)
+}
+
+// validPeriod 校验生效期
+func validPeriod(from time.Time, until *time.Time) error {
+	if until != nil && !until.After(from) {
+		return ErrInvalidPeriod
+	}
+	return nil
+}
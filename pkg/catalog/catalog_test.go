@@ -0,0 +1,195 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	require.NoError(t, Seed(db))
+	require.NoError(t, Seed(db), "seeding twice must not duplicate versions")
+	return db
+}
+
+func TestActiveVersionFollowsSchedule(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	plans, err := ActivePlans(db, now)
+	require.NoError(t, err)
+	assert.Len(t, plans, 4)
+
+	from := now.Add(24 * time.Hour)
+	next, err := CreatePlan(db, models.PlanVersionRequest{
+		Type: models.SubscriptionPremium, Name: "高级版", Price: money.MustParse("49.90"), CreditsPerMonth: 1500, ActiveFrom: &from,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, next.Version)
+
+	current, err := ActivePlan(db, models.SubscriptionPremium, now)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("39.90"), current.Price)
+
+	current, err = ActivePlan(db, models.SubscriptionPremium, from)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("49.90"), current.Price)
+
+	// 下架后不再出售
+	until := now.Add(time.Minute)
+	pkg, err := ActivePackage(db, "small", now)
+	require.NoError(t, err)
+	_, err = UpdatePackage(db, pkg.ID, models.PackageVersionRequest{
+		Code: pkg.Code, Credits: pkg.Credits, Price: pkg.Price, Discount: pkg.Discount, ActiveUntil: &until,
+	})
+	require.NoError(t, err)
+	_, err = ActivePackage(db, "small", until)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSoldVersionsAreLocked(t *testing.T) {
+	db := newTestDB(t)
+	from := time.Now().Add(time.Hour)
+	plan, err := CreatePlan(db, models.PlanVersionRequest{
+		Type: models.SubscriptionBasic, Name: "基础版", Price: money.MustParse("29.90"), CreditsPerMonth: 600, ActiveFrom: &from,
+	})
+	require.NoError(t, err)
+
+	// 未生效且未售出的版本可以改价
+	req := models.PlanVersionRequest{Type: plan.Type, Name: plan.Name, Price: money.MustParse("24.90"), CreditsPerMonth: 600, ActiveFrom: &from}
+	plan, err = UpdatePlan(db, plan.ID, req)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("24.90"), plan.Price)
+
+	require.NoError(t, db.Create(&models.Payment{
+		OrderNo: "S1", Amount: money.MustParse("24.90"), Method: models.PaymentAlipay, Purpose: models.PurposeSubscribe, PlanVersionID: plan.ID,
+	}).Error)
+
+	req.Price = money.MustParse("19.90")
+	_, err = UpdatePlan(db, plan.ID, req)
+	assert.ErrorIs(t, err, ErrVersionLocked)
+	assert.ErrorIs(t, DeletePlan(db, plan.ID), ErrVersionInUse)
+
+	// 展示信息仍可修改
+	req.Price = money.MustParse("24.90")
+	req.Name = "基础版（新）"
+	_, err = UpdatePlan(db, plan.ID, req)
+	assert.NoError(t, err)
+}
+
+func TestActivePeriodBoundaries(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	from := now.Add(time.Hour)
+	until := from.Add(time.Hour)
+
+	_, err := CreatePackage(db, models.PackageVersionRequest{Code: "promo", Credits: 100, Price: money.Yuan(5), ActiveFrom: &from, ActiveUntil: &from})
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+	earlier := from.Add(-time.Second)
+	_, err = CreatePackage(db, models.PackageVersionRequest{Code: "promo", Credits: 100, Price: money.Yuan(5), ActiveFrom: &from, ActiveUntil: &earlier})
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+
+	promo, err := CreatePackage(db, models.PackageVersionRequest{Code: "promo", Credits: 100, Price: money.Yuan(5), ActiveFrom: &from, ActiveUntil: &until})
+	require.NoError(t, err)
+	assert.Equal(t, 1, promo.Version, "rejected versions do not consume a number")
+
+	// 开始时间包含在内，结束时间不包含
+	for at, active := range map[time.Time]bool{
+		from.Add(-time.Nanosecond): false,
+		from:                       true,
+		until.Add(-time.Second):    true,
+		until:                      false,
+	} {
+		_, err := ActivePackage(db, "promo", at)
+		if active {
+			assert.NoError(t, err, at)
+		} else {
+			assert.ErrorIs(t, err, ErrNotFound, at)
+		}
+	}
+
+	packages, err := ActivePackages(db, from)
+	require.NoError(t, err)
+	codes := make([]string, 0, len(packages))
+	for _, p := range packages {
+		codes = append(codes, p.Code)
+	}
+	assert.Contains(t, codes, "promo")
+	packages, err = ActivePackages(db, now)
+	require.NoError(t, err)
+	for _, p := range packages {
+		assert.NotEqual(t, "promo", p.Code)
+	}
+}
+
+func TestVersionsArePinnedAndNumberedMonotonically(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	// 已生效的版本即使未售出也不能改价
+	current, err := ActivePlan(db, models.SubscriptionPremium, now)
+	require.NoError(t, err)
+	req := models.PlanVersionRequest{Type: current.Type, Name: current.Name, Price: money.MustParse("29.90"), CreditsPerMonth: current.CreditsPerMonth}
+	_, err = UpdatePlan(db, current.ID, req)
+	assert.ErrorIs(t, err, ErrVersionLocked)
+	req.Type = models.SubscriptionBasic
+	req.Price = current.Price
+	_, err = UpdatePlan(db, current.ID, req)
+	assert.ErrorIs(t, err, ErrVersionLocked, "a version never changes plan type")
+
+	// 同一时间有多个版本生效时取最新版本，每个计划只出现一次
+	raised, err := CreatePlan(db, models.PlanVersionRequest{
+		Type: models.SubscriptionPremium, Name: "高级版", Price: money.MustParse("44.90"), CreditsPerMonth: 1500,
+	})
+	require.NoError(t, err)
+	plans, err := ActivePlans(db, time.Now())
+	require.NoError(t, err)
+	premium := 0
+	for _, p := range plans {
+		if p.Type == models.SubscriptionPremium {
+			premium++
+			assert.Equal(t, raised.ID, p.ID)
+		}
+	}
+	assert.Equal(t, 1, premium)
+
+	// 已下架和已删除的版本仍可按ID取到签约时的价格
+	from := now.Add(time.Hour)
+	draft, err := CreatePlan(db, models.PlanVersionRequest{
+		Type: models.SubscriptionPremium, Name: "高级版", Price: money.MustParse("59.90"), CreditsPerMonth: 1500, ActiveFrom: &from,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, draft.Version)
+	require.NoError(t, DeletePlan(db, draft.ID))
+	pinned, err := PlanVersion(db, draft.ID)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("59.90"), pinned.Price)
+	pinned, err = PlanVersion(db, current.ID)
+	require.NoError(t, err)
+	assert.Equal(t, current.Price, pinned.Price)
+
+	next, err := CreatePlan(db, models.PlanVersionRequest{
+		Type: models.SubscriptionPremium, Name: "高级版", Price: money.MustParse("54.90"), CreditsPerMonth: 1500, ActiveFrom: &from,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, next.Version, "deleted versions keep their number")
+
+	// 已售出的套餐版本不能删除
+	pkg, err := CreatePackage(db, models.PackageVersionRequest{Code: "medium", Credits: 600, Price: money.Yuan(50), ActiveFrom: &from})
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&models.Payment{
+		OrderNo: "P1", Amount: money.Yuan(50), Method: models.PaymentAlipay, Purpose: models.PurposeRecharge, PackageVersionID: pkg.ID,
+	}).Error)
+	_, err = UpdatePackage(db, pkg.ID, models.PackageVersionRequest{Code: "medium", Credits: 700, Price: money.Yuan(50), ActiveFrom: &from})
+	assert.ErrorIs(t, err, ErrVersionLocked)
+	assert.ErrorIs(t, DeletePackage(db, pkg.ID), ErrVersionInUse)
+}
@@ -0,0 +1,67 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+// epoch 默认版本的生效时间，早于任何订单
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// DefaultPlans 默认订阅计划，作为目录的第一个版本
+func DefaultPlans() []models.SubscriptionPlan {
+	return []models.SubscriptionPlan{
+		{
+			Type:            models.SubscriptionFree,
+			Version:         1,
+			Name:            "免费版",
+			Price:           0,
+			Currency:        money.CNY,
+			CreditsPerMonth: 100,
+			Features:        []string{"基础聊天功能", "随机匹配", "基本AI助手"},
+			ActiveFrom:      epoch,
+		},
+		{
+			Type:            models.SubscriptionBasic,
+			Version:         1,
+			Name:            "基础版",
+			Price:           money.MustParse("19.90"),
+			Currency:        money.CNY,
+			CreditsPerMonth: 500,
+			Features:        []string{"所有免费功能", "专业AI助手", "无广告体验", "优先匹配"},
+			ActiveFrom:      epoch,
+		},
+		{
+			Type:            models.SubscriptionPremium,
+			Version:         1,
+			Name:            "高级版",
+			Price:           money.MustParse("39.90"),
+			Currency:        money.CNY,
+			CreditsPerMonth: 1200,
+			Features:        []string{"所有基础功能", "专属聊天定制", "创建聊天室", "语音转文字"},
+			ActiveFrom:      epoch,
+		},
+		{
+			Type:            models.SubscriptionUnlimited,
+			Version:         1,
+			Name:            "无限版",
+			Price:           money.MustParse("99.90"),
+			Currency:        money.CNY,
+			CreditsPerMonth: 3000,
+			Features:        []string{"所有高级功能", "无限AI助手使用", "VIP客户支持", "专属定制服务"},
+			ActiveFrom:      epoch,
+		},
+	}
+}
+
+// DefaultPackages 默认充值套餐，作为目录的第一个版本
+func DefaultPackages() []models.RechargePackage {
+	return []models.RechargePackage{
+		{Code: "small", Version: 1, Credits: 100, Price: money.Yuan(10), Currency: money.CNY, Discount: 0, ActiveFrom: epoch},
+		{Code: "medium", Version: 1, Credits: 500, Price: money.Yuan(45), Currency: money.CNY, Discount: 10, ActiveFrom: epoch},
+		{Code: "large", Version: 1, Credits: 1200, Price: money.Yuan(100), Currency: money.CNY, Discount: 15, ActiveFrom: epoch},
+		{Code: "xlarge", Version: 1, Credits: 3000, Price: money.Yuan(230), Currency: money.CNY, Discount: 20, ActiveFrom: epoch},
+	}
+}
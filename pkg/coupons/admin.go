@@ -0,0 +1,112 @@
+package coupons
+
+import (
+	"math"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+// Create 新增优惠券，未指定生效时间时立即生效
+func Create(db *gorm.DB, req models.CouponRequest) (*models.Coupon, error) {
+	coupon := &models.Coupon{Code: NormalizeCode(req.Code)}
+	if err := assign(coupon, req); err != nil {
+		return nil, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.Coupon{}).Where("code = ?", coupon.Code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDuplicateCode
+		}
+		return tx.Create(coupon).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coupon, nil
+}
+
+// Update 修改优惠券，优惠码不可修改，请求中的优惠码被忽略；已核销的优惠券不能修改类型和面值，
+// 有效期、使用次数和适用范围随时可以调整
+func Update(db *gorm.DB, id uint, req models.CouponRequest) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&coupon, id).Error; err != nil {
+			return err
+		}
+		if coupon.Redeemed > 0 && (req.Kind != coupon.Kind || req.Value != coupon.Value || req.AmountOff != coupon.AmountOff) {
+			return ErrInUse
+		}
+		if req.ActiveFrom == nil {
+			req.ActiveFrom = &coupon.ActiveFrom
+		}
+		if err := assign(&coupon, req); err != nil {
+			return err
+		}
+		return tx.Save(&coupon).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// Delete 删除未核销的优惠券，已核销或被未支付订单占用的优惠券只能通过设置结束时间停用
+func Delete(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var coupon models.Coupon
+		if err := tx.First(&coupon, id).Error; err != nil {
+			return err
+		}
+		if coupon.Redeemed > 0 || coupon.Reserved > 0 {
+			return ErrInUse
+		}
+		return tx.Delete(&coupon).Error
+	})
+}
+
+// assign 校验请求并写入优惠券
+func assign(coupon *models.Coupon, req models.CouponRequest) error {
+	switch req.Kind {
+	case models.CouponPercentOff:
+		if req.Value > 100 || req.Value*100 != math.Round(req.Value*100) {
+			return ErrInvalidValue
+		}
+		req.AmountOff = 0
+	case models.CouponAmountOff:
+		if !req.AmountOff.IsPositive() {
+			return ErrInvalidValue
+		}
+		req.Value = 0
+	case models.CouponBonusCredits, models.CouponTrialMonths:
+		if req.Value != math.Trunc(req.Value) {
+			return ErrInvalidValue
+		}
+		req.AmountOff = 0
+	}
+
+	from := time.Now()
+	if req.ActiveFrom != nil {
+		from = *req.ActiveFrom
+	}
+	if req.ActiveUntil != nil && !req.ActiveUntil.After(from) {
+		return ErrInvalidPeriod
+	}
+
+	coupon.Kind = req.Kind
+	coupon.Value = req.Value
+	coupon.AmountOff = req.AmountOff
+	coupon.ActiveFrom = from
+	coupon.ActiveUntil = req.ActiveUntil
+	coupon.MaxRedemptions = req.MaxRedemptions
+	coupon.PerUserLimit = req.PerUserLimit
+	coupon.Plans = req.Plans
+	coupon.Packages = req.Packages
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package coupons 优惠券和推广码
+//
+// 下单时校验并应用优惠券，优惠金额、赠送积分和试用月数记录在订单上；
+// 创建订单时在同一事务中占用一次使用次数，支付完成时转为核销，订单失败时释放，
+// 并发下单不会超出总次数和每个用户的次数上限。
+package coupons
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound      = errors.New("coupons: coupon not found")
+	ErrInactive      = errors.New("coupons: coupon is not active")
+	ErrExhausted     = errors.New("coupons: coupon has reached its redemption limit")
+	ErrUserLimit     = errors.New("coupons: coupon already used the maximum number of times")
+	ErrNotApplicable = errors.New("coupons: coupon does not apply to this order")
+	ErrInvalidValue  = errors.New("coupons: invalid coupon value")
+	ErrInUse         = errors.New("coupons: coupon has been redeemed")
+	ErrDuplicateCode = errors.New("coupons: code is already taken")
+	ErrInvalidPeriod = errors.New("coupons: active_until must be after active_from")
+)
+
+// NormalizeCode 优惠码不区分大小写，统一保存为大写
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Find 按优惠码查找优惠券
+func Find(db *gorm.DB, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := db.Where("code = ?", NormalizeCode(code)).First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// Apply 校验优惠券并应用到待创建的订单
+// 充值订单和首次订阅可以使用优惠券；pkg为充值套餐编码，自定义金额充值时为空。
+// 折扣和立减降低订单金额，赠送积分在支付完成时随订单发放，
+// 试用月数只适用于首次订阅，试用期内免费，订阅周期和积分按试用月数计算。
+func Apply(db *gorm.DB, code string, p *models.Payment, pkg string, at time.Time) error {
+	coupon, err := Find(db, code)
+	if err != nil {
+		return err
+	}
+	if err := checkAvailable(db, coupon, p.UserID, at); err != nil {
+		return err
+	}
+	if !applies(coupon, p, pkg) {
+		return ErrNotApplicable
+	}
+
+	var discount money.Amount
+	switch coupon.Kind {
+	case models.CouponPercentOff:
+		// 百分比转换为万分比后按四舍五入计算折扣
+		discount = p.Amount.BasisPoints(int64(math.Round(math.Min(coupon.Value, 100)*100)), money.HalfUp)
+	case models.CouponAmountOff:
+		discount = money.Min(coupon.AmountOff, p.Amount)
+	case models.CouponBonusCredits:
+		p.BonusCredits = int(coupon.Value)
+	case models.CouponTrialMonths:
+		p.TrialMonths = int(coupon.Value)
+		p.Credits *= p.TrialMonths
+		discount = p.Amount
+	}
+
+	p.CouponID = coupon.ID
+	p.Discount = discount
+	p.Amount -= discount
+	return nil
+}
+
+// checkAvailable 检查有效期和使用次数
+func checkAvailable(db *gorm.DB, coupon *models.Coupon, userID uint, at time.Time) error {
+	if !coupon.ActiveAt(at) {
+		return ErrInactive
+	}
+	if coupon.MaxRedemptions > 0 && coupon.Redeemed+coupon.Reserved >= coupon.MaxRedemptions {
+		return ErrExhausted
+	}
+	return checkUserLimit(db, coupon, userID)
+}
+
+// checkUserLimit 用户已核销和未支付订单占用的次数之和不能超过每个用户的上限
+func checkUserLimit(db *gorm.DB, coupon *models.Coupon, userID uint) error {
+	if coupon.PerUserLimit <= 0 {
+		return nil
+	}
+	var redeemed, reserved int64
+	if err := db.Model(&models.CouponRedemption{}).
+		Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).Count(&redeemed).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.CouponReservation{}).
+		Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).Count(&reserved).Error; err != nil {
+		return err
+	}
+	if int(redeemed+reserved) >= coupon.PerUserLimit {
+		return ErrUserLimit
+	}
+	return nil
+}
+
+// applies 优惠券是否适用于订单的用途、计划和套餐
+func applies(coupon *models.Coupon, p *models.Payment, pkg string) bool {
+	switch p.Purpose {
+	case models.PurposeRecharge:
+		if coupon.Kind == models.CouponTrialMonths {
+			return false
+		}
+	case models.PurposeSubscribe:
+	default:
+		return false
+	}
+
+	if len(coupon.Plans) == 0 && len(coupon.Packages) == 0 {
+		return true
+	}
+	for _, plan := range coupon.Plans {
+		if p.Purpose == models.PurposeSubscribe && plan == p.Plan {
+			return true
+		}
+	}
+	for _, code := range coupon.Packages {
+		if p.Purpose == models.PurposeRecharge && pkg != "" && code == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserve 为新建的订单占用一次使用次数，需在创建订单的事务中调用
+// 以条件更新占用总次数，更新同时锁住优惠券，之后的每用户次数检查和写入不会与并发下单交错
+func Reserve(tx *gorm.DB, p *models.Payment) error {
+	if p.CouponID == 0 {
+		return nil
+	}
+	res := tx.Model(&models.Coupon{}).
+		Where("id = ? AND (max_redemptions = 0 OR redeemed + reserved < max_redemptions)", p.CouponID).
+		UpdateColumn("reserved", gorm.Expr("reserved + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrExhausted
+	}
+
+	var coupon models.Coupon
+	if err := tx.First(&coupon, p.CouponID).Error; err != nil {
+		return err
+	}
+	if err := checkUserLimit(tx, &coupon, p.UserID); err != nil {
+		return err
+	}
+	return tx.Create(&models.CouponReservation{CouponID: p.CouponID, UserID: p.UserID, PaymentID: p.ID}).Error
+}
+
+// Release 订单失败时释放占用的使用次数，需在订单状态流转的事务中调用
+func Release(tx *gorm.DB, p *models.Payment) error {
+	if p.CouponID == 0 {
+		return nil
+	}
+	res := tx.Where("payment_id = ?", p.ID).Delete(&models.CouponReservation{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	return tx.Model(&models.Coupon{}).Where("id = ?", p.CouponID).
+		UpdateColumn("reserved", gorm.Expr("reserved - 1")).Error
+}
+
+// Redeem 订单支付完成时把占用转为核销，需在履约事务中调用
+// 同一订单只核销一次；订单失败释放占用后网关才确认到账时，已支付的订单仍然按下单时的优惠核销
+func Redeem(tx *gorm.DB, p *models.Payment) error {
+	if p.CouponID == 0 {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.CouponRedemption{}).Where("payment_id = ?", p.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	reserved := tx.Where("payment_id = ?", p.ID).Delete(&models.CouponReservation{})
+	if reserved.Error != nil {
+		return reserved.Error
+	}
+	redemption := models.CouponRedemption{
+		CouponID:     p.CouponID,
+		UserID:       p.UserID,
+		PaymentID:    p.ID,
+		Discount:     p.Discount,
+		BonusCredits: p.BonusCredits,
+		TrialMonths:  p.TrialMonths,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"redeemed": gorm.Expr("redeemed + 1")}
+	if reserved.RowsAffected > 0 {
+		updates["reserved"] = gorm.Expr("reserved - 1")
+	}
+	return tx.Model(&models.Coupon{}).Where("id = ?", p.CouponID).UpdateColumns(updates).Error
+}
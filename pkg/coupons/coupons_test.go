@@ -0,0 +1,185 @@
+package coupons
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func rechargeOrder(amount money.Amount) *models.Payment {
+	return &models.Payment{UserID: 1, Amount: amount, Credits: 1000, Purpose: models.PurposeRecharge}
+}
+
+func TestApplyComputesDiscounts(t *testing.T) {
+	db := testdb.Open(t)
+	for _, req := range []models.CouponRequest{
+		{Code: "half", Kind: models.CouponPercentOff, Value: 50},
+		{Code: "eighth", Kind: models.CouponPercentOff, Value: 12.5},
+		{Code: "minus30", Kind: models.CouponAmountOff, AmountOff: money.Yuan(30)},
+		{Code: "bonus", Kind: models.CouponBonusCredits, Value: 200},
+	} {
+		_, err := Create(db, req)
+		require.NoError(t, err)
+	}
+	_, err := Create(db, models.CouponRequest{Code: "HALF", Kind: models.CouponAmountOff, AmountOff: money.Yuan(1)})
+	assert.ErrorIs(t, err, ErrDuplicateCode)
+	_, err = Create(db, models.CouponRequest{Code: "FREE", Kind: models.CouponAmountOff})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	p := rechargeOrder(money.MustParse("99.90"))
+	require.NoError(t, Apply(db, "Half", p, "", time.Now()))
+	assert.Equal(t, money.MustParse("49.95"), p.Amount)
+	assert.Equal(t, money.MustParse("49.95"), p.Discount)
+
+	// 12.5%的折扣为12.4875元，四舍五入到分
+	p = rechargeOrder(money.MustParse("99.90"))
+	require.NoError(t, Apply(db, "eighth", p, "", time.Now()))
+	assert.Equal(t, money.MustParse("12.49"), p.Discount)
+	assert.Equal(t, money.MustParse("87.41"), p.Amount)
+
+	// 立减不超过订单金额
+	p = rechargeOrder(money.Yuan(20))
+	require.NoError(t, Apply(db, "minus30", p, "", time.Now()))
+	assert.Equal(t, money.Zero, p.Amount)
+	assert.Equal(t, money.Yuan(20), p.Discount)
+
+	p = rechargeOrder(money.Yuan(20))
+	require.NoError(t, Apply(db, "bonus", p, "", time.Now()))
+	assert.Equal(t, money.Yuan(20), p.Amount)
+	assert.Equal(t, 200, p.BonusCredits)
+
+	assert.ErrorIs(t, Apply(db, "nope", rechargeOrder(money.Yuan(20)), "", time.Now()), ErrNotFound)
+}
+
+func TestApplyEnforcesWindowLimitsAndScope(t *testing.T) {
+	db := testdb.Open(t)
+	now := time.Now()
+	until := now.Add(time.Hour)
+	coupon, err := Create(db, models.CouponRequest{
+		Code: "LARGE", Kind: models.CouponPercentOff, Value: 10, ActiveFrom: &now, ActiveUntil: &until, MaxRedemptions: 1,
+		Packages: []string{"large"},
+	})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, Apply(db, "LARGE", rechargeOrder(money.Yuan(50)), "small", now), ErrNotApplicable)
+	assert.ErrorIs(t, Apply(db, "LARGE", rechargeOrder(money.Yuan(50)), "", now), ErrNotApplicable)
+	assert.ErrorIs(t, Apply(db, "LARGE", rechargeOrder(money.Yuan(50)), "large", until), ErrInactive)
+
+	sub := &models.Payment{UserID: 1, Amount: money.MustParse("39.90"), Purpose: models.PurposeSubscribe, Plan: models.SubscriptionPremium}
+	assert.ErrorIs(t, Apply(db, "LARGE", sub, "", now), ErrNotApplicable)
+
+	p := rechargeOrder(money.Yuan(50))
+	require.NoError(t, Apply(db, "LARGE", p, "large", now))
+	require.NoError(t, db.Create(p).Error)
+	require.NoError(t, Redeem(db, p))
+	require.NoError(t, Redeem(db, p), "redeeming the same order twice must be a no-op")
+	assert.ErrorIs(t, Apply(db, "LARGE", rechargeOrder(money.Yuan(50)), "large", now), ErrExhausted)
+
+	// 已核销的优惠券不能改面值或删除
+	_, err = Update(db, coupon.ID, models.CouponRequest{Code: "LARGE", Kind: models.CouponPercentOff, Value: 20})
+	assert.ErrorIs(t, err, ErrInUse)
+	assert.ErrorIs(t, Delete(db, coupon.ID), ErrInUse)
+}
+
+// order 按下单流程应用优惠券，并在创建订单的事务中占用使用次数
+func order(db *gorm.DB, userID uint, code string) (*models.Payment, error) {
+	p := rechargeOrder(money.Yuan(50))
+	p.UserID = userID
+	p.OrderNo = fmt.Sprintf("R-%d-%d", userID, time.Now().UnixNano())
+	if err := Apply(db, code, p, "", time.Now()); err != nil {
+		return nil, err
+	}
+	return p, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+		return Reserve(tx, p)
+	})
+}
+
+func TestPendingOrdersReserveRedemptions(t *testing.T) {
+	db := testdb.Open(t)
+	coupon, err := Create(db, models.CouponRequest{Code: "ONCE", Kind: models.CouponAmountOff, AmountOff: money.Yuan(5), MaxRedemptions: 2, PerUserLimit: 1})
+	require.NoError(t, err)
+
+	first, err := order(db, 1, "ONCE")
+	require.NoError(t, err)
+	_, err = order(db, 1, "ONCE")
+	assert.ErrorIs(t, err, ErrUserLimit, "an unpaid order already holds the user's only use")
+
+	second, err := order(db, 2, "ONCE")
+	require.NoError(t, err)
+	_, err = order(db, 3, "ONCE")
+	assert.ErrorIs(t, err, ErrExhausted, "two unpaid orders hold both uses")
+
+	// 下单校验之后次数被并发订单占满时，占用失败，订单不会写入
+	late := rechargeOrder(money.Yuan(50))
+	late.UserID, late.OrderNo, late.CouponID = 3, "R-late", coupon.ID
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(late).Error; err != nil {
+			return err
+		}
+		return Reserve(tx, late)
+	})
+	assert.ErrorIs(t, err, ErrExhausted)
+	var orders int64
+	db.Model(&models.Payment{}).Where("order_no = ?", "R-late").Count(&orders)
+	assert.Zero(t, orders)
+
+	// 失败的订单释放占用，重复释放不会多减
+	require.NoError(t, Release(db, first))
+	require.NoError(t, Release(db, first))
+	third, err := order(db, 3, "ONCE")
+	require.NoError(t, err)
+
+	require.NoError(t, Redeem(db, second))
+	require.NoError(t, db.First(coupon, coupon.ID).Error)
+	assert.Equal(t, 1, coupon.Redeemed)
+	assert.Equal(t, 1, coupon.Reserved)
+	assert.ErrorIs(t, Delete(db, coupon.ID), ErrInUse)
+
+	// 释放占用后网关才确认到账的订单仍按下单时的优惠核销
+	require.NoError(t, Release(db, third))
+	require.NoError(t, Redeem(db, third))
+	require.NoError(t, Redeem(db, first))
+	require.NoError(t, db.First(coupon, coupon.ID).Error)
+	assert.Equal(t, 3, coupon.Redeemed)
+	assert.Zero(t, coupon.Reserved)
+}
+
+func TestConcurrentOrdersCannotExceedLimit(t *testing.T) {
+	db := testdb.Open(t)
+	coupon, err := Create(db, models.CouponRequest{Code: "RUSH", Kind: models.CouponPercentOff, Value: 10, MaxRedemptions: 3})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			if _, err := order(db, userID, "RUSH"); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, ErrExhausted)
+			}
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, succeeded)
+	require.NoError(t, db.First(coupon, coupon.ID).Error)
+	assert.Equal(t, 3, coupon.Reserved)
+}
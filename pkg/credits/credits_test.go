@@ -0,0 +1,147 @@
+package credits
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestUser(t *testing.T, db *gorm.DB, balance int) uint {
+	t.Helper()
+	user := models.User{Username: "user", Email: "user@example.com", Credits: balance}
+	require.NoError(t, db.Create(&user).Error)
+	require.NoError(t, RecordOpening(db, &user, models.CreditSignupBonus))
+	return user.ID
+}
+
+func balanceOf(t *testing.T, db *gorm.DB, userID uint) int {
+	t.Helper()
+	var user models.User
+	require.NoError(t, db.First(&user, userID).Error)
+	ledger, err := Balance(db, userID)
+	require.NoError(t, err)
+	assert.Equal(t, user.Credits, ledger, "account balance must match ledger")
+	return user.Credits
+}
+
+func TestApplyRejectsOverdraft(t *testing.T) {
+	db := testdb.Open(t)
+	userID := newTestUser(t, db, 5)
+
+	record, err := Apply(db, Entry{UserID: userID, Amount: -3, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+	assert.Equal(t, 2, record.BalanceAfter)
+
+	_, err = Apply(db, Entry{UserID: userID, Amount: -3, Reason: models.CreditChatUsage})
+	assert.ErrorIs(t, err, ErrInsufficientCredits)
+	assert.Equal(t, 2, balanceOf(t, db, userID))
+}
+
+func TestConcurrentReservationsNeverOverspend(t *testing.T) {
+	db := testdb.Open(t)
+	const balance, senders = 10, 40
+	userID := newTestUser(t, db, balance)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved, rejected := 0, 0
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reservation, err := Reserve(db, Entry{UserID: userID, Amount: 1, Reason: models.CreditChatUsage})
+			if err != nil {
+				assert.ErrorIs(t, err, ErrInsufficientCredits)
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			reserved++
+			mu.Unlock()
+
+			// 一半的生成失败并退回预留
+			if i%2 == 0 {
+				assert.NoError(t, Release(db, reservation))
+			} else {
+				assert.NoError(t, Commit(db, reservation, 1))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, senders, reserved+rejected)
+	assert.GreaterOrEqual(t, reserved, balance)
+
+	var committed int64
+	db.Model(&models.CreditReservation{}).Where("status = ?", models.ReservationCommitted).Count(&committed)
+	assert.Equal(t, balance-int(committed), balanceOf(t, db, userID))
+	assert.GreaterOrEqual(t, balanceOf(t, db, userID), 0)
+}
+
+func TestCommitSettlesActualCostOnce(t *testing.T) {
+	db := testdb.Open(t)
+	userID := newTestUser(t, db, 10)
+
+	// 实际消耗少于预扣，退回差额
+	reservation, err := Reserve(db, Entry{UserID: userID, Amount: 4, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+	assert.Equal(t, 6, balanceOf(t, db, userID))
+	require.NoError(t, Commit(db, reservation, 1))
+	assert.Equal(t, 9, balanceOf(t, db, userID))
+
+	// 重复结算不会再次变动余额
+	assert.ErrorIs(t, Commit(db, reservation, 1), ErrReservationSettled)
+	assert.ErrorIs(t, Release(db, reservation), ErrReservationSettled)
+	assert.Equal(t, 9, balanceOf(t, db, userID))
+
+	// 实际消耗超过预扣且余额不足时扣到零为止
+	reservation, err = Reserve(db, Entry{UserID: userID, Amount: 5, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+	require.NoError(t, Commit(db, reservation, 20))
+	assert.Equal(t, 9, reservation.Charged)
+	assert.Equal(t, 0, balanceOf(t, db, userID))
+}
+
+func TestReconcileRecordsOpeningBalance(t *testing.T) {
+	db := testdb.Open(t)
+	user := models.User{Username: "legacy", Email: "legacy@example.com", Credits: 42}
+	require.NoError(t, db.Create(&user).Error)
+
+	adjusted, err := ReconcileAll(db)
+	require.NoError(t, err)
+	assert.Equal(t, 1, adjusted)
+	assert.Equal(t, 42, balanceOf(t, db, user.ID))
+
+	adjusted, err = ReconcileAll(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, adjusted)
+}
+
+func TestReleaseStaleSkipsInFlightReservations(t *testing.T) {
+	db := testdb.Open(t)
+	userID := newTestUser(t, db, 10)
+
+	stale, err := Reserve(db, Entry{UserID: userID, Amount: 3, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(stale).Update("created_at", time.Now().Add(-2*ReservationTTL)).Error)
+	inFlight, err := Reserve(db, Entry{UserID: userID, Amount: 4, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+
+	released, err := ReleaseStale(db, time.Now().Add(-ReservationTTL))
+	require.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assert.Equal(t, 6, balanceOf(t, db, userID))
+
+	// 仍在处理的请求照常结算
+	require.NoError(t, Commit(db, inFlight, 2))
+	assert.Equal(t, 8, balanceOf(t, db, userID))
+}
@@ -0,0 +1,157 @@
+// Package credits 积分账本，所有积分变动都通过这里写入流水
+package credits
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInsufficientCredits 扣减后余额为负
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// Entry 一次积分变动
+type Entry struct {
+	UserID  uint
+	Amount  int // 正数为收入，负数为支出
+	Reason  models.CreditReason
+	RefType models.CreditRefType
+	RefID   uint
+	Note    string
+	// AllowOverdraft 允许扣减后余额为负，仅用于退款时收回已消耗的积分
+	AllowOverdraft bool
+}
+
+// Apply 原子地更新余额并写入流水，扣减后余额不足时返回ErrInsufficientCredits
+// 扣减使用带余额条件的UPDATE，并发扣减不会透支；在调用方的事务中执行时与其他写操作一起提交或回滚
+func Apply(db *gorm.DB, entry Entry) (*models.CreditTransaction, error) {
+	if entry.Amount == 0 {
+		return nil, nil
+	}
+
+	var record *models.CreditTransaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		balance, err := adjustBalance(tx, entry.UserID, entry.Amount, entry.AllowOverdraft)
+		if err != nil {
+			return err
+		}
+		record = entry.record(balance)
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// adjustBalance 以单条UPDATE调整余额并返回调整后的余额
+// UPDATE持有行锁直到事务结束，随后读取的余额不会被并发修改
+func adjustBalance(tx *gorm.DB, userID uint, amount int, allowOverdraft bool) (int, error) {
+	query := tx.Model(&models.User{Model: gorm.Model{ID: userID}})
+	if amount < 0 && !allowOverdraft {
+		query = query.Where("credits >= ?", -amount)
+	}
+	res := query.Update("credits", gorm.Expr("credits + ?", amount))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	var user models.User
+	if err := tx.Select("id", "credits").First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+	if res.RowsAffected == 0 {
+		return 0, ErrInsufficientCredits
+	}
+	return user.Credits, nil
+}
+
+// RecordOpening 为创建时已带有初始积分的用户记录开户流水
+func RecordOpening(db *gorm.DB, user *models.User, reason models.CreditReason) error {
+	if user.Credits == 0 {
+		return nil
+	}
+	return db.Create(Entry{UserID: user.ID, Amount: user.Credits, Reason: reason}.record(user.Credits)).Error
+}
+
+// Balance 由流水汇总得到的余额
+func Balance(db *gorm.DB, userID uint) (int, error) {
+	var total int
+	err := db.Model(&models.CreditTransaction{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&total).Error
+	return total, err
+}
+
+// Reconcile 核对用户余额与流水，不一致时补记一条调整流水
+// 账本上线前的历史余额也通过这里补记为期初余额
+func Reconcile(db *gorm.DB, userID uint) (*models.CreditTransaction, error) {
+	var record *models.CreditTransaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "credits").
+			First(&user, userID).Error; err != nil {
+			return err
+		}
+		ledger, err := Balance(tx, userID)
+		if err != nil {
+			return err
+		}
+		if ledger == user.Credits {
+			return nil
+		}
+
+		record = Entry{
+			UserID: userID,
+			Amount: user.Credits - ledger,
+			Reason: models.CreditAdjustment,
+			Note:   fmt.Sprintf("reconciled ledger balance %d with account balance %d", ledger, user.Credits),
+		}.record(user.Credits)
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ReconcileAll 核对所有余额与流水不一致的用户，返回补记的流水条数
+func ReconcileAll(db *gorm.DB) (int, error) {
+	ledger := db.Model(&models.CreditTransaction{}).
+		Select("user_id, SUM(amount) AS total").Group("user_id")
+
+	var userIDs []uint
+	if err := db.Model(&models.User{}).
+		Joins("LEFT JOIN (?) AS ledger ON ledger.user_id = users.id", ledger).
+		Where("users.credits <> COALESCE(ledger.total, 0)").
+		Pluck("users.id", &userIDs).Error; err != nil {
+		return 0, err
+	}
+
+	adjusted := 0
+	for _, userID := range userIDs {
+		record, err := Reconcile(db, userID)
+		if err != nil {
+			return adjusted, fmt.Errorf("failed to reconcile user %d: %v", userID, err)
+		}
+		if record != nil {
+			adjusted++
+		}
+	}
+	return adjusted, nil
+}
+
+func (e Entry) record(balanceAfter int) *models.CreditTransaction {
+	return &models.CreditTransaction{
+		UserID:       e.UserID,
+		Amount:       e.Amount,
+		BalanceAfter: balanceAfter,
+		Reason:       e.Reason,
+		RefType:      e.RefType,
+		RefID:        e.RefID,
+		Note:         e.Note,
+	}
+}
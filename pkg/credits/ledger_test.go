@@ -0,0 +1,108 @@
+package credits
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestConcurrentDebitsStopAtZero(t *testing.T) {
+	db := testdb.Open(t)
+	const balance, spenders = 7, 30
+	userID := newTestUser(t, db, balance)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	charged, refused := 0, 0
+	for i := 0; i < spenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Apply(db, Entry{UserID: userID, Amount: -1, Reason: models.CreditChatUsage})
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, ErrInsufficientCredits) {
+				refused++
+				return
+			}
+			assert.NoError(t, err)
+			charged++
+		}()
+	}
+	wg.Wait()
+
+	// 带余额条件的UPDATE使恰好等于余额的扣减成功，其余全部被拒绝
+	assert.Equal(t, balance, charged)
+	assert.Equal(t, spenders-balance, refused)
+	assert.Equal(t, 0, balanceOf(t, db, userID))
+
+	var afters []int
+	require.NoError(t, db.Model(&models.CreditTransaction{}).
+		Where("user_id = ? AND reason = ?", userID, models.CreditChatUsage).
+		Order("balance_after DESC").Pluck("balance_after", &afters).Error)
+	assert.Equal(t, []int{6, 5, 4, 3, 2, 1, 0}, afters, "every debit records a distinct balance")
+}
+
+func TestOverdraftRefusalLeavesNoTrace(t *testing.T) {
+	db := testdb.Open(t)
+	userID := newTestUser(t, db, 5)
+
+	// 余额恰好够扣时成功，多扣一分即被拒绝且不写流水
+	record, err := Apply(db, Entry{UserID: userID, Amount: -5, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+	assert.Equal(t, 0, record.BalanceAfter)
+	_, err = Apply(db, Entry{UserID: userID, Amount: -1, Reason: models.CreditChatUsage})
+	assert.ErrorIs(t, err, ErrInsufficientCredits)
+
+	var entries int64
+	db.Model(&models.CreditTransaction{}).Where("user_id = ?", userID).Count(&entries)
+	assert.Equal(t, int64(2), entries, "opening balance and the successful debit only")
+
+	// 在调用方事务中被拒绝时，同一事务中的其他变动一起回滚
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := Apply(tx, Entry{UserID: userID, Amount: 3, Reason: models.CreditRecharge}); err != nil {
+			return err
+		}
+		_, err := Apply(tx, Entry{UserID: userID, Amount: -4, Reason: models.CreditChatUsage})
+		return err
+	})
+	assert.ErrorIs(t, err, ErrInsufficientCredits)
+	assert.Equal(t, 0, balanceOf(t, db, userID))
+
+	// 只有显式允许时才能扣为负数，金额为零时不写流水
+	record, err = Apply(db, Entry{UserID: userID, Amount: -2, Reason: models.CreditRefund, AllowOverdraft: true})
+	require.NoError(t, err)
+	assert.Equal(t, -2, record.BalanceAfter)
+	assert.Equal(t, -2, balanceOf(t, db, userID))
+	record, err = Apply(db, Entry{UserID: userID, Amount: 0, Reason: models.CreditChatUsage})
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+}
+
+func TestReconcileAdjustsDriftedBalance(t *testing.T) {
+	db := testdb.Open(t)
+	userID := newTestUser(t, db, 10)
+	_, err := Apply(db, Entry{UserID: userID, Amount: -4, Reason: models.CreditChatUsage})
+	require.NoError(t, err)
+
+	// 绕过账本直接修改余额后，核对以账户余额为准补记差额
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", userID).Update("credits", 9).Error)
+	record, err := Reconcile(db, userID)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, 3, record.Amount)
+	assert.Equal(t, 9, record.BalanceAfter)
+	assert.Equal(t, models.CreditAdjustment, record.Reason)
+	assert.Equal(t, 9, balanceOf(t, db, userID))
+
+	record, err = Reconcile(db, userID)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+}
@@ -0,0 +1,156 @@
+package credits
+
+import (
+	"errors"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrReservationSettled 预留已结算或已退回
+var ErrReservationSettled = errors.New("credit reservation already settled")
+
+// ReservationTTL 预留的最长持有时间，超过后仍未结算的预留视为请求已中断
+// 多实例部署时，其他实例正在处理的请求创建的预留不会早于该时长
+const ReservationTTL = 10 * time.Minute
+
+// Reserve 预扣积分，余额不足时返回ErrInsufficientCredits
+// 调用上游服务前预扣，成功后以实际消耗调用Commit，失败时调用Release
+func Reserve(db *gorm.DB, entry Entry) (*models.CreditReservation, error) {
+	if entry.Amount <= 0 {
+		return nil, errors.New("reservation amount must be positive")
+	}
+
+	reservation := &models.CreditReservation{
+		UserID:  entry.UserID,
+		Amount:  entry.Amount,
+		Status:  models.ReservationHeld,
+		Reason:  entry.Reason,
+		RefType: entry.RefType,
+		RefID:   entry.RefID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		charge := entry
+		charge.Amount = -entry.Amount
+		if _, err := Apply(tx, charge); err != nil {
+			return err
+		}
+		return tx.Create(reservation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Commit 按实际消耗结算预留，多扣的部分退回
+// 实际消耗超过预扣时补扣差额，余额不足以补扣时只扣到余额为零
+func Commit(db *gorm.DB, reservation *models.CreditReservation, actual int) error {
+	if actual < 0 {
+		actual = 0
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		charged := actual
+		diff := reservation.Amount - actual
+		switch {
+		case diff > 0:
+			if _, err := Apply(tx, reservationEntry(reservation, diff, models.CreditReleased)); err != nil {
+				return err
+			}
+		case diff < 0:
+			extra, err := chargeUpTo(tx, reservationEntry(reservation, diff, reservation.Reason))
+			if err != nil {
+				return err
+			}
+			charged = reservation.Amount + extra
+		}
+		if err := settle(tx, reservation, models.ReservationCommitted, charged); err != nil {
+			return err
+		}
+		reservation.Status = models.ReservationCommitted
+		reservation.Charged = charged
+		return nil
+	})
+}
+
+// Release 全额退回预留
+func Release(db *gorm.DB, reservation *models.CreditReservation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := Apply(tx, reservationEntry(reservation, reservation.Amount, models.CreditReleased)); err != nil {
+			return err
+		}
+		if err := settle(tx, reservation, models.ReservationReleased, 0); err != nil {
+			return err
+		}
+		reservation.Status = models.ReservationReleased
+		return nil
+	})
+}
+
+// ReleaseStale 退回创建早于before仍未结算的预留，用于进程中断后的恢复，返回退回的条数
+func ReleaseStale(db *gorm.DB, before time.Time) (int, error) {
+	var reservations []models.CreditReservation
+	if err := db.Where("status = ? AND created_at < ?", models.ReservationHeld, before).
+		Find(&reservations).Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range reservations {
+		err := Release(db, &reservations[i])
+		if errors.Is(err, ErrReservationSettled) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// settle 以状态为条件更新预留，已结算的预留返回ErrReservationSettled，调用方的事务随之回滚
+func settle(tx *gorm.DB, reservation *models.CreditReservation, status models.ReservationStatus, charged int) error {
+	res := tx.Model(&models.CreditReservation{}).
+		Where("id = ? AND status = ?", reservation.ID, models.ReservationHeld).
+		Updates(map[string]interface{}{"status": status, "charged": charged})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrReservationSettled
+	}
+	return nil
+}
+
+// chargeUpTo 扣减不超过当前余额的积分，返回实际扣减的数量
+func chargeUpTo(tx *gorm.DB, entry Entry) (int, error) {
+	_, err := Apply(tx, entry)
+	if err == nil {
+		return -entry.Amount, nil
+	}
+	if !errors.Is(err, ErrInsufficientCredits) {
+		return 0, err
+	}
+
+	var user models.User
+	if err := tx.Select("id", "credits").First(&user, entry.UserID).Error; err != nil {
+		return 0, err
+	}
+	if user.Credits <= 0 {
+		return 0, nil
+	}
+	entry.Amount = -user.Credits
+	entry.Note = "partially charged, insufficient balance"
+	if _, err := Apply(tx, entry); err != nil {
+		return 0, err
+	}
+	return user.Credits, nil
+}
+
+// reservationEntry 关联到预留对象的流水
+func reservationEntry(r *models.CreditReservation, amount int, reason models.CreditReason) Entry {
+	return Entry{UserID: r.UserID, Amount: amount, Reason: reason, RefType: r.RefType, RefID: r.RefID}
+}
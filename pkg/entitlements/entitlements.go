@@ -0,0 +1,244 @@
+// Package entitlements 订阅计划对应的功能权限和用量限制
+//
+// 计划目录中的Features只是展示文案，实际能使用哪些功能由这里的权限表决定。
+// 权限不足时返回UpgradeError，说明受限的功能和能够解除限制的最低计划。
+package entitlements
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+// Feature 受订阅计划限制的功能
+type Feature string
+
+const (
+	FeatureAIModel            Feature = "ai_model"
+	FeatureGroupChat          Feature = "group_chat"
+	FeatureGroupSize          Feature = "group_size"
+	FeatureDailyMessages      Feature = "daily_messages"
+	FeatureDailyMatches       Feature = "daily_matches"
+	FeatureVoiceTranscription Feature = "voice_transcription"
+)
+
+// ErrUpgradeRequired 当前计划不包含所需的功能
+var ErrUpgradeRequired = errors.New("upgrade required")
+
+// planOrder 计划由低到高的顺序，用于查找能解除限制的最低计划
+var planOrder = []models.SubscriptionType{
+	models.SubscriptionFree,
+	models.SubscriptionBasic,
+	models.SubscriptionPremium,
+	models.SubscriptionUnlimited,
+}
+
+// Entitlements 一个计划的功能权限和用量限制
+type Entitlements struct {
+	Models             []string `json:"models"`             // 为空时不限制模型
+	MaxGroupSize       int      `json:"maxGroupSize"`       // 为0时不能创建群聊
+	DailyMessages      int      `json:"dailyMessages"`      // 为0时不限制
+	DailyMatches       int      `json:"dailyMatches"`       // 为0时不限制
+	VoiceTranscription bool     `json:"voiceTranscription"` // 能否向AI发送语音消息
+}
+
+// AllowsModel 能否使用指定的AI模型
+func (e Entitlements) AllowsModel(model string) bool {
+	if len(e.Models) == 0 {
+		return true
+	}
+	for _, m := range e.Models {
+		if strings.EqualFold(m, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows 能否使用开关类的功能
+func (e Entitlements) Allows(feature Feature) bool {
+	switch feature {
+	case FeatureGroupChat:
+		return e.MaxGroupSize > 0
+	case FeatureVoiceTranscription:
+		return e.VoiceTranscription
+	}
+	return false
+}
+
+// DailyLimit 每日用量上限，0表示不限制
+func (e Entitlements) DailyLimit(feature Feature) int {
+	switch feature {
+	case FeatureDailyMessages:
+		return e.DailyMessages
+	case FeatureDailyMatches:
+		return e.DailyMatches
+	}
+	return 0
+}
+
+// UpgradeError 权限不足的详细信息
+type UpgradeError struct {
+	Feature      Feature
+	CurrentPlan  models.SubscriptionType
+	RequiredPlan models.SubscriptionType // 没有计划满足时为空
+	Limit        int                     // 用量或人数限制，开关类功能为0
+}
+
+func (e *UpgradeError) Error() string {
+	return fmt.Sprintf("upgrade required: %s is not available on the %s plan", e.Feature, e.CurrentPlan)
+}
+
+// Is 使errors.Is(err, ErrUpgradeRequired)成立
+func (e *UpgradeError) Is(target error) bool {
+	return target == ErrUpgradeRequired
+}
+
+// Response 统一的应答内容
+func (e *UpgradeError) Response() map[string]interface{} {
+	resp := map[string]interface{}{
+		"error":       "Upgrade required",
+		"feature":     e.Feature,
+		"currentPlan": e.CurrentPlan,
+	}
+	if e.RequiredPlan != "" {
+		resp["requiredPlan"] = e.RequiredPlan
+	}
+	if e.Limit > 0 {
+		resp["limit"] = e.Limit
+	}
+	return resp
+}
+
+// Table 各计划的权限表
+type Table struct {
+	plans map[models.SubscriptionType]Entitlements
+}
+
+// NewTable 由配置创建权限表，未配置的计划使用默认值
+func NewTable(cfg map[string]configs.EntitlementConfig) *Table {
+	t := &Table{plans: Defaults()}
+	for name, c := range cfg {
+		t.plans[models.SubscriptionType(strings.ToLower(name))] = Entitlements{
+			Models:             c.Models,
+			MaxGroupSize:       c.MaxGroupSize,
+			DailyMessages:      c.DailyMessages,
+			DailyMatches:       c.DailyMatches,
+			VoiceTranscription: c.VoiceTranscription,
+		}
+	}
+	return t
+}
+
+// For 计划的权限，未知的计划按免费版处理
+func (t *Table) For(plan models.SubscriptionType) Entitlements {
+	if e, ok := t.plans[plan]; ok {
+		return e
+	}
+	return t.plans[models.SubscriptionFree]
+}
+
+// CheckModel 检查计划能否使用指定的AI模型
+func (t *Table) CheckModel(plan models.SubscriptionType, model string) error {
+	if t.For(plan).AllowsModel(model) {
+		return nil
+	}
+	return t.upgrade(plan, FeatureAIModel, 0, func(e Entitlements) bool { return e.AllowsModel(model) })
+}
+
+// CheckFeature 检查计划能否使用开关类的功能
+func (t *Table) CheckFeature(plan models.SubscriptionType, feature Feature) error {
+	if t.For(plan).Allows(feature) {
+		return nil
+	}
+	return t.upgrade(plan, feature, 0, func(e Entitlements) bool { return e.Allows(feature) })
+}
+
+// CheckGroupSize 检查计划能否创建指定人数的群聊
+func (t *Table) CheckGroupSize(plan models.SubscriptionType, size int) error {
+	limit := t.For(plan).MaxGroupSize
+	if limit == 0 {
+		return t.CheckFeature(plan, FeatureGroupChat)
+	}
+	if size <= limit {
+		return nil
+	}
+	return t.upgrade(plan, FeatureGroupSize, limit, func(e Entitlements) bool { return e.MaxGroupSize >= size })
+}
+
+// CheckDaily 检查今日已用量是否还能再使用一次
+func (t *Table) CheckDaily(plan models.SubscriptionType, feature Feature, used int) error {
+	limit := t.For(plan).DailyLimit(feature)
+	if limit == 0 || used < limit {
+		return nil
+	}
+	return t.upgrade(plan, feature, limit, func(e Entitlements) bool {
+		l := e.DailyLimit(feature)
+		return l == 0 || used < l
+	})
+}
+
+// upgrade 生成权限不足的错误，RequiredPlan为高于当前计划且满足条件的最低计划
+func (t *Table) upgrade(plan models.SubscriptionType, feature Feature, limit int, ok func(Entitlements) bool) error {
+	err := &UpgradeError{Feature: feature, CurrentPlan: plan, Limit: limit}
+	above := false
+	for _, p := range planOrder {
+		if p == plan {
+			above = true
+			continue
+		}
+		if above && ok(t.For(p)) {
+			err.RequiredPlan = p
+			break
+		}
+	}
+	return err
+}
+
+// Defaults 内置的默认权限
+func Defaults() map[models.SubscriptionType]Entitlements {
+	return map[models.SubscriptionType]Entitlements{
+		models.SubscriptionFree: {
+			Models:        []string{"gpt-4o-mini"},
+			DailyMessages: 50,
+			DailyMatches:  20,
+		},
+		models.SubscriptionBasic: {
+			Models:        []string{"gpt-4o-mini", "gpt-4o"},
+			DailyMessages: 500,
+			DailyMatches:  100,
+		},
+		models.SubscriptionPremium: {
+			MaxGroupSize:       20,
+			VoiceTranscription: true,
+		},
+		models.SubscriptionUnlimited: {
+			MaxGroupSize:       100,
+			VoiceTranscription: true,
+		},
+	}
+}
+
+// 全局权限表
+var (
+	defaultMu    sync.RWMutex
+	defaultTable = NewTable(nil)
+)
+
+// Init 初始化全局权限表
+func Init(cfg map[string]configs.EntitlementConfig) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultTable = NewTable(cfg)
+}
+
+// Default 获取全局权限表
+func Default() *Table {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultTable
+}
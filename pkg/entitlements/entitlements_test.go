@@ -0,0 +1,78 @@
+package entitlements
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksNameLowestSufficientPlan(t *testing.T) {
+	table := NewTable(map[string]configs.EntitlementConfig{
+		"Basic": {Models: []string{"gpt-4o-mini", "gpt-4o"}, DailyMessages: 500, DailyMatches: 100, MaxGroupSize: 5},
+	})
+
+	assert.NoError(t, table.CheckModel(models.SubscriptionFree, "GPT-4o-mini"))
+	var upgrade *UpgradeError
+	require.True(t, errors.As(table.CheckModel(models.SubscriptionFree, "gpt-4"), &upgrade))
+	assert.Equal(t, FeatureAIModel, upgrade.Feature)
+	assert.Equal(t, models.SubscriptionPremium, upgrade.RequiredPlan, "premium is the first plan without a model list")
+
+	// 配置覆盖的计划允许小群聊，更大的群聊需要更高的计划
+	assert.NoError(t, table.CheckGroupSize(models.SubscriptionBasic, 5))
+	require.True(t, errors.As(table.CheckGroupSize(models.SubscriptionBasic, 30), &upgrade))
+	assert.Equal(t, FeatureGroupSize, upgrade.Feature)
+	assert.Equal(t, 5, upgrade.Limit)
+	assert.Equal(t, models.SubscriptionUnlimited, upgrade.RequiredPlan)
+	assert.ErrorIs(t, table.CheckGroupSize(models.SubscriptionFree, 2), ErrUpgradeRequired)
+
+	assert.NoError(t, table.CheckDaily(models.SubscriptionFree, FeatureDailyMessages, 49))
+	require.True(t, errors.As(table.CheckDaily(models.SubscriptionFree, FeatureDailyMessages, 50), &upgrade))
+	assert.Equal(t, models.SubscriptionBasic, upgrade.RequiredPlan)
+	assert.NoError(t, table.CheckDaily(models.SubscriptionPremium, FeatureDailyMessages, 10000))
+
+	require.True(t, errors.As(table.CheckFeature(models.SubscriptionUnlimited, "unknown"), &upgrade))
+	assert.Empty(t, upgrade.RequiredPlan, "no plan offers an unknown feature")
+	assert.NotContains(t, upgrade.Response(), "requiredPlan")
+}
+
+func TestDailyUsageCountsOwnMessagesOnce(t *testing.T) {
+	db := testdb.Open(t)
+
+	now := time.Now()
+	own := models.ChatSession{UserID: 1, Type: models.SessionStranger, LastActive: now}
+	peer := models.ChatSession{UserID: 2, Type: models.SessionStranger, LastActive: now}
+	require.NoError(t, db.Create(&own).Error)
+	require.NoError(t, db.Create(&peer).Error)
+
+	// 发给对方的消息在双方会话各有一份，只计一次；删除的消息仍然计入
+	for _, sessionID := range []uint{own.ID, peer.ID} {
+		require.NoError(t, db.Create(&models.ChatMessage{SessionID: sessionID, UserID: 1, SenderID: "1", Content: "hi"}).Error)
+	}
+	deleted := models.ChatMessage{SessionID: own.ID, UserID: 1, SenderID: "1", Content: "bye"}
+	require.NoError(t, db.Create(&deleted).Error)
+	require.NoError(t, db.Delete(&deleted).Error)
+	yesterday := models.ChatMessage{SessionID: own.ID, UserID: 1, SenderID: "1", Content: "old"}
+	yesterday.CreatedAt = now.Add(-48 * time.Hour)
+	require.NoError(t, db.Create(&yesterday).Error)
+
+	used, err := DailyUsage(db, 1, FeatureDailyMessages, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, used)
+
+	matches, err := DailyUsage(db, 1, FeatureDailyMatches, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, matches)
+
+	table := NewTable(map[string]configs.EntitlementConfig{"free": {DailyMatches: 1}})
+	user := &models.User{SubType: models.SubscriptionFree}
+	user.ID = 1
+	assert.ErrorIs(t, table.CheckDailyUsage(db, user, FeatureDailyMatches, now), ErrUpgradeRequired)
+	assert.NoError(t, table.CheckDailyUsage(db, user, FeatureDailyMessages, now), "free plan override has no message cap")
+}
@@ -0,0 +1,47 @@
+package entitlements
+
+import (
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+// startOfDay 用量按服务器所在时区的自然日统计
+func startOfDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+// DailyUsage 用户今日对指定功能的用量，已删除的记录同样计入
+func DailyUsage(db *gorm.DB, userID uint, feature Feature, now time.Time) (int, error) {
+	since := startOfDay(now)
+	var count int64
+	var err error
+	switch feature {
+	case FeatureDailyMessages:
+		// 发给其他用户的消息会复制到对方会话，只统计自己会话中的副本
+		err = db.Unscoped().Model(&models.ChatMessage{}).
+			Joins("JOIN chat_sessions ON chat_sessions.id = chat_messages.session_id").
+			Where("chat_messages.user_id = ? AND chat_sessions.user_id = ? AND chat_messages.created_at >= ?", userID, userID, since).
+			Count(&count).Error
+	case FeatureDailyMatches:
+		err = db.Unscoped().Model(&models.ChatSession{}).
+			Where("user_id = ? AND type = ? AND created_at >= ?", userID, models.SessionStranger, since).
+			Count(&count).Error
+	}
+	return int(count), err
+}
+
+// CheckDailyUsage 统计今日用量并检查是否超过计划的上限
+func (t *Table) CheckDailyUsage(db *gorm.DB, user *models.User, feature Feature, now time.Time) error {
+	if t.For(user.SubType).DailyLimit(feature) == 0 {
+		return nil
+	}
+	used, err := DailyUsage(db, user.ID, feature, now)
+	if err != nil {
+		return err
+	}
+	return t.CheckDaily(user.SubType, feature, used)
+}
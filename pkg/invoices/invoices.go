@@ -0,0 +1,280 @@
+// Package invoices 支付和退款的票据
+//
+// 支付完成时开具收据，退款成功时开具金额为负数的退款凭证，编号按系列和年份连续递增。
+// 价格均为含税价，税额按配置的税率从合计中拆出。票据可以渲染为HTML或PDF下载。
+package invoices
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/catalog"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotFound = errors.New("invoices: invoice not found")
+
+// Config 开票配置
+type Config struct {
+	Prefix           string // 收据编号前缀，默认 INV
+	CreditNotePrefix string // 退款凭证编号前缀，默认 CN
+	SellerName       string
+	SellerTaxID      string
+	SellerAddress    string
+	TaxName          string // 税种名称，如 VAT
+	TaxRate          int    // 税率，单位为万分之一，600表示6%
+}
+
+// withDefaults 补全编号前缀，前缀统一为大写
+func (c Config) withDefaults() Config {
+	if c.Prefix == "" {
+		c.Prefix = "INV"
+	}
+	if c.CreditNotePrefix == "" {
+		c.CreditNotePrefix = "CN"
+	}
+	c.Prefix = strings.ToUpper(c.Prefix)
+	c.CreditNotePrefix = strings.ToUpper(c.CreditNotePrefix)
+	return c
+}
+
+// IssueForPayment 为完成的支付开具收据，需在履约事务中调用
+// 同一订单只开具一次；免支付的订单不开具
+func IssueForPayment(tx *gorm.DB, cfg Config, p *models.Payment, at time.Time) (*models.Invoice, error) {
+	if !p.Amount.IsPositive() {
+		return nil, nil
+	}
+	existing, err := findBySource(tx, p.ID, 0)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	cfg = cfg.withDefaults()
+	lines, err := paymentLines(tx, p)
+	if err != nil {
+		return nil, err
+	}
+	inv := &models.Invoice{
+		Kind:      models.InvoiceReceipt,
+		UserID:    p.UserID,
+		PaymentID: p.ID,
+		OrderNo:   p.OrderNo,
+		Currency:  p.Currency,
+		Lines:     lines,
+	}
+	if err := issue(tx, cfg, cfg.Prefix, inv, p.Amount, at); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// IssueForRefund 为成功的退款开具退款凭证，需在退款完成的事务中调用
+// 金额为负数，明细引用原收据编号；同一退款只开具一次
+func IssueForRefund(tx *gorm.DB, cfg Config, r *models.Refund, at time.Time) (*models.Invoice, error) {
+	existing, err := findBySource(tx, r.PaymentID, r.ID)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	var p models.Payment
+	if err = tx.First(&p, r.PaymentID).Error; err != nil {
+		return nil, err
+	}
+	reference := "订单" + p.OrderNo
+	if original, err := findBySource(tx, p.ID, 0); err == nil {
+		reference = "收据" + original.Number
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	cfg = cfg.withDefaults()
+	inv := &models.Invoice{
+		Kind:      models.InvoiceCreditNote,
+		UserID:    r.UserID,
+		PaymentID: p.ID,
+		RefundID:  r.ID,
+		OrderNo:   p.OrderNo,
+		RefundNo:  r.RefundNo,
+		Currency:  p.Currency,
+		Lines: []models.InvoiceLine{{
+			Description: fmt.Sprintf("退款（%s）", reference),
+			Quantity:    1,
+			UnitPrice:   -r.Amount,
+			Amount:      -r.Amount,
+		}},
+	}
+	if err := issue(tx, cfg, cfg.CreditNotePrefix, inv, -r.Amount, at); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Find 按编号查找用户的票据
+func Find(db *gorm.DB, userID uint, number string) (*models.Invoice, error) {
+	var inv models.Invoice
+	err := db.Where("user_id = ? AND number = ?", userID, strings.ToUpper(number)).First(&inv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Profile 用户的开票信息，未填写时返回空的开票信息
+func Profile(db *gorm.DB, userID uint) (*models.BillingProfile, error) {
+	profile := models.BillingProfile{UserID: userID}
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SaveProfile 保存用户的开票信息，只影响之后开具的票据
+func SaveProfile(db *gorm.DB, userID uint, req models.BillingProfileRequest) (*models.BillingProfile, error) {
+	profile, err := Profile(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile.Name = strings.TrimSpace(req.Name)
+	profile.Email = strings.TrimSpace(req.Email)
+	profile.TaxID = strings.TrimSpace(req.TaxID)
+	profile.Address = strings.TrimSpace(req.Address)
+	if err := db.Save(profile).Error; err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// findBySource 查找订单或退款已开具的票据
+func findBySource(tx *gorm.DB, paymentID, refundID uint) (*models.Invoice, error) {
+	var inv models.Invoice
+	err := tx.Where("payment_id = ? AND refund_id = ?", paymentID, refundID).First(&inv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// issue 拆分税额、填写销售方和购买方信息并分配编号后保存
+func issue(tx *gorm.DB, cfg Config, prefix string, inv *models.Invoice, total money.Amount, at time.Time) error {
+	buyer, err := buyerOf(tx, inv.UserID)
+	if err != nil {
+		return err
+	}
+	number, err := nextNumber(tx, prefix, at)
+	if err != nil {
+		return err
+	}
+
+	inv.Number = number
+	inv.IssuedAt = at
+	inv.Total = total
+	inv.TaxName = cfg.TaxName
+	inv.TaxRate = cfg.TaxRate
+	inv.TaxAmount = TaxIncluded(total, cfg.TaxRate)
+	inv.Subtotal = total - inv.TaxAmount
+	inv.SellerName = cfg.SellerName
+	inv.SellerTaxID = cfg.SellerTaxID
+	inv.SellerAddress = cfg.SellerAddress
+	inv.BuyerName = buyer.Name
+	inv.BuyerEmail = buyer.Email
+	inv.BuyerTaxID = buyer.TaxID
+	inv.BuyerAddress = buyer.Address
+	return tx.Create(inv).Error
+}
+
+// TaxIncluded 含税金额中包含的税额，按四舍五入计算到分
+func TaxIncluded(total money.Amount, rate int) money.Amount {
+	if rate <= 0 {
+		return 0
+	}
+	return total.MulRatio(int64(rate), int64(10000+rate), money.HalfUp)
+}
+
+// nextNumber 分配系列中的下一个编号，如 INV-2026-000001；在开票事务中加锁递增，编号不会跳号或重复
+func nextNumber(tx *gorm.DB, prefix string, at time.Time) (string, error) {
+	series := fmt.Sprintf("%s-%d", prefix, at.Year())
+	seq := models.InvoiceSequence{Series: series}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("series = ?", series).First(&seq).Error; err != nil {
+		return "", err
+	}
+	seq.Last++
+	if err := tx.Model(&seq).Where("series = ?", series).Update("last", seq.Last).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%06d", series, seq.Last), nil
+}
+
+// buyerOf 购买方信息，开票信息未填写的字段使用账号的用户名和邮箱
+// 已注销的账号被匿名化并软删除，仍需为其退款开具凭证，使用匿名化后的用户名和邮箱
+func buyerOf(tx *gorm.DB, userID uint) (*models.BillingProfile, error) {
+	profile, err := Profile(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Name != "" && profile.Email != "" {
+		return profile, nil
+	}
+	var user models.User
+	if err := tx.Unscoped().Select("id", "username", "email").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if profile.Name == "" {
+		profile.Name = user.Username
+	}
+	if profile.Email == "" {
+		profile.Email = user.Email
+	}
+	return profile, nil
+}
+
+// paymentLines 按订单用途生成明细，使用优惠券时原价和优惠分两行列出
+func paymentLines(tx *gorm.DB, p *models.Payment) ([]models.InvoiceLine, error) {
+	var description string
+	switch p.Purpose {
+	case models.PurposeRecharge:
+		description = fmt.Sprintf("%d积分充值", p.Credits)
+	default:
+		name := string(p.Plan)
+		if plan, err := catalog.PlanVersion(tx, p.PlanVersionID); err == nil {
+			name = plan.Name
+		} else if !errors.Is(err, catalog.ErrNotFound) {
+			return nil, err
+		}
+		switch p.Purpose {
+		case models.PurposeUpgrade:
+			description = fmt.Sprintf("升级至%s（当前周期差价）", name)
+		case models.PurposeRenewal:
+			description = fmt.Sprintf("%s续费（1个月）", name)
+		default:
+			description = fmt.Sprintf("%s订阅（%d个月）", name, max(p.TrialMonths, 1))
+		}
+	}
+
+	original := p.Amount + p.Discount
+	lines := []models.InvoiceLine{{Description: description, Quantity: 1, UnitPrice: original, Amount: original}}
+	if p.Discount.IsPositive() {
+		lines = append(lines, models.InvoiceLine{Description: "优惠券抵扣", Quantity: 1, UnitPrice: -p.Discount, Amount: -p.Discount})
+	}
+	return lines, nil
+}
@@ -0,0 +1,238 @@
+package invoices
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var testConfig = Config{SellerName: "Chatter Ltd.", SellerTaxID: "91310000TEST", TaxName: "VAT", TaxRate: 600}
+
+func newTestDB(t *testing.T) (*gorm.DB, uint) {
+	t.Helper()
+	db := testdb.Open(t)
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(&user).Error)
+	return db, user.ID
+}
+
+func completedPayment(t *testing.T, db *gorm.DB, userID uint, amount, discount money.Amount) *models.Payment {
+	t.Helper()
+	p := &models.Payment{
+		UserID: userID, OrderNo: fmt.Sprintf("R%d", time.Now().UnixNano()), Amount: amount, Discount: discount,
+		Currency: money.CNY, Credits: 500, Method: models.PaymentAlipay, Purpose: models.PurposeRecharge, Status: models.PaymentCompleted,
+	}
+	require.NoError(t, db.Create(p).Error)
+	return p
+}
+
+func TestIssueNumbersSequentiallyAndSplitsTax(t *testing.T) {
+	db, userID := newTestDB(t)
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	p := completedPayment(t, db, userID, money.Yuan(45), money.Yuan(5))
+	inv, err := IssueForPayment(db, testConfig, p, at)
+	require.NoError(t, err)
+	assert.Equal(t, "INV-2026-000001", inv.Number)
+	assert.Equal(t, "alice", inv.BuyerName)
+	assert.Equal(t, "Chatter Ltd.", inv.SellerName)
+	require.Len(t, inv.Lines, 2)
+	assert.Equal(t, money.Yuan(50), inv.Lines[0].Amount)
+	assert.Equal(t, -money.Yuan(5), inv.Lines[1].Amount)
+	// 45元含6%的税：45 * 6 / 106 = 2.547，四舍五入为2.55
+	assert.Equal(t, money.Yuan(45), inv.Total)
+	assert.Equal(t, money.MustParse("2.55"), inv.TaxAmount)
+	assert.Equal(t, money.MustParse("42.45"), inv.Subtotal)
+
+	// 重复开具返回已有的收据，不占用编号
+	again, err := IssueForPayment(db, testConfig, p, at)
+	require.NoError(t, err)
+	assert.Equal(t, inv.ID, again.ID)
+
+	// 免支付的订单不开具
+	free, err := IssueForPayment(db, testConfig, completedPayment(t, db, userID, 0, money.Yuan(10)), at)
+	require.NoError(t, err)
+	assert.Nil(t, free)
+
+	_, err = SaveProfile(db, userID, models.BillingProfileRequest{Name: "Alice Co.", TaxID: "TAX-1"})
+	require.NoError(t, err)
+	second, err := IssueForPayment(db, testConfig, completedPayment(t, db, userID, money.Yuan(10), 0), at)
+	require.NoError(t, err)
+	assert.Equal(t, "INV-2026-000002", second.Number)
+	assert.Equal(t, "Alice Co.", second.BuyerName)
+	assert.Equal(t, "alice@example.com", second.BuyerEmail)
+	assert.Equal(t, "TAX-1", second.BuyerTaxID)
+
+	// 退款凭证单独编号，金额为负数并引用原收据
+	refund := &models.Refund{PaymentID: p.ID, UserID: userID, RefundNo: "F1", Amount: money.Yuan(9), Status: models.RefundSucceeded}
+	require.NoError(t, db.Create(refund).Error)
+	note, err := IssueForRefund(db, testConfig, refund, at)
+	require.NoError(t, err)
+	assert.Equal(t, "CN-2026-000001", note.Number)
+	assert.Equal(t, models.InvoiceCreditNote, note.Kind)
+	assert.Equal(t, -money.Yuan(9), note.Total)
+	assert.Equal(t, -money.MustParse("0.51"), note.TaxAmount)
+	assert.Contains(t, note.Lines[0].Description, inv.Number)
+
+	// 新的一年重新编号
+	next, err := IssueForPayment(db, testConfig, completedPayment(t, db, userID, money.Yuan(10), 0), at.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "INV-2027-000001", next.Number)
+
+	found, err := Find(db, userID, "inv-2026-000002")
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, found.ID)
+	_, err = Find(db, userID+1, second.Number)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNumbersSurviveRollbackAndYearBoundary(t *testing.T) {
+	db, userID := newTestDB(t)
+	cfg := testConfig
+	cfg.Prefix, cfg.CreditNotePrefix = "rcpt", "cr"
+	newYearsEve := time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)
+
+	first, err := IssueForPayment(db, cfg, completedPayment(t, db, userID, money.Yuan(10), 0), newYearsEve)
+	require.NoError(t, err)
+	assert.Equal(t, "RCPT-2026-000001", first.Number)
+
+	// 履约事务回滚时编号一并回滚，不会跳号
+	p := completedPayment(t, db, userID, money.Yuan(20), 0)
+	rollback := errors.New("fulfilment failed")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		inv, err := IssueForPayment(tx, cfg, p, newYearsEve)
+		require.NoError(t, err)
+		assert.Equal(t, "RCPT-2026-000002", inv.Number)
+		return rollback
+	})
+	require.ErrorIs(t, err, rollback)
+	inv, err := IssueForPayment(db, cfg, p, newYearsEve)
+	require.NoError(t, err)
+	assert.Equal(t, "RCPT-2026-000002", inv.Number)
+
+	next, err := IssueForPayment(db, cfg, completedPayment(t, db, userID, money.Yuan(10), 0), newYearsEve.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, "RCPT-2027-000001", next.Number)
+
+	// 部分退款各自开具退款凭证，未开具收据的订单引用订单号
+	var notes []string
+	for i, amount := range []money.Amount{money.MustParse("0.01"), money.MustParse("9.99")} {
+		refund := &models.Refund{PaymentID: p.ID, UserID: userID, RefundNo: fmt.Sprintf("F%d", i), Amount: amount, Status: models.RefundSucceeded}
+		require.NoError(t, db.Create(refund).Error)
+		note, err := IssueForRefund(db, cfg, refund, newYearsEve)
+		require.NoError(t, err)
+		assert.Equal(t, -amount, note.Total)
+		assert.Equal(t, note.Total, note.Subtotal+note.TaxAmount)
+		assert.Contains(t, note.Lines[0].Description, inv.Number)
+		again, err := IssueForRefund(db, cfg, refund, newYearsEve)
+		require.NoError(t, err)
+		assert.Equal(t, note.ID, again.ID)
+		notes = append(notes, note.Number)
+	}
+	assert.Equal(t, []string{"CR-2026-000001", "CR-2026-000002"}, notes)
+
+	unbilled := completedPayment(t, db, userID, money.Yuan(5), 0)
+	refund := &models.Refund{PaymentID: unbilled.ID, UserID: userID, RefundNo: "F-unbilled", Amount: money.Yuan(5), Status: models.RefundSucceeded}
+	require.NoError(t, db.Create(refund).Error)
+	note, err := IssueForRefund(db, cfg, refund, newYearsEve)
+	require.NoError(t, err)
+	assert.Contains(t, note.Lines[0].Description, "订单"+unbilled.OrderNo)
+}
+
+func TestCreditNoteForDeletedAccount(t *testing.T) {
+	db, userID := newTestDB(t)
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	p := completedPayment(t, db, userID, money.Yuan(10), 0)
+	_, err := IssueForPayment(db, testConfig, p, at)
+	require.NoError(t, err)
+
+	// 注销后账号被匿名化并软删除，退款仍要开具凭证
+	user := models.User{}
+	user.ID = userID
+	require.NoError(t, db.Model(&user).Updates(map[string]interface{}{
+		"username": fmt.Sprintf("deleted_%d", userID),
+		"email":    fmt.Sprintf("deleted_%d@deleted.invalid", userID),
+	}).Error)
+	require.NoError(t, db.Delete(&user).Error)
+
+	refund := &models.Refund{PaymentID: p.ID, UserID: userID, RefundNo: "F1", Amount: money.Yuan(10), Status: models.RefundSucceeded}
+	require.NoError(t, db.Create(refund).Error)
+	note, err := IssueForRefund(db, testConfig, refund, at)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("deleted_%d", userID), note.BuyerName)
+	assert.Equal(t, fmt.Sprintf("deleted_%d@deleted.invalid", userID), note.BuyerEmail)
+}
+
+func TestTaxIncludedRounding(t *testing.T) {
+	for _, c := range []struct {
+		total money.Amount
+		rate  int
+		want  money.Amount
+	}{
+		{money.Yuan(106), 600, money.Yuan(6)},            // 整除
+		{money.MustParse("0.17"), 600, money.Cents(1)},   // 0.0096
+		{money.MustParse("0.08"), 600, 0},                // 0.0045
+		{money.MustParse("0.09"), 600, money.Cents(1)},   // 0.0051
+		{money.Cents(1), 10000, money.Cents(1)},          // 恰好半分时进位
+		{-money.Cents(1), 10000, -money.Cents(1)},        // 负数对称
+		{-money.MustParse("0.17"), 600, -money.Cents(1)}, // 退款凭证的税额与收据对称
+		{money.Yuan(45), 0, 0},                           // 不含税
+		{money.Yuan(45), -600, 0},                        // 无效税率按不含税处理
+		{money.MustParse("99999999.99"), 1300, money.MustParse("11504424.78")},
+	} {
+		assert.Equal(t, c.want, TaxIncluded(c.total, c.rate), "%s at %d", c.total, c.rate)
+	}
+}
+
+func TestRenderHTMLAndPDF(t *testing.T) {
+	inv := &models.Invoice{
+		Number: "INV-2026-000001", Kind: models.InvoiceReceipt, OrderNo: "R1", IssuedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		Currency: money.CNY, Subtotal: money.MustParse("42.45"), TaxName: "VAT", TaxRate: 600, TaxAmount: money.MustParse("2.55"),
+		Total: money.Yuan(45), SellerName: "Chatter Ltd.", BuyerName: "<Alice>", BuyerEmail: "alice@example.com",
+		Lines: []models.InvoiceLine{{Description: "500积分充值", Quantity: 1, UnitPrice: money.Yuan(45), Amount: money.Yuan(45)}},
+	}
+
+	var html bytes.Buffer
+	require.NoError(t, RenderHTML(&html, inv))
+	assert.Contains(t, html.String(), "INV-2026-000001")
+	assert.Contains(t, html.String(), "&lt;Alice&gt;")
+	assert.Contains(t, html.String(), "45.00")
+	assert.Contains(t, html.String(), "VAT")
+
+	var pdf bytes.Buffer
+	require.NoError(t, RenderPDF(&pdf, inv))
+	out := pdf.Bytes()
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, pdf.String(), ucs2Hex("INV-2026-000001"))
+	assert.Contains(t, pdf.String(), ucs2Hex("积分充值"))
+
+	// 交叉引用表中的偏移量指向对应的对象
+	xref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	require.NotNil(t, xref)
+	start, _ := strconv.Atoi(string(xref[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[start:], -1)
+	require.NotEmpty(t, entries)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		assert.True(t, bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))), "object %d", i+1)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	assert.Equal(t, "6%", FormatRate(600))
+	assert.Equal(t, "12.5%", FormatRate(1250))
+	assert.Equal(t, "0%", FormatRate(0))
+}
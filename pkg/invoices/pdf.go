@@ -0,0 +1,112 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// A4纸张尺寸，单位为点
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+)
+
+// pdfDocument 只包含文字和线条的最小PDF文档
+// 文字使用阅读器内置的 STSong-Light 中文字体和 UniGB-UCS2-H 编码，不嵌入字体文件，
+// 因此不依赖外部工具或字体；ASCII字符按半角宽度、其余字符按全角宽度排版。
+type pdfDocument struct {
+	pages []*pdfPage
+}
+
+// pdfPage 一页的内容流
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+// newPage 追加新的一页
+func (d *pdfDocument) newPage() *pdfPage {
+	page := &pdfPage{}
+	page.content.WriteString("0.5 w\n")
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// text 在 (x, y) 处输出文字，坐标原点在页面左下角
+func (p *pdfPage) text(x, y, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, y, ucs2Hex(s))
+}
+
+// textRight 文字右端对齐到 x
+func (p *pdfPage) textRight(x, y, size float64, s string) {
+	p.text(x-textWidth(s, size), y, size, s)
+}
+
+// line 画一条线段
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// textWidth 文字宽度，与字体的宽度表一致
+func textWidth(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		if r >= 0x20 && r <= 0x7e {
+			units += 500
+		} else {
+			units += 1000
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// ucs2Hex 文字编码为UCS-2大端序的十六进制串，超出基本多文种平面的字符以问号代替
+func ucs2Hex(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if r > 0xffff || r == utf8.RuneError {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// writeTo 输出PDF文件：目录、页面树、字体，随后每页一个页面对象和一个内容流
+func (d *pdfDocument) writeTo(w io.Writer) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	const firstPage = 6
+	kids := ""
+	for i := range d.pages {
+		kids += fmt.Sprintf("%d 0 R ", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(d.pages)))
+	object("<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>")
+	object("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>")
+	object("<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
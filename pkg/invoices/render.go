@@ -0,0 +1,181 @@
+package invoices
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+// Title 票据标题
+func Title(inv *models.Invoice) string {
+	if inv.Kind == models.InvoiceCreditNote {
+		return "退款凭证"
+	}
+	return "电子收据"
+}
+
+// FormatRate 格式化万分比税率，如600为 "6%"，1250为 "12.5%"
+func FormatRate(rate int) string {
+	return strconv.FormatFloat(float64(rate)/100, 'f', -1, 64) + "%"
+}
+
+// taxLabel 税额一栏的名称
+func taxLabel(inv *models.Invoice) string {
+	name := "税额"
+	if inv.TaxName != "" {
+		name += "（" + inv.TaxName + "）"
+	}
+	return fmt.Sprintf("%s %s", name, FormatRate(inv.TaxRate))
+}
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"title":    Title,
+	"taxLabel": taxLabel,
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{title .}} {{.Number}}</title>
+<style>
+body{font-family:sans-serif;max-width:720px;margin:40px auto;color:#222}
+h1{font-size:24px;margin-bottom:4px}
+table{width:100%;border-collapse:collapse;margin:16px 0}
+th,td{padding:6px 8px;border-bottom:1px solid #ddd;text-align:left}
+.num{text-align:right}
+.parties{display:flex;gap:32px}
+.parties div{flex:1}
+.total td{font-weight:bold;border-bottom:none}
+</style>
+</head>
+<body>
+<h1>{{title .}}</h1>
+<p>编号：{{.Number}}<br>开具日期：{{.IssuedAt.Format "2006-01-02"}}<br>订单号：{{.OrderNo}}{{if .RefundNo}}<br>退款单号：{{.RefundNo}}{{end}}</p>
+<div class="parties">
+<div><h3>销售方</h3><p>{{.SellerName}}{{if .SellerTaxID}}<br>税号：{{.SellerTaxID}}{{end}}{{if .SellerAddress}}<br>{{.SellerAddress}}{{end}}</p></div>
+<div><h3>购买方</h3><p>{{.BuyerName}}<br>{{.BuyerEmail}}{{if .BuyerTaxID}}<br>税号：{{.BuyerTaxID}}{{end}}{{if .BuyerAddress}}<br>{{.BuyerAddress}}{{end}}</p></div>
+</div>
+<table>
+<tr><th>项目</th><th class="num">数量</th><th class="num">单价</th><th class="num">金额</th></tr>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice}}</td><td class="num">{{.Amount}}</td></tr>
+{{end}}</table>
+<table>
+<tr><td>不含税金额</td><td class="num">{{.Subtotal}}</td></tr>
+<tr><td>{{taxLabel .}}</td><td class="num">{{.TaxAmount}}</td></tr>
+<tr class="total"><td>合计（{{.Currency}}）</td><td class="num">{{.Total}}</td></tr>
+</table>
+<p>价格均为含税价。</p>
+</body>
+</html>
+`))
+
+// RenderHTML 渲染HTML格式的票据
+func RenderHTML(w io.Writer, inv *models.Invoice) error {
+	return receiptTemplate.Execute(w, inv)
+}
+
+// RenderPDF 渲染单页A4的PDF票据，明细过多时续页
+func RenderPDF(w io.Writer, inv *models.Invoice) error {
+	const (
+		left   = 56.0
+		right  = pageWidth - 56
+		bottom = 72.0
+		body   = 10.0
+	)
+	doc := &pdfDocument{}
+	page := doc.newPage()
+	y := pageHeight - 72
+
+	page.text(left, y, 20, Title(inv))
+	y -= 30
+	meta := []string{"编号：" + inv.Number, "开具日期：" + inv.IssuedAt.Format("2006-01-02"), "订单号：" + inv.OrderNo}
+	if inv.RefundNo != "" {
+		meta = append(meta, "退款单号："+inv.RefundNo)
+	}
+	for _, s := range meta {
+		page.text(left, y, body, s)
+		y -= 15
+	}
+
+	// 销售方和购买方左右两栏
+	y -= 10
+	seller := compact("销售方", inv.SellerName, prefixed("税号：", inv.SellerTaxID), inv.SellerAddress)
+	buyer := compact("购买方", inv.BuyerName, inv.BuyerEmail, prefixed("税号：", inv.BuyerTaxID), inv.BuyerAddress)
+	rows := max(len(seller), len(buyer))
+	for i := 0; i < rows; i++ {
+		size := body
+		if i == 0 {
+			size = 12
+		}
+		if i < len(seller) {
+			page.text(left, y, size, seller[i])
+		}
+		if i < len(buyer) {
+			page.text(pageWidth/2, y, size, buyer[i])
+		}
+		y -= 15
+	}
+
+	// 明细表
+	y -= 10
+	columns := []float64{right - 190, right - 100, right}
+	header := func() {
+		page.text(left, y, body, "项目")
+		for i, s := range []string{"数量", "单价", "金额"} {
+			page.textRight(columns[i], y, body, s)
+		}
+		y -= 6
+		page.line(left, y, right, y)
+		y -= 15
+	}
+	header()
+	for _, line := range inv.Lines {
+		if y < bottom+80 {
+			page = doc.newPage()
+			y = pageHeight - 72
+			header()
+		}
+		page.text(left, y, body, line.Description)
+		for i, s := range []string{strconv.Itoa(line.Quantity), line.UnitPrice.String(), line.Amount.String()} {
+			page.textRight(columns[i], y, body, s)
+		}
+		y -= 15
+	}
+	page.line(left, y+9, right, y+9)
+
+	y -= 10
+	for _, row := range [][2]string{
+		{"不含税金额", inv.Subtotal.String()},
+		{taxLabel(inv), inv.TaxAmount.String()},
+		{"合计（" + string(inv.Currency) + "）", inv.Total.String()},
+	} {
+		page.text(columns[0]-100, y, body, row[0])
+		page.textRight(right, y, body, row[1])
+		y -= 15
+	}
+	page.text(left, bottom, 8, "价格均为含税价。")
+
+	return doc.writeTo(w)
+}
+
+// compact 去掉空行
+func compact(lines ...string) []string {
+	out := lines[:0]
+	for _, s := range lines {
+		if strings.TrimSpace(s) != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// prefixed 非空时加上前缀
+func prefixed(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + s
+}
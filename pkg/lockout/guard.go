@@ -0,0 +1,322 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope 计数维度
+type Scope string
+
+const (
+	ScopeAccount Scope = "account"
+	ScopeIP      Scope = "ip"
+)
+
+// Policy 登录失败限制策略
+type Policy struct {
+	AccountMaxAttempts int           // 单账号触发锁定的失败次数
+	IPMaxAttempts      int           // 单IP触发锁定的失败次数
+	FreeAttempts       int           // 不触发退避的失败次数
+	BackoffBase        time.Duration // 退避基础时长，之后每次失败翻倍
+	LockoutDuration    time.Duration // 首次锁定时长，之后每次锁定翻倍
+	MaxLockout         time.Duration // 最长锁定时长
+	Window             time.Duration // 无新失败超过该时长后计数清零
+}
+
+// DefaultPolicy 默认策略
+func DefaultPolicy() Policy {
+	return Policy{
+		AccountMaxAttempts: 5,
+		IPMaxAttempts:      20,
+		FreeAttempts:       3,
+		BackoffBase:        time.Second,
+		LockoutDuration:    15 * time.Minute,
+		MaxLockout:         24 * time.Hour,
+		Window:             24 * time.Hour,
+	}
+}
+
+// Decision 登录尝试检查结果
+type Decision struct {
+	Allowed    bool
+	Scope      Scope
+	RetryAfter time.Duration
+}
+
+// LockoutEvent 锁定事件
+type LockoutEvent struct {
+	Scope       Scope
+	Account     string
+	IP          string
+	Lockouts    int
+	LockedUntil time.Time
+}
+
+// Guard 登录暴力破解防护
+type Guard struct {
+	store  AttemptStore
+	policy Policy
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	hooks []func(context.Context, LockoutEvent)
+}
+
+// NewGuard 创建登录防护
+func NewGuard(store AttemptStore, policy Policy) *Guard {
+	defaults := DefaultPolicy()
+	if policy.AccountMaxAttempts <= 0 {
+		policy.AccountMaxAttempts = defaults.AccountMaxAttempts
+	}
+	if policy.IPMaxAttempts <= 0 {
+		policy.IPMaxAttempts = defaults.IPMaxAttempts
+	}
+	if policy.BackoffBase <= 0 {
+		policy.BackoffBase = defaults.BackoffBase
+	}
+	if policy.LockoutDuration <= 0 {
+		policy.LockoutDuration = defaults.LockoutDuration
+	}
+	if policy.MaxLockout < policy.LockoutDuration {
+		policy.MaxLockout = policy.LockoutDuration
+	}
+	if policy.Window <= 0 {
+		policy.Window = defaults.Window
+	}
+
+	return &Guard{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// OnLockout 注册锁定事件回调，用于审计和通知
+func (g *Guard) OnLockout(fn func(context.Context, LockoutEvent)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.hooks = append(g.hooks, fn)
+}
+
+// pendingTimeout 放行的尝试超过该时长仍未得出结果时不再计入，避免进程中断后计数无法回收
+const pendingTimeout = time.Minute
+
+// Attempt 检查账号和IP当前是否允许尝试登录，允许时原子地占用一次尝试
+// 放行的尝试与失败次数一起计入退避和锁定，并发请求不能越过限制；调用方随后必须调用Fail或Succeed
+func (g *Guard) Attempt(ctx context.Context, account, ip string) (Decision, error) {
+	now := g.now()
+	keys := g.keys(account, ip)
+	decision := Decision{Allowed: true}
+
+	reserved := make([]scopedKey, 0, len(keys))
+	for _, k := range keys {
+		var wait time.Duration
+		_, err := g.store.Update(ctx, k.key, g.ttl(), func(r *AttemptRecord) {
+			g.expire(r, now)
+			wait = g.blockedUntil(r).Sub(now)
+			if r.Failures+r.Pending >= k.maxAttempts && wait < g.policy.BackoffBase {
+				wait = g.policy.BackoffBase
+			}
+			if wait > 0 {
+				return
+			}
+			r.Pending++
+			r.LastAttempt = now
+		})
+		if err != nil {
+			g.release(ctx, reserved)
+			return Decision{}, err
+		}
+		if wait > 0 {
+			if decision.Allowed || wait > decision.RetryAfter {
+				decision = Decision{Allowed: false, Scope: k.scope, RetryAfter: wait}
+			}
+			continue
+		}
+		reserved = append(reserved, k)
+	}
+
+	// 任一维度被拒绝时退回已占用的尝试
+	if !decision.Allowed {
+		g.release(ctx, reserved)
+	}
+	return decision, nil
+}
+
+// Check 检查账号和IP当前是否允许尝试登录，只读取计数，不占用尝试
+func (g *Guard) Check(ctx context.Context, account, ip string) (Decision, error) {
+	now := g.now()
+	decision := Decision{Allowed: true}
+
+	for _, k := range g.keys(account, ip) {
+		record, err := g.store.Get(ctx, k.key)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		until := g.blockedUntil(&record)
+		if wait := until.Sub(now); wait > 0 && wait > decision.RetryAfter {
+			decision = Decision{Allowed: false, Scope: k.scope, RetryAfter: wait}
+		}
+	}
+
+	return decision, nil
+}
+
+// Fail 记录一次失败尝试，返回本次触发的锁定事件，由Attempt放行的尝试转为失败
+func (g *Guard) Fail(ctx context.Context, account, ip string) ([]LockoutEvent, error) {
+	now := g.now()
+
+	var events []LockoutEvent
+	for _, k := range g.keys(account, ip) {
+		var locked bool
+		record, err := g.store.Update(ctx, k.key, g.ttl(), func(r *AttemptRecord) {
+			g.expire(r, now)
+			if r.Pending > 0 {
+				r.Pending--
+			}
+
+			r.Failures++
+			r.LastFailure = now
+
+			if r.Failures >= k.maxAttempts {
+				r.LockedUntil = now.Add(g.lockoutDuration(r.Lockouts))
+				r.Lockouts++
+				r.Failures = 0
+				locked = true
+			}
+		})
+		if err != nil {
+			return events, err
+		}
+
+		if locked {
+			events = append(events, LockoutEvent{
+				Scope:       k.scope,
+				Account:     normalizeAccount(account),
+				IP:          ip,
+				Lockouts:    record.Lockouts,
+				LockedUntil: record.LockedUntil,
+			})
+		}
+	}
+
+	g.mu.RLock()
+	hooks := g.hooks
+	g.mu.RUnlock()
+	for _, event := range events {
+		for _, hook := range hooks {
+			hook(ctx, event)
+		}
+	}
+
+	return events, nil
+}
+
+// Succeed 登录成功后清除账号的失败计数，IP只退回放行的尝试，失败计数保留以防止撞库
+func (g *Guard) Succeed(ctx context.Context, account, ip string) error {
+	if err := g.store.Reset(ctx, accountKey(account)); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	_, err := g.store.Update(ctx, "ip:"+ip, g.ttl(), func(r *AttemptRecord) {
+		if r.Pending > 0 {
+			r.Pending--
+		}
+	})
+	return err
+}
+
+// release 退回Attempt占用的尝试
+func (g *Guard) release(ctx context.Context, keys []scopedKey) {
+	for _, k := range keys {
+		g.store.Update(ctx, k.key, g.ttl(), func(r *AttemptRecord) {
+			if r.Pending > 0 {
+				r.Pending--
+			}
+		})
+	}
+}
+
+// expire 无新失败超过窗口期时清零计数，超时未得出结果的尝试不再计入
+func (g *Guard) expire(r *AttemptRecord, now time.Time) {
+	if !r.LastFailure.IsZero() && now.Sub(r.LastFailure) > g.policy.Window {
+		*r = AttemptRecord{Pending: r.Pending, LastAttempt: r.LastAttempt}
+	}
+	if r.Pending > 0 && now.Sub(r.LastAttempt) > pendingTimeout {
+		r.Pending = 0
+	}
+}
+
+// ttl 记录的保留时间
+func (g *Guard) ttl() time.Duration {
+	if g.policy.MaxLockout > g.policy.Window {
+		return g.policy.MaxLockout
+	}
+	return g.policy.Window
+}
+
+// blockedUntil 计算记录对应的最早可重试时间
+// 放行中的尝试按失败计入，退避从最近一次失败或放行的时间开始计算
+func (g *Guard) blockedUntil(r *AttemptRecord) time.Time {
+	until := r.LockedUntil
+
+	attempts := r.Failures + r.Pending
+	if attempts >= g.policy.FreeAttempts && attempts > 0 {
+		backoff := g.policy.BackoffBase << uint(attempts-g.policy.FreeAttempts)
+		if backoff <= 0 || backoff > g.policy.LockoutDuration {
+			backoff = g.policy.LockoutDuration
+		}
+		last := r.LastFailure
+		if r.Pending > 0 && r.LastAttempt.After(last) {
+			last = r.LastAttempt
+		}
+		if next := last.Add(backoff); next.After(until) {
+			until = next
+		}
+	}
+
+	return until
+}
+
+// lockoutDuration 第n+1次锁定的时长（指数增长）
+func (g *Guard) lockoutDuration(previous int) time.Duration {
+	d := g.policy.LockoutDuration
+	for i := 0; i < previous; i++ {
+		d *= 2
+		if d >= g.policy.MaxLockout {
+			return g.policy.MaxLockout
+		}
+	}
+	return d
+}
+
+type scopedKey struct {
+	scope       Scope
+	key         string
+	maxAttempts int
+}
+
+func (g *Guard) keys(account, ip string) []scopedKey {
+	var keys []scopedKey
+	if account != "" {
+		keys = append(keys, scopedKey{ScopeAccount, accountKey(account), g.policy.AccountMaxAttempts})
+	}
+	if ip != "" {
+		keys = append(keys, scopedKey{ScopeIP, "ip:" + ip, g.policy.IPMaxAttempts})
+	}
+	return keys
+}
+
+func normalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func accountKey(account string) string {
+	return "account:" + normalizeAccount(account)
+}
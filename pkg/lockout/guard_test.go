@@ -0,0 +1,224 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 可控时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGuard(policy Policy) (*Guard, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	guard := NewGuard(store, policy)
+	guard.now = clock.Now
+	return guard, clock
+}
+
+func TestGuardBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	guard, clock := newTestGuard(Policy{
+		AccountMaxAttempts: 5,
+		IPMaxAttempts:      100,
+		FreeAttempts:       3,
+		BackoffBase:        time.Second,
+		LockoutDuration:    time.Minute,
+		MaxLockout:         time.Hour,
+		Window:             time.Hour,
+	})
+
+	var events []LockoutEvent
+	guard.OnLockout(func(_ context.Context, e LockoutEvent) {
+		events = append(events, e)
+	})
+
+	// 前三次失败不触发退避
+	for i := 0; i < 2; i++ {
+		_, err := guard.Fail(ctx, "User@Example.com", "1.2.3.4")
+		assert.NoError(t, err)
+		d, err := guard.Check(ctx, "user@example.com", "1.2.3.4")
+		assert.NoError(t, err)
+		assert.True(t, d.Allowed)
+	}
+
+	// 第三次失败后需要等待1秒
+	guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	d, _ := guard.Check(ctx, "user@example.com", "1.2.3.4")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, ScopeAccount, d.Scope)
+	assert.Equal(t, time.Second, d.RetryAfter)
+
+	// 第四次失败后退避翻倍
+	clock.Advance(time.Second)
+	guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	d, _ = guard.Check(ctx, "user@example.com", "1.2.3.4")
+	assert.Equal(t, 2*time.Second, d.RetryAfter)
+
+	// 第五次失败触发锁定
+	clock.Advance(2 * time.Second)
+	got, err := guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "user@example.com", events[0].Account)
+	assert.Equal(t, 1, events[0].Lockouts)
+
+	d, _ = guard.Check(ctx, "user@example.com", "5.6.7.8")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Minute, d.RetryAfter)
+
+	// 锁定结束后可以再次尝试，再次锁定时长翻倍
+	clock.Advance(time.Minute)
+	d, _ = guard.Check(ctx, "user@example.com", "1.2.3.4")
+	assert.True(t, d.Allowed)
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Minute)
+		guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	}
+	assert.Len(t, events, 2)
+	assert.Equal(t, 2*time.Minute, events[1].LockedUntil.Sub(clock.Now()))
+}
+
+func TestGuardIPScope(t *testing.T) {
+	ctx := context.Background()
+	guard, _ := newTestGuard(Policy{
+		AccountMaxAttempts: 100,
+		IPMaxAttempts:      3,
+		FreeAttempts:       10,
+		LockoutDuration:    time.Minute,
+	})
+
+	// 同一IP尝试不同账号
+	guard.Fail(ctx, "a@example.com", "9.9.9.9")
+	guard.Fail(ctx, "b@example.com", "9.9.9.9")
+	events, _ := guard.Fail(ctx, "c@example.com", "9.9.9.9")
+	assert.Len(t, events, 1)
+	assert.Equal(t, ScopeIP, events[0].Scope)
+
+	d, _ := guard.Check(ctx, "d@example.com", "9.9.9.9")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, ScopeIP, d.Scope)
+
+	d, _ = guard.Check(ctx, "d@example.com", "8.8.8.8")
+	assert.True(t, d.Allowed)
+}
+
+func TestGuardSucceedResetsAccount(t *testing.T) {
+	ctx := context.Background()
+	guard, clock := newTestGuard(DefaultPolicy())
+
+	for i := 0; i < 4; i++ {
+		guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	}
+	d, _ := guard.Check(ctx, "user@example.com", "")
+	assert.False(t, d.Allowed)
+
+	assert.NoError(t, guard.Succeed(ctx, "user@example.com", ""))
+	d, _ = guard.Check(ctx, "user@example.com", "")
+	assert.True(t, d.Allowed)
+
+	// 超过窗口期后计数清零
+	for i := 0; i < 4; i++ {
+		guard.Fail(ctx, "other@example.com", "")
+	}
+	clock.Advance(25 * time.Hour)
+	guard.Fail(ctx, "other@example.com", "")
+	d, _ = guard.Check(ctx, "other@example.com", "")
+	assert.True(t, d.Allowed)
+}
+
+func TestGuardAttemptReservesConcurrentTries(t *testing.T) {
+	ctx := context.Background()
+	guard, clock := newTestGuard(Policy{
+		AccountMaxAttempts: 5,
+		IPMaxAttempts:      100,
+		FreeAttempts:       3,
+		BackoffBase:        time.Second,
+		LockoutDuration:    time.Minute,
+		MaxLockout:         time.Hour,
+		Window:             time.Hour,
+	})
+
+	// 结果未返回前的并发尝试同样计数，只放行免退避的次数
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+			assert.NoError(t, err)
+			if d.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, allowed)
+
+	for i := 0; i < 3; i++ {
+		guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	}
+	d, _ := guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+
+	// 两次放行的尝试都失败后达到上限并锁定
+	clock.Advance(time.Second)
+	d, _ = guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+	assert.True(t, d.Allowed)
+	clock.Advance(2 * time.Second)
+	d, _ = guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+	assert.True(t, d.Allowed)
+	d, _ = guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+	assert.False(t, d.Allowed, "attempts in flight count towards the limit")
+	guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	events, _ := guard.Fail(ctx, "user@example.com", "1.2.3.4")
+	assert.Len(t, events, 1)
+	d, _ = guard.Attempt(ctx, "user@example.com", "1.2.3.4")
+	assert.Equal(t, time.Minute, d.RetryAfter)
+}
+
+func TestGuardAttemptReleasedOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	guard, clock := newTestGuard(Policy{IPMaxAttempts: 3, FreeAttempts: 10, LockoutDuration: time.Minute})
+
+	// 成功登录退回IP上占用的尝试，不计入撞库计数
+	for i := 0; i < 10; i++ {
+		d, err := guard.Attempt(ctx, "user@example.com", "9.9.9.9")
+		assert.NoError(t, err)
+		assert.True(t, d.Allowed)
+		assert.NoError(t, guard.Succeed(ctx, "user@example.com", "9.9.9.9"))
+	}
+
+	// 被IP拒绝时不占用账号的尝试
+	for i := 0; i < 3; i++ {
+		guard.Attempt(ctx, "other@example.com", "9.9.9.9")
+	}
+	d, _ := guard.Attempt(ctx, "user@example.com", "9.9.9.9")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, ScopeIP, d.Scope)
+	d, _ = guard.Attempt(ctx, "user@example.com", "8.8.8.8")
+	assert.True(t, d.Allowed)
+	assert.NoError(t, guard.Succeed(ctx, "user@example.com", "8.8.8.8"))
+
+	// 未得出结果的尝试超时后不再计入
+	clock.Advance(2 * time.Minute)
+	d, _ = guard.Attempt(ctx, "other@example.com", "9.9.9.9")
+	assert.True(t, d.Allowed)
+}
@@ -0,0 +1,108 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AttemptRecord 某个键（账号或IP）的失败尝试记录
+type AttemptRecord struct {
+	Failures    int       // 当前窗口内的连续失败次数
+	Lockouts    int       // 已触发的锁定次数，用于计算下一次锁定时长
+	LastFailure time.Time // 最近一次失败时间
+	LockedUntil time.Time // 锁定截止时间
+	Pending     int       // 已放行、尚未得出结果的尝试次数
+	LastAttempt time.Time // 最近一次放行尝试的时间
+}
+
+// AttemptStore 失败尝试计数存储接口
+// 单进程部署使用内存实现，多实例部署可替换为Redis等共享存储
+type AttemptStore interface {
+	// Get 获取记录，不存在时返回零值
+	Get(ctx context.Context, key string) (AttemptRecord, error)
+	// Update 原子地修改记录并返回修改后的结果，ttl为记录的保留时间
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(*AttemptRecord)) (AttemptRecord, error)
+	// Reset 清除记录
+	Reset(ctx context.Context, key string) error
+}
+
+type memoryEntry struct {
+	record AttemptRecord
+	expiry time.Time
+}
+
+// MemoryStore 基于内存的AttemptStore实现
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// Get 实现AttemptStore接口
+func (s *MemoryStore) Get(ctx context.Context, key string) (AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return AttemptRecord{}, nil
+	}
+	if s.now().After(entry.expiry) {
+		delete(s.entries, key)
+		return AttemptRecord{}, nil
+	}
+	return entry.record, nil
+}
+
+// Update 实现AttemptStore接口
+func (s *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(*AttemptRecord)) (AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.purgeExpired(now)
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiry) {
+		entry = memoryEntry{}
+	}
+	fn(&entry.record)
+	entry.expiry = now.Add(ttl)
+	if entry.record.LockedUntil.After(entry.expiry) {
+		entry.expiry = entry.record.LockedUntil
+	}
+	s.entries[key] = entry
+
+	return entry.record, nil
+}
+
+// Reset 实现AttemptStore接口
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// purgeExpired 清理过期记录，每分钟最多执行一次，调用方需持有锁
+func (s *MemoryStore) purgeExpired(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+	s.lastPurge = now
+	for key, entry := range s.entries {
+		if now.After(entry.expiry) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Package money 精确的金额运算
+//
+// 金额以最小货币单位（分）的整数保存，乘除运算必须显式指定舍入方式，避免浮点误差。
+// 支持的币种都以百分之一为最小单位；JSON和接口中金额以两位小数的十进制字符串表示，如 "19.90"。
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount   = errors.New("money: invalid amount")
+	ErrInvalidCurrency = errors.New("money: unsupported currency")
+	ErrOverflow        = errors.New("money: amount out of range")
+)
+
+// Currency ISO 4217币种代码
+type Currency string
+
+const (
+	CNY Currency = "CNY"
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	HKD Currency = "HKD"
+)
+
+// DefaultCurrency 默认结算币种
+const DefaultCurrency = CNY
+
+// supportedCurrencies 支持的币种，最小单位都是百分之一
+var supportedCurrencies = map[Currency]bool{CNY: true, USD: true, EUR: true, HKD: true}
+
+// ParseCurrency 解析币种代码，不区分大小写，空值为默认币种
+func ParseCurrency(code string) (Currency, error) {
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	c := Currency(strings.ToUpper(code))
+	if !supportedCurrencies[c] {
+		return "", fmt.Errorf("%w: %s", ErrInvalidCurrency, code)
+	}
+	return c, nil
+}
+
+// Rounding 舍入方式
+type Rounding int
+
+const (
+	HalfUp   Rounding = iota // 四舍五入，远离零
+	HalfEven                 // 银行家舍入，恰好一半时取偶数
+	Down                     // 向零截断
+	Up                       // 远离零进位
+)
+
+// Amount 金额，单位为分
+type Amount int64
+
+// Zero 零金额
+const Zero Amount = 0
+
+// Cents 以分为单位构造金额
+func Cents(cents int64) Amount {
+	return Amount(cents)
+}
+
+// Yuan 以元为单位构造整数金额
+func Yuan(yuan int64) Amount {
+	return Amount(yuan * 100)
+}
+
+// Parse 解析十进制金额字符串，最多两位小数，如 "19.9"、"-0.05"
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" || len(frac) > 2 || strings.ContainsAny(whole+frac, "+-") {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if units > (1<<63-1-cents)/100 {
+		return 0, fmt.Errorf("%w: %q out of range", ErrInvalidAmount, s)
+	}
+	a := Amount(units*100 + cents)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+// MustParse 解析金额，格式错误时panic，用于常量和测试
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Cents 金额的分值
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// String 两位小数的十进制表示
+func (a Amount) String() string {
+	sign := ""
+	v := uint64(a)
+	if a < 0 {
+		// 以无符号数取绝对值，最小的负数取反也不会溢出
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// IsZero 是否为零
+func (a Amount) IsZero() bool {
+	return a == 0
+}
+
+// IsPositive 是否大于零
+func (a Amount) IsPositive() bool {
+	return a > 0
+}
+
+// Min 取较小的金额
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Max 取较大的金额
+func Max(a, b Amount) Amount {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Times 金额乘以整数倍，结果超出范围时返回ErrOverflow
+func (a Amount) Times(n int64) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(n))
+	if !product.IsInt64() {
+		return 0, fmt.Errorf("%w: %s * %d", ErrOverflow, a, n)
+	}
+	return Amount(product.Int64()), nil
+}
+
+// MulRatio 金额乘以 num/den，按指定方式舍入到分
+func (a Amount) MulRatio(num, den int64, r Rounding) Amount {
+	return Amount(Scale(int64(a), num, den, r))
+}
+
+// BasisPoints 金额的万分比，如1250表示12.5%
+func (a Amount) BasisPoints(bp int64, r Rounding) Amount {
+	return a.MulRatio(bp, 10000, r)
+}
+
+// Scale 计算 v * num / den 并按指定方式舍入，中间结果不会溢出；den为0或结果超出int64时panic
+func Scale(v, num, den int64, r Rounding) int64 {
+	if den == 0 {
+		panic("money: division by zero")
+	}
+	n := new(big.Int).Mul(big.NewInt(v), big.NewInt(num))
+	d := big.NewInt(den)
+	if d.Sign() < 0 {
+		n.Neg(n)
+		d.Neg(d)
+	}
+
+	q, rem := new(big.Int).QuoRem(n, d, new(big.Int))
+	if rem.Sign() == 0 {
+		return int64Of(q)
+	}
+	// 比较余数的两倍与除数，判断是否超过一半
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	cmp := twice.Cmp(d)
+
+	away := false
+	switch r {
+	case HalfUp:
+		away = cmp >= 0
+	case HalfEven:
+		away = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+	case Up:
+		away = true
+	}
+	if away {
+		if n.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return int64Of(q)
+}
+
+// int64Of 转换为int64，超出范围时panic，不静默截断
+func int64Of(q *big.Int) int64 {
+	if !q.IsInt64() {
+		panic(fmt.Sprintf("money: result %s out of range", q))
+	}
+	return q.Int64()
+}
+
+// MarshalJSON 以十进制字符串输出
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON 接受十进制字符串或JSON数字，都不能超过两位小数
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value 以分为单位的整数写入数据库
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan 从以分为单位的整数列读取
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*a = Amount(v)
+	case []byte:
+		return a.Scan(string(v))
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAmount, v)
+		}
+		*a = Amount(n)
+	case nil:
+		*a = 0
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAndString(t *testing.T) {
+	for in, want := range map[string]Amount{"19.9": 1990, "19.90": 1990, "0.05": 5, "-0.05": -5, "45": 4500, ".5": 50} {
+		got, err := Parse(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "1.234", "abc", "1.2.3", "--1", "99999999999999999999"} {
+		_, err := Parse(in)
+		assert.ErrorIs(t, err, ErrInvalidAmount, in)
+	}
+
+	assert.Equal(t, "19.90", Cents(1990).String())
+	assert.Equal(t, "-0.05", Cents(-5).String())
+	assert.Equal(t, "0.00", Zero.String())
+	assert.Equal(t, "-92233720368547758.08", Amount(math.MinInt64).String())
+	assert.Equal(t, "92233720368547758.07", Amount(math.MaxInt64).String())
+}
+
+func TestScaleRounding(t *testing.T) {
+	cases := []struct {
+		v, num, den int64
+		want        [4]int64 // HalfUp, HalfEven, Down, Up
+	}{
+		{5, 1, 2, [4]int64{3, 2, 2, 3}},       // 2.5
+		{7, 1, 2, [4]int64{4, 4, 3, 4}},       // 3.5
+		{-5, 1, 2, [4]int64{-3, -2, -2, -3}},  // -2.5
+		{10, 1, 3, [4]int64{3, 3, 3, 4}},      // 3.33
+		{20, 1, 3, [4]int64{7, 7, 6, 7}},      // 6.67
+		{6, 2, 3, [4]int64{4, 4, 4, 4}},       // 整除
+		{-7, 1, 2, [4]int64{-4, -4, -3, -4}},  // -3.5
+		{-10, 1, 3, [4]int64{-3, -3, -3, -4}}, // -3.33
+		{-20, 1, 3, [4]int64{-7, -7, -6, -7}}, // -6.67
+		{5, 1, -2, [4]int64{-3, -2, -2, -3}},  // 负除数
+		{-5, -1, 2, [4]int64{3, 2, 2, 3}},     // 负负得正
+		{0, 7, 3, [4]int64{0, 0, 0, 0}},
+	}
+	for _, c := range cases {
+		for r, want := range c.want {
+			assert.Equal(t, want, Scale(c.v, c.num, c.den, Rounding(r)), "%d*%d/%d mode %d", c.v, c.num, c.den, r)
+		}
+	}
+
+	// 中间结果超出int64也不会溢出
+	assert.Equal(t, int64(1<<62), Scale(1<<62, 1<<40, 1<<40, HalfUp))
+
+	assert.Equal(t, MustParse("12.49"), MustParse("99.90").BasisPoints(1250, HalfUp))
+	assert.Equal(t, MustParse("6.66"), MustParse("19.99").MulRatio(1, 3, HalfUp))
+	assert.Equal(t, MustParse("-12.49"), MustParse("-99.90").BasisPoints(1250, HalfUp))
+	assert.Equal(t, MustParse("0.01"), Cents(1).BasisPoints(1, Up))
+	assert.Equal(t, Zero, Cents(1).BasisPoints(1, HalfUp))
+
+	assert.Panics(t, func() { Scale(1, 1, 0, HalfUp) })
+	assert.Panics(t, func() { Scale(math.MaxInt64, 2, 1, Down) })
+	assert.Panics(t, func() { Scale(math.MaxInt64, 3, 2, Up) })
+	assert.Panics(t, func() { Scale(math.MinInt64, -1, 1, HalfUp) })
+	assert.Equal(t, int64(math.MinInt64), Scale(math.MinInt64, 1, 1, HalfUp))
+}
+
+func TestTimesDetectsOverflow(t *testing.T) {
+	a, err := Cents(10).Times(1000000)
+	require.NoError(t, err)
+	assert.Equal(t, Yuan(100000), a)
+	a, err = Cents(-10).Times(3)
+	require.NoError(t, err)
+	assert.Equal(t, Cents(-30), a)
+
+	// 922337203685477581 * 10 和 1844674407370955162 * 10 都会溢出int64
+	for _, n := range []int64{922337203685477581, 1844674407370955162, -922337203685477581} {
+		_, err := Cents(10).Times(n)
+		assert.ErrorIs(t, err, ErrOverflow, n)
+	}
+}
+
+func TestParseEdgeCases(t *testing.T) {
+	for in, want := range map[string]Amount{"+1.5": 150, "-.5": -50, "1.": 100, " 2.30 ": 230, "-0": 0, "92233720368547758.07": 1<<63 - 1} {
+		got, err := Parse(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"-", ".", "+-1", "1.-5", "1e3", "92233720368547758.08"} {
+		_, err := Parse(in)
+		assert.ErrorIs(t, err, ErrInvalidAmount, in)
+	}
+	assert.Panics(t, func() { MustParse("1.001") })
+
+	assert.Equal(t, Cents(-3), Min(Cents(-3), Cents(2)))
+	assert.Equal(t, Cents(2), Max(Cents(-3), Cents(2)))
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Amount Amount `json:"amount"`
+	}{MustParse("19.90")})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount":"19.90"}`, string(data))
+
+	var v struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"a":"45.5","b":19.9}`), &v))
+	assert.Equal(t, Cents(4550), v.A)
+	assert.Equal(t, Cents(1990), v.B)
+	assert.Error(t, json.Unmarshal([]byte(`{"a":0.001}`), &v))
+}
+
+func TestParseCurrency(t *testing.T) {
+	c, err := ParseCurrency("usd")
+	require.NoError(t, err)
+	assert.Equal(t, USD, c)
+	c, err = ParseCurrency("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCurrency, c)
+	_, err = ParseCurrency("JPY")
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Notification 发送给用户的通知
+type Notification struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+// 通知类型
+const (
+	TypeAccountLocked         = "security.account_locked"
+	TypeFriendRequest         = "friend.request"
+	TypeFriendRequestAccepted = "friend.accepted"
+	TypeModerationAction      = "moderation.action"
+	TypeRenewalDue            = "billing.renewal_due"
+	TypeRenewalFailed         = "billing.renewal_failed"
+	TypeSubscriptionExpired   = "billing.subscription_expired"
+	TypeRefundSucceeded       = "billing.refund_succeeded"
+)
+
+// Notifier 通知发送接口，可替换为邮件、短信或推送等实现
+type Notifier interface {
+	// Notify 向指定用户发送通知
+	Notify(ctx context.Context, userID uint, n Notification) error
+}
+
+// NotifierFunc 将函数适配为Notifier
+type NotifierFunc func(ctx context.Context, userID uint, n Notification) error
+
+// Notify 实现Notifier接口
+func (f NotifierFunc) Notify(ctx context.Context, userID uint, n Notification) error {
+	return f(ctx, userID, n)
+}
+
+// LogNotifier 仅将通知写入日志的默认实现
+type LogNotifier struct{}
+
+// Notify 实现Notifier接口
+func (LogNotifier) Notify(ctx context.Context, userID uint, n Notification) error {
+	log.Printf("notify user=%d type=%s title=%q", userID, n.Type, n.Title)
+	return nil
+}
+
+var (
+	mu       sync.RWMutex
+	notifier Notifier = LogNotifier{}
+)
+
+// SetNotifier 设置全局通知发送器
+func SetNotifier(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	if n == nil {
+		n = LogNotifier{}
+	}
+	notifier = n
+}
+
+// Send 通过全局通知发送器发送通知，发送失败只记录日志
+func Send(ctx context.Context, userID uint, n Notification) {
+	mu.RLock()
+	current := notifier
+	mu.RUnlock()
+
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+	if err := current.Notify(ctx, userID, n); err != nil {
+		log.Printf("Failed to send notification %s to user %d: %v", n.Type, userID, err)
+	}
+}
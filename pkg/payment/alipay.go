@@ -0,0 +1,405 @@
+package payment
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+const alipayTimeLayout = "2006-01-02 15:04:05"
+
+// 支付宝接口使用北京时间
+var alipayLocation = time.FixedZone("CST", 8*3600)
+
+// AlipayConfig 支付宝开放平台配置
+type AlipayConfig struct {
+	AppID      string
+	PrivateKey *rsa.PrivateKey // 应用私钥
+	PublicKey  *rsa.PublicKey  // 支付宝公钥
+	GatewayURL string
+	NotifyURL  string
+	ReturnURL  string
+}
+
+// AlipayGateway 支付宝电脑网站支付，使用RSA2签名
+type AlipayGateway struct {
+	config AlipayConfig
+	client *http.Client
+}
+
+// NewAlipayGateway 创建支付宝网关
+func NewAlipayGateway(config AlipayConfig) *AlipayGateway {
+	if config.GatewayURL == "" {
+		config.GatewayURL = "https://openapi.alipay.com/gateway.do"
+	}
+	return &AlipayGateway{config: config, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Method 实现PaymentGateway接口
+func (g *AlipayGateway) Method() models.PaymentMethod {
+	return models.PaymentAlipay
+}
+
+// CreateOrder 生成支付宝收银台跳转链接
+func (g *AlipayGateway) CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	biz := map[string]interface{}{
+		"out_trade_no": req.OrderNo,
+		"product_code": "FAST_INSTANT_TRADE_PAY",
+		"total_amount": req.Amount.String(),
+		"subject":      req.Subject,
+	}
+	if !req.ExpiresAt.IsZero() {
+		biz["time_expire"] = req.ExpiresAt.In(alipayLocation).Format(alipayTimeLayout)
+	}
+
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		returnURL = g.config.ReturnURL
+	}
+	values, err := g.signedParams("alipay.trade.page.pay", biz, returnURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		PaymentURL: g.config.GatewayURL + "?" + values.Encode(),
+	}, nil
+}
+
+// QueryOrder 查询交易状态
+func (g *AlipayGateway) QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error) {
+	var resp struct {
+		alipayResponse
+		TradeNo     string `json:"trade_no"`
+		OutTradeNo  string `json:"out_trade_no"`
+		TradeStatus string `json:"trade_status"`
+		TotalAmount string `json:"total_amount"`
+		SendPayDate string `json:"send_pay_date"`
+	}
+	if err := g.call(ctx, "alipay.trade.query", map[string]interface{}{"out_trade_no": req.OrderNo}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.SubCode == "ACQ.TRADE_NOT_EXIST" {
+		return nil, ErrOrderNotFound
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+
+	amount, _ := money.Parse(resp.TotalAmount)
+	result := &TradeResult{
+		OrderNo:       resp.OutTradeNo,
+		TransactionID: resp.TradeNo,
+		Status:        alipayTradeStatus(resp.TradeStatus),
+		Amount:        amount,
+		Currency:      DefaultCurrency,
+	}
+	if t, err := time.ParseInLocation(alipayTimeLayout, resp.SendPayDate, alipayLocation); err == nil {
+		result.PaidAt = &t
+	}
+	return result, nil
+}
+
+// Refund 申请退款，支付宝退款同步返回结果
+func (g *AlipayGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	var resp struct {
+		alipayResponse
+		TradeNo      string `json:"trade_no"`
+		RefundFee    string `json:"refund_fee"`
+		FundChange   string `json:"fund_change"`
+		OutRequestNo string `json:"out_request_no"`
+	}
+	biz := map[string]interface{}{
+		"out_trade_no":   req.OrderNo,
+		"refund_amount":  req.Amount.String(),
+		"out_request_no": req.RefundNo,
+		"refund_reason":  req.Reason,
+	}
+	if err := g.call(ctx, "alipay.trade.refund", biz, &resp); err != nil {
+		return nil, err
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+
+	status := TradeSuccess
+	if resp.FundChange != "Y" {
+		status = TradePending
+	}
+	return &RefundResult{
+		RefundNo:        req.RefundNo,
+		GatewayRefundID: resp.TradeNo,
+		Status:          status,
+		Amount:          req.Amount,
+	}, nil
+}
+
+// VerifyCallback 验证支付宝异步通知
+func (g *AlipayGateway) VerifyCallback(r *http.Request) (*TradeResult, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrInvalidCallback
+	}
+	form := r.PostForm
+	if form.Get("sign") == "" {
+		return nil, ErrInvalidSignature
+	}
+	if err := verifySHA256WithRSA(g.config.PublicKey, []byte(canonicalQuery(form, "sign", "sign_type")), form.Get("sign")); err != nil {
+		return nil, err
+	}
+	if form.Get("app_id") != g.config.AppID {
+		return nil, fmt.Errorf("%w: app_id mismatch", ErrInvalidCallback)
+	}
+
+	amount, err := money.Parse(form.Get("total_amount"))
+	if err != nil || form.Get("out_trade_no") == "" {
+		return nil, ErrInvalidCallback
+	}
+	result := &TradeResult{
+		OrderNo:       form.Get("out_trade_no"),
+		TransactionID: form.Get("trade_no"),
+		Status:        alipayTradeStatus(form.Get("trade_status")),
+		Amount:        amount,
+		Currency:      DefaultCurrency,
+	}
+	if t, err := time.ParseInLocation(alipayTimeLayout, form.Get("gmt_payment"), alipayLocation); err == nil {
+		result.PaidAt = &t
+	}
+	return result, nil
+}
+
+// Acknowledge 支付宝要求返回纯文本success，否则会重试通知
+func (g *AlipayGateway) Acknowledge(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "failure")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "success")
+}
+
+// signedParams 组装公共参数并签名
+func (g *AlipayGateway) signedParams(method string, biz map[string]interface{}, returnURL string) (url.Values, error) {
+	content, err := json.Marshal(biz)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("app_id", g.config.AppID)
+	values.Set("method", method)
+	values.Set("format", "JSON")
+	values.Set("charset", "utf-8")
+	values.Set("sign_type", "RSA2")
+	values.Set("timestamp", time.Now().In(alipayLocation).Format(alipayTimeLayout))
+	values.Set("version", "1.0")
+	values.Set("notify_url", g.config.NotifyURL)
+	if returnURL != "" {
+		values.Set("return_url", returnURL)
+	}
+	values.Set("biz_content", string(content))
+
+	sign, err := signSHA256WithRSA(g.config.PrivateKey, []byte(canonicalQuery(values, "sign")))
+	if err != nil {
+		return nil, err
+	}
+	values.Set("sign", sign)
+	return values, nil
+}
+
+// call 调用支付宝接口并验证响应签名
+func (g *AlipayGateway) call(ctx context.Context, method string, biz map[string]interface{}, out interface{}) error {
+	values, err := g.signedParams(method, biz, "")
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.config.GatewayURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("alipay: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("alipay: %v", err)
+	}
+
+	// 签名针对响应节点的原始内容计算，需保留原始字节
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("alipay: invalid response: %v", err)
+	}
+	node := envelope[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if node == nil {
+		return fmt.Errorf("alipay: unexpected response: %s", body)
+	}
+	var sign string
+	json.Unmarshal(envelope["sign"], &sign)
+	if err := verifySHA256WithRSA(g.config.PublicKey, node, sign); err != nil {
+		return err
+	}
+	return json.Unmarshal(node, out)
+}
+
+// alipayResponse 支付宝接口的公共响应字段
+type alipayResponse struct {
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+	SubCode string `json:"sub_code"`
+	SubMsg  string `json:"sub_msg"`
+}
+
+func (r alipayResponse) err() error {
+	if r.Code == "10000" {
+		return nil
+	}
+	// 4xxxx为参数、权限或业务错误，系统繁忙时也返回40004，需与服务不可用的2xxxx一样按结果未知处理
+	if strings.HasPrefix(r.Code, "4") && r.SubCode != "ACQ.SYSTEM_ERROR" {
+		return fmt.Errorf("%w: alipay: %s %s (%s %s)", ErrRejected, r.Code, r.Msg, r.SubCode, r.SubMsg)
+	}
+	return fmt.Errorf("alipay: %s %s (%s %s)", r.Code, r.Msg, r.SubCode, r.SubMsg)
+}
+
+func alipayTradeStatus(status string) TradeStatus {
+	switch status {
+	case "TRADE_SUCCESS", "TRADE_FINISHED":
+		return TradeSuccess
+	case "TRADE_CLOSED":
+		return TradeClosed
+	default:
+		return TradePending
+	}
+}
+
+// Settlement 实现SettlementGateway接口，查询并下载指定日期的交易账单
+// 账单在次日上午生成，生成前返回ErrSettlementNotReady
+func (g *AlipayGateway) Settlement(ctx context.Context, date time.Time) ([]SettlementRecord, error) {
+	var resp struct {
+		alipayResponse
+		BillDownloadURL string `json:"bill_download_url"`
+	}
+	biz := map[string]interface{}{
+		"bill_type": "trade",
+		"bill_date": date.Format("2006-01-02"),
+	}
+	if err := g.call(ctx, "alipay.data.dataservice.bill.downloadurl.query", biz, &resp); err != nil {
+		return nil, err
+	}
+	if resp.SubCode == "isp.bill_not_exist" {
+		return nil, ErrSettlementNotReady
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+
+	// 下载地址有效期很短且无需签名，内容为zip压缩的CSV文件
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, resp.BillDownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("alipay: %v", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("alipay: %v", err)
+	}
+	if httpResp.StatusCode >= 300 {
+		return nil, statusError("alipay", httpResp.StatusCode, "bill download failed")
+	}
+	return parseAlipayBill(body)
+}
+
+// parseAlipayBill 解析zip压缩的交易账单，包含业务明细和汇总两个文件
+// 只取支付宝交易号开头的明细行，列：0支付宝交易号 1商户订单号 5完成时间 11订单金额 21退款批次号
+// 退款行的订单金额为负数，金额单位为元；文件为GBK编码，用到的列均为ASCII字符
+func parseAlipayBill(data []byte) ([]SettlementRecord, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("alipay: malformed bill archive: %v", err)
+	}
+
+	var records []SettlementRecord
+	for _, file := range archive.File {
+		f, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("alipay: malformed bill archive: %v", err)
+		}
+		reader := csv.NewReader(f)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		rows, err := reader.ReadAll()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("alipay: malformed bill: %v", err)
+		}
+
+		for i, row := range rows {
+			if len(row) < 22 || !isDigits(strings.TrimSpace(row[0])) {
+				continue
+			}
+			field := func(n int) string {
+				return strings.Trim(row[n], "\t ")
+			}
+			settledAt, err := time.ParseInLocation(alipayTimeLayout, field(5), alipayLocation)
+			if err != nil {
+				return nil, fmt.Errorf("alipay: malformed bill time on line %d", i+1)
+			}
+			amount, err := money.Parse(field(11))
+			if err != nil {
+				return nil, fmt.Errorf("alipay: malformed bill amount on line %d", i+1)
+			}
+			record := SettlementRecord{
+				Type:          SettlementPayment,
+				OrderNo:       field(1),
+				TransactionID: field(0),
+				Amount:        amount,
+				Currency:      DefaultCurrency,
+				SettledAt:     settledAt,
+			}
+			if refundNo := field(21); refundNo != "" {
+				record.Type = SettlementRefund
+				record.RefundNo = refundNo
+				if amount.Cents() < 0 {
+					record.Amount = money.Cents(-amount.Cents())
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
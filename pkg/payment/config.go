@@ -0,0 +1,157 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+var (
+	ErrMockNotAllowed = errors.New("payment: mock gateway can only be enabled in dev mode")
+	ErrMockSecret     = errors.New("payment: mock gateway secret is empty, a placeholder or too short")
+	ErrWebhookSecret  = errors.New("payment: credit card webhook secret is empty, a placeholder or too short")
+)
+
+// minMockSecretLength 回调签名共享密钥的最小长度
+const minMockSecretLength = 16
+
+// 已知的占位密钥，任何模式下都不能用于回调签名
+var mockPlaceholderSecrets = map[string]bool{
+	"mock-secret": true,
+	"change-me":   true,
+	"changeme":    true,
+	"secret":      true,
+}
+
+// NewRegistryFromConfig 按配置注册网关
+// 配置了商户信息的支付方式使用真实网关，其余支付方式在开发模式下启用模拟网关时由模拟网关处理
+// 非开发模式启用模拟网关，或模拟网关、银行卡回调的签名密钥为空、为占位值时返回错误，拒绝启动
+func NewRegistryFromConfig(cfg *configs.Config) (*Registry, error) {
+	pc := cfg.Payment
+	registry := NewRegistry()
+
+	if c := pc.Alipay; c.AppID != "" {
+		privateKey, err := loadRSAPrivateKey(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("alipay: %v", err)
+		}
+		publicKey, err := loadRSAPublicKey(c.AlipayPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("alipay: %v", err)
+		}
+		registry.Register(NewAlipayGateway(AlipayConfig{
+			AppID:      c.AppID,
+			PrivateKey: privateKey,
+			PublicKey:  publicKey,
+			GatewayURL: c.GatewayURL,
+			NotifyURL:  callbackURL(pc.NotifyBaseURL, models.PaymentAlipay),
+			ReturnURL:  pc.ReturnURL,
+		}))
+	}
+
+	if c := pc.Wechat; c.MchID != "" {
+		privateKey, err := loadRSAPrivateKey(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("wechat: %v", err)
+		}
+		platformKey, err := loadRSAPublicKey(c.PlatformCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("wechat: %v", err)
+		}
+		registry.Register(NewWechatGateway(WechatConfig{
+			MchID:       c.MchID,
+			AppID:       c.AppID,
+			SerialNo:    c.SerialNo,
+			PrivateKey:  privateKey,
+			PlatformKey: platformKey,
+			APIv3Key:    c.APIv3Key,
+			BaseURL:     c.BaseURL,
+			NotifyURL:   callbackURL(pc.NotifyBaseURL, models.PaymentWechat),
+		}))
+	}
+
+	if c := pc.UnionPay; c.MerID != "" {
+		privateKey, err := loadRSAPrivateKey(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unionpay: %v", err)
+		}
+		publicKey, err := loadRSAPublicKey(c.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unionpay: %v", err)
+		}
+		registry.Register(NewUnionPayGateway(UnionPayConfig{
+			MerID:      c.MerID,
+			CertID:     c.CertID,
+			PrivateKey: privateKey,
+			PublicKey:  publicKey,
+			BaseURL:    c.BaseURL,
+			NotifyURL:  callbackURL(pc.NotifyBaseURL, models.PaymentUnionPay),
+		}))
+	}
+
+	if c := pc.CreditCard; c.SecretKey != "" {
+		if weakSecret(c.WebhookSecret) {
+			return nil, ErrWebhookSecret
+		}
+		registry.Register(NewCreditCardGateway(CreditCardConfig{
+			SecretKey:     c.SecretKey,
+			WebhookSecret: c.WebhookSecret,
+			BaseURL:       c.BaseURL,
+			ReturnURL:     pc.ReturnURL,
+		}))
+	}
+
+	if pc.Mock.Enabled {
+		if !cfg.Server.DevMode {
+			return nil, ErrMockNotAllowed
+		}
+		if err := checkMockSecret(pc.Mock.Secret); err != nil {
+			return nil, err
+		}
+		for _, method := range []models.PaymentMethod{
+			models.PaymentAlipay, models.PaymentWechat, models.PaymentUnionPay, models.PaymentCreditCard,
+		} {
+			if _, err := registry.Get(method); err == nil {
+				continue
+			}
+			registry.Register(NewMockGateway(method, MockConfig{
+				BaseURL:   pc.Mock.BaseURL,
+				Secret:    pc.Mock.Secret,
+				NotifyURL: callbackURL(pc.NotifyBaseURL, method),
+				ReturnURL: pc.ReturnURL,
+			}))
+		}
+	}
+
+	return registry, nil
+}
+
+// CheckProduction 非开发模式下任何支付方式都不能由模拟网关处理，启动时调用
+func (r *Registry) CheckProduction(devMode bool) error {
+	if devMode {
+		return nil
+	}
+	for _, method := range r.Methods() {
+		if _, ok := r.gateways[method].(*MockGateway); ok {
+			return fmt.Errorf("%w: %s resolves to the mock gateway", ErrMockNotAllowed, method)
+		}
+	}
+	return nil
+}
+
+// checkMockSecret 模拟网关的回调只凭共享密钥验签，密钥泄露即可伪造支付成功
+func checkMockSecret(secret string) error {
+	if weakSecret(secret) {
+		return ErrMockSecret
+	}
+	return nil
+}
+
+// weakSecret 签名密钥为空、过短或为已知的占位值
+func weakSecret(secret string) bool {
+	secret = strings.TrimSpace(secret)
+	return len(secret) < minMockSecretLength || mockPlaceholderSecrets[strings.ToLower(secret)]
+}
@@ -0,0 +1,76 @@
+package payment
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockConfig(devMode bool, secret string) *configs.Config {
+	cfg := &configs.Config{}
+	cfg.Server.DevMode = devMode
+	cfg.Payment.Mock.Enabled = true
+	cfg.Payment.Mock.BaseURL = "http://localhost:8090"
+	cfg.Payment.Mock.Secret = secret
+	return cfg
+}
+
+func TestMockGatewayRequiresDevModeAndSecret(t *testing.T) {
+	_, err := NewRegistryFromConfig(mockConfig(false, "a-long-random-mock-secret"))
+	assert.ErrorIs(t, err, ErrMockNotAllowed)
+
+	for _, secret := range []string{"", "mock-secret", "  Mock-Secret  ", "short"} {
+		_, err := NewRegistryFromConfig(mockConfig(true, secret))
+		assert.ErrorIs(t, err, ErrMockSecret, secret)
+	}
+
+	registry, err := NewRegistryFromConfig(mockConfig(true, "a-long-random-mock-secret"))
+	require.NoError(t, err)
+	gateway, err := registry.Get(models.PaymentAlipay)
+	require.NoError(t, err)
+	assert.IsType(t, &MockGateway{}, gateway)
+
+	cfg := mockConfig(false, "")
+	cfg.Payment.Mock.Enabled = false
+	registry, err = NewRegistryFromConfig(cfg)
+	require.NoError(t, err)
+	assert.Empty(t, registry.Methods())
+}
+
+func TestCreditCardGatewayRequiresWebhookSecret(t *testing.T) {
+	cfg := &configs.Config{}
+	cfg.Payment.CreditCard.SecretKey = "sk_live_123"
+	for _, secret := range []string{"", "   ", "changeme", "whsec_short"} {
+		cfg.Payment.CreditCard.WebhookSecret = secret
+		_, err := NewRegistryFromConfig(cfg)
+		assert.ErrorIs(t, err, ErrWebhookSecret, secret)
+	}
+
+	cfg.Payment.CreditCard.WebhookSecret = "whsec_a-long-random-webhook-secret"
+	registry, err := NewRegistryFromConfig(cfg)
+	require.NoError(t, err)
+	gateway, err := registry.Get(models.PaymentCreditCard)
+	require.NoError(t, err)
+	assert.IsType(t, &CreditCardGateway{}, gateway)
+
+	// 直接构造的网关未配置密钥时不接受以空密钥签名的回调
+	unsigned := NewCreditCardGateway(CreditCardConfig{SecretKey: "sk_live_123"})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	body := []byte(`{"type":"checkout.session.completed"}`)
+	header := "t=" + timestamp + ",v1=" + hmacSHA256Hex("", timestamp+"."+string(body))
+	assert.ErrorIs(t, unsigned.verifySignature(header, body), ErrInvalidSignature)
+}
+
+func TestCheckProductionRejectsMockGateway(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(NewMockGateway(models.PaymentWechat, MockConfig{Secret: "a-long-random-mock-secret"}))
+
+	assert.NoError(t, registry.CheckProduction(true))
+	assert.ErrorIs(t, registry.CheckProduction(false), ErrMockNotAllowed)
+	assert.NoError(t, NewRegistry().CheckProduction(false))
+}
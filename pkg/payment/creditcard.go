@@ -0,0 +1,398 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+// 回调签名时间戳允许的最大偏差
+const cardSignatureTolerance = 5 * time.Minute
+
+// CreditCardConfig 信用卡收单配置，对接Stripe兼容的托管收银台接口
+type CreditCardConfig struct {
+	SecretKey     string
+	WebhookSecret string
+	BaseURL       string
+	ReturnURL     string
+}
+
+// CreditCardGateway 信用卡支付，跳转到收单机构的托管收银台
+// 网关订单标识为收银台会话ID，交易号为支付意图ID
+type CreditCardGateway struct {
+	config CreditCardConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewCreditCardGateway 创建信用卡网关
+func NewCreditCardGateway(config CreditCardConfig) *CreditCardGateway {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.stripe.com"
+	}
+	return &CreditCardGateway{config: config, client: &http.Client{Timeout: 15 * time.Second}, now: time.Now}
+}
+
+// Method 实现PaymentGateway接口
+func (g *CreditCardGateway) Method() models.PaymentMethod {
+	return models.PaymentCreditCard
+}
+
+// cardSession 收银台会话
+type cardSession struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	Status            string            `json:"status"`
+	PaymentStatus     string            `json:"payment_status"`
+	PaymentIntent     string            `json:"payment_intent"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// cardRefund 退款对象，接口响应和Webhook事件中格式相同
+type cardRefund struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Amount        int64             `json:"amount"`
+	Currency      string            `json:"currency"`
+	PaymentIntent string            `json:"payment_intent"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+func (r *cardRefund) result() *RefundResult {
+	result := &RefundResult{
+		RefundNo:        r.Metadata["refund_no"],
+		GatewayRefundID: r.ID,
+		Status:          TradePending,
+		Amount:          money.Cents(r.Amount),
+	}
+	switch r.Status {
+	case "succeeded":
+		result.Status = TradeSuccess
+	case "failed", "canceled":
+		result.Status = TradeFailed
+	}
+	return result
+}
+
+// CreateOrder 创建托管收银台会话
+func (g *CreditCardGateway) CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		returnURL = g.config.ReturnURL
+	}
+
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", req.OrderNo)
+	form.Set("success_url", returnURL)
+	form.Set("cancel_url", returnURL)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(currencyOrDefault(req.Currency)))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(req.Amount.Cents(), 10))
+	form.Set("line_items[0][price_data][product_data][name]", req.Subject)
+	form.Set("metadata[order_no]", req.OrderNo)
+	form.Set("payment_intent_data[metadata][order_no]", req.OrderNo)
+	if !req.ExpiresAt.IsZero() {
+		form.Set("expires_at", strconv.FormatInt(req.ExpiresAt.Unix(), 10))
+	}
+
+	var session cardSession
+	if err := g.do(ctx, http.MethodPost, "/v1/checkout/sessions", form, req.OrderNo, &session); err != nil {
+		return nil, err
+	}
+	return &OrderResult{
+		GatewayOrderID: session.ID,
+		PaymentURL:     session.URL,
+	}, nil
+}
+
+// QueryOrder 查询收银台会话状态
+func (g *CreditCardGateway) QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error) {
+	if req.GatewayOrderID == "" {
+		return nil, ErrOrderNotFound
+	}
+	var session cardSession
+	if err := g.do(ctx, http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(req.GatewayOrderID), nil, "", &session); err != nil {
+		return nil, err
+	}
+	return session.result(), nil
+}
+
+// Refund 对支付意图发起退款
+func (g *CreditCardGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	form := url.Values{}
+	form.Set("payment_intent", req.TransactionID)
+	form.Set("amount", strconv.FormatInt(req.Amount.Cents(), 10))
+	form.Set("metadata[order_no]", req.OrderNo)
+	form.Set("metadata[refund_no]", req.RefundNo)
+
+	var refund cardRefund
+	if err := g.do(ctx, http.MethodPost, "/v1/refunds", form, req.RefundNo, &refund); err != nil {
+		return nil, err
+	}
+	result := refund.result()
+	result.RefundNo = req.RefundNo
+	result.Amount = req.Amount
+	return result, nil
+}
+
+// VerifyCallback 验证Webhook签名并解析收银台会话事件
+func (g *CreditCardGateway) VerifyCallback(r *http.Request) (*TradeResult, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, ErrInvalidCallback
+	}
+	if err := g.verifySignature(r.Header.Get("Stripe-Signature"), body); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, ErrInvalidCallback
+	}
+
+	// 退款状态变更事件
+	if strings.HasPrefix(event.Type, "refund.") {
+		var refund cardRefund
+		if err := json.Unmarshal(event.Data.Object, &refund); err != nil || refund.Metadata["refund_no"] == "" {
+			return nil, ErrInvalidCallback
+		}
+		return &TradeResult{
+			OrderNo:       refund.Metadata["order_no"],
+			TransactionID: refund.PaymentIntent,
+			Currency:      strings.ToUpper(refund.Currency),
+			Refund:        refund.result(),
+		}, nil
+	}
+
+	var session cardSession
+	if err := json.Unmarshal(event.Data.Object, &session); err != nil || session.ClientReferenceID == "" {
+		return nil, ErrInvalidCallback
+	}
+
+	result := session.result()
+	switch event.Type {
+	case "checkout.session.async_payment_failed":
+		result.Status = TradeFailed
+	case "checkout.session.expired":
+		result.Status = TradeClosed
+	}
+	return result, nil
+}
+
+// Acknowledge 返回2xx表示已接收，否则收单机构会重试
+func (g *CreditCardGateway) Acknowledge(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"received":true}`)
+}
+
+// verifySignature 验证 t=时间戳,v1=签名 格式的签名头，未配置密钥时拒绝所有回调
+func (g *CreditCardGateway) verifySignature(header string, body []byte) error {
+	if g.config.WebhookSecret == "" {
+		return ErrInvalidSignature
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := g.now().Sub(time.Unix(ts, 0)); d > cardSignatureTolerance || d < -cardSignatureTolerance {
+		return fmt.Errorf("%w: timestamp out of range", ErrInvalidSignature)
+	}
+
+	expected := hmacSHA256Hex(g.config.WebhookSecret, timestamp+"."+string(body))
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// do 调用收单机构接口，写操作带幂等键
+func (g *CreditCardGateway) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.config.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.SetBasicAuth(g.config.SecretKey, "")
+	if form != nil {
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("creditcard: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("creditcard: %v", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrOrderNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return statusError("creditcard", resp.StatusCode, apiErr.Error.Code+" "+apiErr.Error.Message)
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func (s *cardSession) result() *TradeResult {
+	result := &TradeResult{
+		OrderNo:       s.ClientReferenceID,
+		TransactionID: s.PaymentIntent,
+		Amount:        money.Cents(s.AmountTotal),
+		Currency:      strings.ToUpper(s.Currency),
+		Status:        TradePending,
+	}
+	switch {
+	case s.PaymentStatus == "paid":
+		result.Status = TradeSuccess
+	case s.Status == "expired":
+		result.Status = TradeClosed
+	}
+	return result
+}
+
+func hmacSHA256Hex(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cardBalanceTransaction 余额流水，来源对象展开为扣款或退款
+type cardBalanceTransaction struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Source  struct {
+		ID            string            `json:"id"`
+		Object        string            `json:"object"`
+		Amount        int64             `json:"amount"`
+		Currency      string            `json:"currency"`
+		Metadata      map[string]string `json:"metadata"`
+		PaymentIntent struct {
+			ID       string            `json:"id"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"payment_intent"`
+	} `json:"source"`
+}
+
+// record 转换为对账记录，与扣款和退款无关的流水返回nil
+func (t *cardBalanceTransaction) record() *SettlementRecord {
+	source := &t.Source
+	record := &SettlementRecord{
+		OrderNo:       source.PaymentIntent.Metadata["order_no"],
+		TransactionID: source.PaymentIntent.ID,
+		Amount:        money.Cents(source.Amount),
+		Currency:      strings.ToUpper(source.Currency),
+		SettledAt:     time.Unix(t.Created, 0),
+	}
+	switch source.Object {
+	case "charge":
+		record.Type = SettlementPayment
+	case "refund":
+		record.Type = SettlementRefund
+		record.RefundNo = source.Metadata["refund_no"]
+		if no := source.Metadata["order_no"]; no != "" {
+			record.OrderNo = no
+		}
+	default:
+		return nil
+	}
+	return record
+}
+
+// Settlement 实现SettlementGateway接口，按日分页拉取余额流水中的扣款和退款
+func (g *CreditCardGateway) Settlement(ctx context.Context, date time.Time) ([]SettlementRecord, error) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	end := start.AddDate(0, 0, 1)
+	// 当天的流水尚未全部入账前不对账
+	if g.now().Before(end) {
+		return nil, ErrSettlementNotReady
+	}
+
+	var records []SettlementRecord
+	query := url.Values{}
+	query.Set("created[gte]", strconv.FormatInt(start.Unix(), 10))
+	query.Set("created[lt]", strconv.FormatInt(end.Unix(), 10))
+	query.Set("limit", "100")
+	query.Add("expand[]", "data.source.payment_intent")
+	for {
+		var page struct {
+			Data    []cardBalanceTransaction `json:"data"`
+			HasMore bool                     `json:"has_more"`
+		}
+		if err := g.do(ctx, http.MethodGet, "/v1/balance_transactions?"+query.Encode(), nil, "", &page); err != nil {
+			return nil, err
+		}
+		for i := range page.Data {
+			if record := page.Data[i].record(); record != nil {
+				records = append(records, *record)
+			}
+		}
+		if !page.HasMore || len(page.Data) == 0 {
+			return records, nil
+		}
+		query.Set("starting_after", page.Data[len(page.Data)-1].ID)
+	}
+}
@@ -0,0 +1,214 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+var (
+	ErrUnsupportedMethod = errors.New("payment: unsupported payment method")
+	ErrInvalidSignature  = errors.New("payment: invalid signature")
+	ErrInvalidCallback   = errors.New("payment: malformed callback")
+	ErrOrderNotFound     = errors.New("payment: order not found at gateway")
+	ErrAmountMismatch    = errors.New("payment: amount or currency mismatch")
+	// ErrRejected 网关明确拒绝了请求；其他错误（超时、网络中断、网关内部错误）时请求结果未知
+	ErrRejected = errors.New("payment: request rejected by gateway")
+)
+
+// DefaultCurrency 默认结算币种
+const DefaultCurrency = "CNY"
+
+// TradeStatus 网关侧的交易状态
+type TradeStatus string
+
+const (
+	TradePending  TradeStatus = "pending"  // 等待支付
+	TradeSuccess  TradeStatus = "success"  // 支付成功
+	TradeFailed   TradeStatus = "failed"   // 支付失败
+	TradeClosed   TradeStatus = "closed"   // 超时未支付或已关闭
+	TradeRefunded TradeStatus = "refunded" // 已全额退款
+)
+
+// OrderRequest 创建支付订单的参数
+type OrderRequest struct {
+	OrderNo   string
+	Amount    money.Amount
+	Currency  string
+	Subject   string
+	UserID    uint
+	ClientIP  string
+	ReturnURL string // 支付完成后的跳转地址，为空时使用网关配置
+	ExpiresAt time.Time
+}
+
+// OrderResult 创建支付订单的结果，PaymentURL 和 QRCode 至少有一个
+type OrderResult struct {
+	GatewayOrderID string // 网关侧的订单标识，部分网关查询和退款时需要
+	PaymentURL     string // 跳转支付页面
+	QRCode         string // 二维码内容，由前端生成二维码图片
+}
+
+// QueryRequest 查询订单的参数
+type QueryRequest struct {
+	OrderNo        string
+	GatewayOrderID string
+}
+
+// TradeResult 网关返回的交易信息，查询和回调共用
+type TradeResult struct {
+	OrderNo       string
+	TransactionID string
+	Status        TradeStatus
+	Amount        money.Amount
+	Currency      string
+	PaidAt        *time.Time
+	Refund        *RefundResult // 非空时为退款结果通知，OrderNo可能为空
+}
+
+// Matches 校验交易金额和币种与订单一致
+func (r *TradeResult) Matches(amount money.Amount, currency string) error {
+	if r.Amount != amount {
+		return fmt.Errorf("%w: paid %s, expected %s", ErrAmountMismatch, r.Amount.String(), amount.String())
+	}
+	if !strings.EqualFold(currencyOrDefault(r.Currency), currencyOrDefault(currency)) {
+		return fmt.Errorf("%w: paid in %s, expected %s", ErrAmountMismatch, r.Currency, currency)
+	}
+	return nil
+}
+
+// RefundRequest 退款参数
+type RefundRequest struct {
+	OrderNo        string
+	GatewayOrderID string
+	TransactionID  string
+	RefundNo       string
+	Amount         money.Amount // 本次退款金额
+	TotalAmount    money.Amount // 原订单金额
+	Currency       string
+	Reason         string
+}
+
+// RefundResult 退款结果
+type RefundResult struct {
+	RefundNo        string
+	GatewayRefundID string
+	Status          TradeStatus // success 表示退款完成，pending 表示处理中
+	Amount          money.Amount
+}
+
+// PaymentGateway 支付网关接口，每种支付方式一个实现
+type PaymentGateway interface {
+	// Method 支付方式
+	Method() models.PaymentMethod
+	// CreateOrder 在网关下单，返回支付链接或二维码
+	CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error)
+	// QueryOrder 主动查询订单状态
+	QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error)
+	// Refund 申请退款
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+	// VerifyCallback 验证异步回调的签名并解析交易结果
+	VerifyCallback(r *http.Request) (*TradeResult, error)
+	// Acknowledge 按网关要求的格式应答回调，err不为空时表示处理失败，网关会重试
+	Acknowledge(w http.ResponseWriter, err error)
+}
+
+// RecurringGateway 支持免密代扣的网关，自动续费时直接扣款而不需要用户跳转支付
+// 不支持代扣的网关续费时只能下单后通知用户手动支付
+type RecurringGateway interface {
+	// ChargeRecurring 同步扣款，返回扣款结果；结果为pending时以后续回调或查询为准
+	ChargeRecurring(ctx context.Context, req OrderRequest) (*TradeResult, error)
+}
+
+// Registry 支付方式到网关实现的映射
+type Registry struct {
+	gateways map[models.PaymentMethod]PaymentGateway
+}
+
+// NewRegistry 创建空的网关注册表
+func NewRegistry() *Registry {
+	return &Registry{gateways: make(map[models.PaymentMethod]PaymentGateway)}
+}
+
+// Register 注册网关，同一支付方式后注册的覆盖先注册的
+func (r *Registry) Register(g PaymentGateway) {
+	r.gateways[g.Method()] = g
+}
+
+// Get 获取支付方式对应的网关
+func (r *Registry) Get(method models.PaymentMethod) (PaymentGateway, error) {
+	g, ok := r.gateways[method]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, method)
+	}
+	return g, nil
+}
+
+// Methods 已注册的支付方式
+func (r *Registry) Methods() []models.PaymentMethod {
+	methods := make([]models.PaymentMethod, 0, len(r.gateways))
+	for m := range r.gateways {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i] < methods[j] })
+	return methods
+}
+
+// statusError 网关HTTP应答的错误，4xx中除超时、冲突和限流外视为明确拒绝，其余状态的请求结果未知
+func statusError(prefix string, status int, detail string) error {
+	switch {
+	case status == http.StatusRequestTimeout, status == http.StatusConflict, status == http.StatusTooManyRequests:
+	case status >= 400 && status < 500:
+		return fmt.Errorf("%w: %s: %d %s", ErrRejected, prefix, status, detail)
+	}
+	return fmt.Errorf("%s: %d %s", prefix, status, detail)
+}
+
+// canonicalQuery 按键名排序拼接参数，跳过空值和指定的键，用于签名
+func canonicalQuery(values url.Values, skip ...string) string {
+	skipped := make(map[string]bool, len(skip))
+	for _, k := range skip {
+		skipped[k] = true
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if !skipped[k] && values.Get(k) != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(values.Get(k))
+	}
+	return b.String()
+}
+
+// callbackURL 拼接回调地址
+func callbackURL(base string, method models.PaymentMethod) string {
+	return strings.TrimRight(base, "/") + "/api/payments/callback/" + string(method)
+}
+
+// randomHex 生成指定字节数的随机十六进制串
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,151 @@
+package payment
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTradeResultMatches(t *testing.T) {
+	result := &TradeResult{Amount: money.Yuan(45), Currency: "cny"}
+	assert.NoError(t, result.Matches(money.MustParse("45.00"), "CNY"))
+	assert.NoError(t, (&TradeResult{Amount: money.Cents(30)}).Matches(money.MustParse("0.3"), ""))
+
+	assert.ErrorIs(t, result.Matches(money.MustParse("45.01"), "CNY"), ErrAmountMismatch)
+	assert.ErrorIs(t, result.Matches(money.Yuan(45), "USD"), ErrAmountMismatch)
+}
+
+func TestGatewayErrorsDistinguishRejections(t *testing.T) {
+	for _, status := range []int{400, 403, 422} {
+		assert.ErrorIs(t, statusError("wechat", status, "INVALID_REQUEST"), ErrRejected, status)
+	}
+	for _, status := range []int{408, 409, 429, 500, 502, 503} {
+		assert.NotErrorIs(t, statusError("wechat", status, "SYSTEM_ERROR"), ErrRejected, status)
+	}
+
+	assert.NoError(t, alipayResponse{Code: "10000"}.err())
+	assert.ErrorIs(t, alipayResponse{Code: "40004", SubCode: "ACQ.TRADE_NOT_EXIST"}.err(), ErrRejected)
+	assert.NotErrorIs(t, alipayResponse{Code: "40004", SubCode: "ACQ.SYSTEM_ERROR"}.err(), ErrRejected)
+	assert.NotErrorIs(t, alipayResponse{Code: "20000"}.err(), ErrRejected)
+}
+
+func TestSettlementCSVRoundTrip(t *testing.T) {
+	settledAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []SettlementRecord{
+		{Type: SettlementPayment, OrderNo: "R1", TransactionID: "T1", Amount: money.MustParse("19.90"), Currency: "CNY", SettledAt: settledAt},
+		{Type: SettlementRefund, OrderNo: "R1", TransactionID: "T1", RefundNo: "F1", Amount: money.Cents(10), Currency: "CNY", SettledAt: settledAt},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSettlementCSV(&buf, records))
+	parsed, err := ParseSettlementCSV(&buf)
+	require.NoError(t, err)
+	require.Len(t, parsed, 2)
+	assert.Equal(t, records[0].Amount, parsed[0].Amount)
+	assert.Equal(t, "F1", parsed[1].RefundNo)
+	assert.True(t, parsed[1].SettledAt.Equal(settledAt))
+
+	_, err = ParseSettlementCSV(bytes.NewBufferString("type,order_no,transaction_id,refund_no,amount,currency,settled_at\npayment,R1,T1,,abc,CNY,2026-01-01T00:00:00Z\n"))
+	assert.Error(t, err)
+}
+
+func TestParseWechatBill(t *testing.T) {
+	header := "交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\n"
+	bill := header +
+		"`2026-01-01 10:00:00,`wx1,`m1,`0,`,`T1,`R1,`o1,`NATIVE,`SUCCESS,`OTHERS,`CNY,`19.90,`0.00,`0,`,`0.00,`0.00,`,`,`积分,`,`0.12,`0.60%,`19.90,`0.00,`\n" +
+		"`2026-01-01 11:00:00,`wx1,`m1,`0,`,`T2,`R2,`o1,`NATIVE,`REFUND,`OTHERS,`CNY,`0.00,`0.00,`W1,`F1,`5.00,`0.00,`ORIGINAL,`SUCCESS,`积分,`,`-0.03,`0.60%,`0.00,`5.00,`\n" +
+		"`2026-01-01 12:00:00,`wx1,`m1,`0,`,`T3,`R3,`o1,`NATIVE,`REVOKED,`OTHERS,`CNY,`0.00,`0.00,`0,`,`0.00,`0.00,`,`,`积分,`,`0.00,`0.60%,`9.90,`0.00,`\n" +
+		"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\n" +
+		"`2,`19.90,`5.00,`0.00,`0.09,`19.90,`5.00\n"
+
+	records, err := parseWechatBill(bytes.NewBufferString(bill))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, SettlementPayment, records[0].Type)
+	assert.Equal(t, "R1", records[0].OrderNo)
+	assert.Equal(t, "T1", records[0].TransactionID)
+	assert.Equal(t, money.MustParse("19.90"), records[0].Amount)
+	assert.True(t, records[0].SettledAt.Equal(time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)))
+	assert.Equal(t, SettlementRefund, records[1].Type)
+	assert.Equal(t, "F1", records[1].RefundNo)
+	assert.Equal(t, money.Yuan(5), records[1].Amount)
+}
+
+func TestParseAlipayBill(t *testing.T) {
+	detail := "#支付宝业务明细查询\n#账号：[20880000000000000156]\n" +
+		"支付宝交易号,商户订单号,业务类型,商品名称,创建时间,完成时间,门店编号,门店名称,操作员,终端号,对方账户,订单金额（元）,商家实收（元）,支付宝红包（元）,集分宝（元）,支付宝优惠（元）,商家优惠（元）,券核销金额（元）,券名称,商家红包消费金额（元）,卡消费金额（元）,退款批次号/请求号,服务费（元）,分润（元）,备注\n" +
+		"2026010122001400001,R1\t,交易,积分,2026-01-01 10:00:00,2026-01-01 10:00:05,,,,,buyer,19.90,19.90,0.00,0.00,0.00,0.00,0.00,,0.00,0.00,,-0.12,0.00,\n" +
+		"2026010122001400001,R1\t,退款,积分,2026-01-01 10:00:00,2026-01-01 11:00:00,,,,,buyer,-5.00,-5.00,0.00,0.00,0.00,0.00,0.00,,0.00,0.00,F1\t,0.03,0.00,\n" +
+		"#-----------------------------------------业务明细列表结束------------------------------------\n"
+	summary := "门店编号,门店名称,交易订单总笔数,退款订单总笔数\n,,1,1\n"
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{"detail.csv": detail, "summary.csv": summary} {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+
+	records, err := parseAlipayBill(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, SettlementPayment, records[0].Type)
+	assert.Equal(t, "R1", records[0].OrderNo)
+	assert.Equal(t, money.MustParse("19.90"), records[0].Amount)
+	assert.True(t, records[0].SettledAt.Equal(time.Date(2026, 1, 1, 2, 0, 5, 0, time.UTC)))
+	assert.Equal(t, SettlementRefund, records[1].Type)
+	assert.Equal(t, "F1", records[1].RefundNo)
+	assert.Equal(t, money.Yuan(5), records[1].Amount)
+}
+
+func TestCreditCardSettlementPagesThroughBalanceTransactions(t *testing.T) {
+	pages := map[string]string{
+		"": `{"has_more":true,"data":[
+			{"id":"txn_1","type":"charge","created":1767232800,"source":{"id":"ch_1","object":"charge","amount":1990,"currency":"cny","payment_intent":{"id":"pi_1","metadata":{"order_no":"R1"}}}},
+			{"id":"txn_2","type":"payout","created":1767232900,"source":{"id":"po_1","object":"payout","amount":1000,"currency":"cny"}}]}`,
+		"txn_2": `{"has_more":false,"data":[
+			{"id":"txn_3","type":"refund","created":1767236400,"source":{"id":"re_1","object":"refund","amount":500,"currency":"cny","metadata":{"order_no":"R1","refund_no":"F1"},"payment_intent":{"id":"pi_1","metadata":{"order_no":"R1"}}}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/balance_transactions", r.URL.Path)
+		assert.Equal(t, "1767225600", r.URL.Query().Get("created[gte]"))
+		io.WriteString(w, pages[r.URL.Query().Get("starting_after")])
+	}))
+	defer server.Close()
+
+	gateway := NewCreditCardGateway(CreditCardConfig{SecretKey: "sk_test", BaseURL: server.URL})
+	date := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	gateway.now = func() time.Time { return date }
+	_, err := gateway.Settlement(context.Background(), date)
+	assert.ErrorIs(t, err, ErrSettlementNotReady)
+
+	gateway.now = func() time.Time { return date.AddDate(0, 0, 1) }
+	records, err := gateway.Settlement(context.Background(), date)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, SettlementRecord{Type: SettlementPayment, OrderNo: "R1", TransactionID: "pi_1", Amount: money.MustParse("19.90"), Currency: "CNY", SettledAt: time.Unix(1767232800, 0)}, records[0])
+	assert.Equal(t, SettlementRefund, records[1].Type)
+	assert.Equal(t, "F1", records[1].RefundNo)
+	assert.Equal(t, money.Yuan(5), records[1].Amount)
+}
+
+func TestRegistryListsGatewaysWithoutSettlement(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(NewMockGateway(models.PaymentWechat, MockConfig{}))
+	registry.Register(NewAlipayGateway(AlipayConfig{}))
+	registry.Register(NewUnionPayGateway(UnionPayConfig{}))
+	assert.Equal(t, []models.PaymentMethod{models.PaymentUnionPay}, registry.WithoutSettlement())
+}
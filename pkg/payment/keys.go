@@ -0,0 +1,106 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadPEMBlock 读取PEM文件，也支持只有base64内容、没有PEM头的密钥文件
+func loadPEMBlock(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		return block, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a PEM or base64 encoded key", file)
+	}
+	return &pem.Block{Bytes: der}, nil
+}
+
+// loadRSAPrivateKey 加载PKCS#1或PKCS#8格式的RSA私钥
+func loadRSAPrivateKey(file string) (*rsa.PrivateKey, error) {
+	block, err := loadPEMBlock(file)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", file)
+	}
+	return rsaKey, nil
+}
+
+// loadRSAPublicKey 加载RSA公钥，支持PKIX、PKCS#1公钥和X.509证书
+func loadRSAPublicKey(file string) (*rsa.PublicKey, error) {
+	block, err := loadPEMBlock(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		key = cert.PublicKey
+	} else if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		key = pub
+	} else if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		key = pub
+	} else {
+		return nil, fmt.Errorf("%s: unsupported public key format", file)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", file)
+	}
+	return rsaKey, nil
+}
+
+// signSHA256WithRSA 计算SHA256withRSA签名并以base64编码
+func signSHA256WithRSA(key *rsa.PrivateKey, data []byte) (string, error) {
+	if key == nil {
+		return "", errors.New("payment: signing key is not configured")
+	}
+	digest := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// verifySHA256WithRSA 验证base64编码的SHA256withRSA签名
+func verifySHA256WithRSA(key *rsa.PublicKey, data []byte, signature string) error {
+	if key == nil {
+		return errors.New("payment: verification key is not configured")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	digest := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+// 模拟网关请求和回调的签名头
+const (
+	MockTimestampHeader = "X-Mock-Timestamp"
+	MockSignatureHeader = "X-Mock-Signature"
+)
+
+// 模拟网关签名时间戳允许的最大偏差
+const mockSignatureTolerance = 5 * time.Minute
+
+// MockOrder 模拟网关的订单，也是查询接口和回调的报文格式
+type MockOrder struct {
+	OrderID       string      `json:"order_id"`
+	OrderNo       string      `json:"order_no"`
+	Method        string      `json:"method"`
+	Amount        int64       `json:"amount"` // 单位：分
+	Currency      string      `json:"currency"`
+	Subject       string      `json:"subject"`
+	Status        TradeStatus `json:"status"`
+	TransactionID string      `json:"transaction_id,omitempty"`
+	NotifyURL     string      `json:"notify_url,omitempty"`
+	ReturnURL     string      `json:"return_url,omitempty"`
+	ExpiresAt     *time.Time  `json:"expires_at,omitempty"`
+	PaidAt        *time.Time  `json:"paid_at,omitempty"`
+	PayURL        string      `json:"pay_url,omitempty"`
+	Refunded      int64       `json:"refunded,omitempty"` // 已退款金额，单位：分
+	Refund        *MockRefund `json:"refund,omitempty"`   // 退款结果回调时为本次退款
+}
+
+// MockRefund 模拟网关的退款请求和结果
+type MockRefund struct {
+	RefundID string      `json:"refund_id,omitempty"`
+	OrderNo  string      `json:"order_no"`
+	RefundNo string      `json:"refund_no"`
+	Amount   int64       `json:"amount"` // 单位：分
+	Status   TradeStatus `json:"status,omitempty"`
+}
+
+// SignMock 计算模拟网关报文签名
+func SignMock(secret, timestamp string, body []byte) string {
+	return hmacSHA256Hex(secret, timestamp+"."+string(body))
+}
+
+// VerifyMock 验证模拟网关报文签名
+func VerifyMock(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get(MockTimestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > mockSignatureTolerance || d < -mockSignatureTolerance {
+		return fmt.Errorf("%w: timestamp out of range", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(header.Get(MockSignatureHeader)), []byte(SignMock(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// MockConfig 模拟网关配置
+type MockConfig struct {
+	BaseURL   string
+	Secret    string
+	NotifyURL string
+	ReturnURL string
+}
+
+// MockGateway 对接本地模拟网关服务，用于开发和测试，可以模拟任意支付方式
+type MockGateway struct {
+	method models.PaymentMethod
+	config MockConfig
+	client *http.Client
+}
+
+// NewMockGateway 创建模拟网关，method为其模拟的支付方式
+func NewMockGateway(method models.PaymentMethod, config MockConfig) *MockGateway {
+	return &MockGateway{method: method, config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Method 实现PaymentGateway接口
+func (g *MockGateway) Method() models.PaymentMethod {
+	return g.method
+}
+
+// CreateOrder 在模拟网关下单，扫码类支付方式同时返回二维码内容
+func (g *MockGateway) CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		returnURL = g.config.ReturnURL
+	}
+	order := MockOrder{
+		OrderNo:   req.OrderNo,
+		Method:    string(g.method),
+		Amount:    req.Amount.Cents(),
+		Currency:  currencyOrDefault(req.Currency),
+		Subject:   req.Subject,
+		NotifyURL: g.config.NotifyURL,
+		ReturnURL: returnURL,
+	}
+	if !req.ExpiresAt.IsZero() {
+		expiresAt := req.ExpiresAt
+		order.ExpiresAt = &expiresAt
+	}
+
+	var created MockOrder
+	if err := g.do(ctx, http.MethodPost, "/api/orders", order, &created); err != nil {
+		return nil, err
+	}
+
+	result := &OrderResult{GatewayOrderID: created.OrderID, PaymentURL: created.PayURL}
+	if g.method == models.PaymentWechat || g.method == models.PaymentUnionPay {
+		result.QRCode = created.PayURL
+	}
+	return result, nil
+}
+
+// QueryOrder 查询模拟网关订单
+func (g *MockGateway) QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error) {
+	var order MockOrder
+	if err := g.do(ctx, http.MethodGet, "/api/orders/"+url.PathEscape(req.OrderNo), nil, &order); err != nil {
+		return nil, err
+	}
+	return order.result(), nil
+}
+
+// ChargeRecurring 实现RecurringGateway接口，模拟网关同步返回代扣结果
+func (g *MockGateway) ChargeRecurring(ctx context.Context, req OrderRequest) (*TradeResult, error) {
+	var charged MockOrder
+	err := g.do(ctx, http.MethodPost, "/api/charges", MockOrder{
+		OrderNo:   req.OrderNo,
+		Method:    string(g.method),
+		Amount:    req.Amount.Cents(),
+		Currency:  currencyOrDefault(req.Currency),
+		Subject:   req.Subject,
+		NotifyURL: g.config.NotifyURL,
+	}, &charged)
+	if err != nil {
+		return nil, err
+	}
+	return charged.result(), nil
+}
+
+// Refund 模拟网关退款，按网关配置同步完成或异步回调结果
+func (g *MockGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	var refund MockRefund
+	err := g.do(ctx, http.MethodPost, "/api/refunds", MockRefund{
+		OrderNo:  req.OrderNo,
+		RefundNo: req.RefundNo,
+		Amount:   req.Amount.Cents(),
+	}, &refund)
+	if err != nil {
+		return nil, err
+	}
+	return refund.result(), nil
+}
+
+// VerifyCallback 验证模拟网关回调
+func (g *MockGateway) VerifyCallback(r *http.Request) (*TradeResult, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, ErrInvalidCallback
+	}
+	if err := VerifyMock(g.config.Secret, r.Header, body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var order MockOrder
+	if err := json.Unmarshal(body, &order); err != nil || order.OrderNo == "" {
+		return nil, ErrInvalidCallback
+	}
+	if order.Method != string(g.method) {
+		return nil, fmt.Errorf("%w: method mismatch", ErrInvalidCallback)
+	}
+	result := order.result()
+	if order.Refund != nil {
+		result.Refund = order.Refund.result()
+	}
+	return result, nil
+}
+
+// Acknowledge 模拟网关收到非2xx应答时会重试回调
+func (g *MockGateway) Acknowledge(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "OK")
+}
+
+// Settlement 实现SettlementGateway接口，下载模拟网关的CSV对账单
+func (g *MockGateway) Settlement(ctx context.Context, date time.Time) ([]SettlementRecord, error) {
+	body, err := g.send(ctx, http.MethodGet, "/api/settlements/"+date.Format("2006-01-02"), nil)
+	if errors.Is(err, ErrOrderNotFound) {
+		return nil, ErrSettlementNotReady
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSettlementCSV(bytes.NewReader(body))
+}
+
+// do 发送签名请求并解析JSON应答
+func (g *MockGateway) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	respBody, err := g.send(ctx, method, path, payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// send 发送签名请求，返回应答内容
+func (g *MockGateway) send(ctx context.Context, method, path string, payload []byte) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.config.BaseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(MockTimestampHeader, timestamp)
+	httpReq.Header.Set(MockSignatureHeader, SignMock(g.config.Secret, timestamp, payload))
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("mock gateway: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("mock gateway: %v", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrOrderNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, statusError("mock gateway", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func (o *MockOrder) result() *TradeResult {
+	return &TradeResult{
+		OrderNo:       o.OrderNo,
+		TransactionID: o.TransactionID,
+		Status:        o.Status,
+		Amount:        money.Cents(o.Amount),
+		Currency:      o.Currency,
+		PaidAt:        o.PaidAt,
+	}
+}
+
+func (r *MockRefund) result() *RefundResult {
+	return &RefundResult{
+		RefundNo:        r.RefundNo,
+		GatewayRefundID: r.RefundID,
+		Status:          r.Status,
+		Amount:          money.Cents(r.Amount),
+	}
+}
@@ -0,0 +1,603 @@
+// Package mockserver 本地模拟支付网关，用于开发和测试支付流程
+//
+// 下单后按场景异步回调：success 延迟后回调支付成功，fail 延迟后回调支付失败，
+// timeout 不支付，订单到期后回调关闭。manual 只能在支付页面手动操作。
+// 代扣接口同步返回结果，fail 场景扣款失败，其余场景均扣款成功。
+// 退款默认同步完成；配置 RefundDelay 后退款先返回处理中，延迟后回调退款成功。
+// 金额角分为 0.01 的订单按 fail 处理，0.02 的按 timeout 处理，便于单独测试异常流程。
+// 成功的支付和退款记入对账单，次日起可以下载当日的CSV对账单。
+package mockserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+)
+
+// Scenario 下单后的模拟结果
+type Scenario string
+
+const (
+	ScenarioSuccess Scenario = "success"
+	ScenarioFail    Scenario = "fail"
+	ScenarioTimeout Scenario = "timeout"
+	ScenarioManual  Scenario = "manual"
+)
+
+// Config 模拟网关配置
+type Config struct {
+	Secret        string        // 与业务服务共享的签名密钥
+	BaseURL       string        // 模拟网关的访问地址，用于生成支付链接
+	Scenario      Scenario      // 默认场景
+	Delay         time.Duration // 下单到回调的延迟
+	OrderTimeout  time.Duration // 未指定过期时间的订单的支付时限
+	RetryInterval time.Duration // 回调失败后的首次重试间隔，之后每次翻倍
+	MaxAttempts   int           // 回调最大尝试次数
+	RefundDelay   time.Duration // 大于0时退款异步完成，延迟后回调结果
+}
+
+// order 模拟网关内部的订单
+type order struct {
+	payment.MockOrder
+	scenario Scenario
+	refunds  map[string]payment.MockRefund
+	timer    *time.Timer
+}
+
+// Server 模拟网关服务，实现http.Handler
+type Server struct {
+	config Config
+	client *http.Client
+	mux    *http.ServeMux
+
+	mu      sync.Mutex
+	orders  map[string]*order // 商户订单号到订单
+	byID    map[string]string // 网关订单号到商户订单号
+	closed  bool
+	pending sync.WaitGroup
+
+	settlements []payment.SettlementRecord // 成功的支付和退款，按发生顺序
+}
+
+// New 创建模拟网关
+func New(config Config) *Server {
+	if config.Scenario == "" {
+		config.Scenario = ScenarioSuccess
+	}
+	if config.Delay <= 0 {
+		config.Delay = 2 * time.Second
+	}
+	if config.OrderTimeout <= 0 {
+		config.OrderTimeout = 30 * time.Minute
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+
+	s := &Server{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		mux:    http.NewServeMux(),
+		orders: make(map[string]*order),
+		byID:   make(map[string]string),
+	}
+	s.mux.HandleFunc("POST /api/orders", s.signed(s.createOrder))
+	s.mux.HandleFunc("GET /api/orders/{orderNo}", s.signed(s.queryOrder))
+	s.mux.HandleFunc("POST /api/charges", s.signed(s.charge))
+	s.mux.HandleFunc("POST /api/refunds", s.signed(s.refund))
+	s.mux.HandleFunc("GET /api/settlements/{date}", s.signed(s.settlement))
+	s.mux.HandleFunc("GET /pay/{orderID}", s.payPage)
+	s.mux.HandleFunc("POST /pay/{orderID}", s.pay)
+	return s
+}
+
+// ServeHTTP 实现http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetBaseURL 设置支付链接前缀，用于监听地址确定后再设置的场景
+func (s *Server) SetBaseURL(baseURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.BaseURL = baseURL
+}
+
+// Close 停止未触发的回调并等待进行中的回调结束
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, o := range s.orders {
+		if o.timer != nil && o.timer.Stop() {
+			s.pending.Done()
+		}
+	}
+	s.mu.Unlock()
+	s.pending.Wait()
+}
+
+// Order 返回订单快照
+func (s *Server) Order(orderNo string) (payment.MockOrder, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderNo]
+	if !ok {
+		return payment.MockOrder{}, false
+	}
+	s.expire(o)
+	return o.MockOrder, true
+}
+
+// signed 验证请求签名，并把请求体交给处理函数
+func (s *Server) signed(next func(w http.ResponseWriter, r *http.Request, body []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := payment.VerifyMock(s.config.Secret, r.Header, body, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, r, body)
+	}
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req struct {
+		payment.MockOrder
+		Scenario Scenario `json:"scenario"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid order", http.StatusBadRequest)
+		return
+	}
+	if req.OrderNo == "" || req.Amount <= 0 || req.NotifyURL == "" {
+		http.Error(w, "order_no, amount and notify_url are required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 同一订单号重复下单返回原订单，金额不同视为冲突
+	if existing, ok := s.orders[req.OrderNo]; ok {
+		if existing.Amount != req.Amount || existing.Currency != req.Currency {
+			http.Error(w, "order_no already used with different amount", http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, existing.MockOrder)
+		return
+	}
+
+	o := &order{MockOrder: req.MockOrder, refunds: make(map[string]payment.MockRefund)}
+	o.OrderID = "MOCK" + randomHex(8)
+	o.Status = payment.TradePending
+	o.TransactionID = ""
+	o.PaidAt = nil
+	o.Refunded = 0
+	o.PayURL = strings.TrimRight(s.config.BaseURL, "/") + "/pay/" + o.OrderID
+	if o.Currency == "" {
+		o.Currency = payment.DefaultCurrency
+	}
+	if o.ExpiresAt == nil {
+		expiresAt := time.Now().Add(s.config.OrderTimeout)
+		o.ExpiresAt = &expiresAt
+	}
+	o.scenario = s.resolveScenario(req.Scenario, o.Amount)
+
+	s.orders[o.OrderNo] = o
+	s.byID[o.OrderID] = o.OrderNo
+	s.schedule(o)
+
+	writeJSON(w, http.StatusOK, o.MockOrder)
+}
+
+// charge 代扣，同步返回结果且不发送回调
+func (s *Server) charge(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req struct {
+		payment.MockOrder
+		Scenario Scenario `json:"scenario"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid charge", http.StatusBadRequest)
+		return
+	}
+	if req.OrderNo == "" || req.Amount <= 0 {
+		http.Error(w, "order_no and amount are required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.orders[req.OrderNo]; ok {
+		if existing.Amount != req.Amount || existing.Currency != req.Currency {
+			http.Error(w, "order_no already used with different amount", http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, existing.MockOrder)
+		return
+	}
+
+	o := &order{MockOrder: req.MockOrder, refunds: make(map[string]payment.MockRefund)}
+	o.OrderID = "MOCK" + randomHex(8)
+	o.Refunded = 0
+	if o.Currency == "" {
+		o.Currency = payment.DefaultCurrency
+	}
+	o.scenario = s.resolveScenario(req.Scenario, o.Amount)
+	if o.scenario == ScenarioFail {
+		o.Status = payment.TradeFailed
+	} else {
+		now := time.Now()
+		o.Status = payment.TradeSuccess
+		o.PaidAt = &now
+		o.TransactionID = "MT" + randomHex(10)
+		s.recordPayment(o)
+	}
+
+	s.orders[o.OrderNo] = o
+	s.byID[o.OrderID] = o.OrderNo
+	writeJSON(w, http.StatusOK, o.MockOrder)
+}
+
+func (s *Server) queryOrder(w http.ResponseWriter, r *http.Request, body []byte) {
+	o, ok := s.Order(r.PathValue("orderNo"))
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) refund(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req payment.MockRefund
+	if err := json.Unmarshal(body, &req); err != nil || req.RefundNo == "" || req.Amount <= 0 {
+		http.Error(w, "invalid refund", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.OrderNo]
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	// 同一退款单号重复请求返回原结果
+	if existing, ok := o.refunds[req.RefundNo]; ok {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+	if o.Status != payment.TradeSuccess {
+		http.Error(w, "order is not paid", http.StatusConflict)
+		return
+	}
+	if o.Refunded+req.Amount > o.Amount {
+		http.Error(w, "refund amount exceeds paid amount", http.StatusConflict)
+		return
+	}
+
+	o.Refunded += req.Amount
+	req.RefundID = "MR" + randomHex(8)
+	if s.config.RefundDelay > 0 && !s.closed {
+		req.Status = payment.TradePending
+		o.refunds[req.RefundNo] = req
+		s.scheduleRefund(o, req.RefundNo)
+		writeJSON(w, http.StatusOK, req)
+		return
+	}
+
+	req.Status = payment.TradeSuccess
+	o.refunds[req.RefundNo] = req
+	s.recordRefund(o, req)
+	if o.Refunded == o.Amount {
+		o.Status = payment.TradeRefunded
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+// scheduleRefund 延迟完成退款并回调退款结果，调用方需持有锁
+func (s *Server) scheduleRefund(o *order, refundNo string) {
+	s.pending.Add(1)
+	time.AfterFunc(s.config.RefundDelay, func() {
+		defer s.pending.Done()
+		s.mu.Lock()
+		refund := o.refunds[refundNo]
+		refund.Status = payment.TradeSuccess
+		o.refunds[refundNo] = refund
+		s.recordRefund(o, refund)
+		if o.Refunded == o.Amount {
+			o.Status = payment.TradeRefunded
+		}
+		snapshot := o.MockOrder
+		snapshot.Refund = &refund
+		closed := s.closed
+		s.mu.Unlock()
+
+		if !closed {
+			s.notify(snapshot)
+		}
+	})
+}
+
+var payPageTemplate = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>模拟支付</title></head>
+<body>
+<h1>模拟支付网关</h1>
+<p>订单号：{{.OrderNo}}</p>
+<p>商品：{{.Subject}}</p>
+<p>金额：{{.Amount}} {{.Currency}}</p>
+<p>状态：{{.Status}}</p>
+{{if .Pending}}
+<form method="post">
+<button name="outcome" value="success">支付成功</button>
+<button name="outcome" value="fail">支付失败</button>
+</form>
+{{else if .ReturnURL}}
+<p><a href="{{.ReturnURL}}">返回商户</a></p>
+{{end}}
+</body>
+</html>`))
+
+// payPage 模拟收银台页面
+func (s *Server) payPage(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.orderByID(r.PathValue("orderID"))
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	payPageTemplate.Execute(w, map[string]interface{}{
+		"OrderNo":   o.OrderNo,
+		"Subject":   o.Subject,
+		"Amount":    money.Cents(o.Amount).String(),
+		"Currency":  o.Currency,
+		"Status":    o.Status,
+		"Pending":   o.Status == payment.TradePending,
+		"ReturnURL": o.ReturnURL,
+	})
+}
+
+// pay 在收银台页面手动完成或拒绝支付
+func (s *Server) pay(w http.ResponseWriter, r *http.Request) {
+	var status payment.TradeStatus
+	switch Scenario(r.FormValue("outcome")) {
+	case ScenarioSuccess:
+		status = payment.TradeSuccess
+	case ScenarioFail:
+		status = payment.TradeFailed
+	default:
+		http.Error(w, "outcome must be success or fail", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	orderNo, ok := s.byID[r.PathValue("orderID")]
+	if !ok {
+		s.mu.Unlock()
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	o := s.orders[orderNo]
+	s.expire(o)
+	if o.Status != payment.TradePending {
+		s.mu.Unlock()
+		http.Error(w, "order is not pending", http.StatusConflict)
+		return
+	}
+	if o.timer != nil && o.timer.Stop() {
+		s.pending.Done()
+	}
+	o.timer = nil
+	s.settle(o, status)
+	s.mu.Unlock()
+
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func (s *Server) orderByID(orderID string) (payment.MockOrder, bool) {
+	s.mu.Lock()
+	orderNo, ok := s.byID[orderID]
+	s.mu.Unlock()
+	if !ok {
+		return payment.MockOrder{}, false
+	}
+	return s.Order(orderNo)
+}
+
+// resolveScenario 请求指定的场景优先，其次按金额角分，最后使用默认场景
+func (s *Server) resolveScenario(requested Scenario, amount int64) Scenario {
+	switch requested {
+	case ScenarioSuccess, ScenarioFail, ScenarioTimeout, ScenarioManual:
+		return requested
+	}
+	switch amount % 100 {
+	case 1:
+		return ScenarioFail
+	case 2:
+		return ScenarioTimeout
+	}
+	return s.config.Scenario
+}
+
+// schedule 按场景安排异步回调，调用方需持有锁
+func (s *Server) schedule(o *order) {
+	if s.closed {
+		return
+	}
+
+	var delay time.Duration
+	var status payment.TradeStatus
+	switch o.scenario {
+	case ScenarioSuccess:
+		delay, status = s.config.Delay, payment.TradeSuccess
+	case ScenarioFail:
+		delay, status = s.config.Delay, payment.TradeFailed
+	case ScenarioTimeout:
+		delay, status = time.Until(*o.ExpiresAt), payment.TradeClosed
+	default:
+		return
+	}
+
+	s.pending.Add(1)
+	o.timer = time.AfterFunc(delay, func() {
+		defer s.pending.Done()
+		s.mu.Lock()
+		o.timer = nil
+		if o.Status == payment.TradePending {
+			s.settle(o, status)
+		}
+		s.mu.Unlock()
+	})
+}
+
+// expire 待支付订单到期后关闭，调用方需持有锁
+// 到期关闭的回调由 timeout 场景的定时器发送，这里只修正查询结果
+func (s *Server) expire(o *order) {
+	if o.Status == payment.TradePending && o.ExpiresAt != nil && time.Now().After(*o.ExpiresAt) {
+		o.Status = payment.TradeClosed
+	}
+}
+
+// settle 更新订单状态并异步发送回调，调用方需持有锁
+func (s *Server) settle(o *order, status payment.TradeStatus) {
+	o.Status = status
+	if status == payment.TradeSuccess {
+		now := time.Now()
+		o.PaidAt = &now
+		o.TransactionID = "MT" + randomHex(10)
+		s.recordPayment(o)
+	}
+	if s.closed {
+		return
+	}
+
+	snapshot := o.MockOrder
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		s.notify(snapshot)
+	}()
+}
+
+// notify 发送签名回调，非2xx应答时按指数退避重试
+func (s *Server) notify(o payment.MockOrder) {
+	body, err := json.Marshal(o)
+	if err != nil {
+		return
+	}
+
+	interval := s.config.RetryInterval
+	for attempt := 1; attempt <= s.config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(interval)
+			interval *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, o.NotifyURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("mockpay: invalid notify url for %s: %v", o.OrderNo, err)
+			return
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(payment.MockTimestampHeader, timestamp)
+		req.Header.Set(payment.MockSignatureHeader, payment.SignMock(s.config.Secret, timestamp, body))
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			log.Printf("mockpay: notify %s attempt %d failed: %v", o.OrderNo, attempt, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return
+		}
+		log.Printf("mockpay: notify %s attempt %d got status %d", o.OrderNo, attempt, resp.StatusCode)
+	}
+}
+
+// settlement 下载指定日期的对账单，当日及以后的对账单尚未生成
+func (s *Server) settlement(w http.ResponseWriter, r *http.Request, body []byte) {
+	date, err := time.ParseInLocation("2006-01-02", r.PathValue("date"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid date", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !date.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)) {
+		http.Error(w, "settlement not ready", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	var records []payment.SettlementRecord
+	for _, record := range s.settlements {
+		if !record.SettledAt.Before(date) && record.SettledAt.Before(date.AddDate(0, 0, 1)) {
+			records = append(records, record)
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/csv")
+	payment.WriteSettlementCSV(w, records)
+}
+
+// recordPayment 支付成功记入对账单，调用方需持有锁
+func (s *Server) recordPayment(o *order) {
+	s.settlements = append(s.settlements, payment.SettlementRecord{
+		Type:          payment.SettlementPayment,
+		OrderNo:       o.OrderNo,
+		TransactionID: o.TransactionID,
+		Amount:        money.Cents(o.Amount),
+		Currency:      o.Currency,
+		SettledAt:     *o.PaidAt,
+	})
+}
+
+// recordRefund 退款成功记入对账单，调用方需持有锁
+func (s *Server) recordRefund(o *order, refund payment.MockRefund) {
+	s.settlements = append(s.settlements, payment.SettlementRecord{
+		Type:          payment.SettlementRefund,
+		OrderNo:       o.OrderNo,
+		TransactionID: o.TransactionID,
+		RefundNo:      refund.RefundNo,
+		Amount:        money.Cents(refund.Amount),
+		Currency:      o.Currency,
+		SettledAt:     time.Now(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
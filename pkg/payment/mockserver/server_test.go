@@ -0,0 +1,186 @@
+package mockserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/payment"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret"
+
+// newTestGateway 启动模拟网关和接收回调的业务端，回调结果写入返回的通道
+func newTestGateway(t *testing.T, config Config) (*payment.MockGateway, <-chan *payment.TradeResult) {
+	t.Helper()
+	config.Secret = testSecret
+	server := New(config)
+	ts := httptest.NewServer(server)
+	server.SetBaseURL(ts.URL)
+
+	var gateway *payment.MockGateway
+	results := make(chan *payment.TradeResult, 4)
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := gateway.VerifyCallback(r)
+		if err == nil {
+			results <- result
+		}
+		gateway.Acknowledge(w, err)
+	}))
+	gateway = payment.NewMockGateway(models.PaymentAlipay, payment.MockConfig{
+		BaseURL:   ts.URL,
+		Secret:    testSecret,
+		NotifyURL: merchant.URL,
+	})
+
+	t.Cleanup(func() {
+		server.Close()
+		ts.Close()
+		merchant.Close()
+	})
+	return gateway, results
+}
+
+func waitResult(t *testing.T, results <-chan *payment.TradeResult) *payment.TradeResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback not received")
+		return nil
+	}
+}
+
+func TestSuccessCallbackAndRefund(t *testing.T) {
+	ctx := context.Background()
+	gateway, results := newTestGateway(t, Config{Scenario: ScenarioSuccess, Delay: 10 * time.Millisecond})
+
+	order, err := gateway.CreateOrder(ctx, payment.OrderRequest{OrderNo: "R1", Amount: money.Yuan(45), Subject: "500 credits"})
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(order.PaymentURL, "/pay/"+order.GatewayOrderID))
+
+	result := waitResult(t, results)
+	assert.Equal(t, "R1", result.OrderNo)
+	assert.Equal(t, payment.TradeSuccess, result.Status)
+	assert.Equal(t, money.Yuan(45), result.Amount)
+	assert.Equal(t, payment.DefaultCurrency, result.Currency)
+	assert.NotEmpty(t, result.TransactionID)
+
+	queried, err := gateway.QueryOrder(ctx, payment.QueryRequest{OrderNo: "R1"})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeSuccess, queried.Status)
+
+	refund, err := gateway.Refund(ctx, payment.RefundRequest{OrderNo: "R1", RefundNo: "F1", Amount: money.Yuan(45)})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeSuccess, refund.Status)
+
+	queried, err = gateway.QueryOrder(ctx, payment.QueryRequest{OrderNo: "R1"})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeRefunded, queried.Status)
+}
+
+func TestAsyncRefundCallback(t *testing.T) {
+	ctx := context.Background()
+	gateway, results := newTestGateway(t, Config{Scenario: ScenarioSuccess, Delay: 10 * time.Millisecond, RefundDelay: 10 * time.Millisecond})
+
+	_, err := gateway.CreateOrder(ctx, payment.OrderRequest{OrderNo: "R5", Amount: money.Yuan(45)})
+	require.NoError(t, err)
+	require.Equal(t, payment.TradeSuccess, waitResult(t, results).Status)
+
+	refund, err := gateway.Refund(ctx, payment.RefundRequest{OrderNo: "R5", RefundNo: "F5", Amount: money.Yuan(20)})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradePending, refund.Status)
+
+	result := waitResult(t, results)
+	require.NotNil(t, result.Refund)
+	assert.Equal(t, "F5", result.Refund.RefundNo)
+	assert.Equal(t, payment.TradeSuccess, result.Refund.Status)
+	assert.Equal(t, money.Yuan(20), result.Refund.Amount)
+
+	// 部分退款后订单仍为支付成功
+	queried, err := gateway.QueryOrder(ctx, payment.QueryRequest{OrderNo: "R5"})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeSuccess, queried.Status)
+}
+
+func TestFailAndTimeoutScenarios(t *testing.T) {
+	ctx := context.Background()
+	gateway, results := newTestGateway(t, Config{Scenario: ScenarioSuccess, Delay: 10 * time.Millisecond})
+
+	// 角分为0.01的订单支付失败
+	_, err := gateway.CreateOrder(ctx, payment.OrderRequest{OrderNo: "R2", Amount: money.MustParse("10.01")})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeFailed, waitResult(t, results).Status)
+
+	// 角分为0.02的订单到期后关闭
+	_, err = gateway.CreateOrder(ctx, payment.OrderRequest{
+		OrderNo:   "R3",
+		Amount:    money.MustParse("10.02"),
+		ExpiresAt: time.Now().Add(50 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	result := waitResult(t, results)
+	assert.Equal(t, "R3", result.OrderNo)
+	assert.Equal(t, payment.TradeClosed, result.Status)
+}
+
+func TestRejectsUnsignedRequests(t *testing.T) {
+	server := New(Config{Secret: testSecret})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/orders", "application/json", strings.NewReader(`{"order_no":"R4","amount":100}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, err = payment.NewMockGateway(models.PaymentWechat, payment.MockConfig{BaseURL: ts.URL, Secret: "wrong"}).
+		QueryOrder(context.Background(), payment.QueryRequest{OrderNo: "R4"})
+	assert.Error(t, err)
+}
+
+func TestRecurringCharge(t *testing.T) {
+	ctx := context.Background()
+	gateway, results := newTestGateway(t, Config{Scenario: ScenarioManual, Delay: 10 * time.Millisecond})
+
+	result, err := gateway.ChargeRecurring(ctx, payment.OrderRequest{OrderNo: "S1", Amount: money.MustParse("19.9")})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeSuccess, result.Status)
+	assert.Equal(t, money.MustParse("19.9"), result.Amount)
+
+	// 角分为0.01的扣款失败
+	result, err = gateway.ChargeRecurring(ctx, payment.OrderRequest{OrderNo: "S2", Amount: money.MustParse("19.01")})
+	require.NoError(t, err)
+	assert.Equal(t, payment.TradeFailed, result.Status)
+
+	// 代扣同步返回，不发送回调
+	select {
+	case <-results:
+		t.Fatal("unexpected callback for recurring charge")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSettlementFileIsAvailableFromNextDay(t *testing.T) {
+	ctx := context.Background()
+	gateway, _ := newTestGateway(t, Config{Scenario: ScenarioManual})
+
+	_, err := gateway.ChargeRecurring(ctx, payment.OrderRequest{OrderNo: "S1", Amount: money.MustParse("19.9")})
+	require.NoError(t, err)
+
+	_, err = gateway.Settlement(ctx, time.Now())
+	assert.ErrorIs(t, err, payment.ErrSettlementNotReady)
+
+	records, err := gateway.Settlement(ctx, time.Now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
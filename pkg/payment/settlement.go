@@ -0,0 +1,114 @@
+package payment
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+// ErrSettlementNotReady 网关尚未生成指定日期的对账单
+var ErrSettlementNotReady = errors.New("payment: settlement file is not ready")
+
+// SettlementType 对账单记录类型
+type SettlementType string
+
+const (
+	SettlementPayment SettlementType = "payment" // 支付到账
+	SettlementRefund  SettlementType = "refund"  // 退款
+)
+
+// SettlementRecord 网关日对账单中的一笔交易
+type SettlementRecord struct {
+	Type          SettlementType
+	OrderNo       string
+	TransactionID string
+	RefundNo      string // 退款记录的退款单号
+	Amount        money.Amount
+	Currency      string
+	SettledAt     time.Time
+}
+
+// SettlementGateway 提供日对账单的网关，对账任务按日下载并与本地订单核对
+type SettlementGateway interface {
+	// Settlement 下载指定日期的对账单，尚未生成时返回ErrSettlementNotReady
+	Settlement(ctx context.Context, date time.Time) ([]SettlementRecord, error)
+}
+
+// settlementHeader CSV对账单的表头，金额单位为分，时间为RFC3339格式
+var settlementHeader = []string{"type", "order_no", "transaction_id", "refund_no", "amount", "currency", "settled_at"}
+
+// ParseSettlementCSV 解析CSV格式的对账单
+func ParseSettlementCSV(r io.Reader) ([]SettlementRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(settlementHeader)
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("payment: malformed settlement file: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	records := make([]SettlementRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		amount, err := strconv.ParseInt(row[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("payment: malformed settlement amount on line %d", i+2)
+		}
+		settledAt, err := time.Parse(time.RFC3339, row[6])
+		if err != nil {
+			return nil, fmt.Errorf("payment: malformed settlement time on line %d", i+2)
+		}
+		records = append(records, SettlementRecord{
+			Type:          SettlementType(row[0]),
+			OrderNo:       row[1],
+			TransactionID: row[2],
+			RefundNo:      row[3],
+			Amount:        money.Cents(amount),
+			Currency:      currencyOrDefault(row[5]),
+			SettledAt:     settledAt,
+		})
+	}
+	return records, nil
+}
+
+// WriteSettlementCSV 以CSV格式写出对账单
+func WriteSettlementCSV(w io.Writer, records []SettlementRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(settlementHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := writer.Write([]string{
+			string(r.Type),
+			r.OrderNo,
+			r.TransactionID,
+			r.RefundNo,
+			strconv.FormatInt(r.Amount.Cents(), 10),
+			currencyOrDefault(r.Currency),
+			r.SettledAt.Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WithoutSettlement 不提供对账单的支付方式，日对账任务会跳过这些方式，启动时应告警
+func (r *Registry) WithoutSettlement() []models.PaymentMethod {
+	var methods []models.PaymentMethod
+	for _, method := range r.Methods() {
+		if _, ok := r.gateways[method].(SettlementGateway); !ok {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
@@ -0,0 +1,276 @@
+package payment
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+const unionpayTimeLayout = "20060102150405"
+
+// 银联接口使用北京时间
+var unionpayLocation = time.FixedZone("CST", 8*3600)
+
+// UnionPayConfig 银联全渠道配置
+type UnionPayConfig struct {
+	MerID      string
+	CertID     string          // 签名证书序列号
+	PrivateKey *rsa.PrivateKey // 商户签名私钥
+	PublicKey  *rsa.PublicKey  // 银联验签公钥
+	BaseURL    string
+	NotifyURL  string
+}
+
+// UnionPayGateway 银联二维码支付，使用5.1.0版本接口和RSA-SHA256签名
+// 网关订单标识记录下单时间，查询原交易时需要
+type UnionPayGateway struct {
+	config UnionPayConfig
+	client *http.Client
+}
+
+// NewUnionPayGateway 创建银联网关
+func NewUnionPayGateway(config UnionPayConfig) *UnionPayGateway {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://gateway.95516.com"
+	}
+	return &UnionPayGateway{config: config, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Method 实现PaymentGateway接口
+func (g *UnionPayGateway) Method() models.PaymentMethod {
+	return models.PaymentUnionPay
+}
+
+// CreateOrder 申请消费二维码
+func (g *UnionPayGateway) CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	txnTime := time.Now().In(unionpayLocation).Format(unionpayTimeLayout)
+	values := g.baseParams("01", "07", "000000")
+	values.Set("orderId", req.OrderNo)
+	values.Set("txnTime", txnTime)
+	values.Set("txnAmt", strconv.FormatInt(req.Amount.Cents(), 10))
+	values.Set("currencyCode", "156")
+	values.Set("backUrl", g.config.NotifyURL)
+	if !req.ExpiresAt.IsZero() {
+		values.Set("payTimeout", req.ExpiresAt.In(unionpayLocation).Format(unionpayTimeLayout))
+	}
+
+	resp, err := g.post(ctx, "/gateway/api/backTransReq.do", values)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Get("respCode") != "00" {
+		return nil, fmt.Errorf("unionpay: %s %s", resp.Get("respCode"), resp.Get("respMsg"))
+	}
+	return &OrderResult{
+		GatewayOrderID: txnTime,
+		QRCode:         resp.Get("qrCode"),
+	}, nil
+}
+
+// QueryOrder 查询原交易
+func (g *UnionPayGateway) QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error) {
+	values := g.baseParams("00", "00", "000201")
+	values.Set("orderId", req.OrderNo)
+	values.Set("txnTime", req.GatewayOrderID)
+
+	resp, err := g.post(ctx, "/gateway/api/queryTrans.do", values)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Get("respCode") {
+	case "00":
+	case "34":
+		return nil, ErrOrderNotFound
+	default:
+		return nil, fmt.Errorf("unionpay: %s %s", resp.Get("respCode"), resp.Get("respMsg"))
+	}
+
+	result := unionpayResult(resp, resp.Get("origRespCode"))
+	if result.Status == TradeSuccess {
+		if t, err := time.ParseInLocation(unionpayTimeLayout, resp.Get("txnTime"), unionpayLocation); err == nil {
+			result.PaidAt = &t
+		}
+	}
+	return result, nil
+}
+
+// Refund 申请退货，银联退货结果异步通知
+func (g *UnionPayGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	values := g.baseParams("04", "00", "000201")
+	values.Set("orderId", req.RefundNo)
+	values.Set("origQryId", req.TransactionID)
+	values.Set("txnTime", time.Now().In(unionpayLocation).Format(unionpayTimeLayout))
+	values.Set("txnAmt", strconv.FormatInt(req.Amount.Cents(), 10))
+	values.Set("backUrl", g.config.NotifyURL)
+
+	resp, err := g.post(ctx, "/gateway/api/backTransReq.do", values)
+	if err != nil {
+		return nil, err
+	}
+	switch code := resp.Get("respCode"); code {
+	case "00":
+	case "03", "04", "05":
+		// 交易状态未明，以退货结果通知为准
+		return &RefundResult{RefundNo: req.RefundNo, Status: TradePending, Amount: req.Amount}, nil
+	default:
+		return nil, fmt.Errorf("%w: unionpay: %s %s", ErrRejected, code, resp.Get("respMsg"))
+	}
+	return &RefundResult{
+		RefundNo:        req.RefundNo,
+		GatewayRefundID: resp.Get("queryId"),
+		Status:          TradePending,
+		Amount:          req.Amount,
+	}, nil
+}
+
+// VerifyCallback 验证银联后台通知
+func (g *UnionPayGateway) VerifyCallback(r *http.Request) (*TradeResult, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrInvalidCallback
+	}
+	form := r.PostForm
+	if err := g.verify(form); err != nil {
+		return nil, err
+	}
+	if form.Get("merId") != g.config.MerID || form.Get("orderId") == "" {
+		return nil, fmt.Errorf("%w: merId mismatch", ErrInvalidCallback)
+	}
+
+	// 退货交易的结果通知，orderId为退款单号
+	if form.Get("txnType") == "04" {
+		original := unionpayResult(form, form.Get("respCode"))
+		return &TradeResult{
+			Currency: original.Currency,
+			Refund: &RefundResult{
+				RefundNo:        form.Get("orderId"),
+				GatewayRefundID: form.Get("queryId"),
+				Status:          original.Status,
+				Amount:          original.Amount,
+			},
+		}, nil
+	}
+
+	result := unionpayResult(form, form.Get("respCode"))
+	if result.Status == TradeSuccess {
+		now := time.Now()
+		result.PaidAt = &now
+	}
+	return result, nil
+}
+
+// Acknowledge 银联以HTTP 200作为通知成功的应答
+func (g *UnionPayGateway) Acknowledge(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "fail")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+}
+
+func (g *UnionPayGateway) baseParams(txnType, txnSubType, bizType string) url.Values {
+	values := url.Values{}
+	values.Set("version", "5.1.0")
+	values.Set("encoding", "UTF-8")
+	values.Set("signMethod", "01")
+	values.Set("txnType", txnType)
+	values.Set("txnSubType", txnSubType)
+	values.Set("bizType", bizType)
+	values.Set("channelType", "08")
+	values.Set("accessType", "0")
+	values.Set("merId", g.config.MerID)
+	values.Set("certId", g.config.CertID)
+	return values
+}
+
+// sign 5.1.0版本签名：对排序后的参数串计算SHA256十六进制摘要，再以RSA-SHA256签名
+func (g *UnionPayGateway) sign(values url.Values) error {
+	digest := sha256.Sum256([]byte(canonicalQuery(values, "signature")))
+	signature, err := signSHA256WithRSA(g.config.PrivateKey, []byte(hex.EncodeToString(digest[:])))
+	if err != nil {
+		return err
+	}
+	values.Set("signature", signature)
+	return nil
+}
+
+func (g *UnionPayGateway) verify(values url.Values) error {
+	signature := values.Get("signature")
+	if signature == "" {
+		return ErrInvalidSignature
+	}
+	digest := sha256.Sum256([]byte(canonicalQuery(values, "signature")))
+	return verifySHA256WithRSA(g.config.PublicKey, []byte(hex.EncodeToString(digest[:])), signature)
+}
+
+// post 发送签名请求，验证并解析响应
+func (g *UnionPayGateway) post(ctx context.Context, path string, values url.Values) (url.Values, error) {
+	if err := g.sign(values); err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(g.config.BaseURL, "/")+path, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=UTF-8")
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("unionpay: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unionpay: %v", err)
+	}
+
+	// 银联响应为未编码的key=value串，值中可能包含=，按&分割后取第一个=
+	result := url.Values{}
+	for _, pair := range strings.Split(string(body), "&") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			result.Set(k, v)
+		}
+	}
+	if result.Get("signature") != "" {
+		if err := g.verify(result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func unionpayResult(values url.Values, respCode string) *TradeResult {
+	amount, _ := strconv.ParseInt(values.Get("txnAmt"), 10, 64)
+	result := &TradeResult{
+		OrderNo:       values.Get("orderId"),
+		TransactionID: values.Get("queryId"),
+		Amount:        money.Cents(amount),
+		Currency:      DefaultCurrency,
+	}
+	switch respCode {
+	case "00", "A6":
+		result.Status = TradeSuccess
+	case "03", "04", "05":
+		result.Status = TradePending
+	default:
+		result.Status = TradeFailed
+	}
+	if code := values.Get("currencyCode"); code != "" && code != "156" {
+		result.Currency = code
+	}
+	return result
+}
@@ -0,0 +1,518 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+)
+
+// 回调和响应的签名时间戳允许的最大偏差
+const wechatSignatureTolerance = 5 * time.Minute
+
+// WechatConfig 微信支付APIv3配置
+type WechatConfig struct {
+	MchID        string
+	AppID        string
+	SerialNo     string          // 商户API证书序列号
+	PrivateKey   *rsa.PrivateKey // 商户API私钥
+	PlatformKey  *rsa.PublicKey  // 微信支付平台证书公钥
+	APIv3Key     string          // 用于解密回调报文
+	BaseURL      string
+	NotifyURL    string
+	RefundNotify string // 退款回调地址，为空时使用NotifyURL
+}
+
+// WechatGateway 微信支付Native支付，返回二维码链接
+type WechatGateway struct {
+	config WechatConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWechatGateway 创建微信支付网关
+func NewWechatGateway(config WechatConfig) *WechatGateway {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.mch.weixin.qq.com"
+	}
+	if config.RefundNotify == "" {
+		config.RefundNotify = config.NotifyURL
+	}
+	return &WechatGateway{config: config, client: &http.Client{Timeout: 15 * time.Second}, now: time.Now}
+}
+
+// Method 实现PaymentGateway接口
+func (g *WechatGateway) Method() models.PaymentMethod {
+	return models.PaymentWechat
+}
+
+// wechatAmount 微信支付金额，单位为分
+type wechatAmount struct {
+	Total    int64  `json:"total,omitempty"`
+	Refund   int64  `json:"refund,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// wechatTransaction 交易信息，查询接口和回调解密后的报文格式相同
+type wechatTransaction struct {
+	MchID         string       `json:"mchid"`
+	OutTradeNo    string       `json:"out_trade_no"`
+	TransactionID string       `json:"transaction_id"`
+	TradeState    string       `json:"trade_state"`
+	SuccessTime   string       `json:"success_time"`
+	Amount        wechatAmount `json:"amount"`
+}
+
+// wechatRefund 退款结果通知解密后的报文
+type wechatRefund struct {
+	MchID         string       `json:"mchid"`
+	OutTradeNo    string       `json:"out_trade_no"`
+	TransactionID string       `json:"transaction_id"`
+	OutRefundNo   string       `json:"out_refund_no"`
+	RefundID      string       `json:"refund_id"`
+	RefundStatus  string       `json:"refund_status"`
+	Amount        wechatAmount `json:"amount"`
+}
+
+// CreateOrder Native下单，返回二维码链接
+func (g *WechatGateway) CreateOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	body := map[string]interface{}{
+		"appid":        g.config.AppID,
+		"mchid":        g.config.MchID,
+		"description":  req.Subject,
+		"out_trade_no": req.OrderNo,
+		"notify_url":   g.config.NotifyURL,
+		"amount":       wechatAmount{Total: req.Amount.Cents(), Currency: currencyOrDefault(req.Currency)},
+	}
+	if !req.ExpiresAt.IsZero() {
+		body["time_expire"] = req.ExpiresAt.Format(time.RFC3339)
+	}
+
+	var resp struct {
+		CodeURL string `json:"code_url"`
+	}
+	if _, err := g.do(ctx, http.MethodPost, "/v3/pay/transactions/native", body, &resp); err != nil {
+		return nil, err
+	}
+	return &OrderResult{QRCode: resp.CodeURL}, nil
+}
+
+// QueryOrder 按商户订单号查询
+func (g *WechatGateway) QueryOrder(ctx context.Context, req QueryRequest) (*TradeResult, error) {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(req.OrderNo) + "?mchid=" + url.QueryEscape(g.config.MchID)
+
+	var tx wechatTransaction
+	status, err := g.do(ctx, http.MethodGet, path, nil, &tx)
+	if status == http.StatusNotFound {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx.result(), nil
+}
+
+// Refund 申请退款，结果可能异步返回
+func (g *WechatGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	body := map[string]interface{}{
+		"out_trade_no":  req.OrderNo,
+		"out_refund_no": req.RefundNo,
+		"reason":        req.Reason,
+		"notify_url":    g.config.RefundNotify,
+		"amount": wechatAmount{
+			Refund:   req.Amount.Cents(),
+			Total:    req.TotalAmount.Cents(),
+			Currency: currencyOrDefault(req.Currency),
+		},
+	}
+
+	var resp struct {
+		RefundID string `json:"refund_id"`
+		Status   string `json:"status"`
+	}
+	if _, err := g.do(ctx, http.MethodPost, "/v3/refund/domestic/refunds", body, &resp); err != nil {
+		return nil, err
+	}
+
+	status := TradePending
+	switch resp.Status {
+	case "SUCCESS":
+		status = TradeSuccess
+	case "CLOSED", "ABNORMAL":
+		status = TradeFailed
+	}
+	return &RefundResult{
+		RefundNo:        req.RefundNo,
+		GatewayRefundID: resp.RefundID,
+		Status:          status,
+		Amount:          req.Amount,
+	}, nil
+}
+
+// VerifyCallback 验证回调签名并解密交易信息
+func (g *WechatGateway) VerifyCallback(r *http.Request) (*TradeResult, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, ErrInvalidCallback
+	}
+	if err := g.verifySignature(r.Header, body); err != nil {
+		return nil, err
+	}
+
+	var notification struct {
+		EventType string `json:"event_type"`
+		Resource  struct {
+			Algorithm      string `json:"algorithm"`
+			Ciphertext     string `json:"ciphertext"`
+			AssociatedData string `json:"associated_data"`
+			Nonce          string `json:"nonce"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, ErrInvalidCallback
+	}
+	if notification.Resource.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidCallback, notification.Resource.Algorithm)
+	}
+
+	plaintext, err := decryptAES256GCM(g.config.APIv3Key, notification.Resource.Nonce,
+		notification.Resource.AssociatedData, notification.Resource.Ciphertext)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	// 退款结果通知与支付通知使用同一回调地址，以事件类型区分
+	if strings.HasPrefix(notification.EventType, "REFUND.") {
+		var refund wechatRefund
+		if err := json.Unmarshal(plaintext, &refund); err != nil || refund.OutRefundNo == "" {
+			return nil, ErrInvalidCallback
+		}
+		if refund.MchID != g.config.MchID {
+			return nil, fmt.Errorf("%w: mchid mismatch", ErrInvalidCallback)
+		}
+		return refund.result(), nil
+	}
+
+	var tx wechatTransaction
+	if err := json.Unmarshal(plaintext, &tx); err != nil || tx.OutTradeNo == "" {
+		return nil, ErrInvalidCallback
+	}
+	if tx.MchID != g.config.MchID {
+		return nil, fmt.Errorf("%w: mchid mismatch", ErrInvalidCallback)
+	}
+	return tx.result(), nil
+}
+
+// Acknowledge 成功时返回204，失败时返回错误码让微信支付重试
+func (g *WechatGateway) Acknowledge(w http.ResponseWriter, err error) {
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"code": "FAIL", "message": err.Error()})
+}
+
+// do 发送签名请求并验证响应签名，返回HTTP状态码
+func (g *WechatGateway) do(ctx context.Context, method, path string, body interface{}, out interface{}) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.config.BaseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	auth, err := g.authorization(method, path, payload)
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Authorization", auth)
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("wechat: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("wechat: %v", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &apiErr)
+		return resp.StatusCode, statusError("wechat", resp.StatusCode, apiErr.Code+" "+apiErr.Message)
+	}
+	if err := g.verifySignature(resp.Header, respBody); err != nil {
+		return resp.StatusCode, err
+	}
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("wechat: invalid response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// authorization 生成请求的Authorization头
+func (g *WechatGateway) authorization(method, path string, body []byte) (string, error) {
+	nonce := randomHex(16)
+	timestamp := strconv.FormatInt(g.now().Unix(), 10)
+	message := method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + string(body) + "\n"
+
+	signature, err := signSHA256WithRSA(g.config.PrivateKey, []byte(message))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		g.config.MchID, nonce, signature, timestamp, g.config.SerialNo), nil
+}
+
+// verifySignature 验证微信支付平台对响应或回调的签名
+func (g *WechatGateway) verifySignature(header http.Header, body []byte) error {
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	signature := header.Get("Wechatpay-Signature")
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := g.now().Sub(time.Unix(ts, 0)); d > wechatSignatureTolerance || d < -wechatSignatureTolerance {
+		return fmt.Errorf("%w: timestamp out of range", ErrInvalidSignature)
+	}
+
+	message := timestamp + "\n" + nonce + "\n" + string(body) + "\n"
+	return verifySHA256WithRSA(g.config.PlatformKey, []byte(message), signature)
+}
+
+func (tx *wechatTransaction) result() *TradeResult {
+	result := &TradeResult{
+		OrderNo:       tx.OutTradeNo,
+		TransactionID: tx.TransactionID,
+		Amount:        money.Cents(tx.Amount.Total),
+		Currency:      currencyOrDefault(tx.Amount.Currency),
+	}
+	switch tx.TradeState {
+	case "SUCCESS":
+		result.Status = TradeSuccess
+	case "REFUND":
+		result.Status = TradeRefunded
+	case "CLOSED", "REVOKED":
+		result.Status = TradeClosed
+	case "PAYERROR":
+		result.Status = TradeFailed
+	default:
+		result.Status = TradePending
+	}
+	if t, err := time.Parse(time.RFC3339, tx.SuccessTime); err == nil {
+		result.PaidAt = &t
+	}
+	return result
+}
+
+func (r *wechatRefund) result() *TradeResult {
+	refund := &RefundResult{
+		RefundNo:        r.OutRefundNo,
+		GatewayRefundID: r.RefundID,
+		Amount:          money.Cents(r.Amount.Refund),
+	}
+	switch r.RefundStatus {
+	case "SUCCESS":
+		refund.Status = TradeSuccess
+	case "CLOSED", "ABNORMAL":
+		refund.Status = TradeFailed
+	default:
+		refund.Status = TradePending
+	}
+	return &TradeResult{
+		OrderNo:       r.OutTradeNo,
+		TransactionID: r.TransactionID,
+		Amount:        money.Cents(r.Amount.Total),
+		Currency:      DefaultCurrency,
+		Refund:        refund,
+	}
+}
+
+// decryptAES256GCM 解密微信支付回调中的资源数据
+func decryptAES256GCM(key, nonce, associatedData, ciphertext string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, []byte(nonce), data, []byte(associatedData))
+}
+
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+// wechatBillLayout 交易账单中的时间格式，为北京时间
+const wechatBillLayout = "2006-01-02 15:04:05"
+
+var wechatBillLocation = time.FixedZone("CST", 8*3600)
+
+// Settlement 实现SettlementGateway接口，申请并下载指定日期的交易账单
+// 账单在次日上午生成，生成前返回ErrSettlementNotReady
+func (g *WechatGateway) Settlement(ctx context.Context, date time.Time) ([]SettlementRecord, error) {
+	var bill struct {
+		HashType    string `json:"hash_type"`
+		HashValue   string `json:"hash_value"`
+		DownloadURL string `json:"download_url"`
+	}
+	path := "/v3/bill/tradebill?bill_date=" + date.Format("2006-01-02") + "&bill_type=ALL"
+	if _, err := g.do(ctx, http.MethodGet, path, nil, &bill); err != nil {
+		// 当日无交易或账单生成中时也返回这两个错误码
+		if strings.Contains(err.Error(), "NO_STATEMENT_EXIST") || strings.Contains(err.Error(), "STATEMENT_CREATING") {
+			return nil, ErrSettlementNotReady
+		}
+		return nil, err
+	}
+
+	body, err := g.download(ctx, bill.DownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(bill.HashType, "SHA1") {
+		sum := sha1.Sum(body)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), bill.HashValue) {
+			return nil, fmt.Errorf("wechat: bill hash mismatch")
+		}
+	}
+	return parseWechatBill(bytes.NewReader(body))
+}
+
+// download 下载账单文件，下载请求同样需要签名，但响应不带签名
+func (g *WechatGateway) download(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("wechat: invalid download url: %v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := g.authorization(http.MethodGet, u.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", auth)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("wechat: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("wechat: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, statusError("wechat", resp.StatusCode, "bill download failed")
+	}
+	return body, nil
+}
+
+// parseWechatBill 解析交易账单，明细行的每个字段以反引号开头，之后是不带反引号的汇总部分
+// 明细列：0交易时间 5微信支付订单号 6商户订单号 9交易状态 11货币种类 12应结订单金额
+// 15商户退款单号 16退款金额 24订单金额，金额单位为元
+func parseWechatBill(r io.Reader) ([]SettlementRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("wechat: malformed bill: %v", err)
+	}
+
+	var records []SettlementRecord
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimSpace(row[0]), "`") {
+			break
+		}
+		if len(row) < 17 {
+			return nil, fmt.Errorf("wechat: malformed bill on line %d", i+1)
+		}
+		field := func(n int) string {
+			if n >= len(row) {
+				return ""
+			}
+			return strings.TrimPrefix(strings.TrimSpace(row[n]), "`")
+		}
+
+		settledAt, err := time.ParseInLocation(wechatBillLayout, field(0), wechatBillLocation)
+		if err != nil {
+			return nil, fmt.Errorf("wechat: malformed bill time on line %d", i+1)
+		}
+		record := SettlementRecord{
+			OrderNo:       field(6),
+			TransactionID: field(5),
+			Currency:      currencyOrDefault(field(11)),
+			SettledAt:     settledAt,
+		}
+		var amount string
+		switch field(9) {
+		case "SUCCESS":
+			record.Type = SettlementPayment
+			if amount = field(24); amount == "" {
+				amount = field(12)
+			}
+		case "REFUND":
+			record.Type = SettlementRefund
+			record.RefundNo = field(15)
+			amount = field(16)
+		default:
+			// 已撤销的交易未产生资金变动
+			continue
+		}
+		if record.Amount, err = money.Parse(amount); err != nil {
+			return nil, fmt.Errorf("wechat: malformed bill amount on line %d", i+1)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,60 @@
+package presence
+
+import (
+	"sync"
+	"time"
+)
+
+// OnlineWindow 最近活跃时间在该时长内视为在线
+const OnlineWindow = 5 * time.Minute
+
+// Tracker 记录用户最近活跃时间，用于展示在线状态
+type Tracker struct {
+	mu       sync.RWMutex
+	lastSeen map[uint]time.Time
+}
+
+// NewTracker 创建在线状态跟踪器
+func NewTracker() *Tracker {
+	return &Tracker{lastSeen: make(map[uint]time.Time)}
+}
+
+// Touch 记录用户活跃
+func (t *Tracker) Touch(userID uint) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSeen[userID] = now
+
+	// 顺带清理长时间未活跃的记录
+	if len(t.lastSeen)%1024 == 0 {
+		for id, seen := range t.lastSeen {
+			if now.Sub(seen) > 24*time.Hour {
+				delete(t.lastSeen, id)
+			}
+		}
+	}
+}
+
+// LastSeen 获取用户最近活跃时间
+func (t *Tracker) LastSeen(userID uint) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	seen, ok := t.lastSeen[userID]
+	return seen, ok
+}
+
+// IsOnline 判断用户是否在线
+func (t *Tracker) IsOnline(userID uint) bool {
+	seen, ok := t.LastSeen(userID)
+	return ok && time.Since(seen) <= OnlineWindow
+}
+
+// 全局在线状态跟踪器
+var defaultTracker = NewTracker()
+
+// Default 获取全局在线状态跟踪器
+func Default() *Tracker {
+	return defaultTracker
+}
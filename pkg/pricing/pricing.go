@@ -0,0 +1,74 @@
+// Package pricing 按token用量计算AI消息消耗的积分
+package pricing
+
+import (
+	"math"
+	"strings"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+)
+
+// Table 价格表，按智能体、模型、默认价格的顺序匹配
+type Table struct {
+	defaultModel string
+	fallback     configs.ModelPrice
+	models       map[string]configs.ModelPrice
+	agents       map[string]configs.ModelPrice
+}
+
+// NewTable 由配置创建价格表，defaultModel为未指定模型时使用的模型
+func NewTable(cfg configs.PricingConfig, defaultModel string) *Table {
+	t := &Table{
+		defaultModel: defaultModel,
+		fallback:     cfg.Default,
+		models:       make(map[string]configs.ModelPrice, len(cfg.Models)),
+		agents:       make(map[string]configs.ModelPrice, len(cfg.Agents)),
+	}
+	// 配置加载后键名均为小写，查找时统一转换
+	for name, price := range cfg.Models {
+		t.models[strings.ToLower(name)] = price
+	}
+	for name, price := range cfg.Agents {
+		t.agents[strings.ToLower(name)] = price
+	}
+	return t
+}
+
+// Model 解析会话请求的模型，只允许使用价格表中的模型，否则使用默认模型
+func (t *Table) Model(requested string) string {
+	if _, ok := t.models[strings.ToLower(requested)]; ok && requested != "" {
+		return requested
+	}
+	return t.defaultModel
+}
+
+// Price 查找智能体或模型的价格
+func (t *Table) Price(model, agent string) configs.ModelPrice {
+	if price, ok := t.agents[strings.ToLower(agent)]; ok && agent != "" {
+		return price
+	}
+	if price, ok := t.models[strings.ToLower(model)]; ok {
+		return price
+	}
+	return t.fallback
+}
+
+// Cost 由token用量计算消耗的积分，向上取整且不低于最低消耗
+func (t *Table) Cost(model, agent string, promptTokens, completionTokens int) int {
+	price := t.Price(model, agent)
+	raw := float64(promptTokens)/1000*price.PromptPer1K + float64(completionTokens)/1000*price.CompletionPer1K
+	cost := int(math.Ceil(raw - 1e-9))
+	minimum := price.Minimum
+	if minimum < 1 {
+		minimum = 1
+	}
+	if cost < minimum {
+		cost = minimum
+	}
+	return cost
+}
+
+// Estimate 调用前预估的消耗，输出按最大token数计算，是实际消耗的上限
+func (t *Table) Estimate(model, agent string, promptTokens, maxCompletionTokens int) int {
+	return t.Cost(model, agent, promptTokens, maxCompletionTokens)
+}
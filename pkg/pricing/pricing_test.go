@@ -0,0 +1,50 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTable() *Table {
+	return NewTable(configs.PricingConfig{
+		Default: configs.ModelPrice{PromptPer1K: 3, CompletionPer1K: 6, Minimum: 1},
+		Models: map[string]configs.ModelPrice{
+			"gpt-4o-mini": {PromptPer1K: 0.05, CompletionPer1K: 0.2, Minimum: 1},
+		},
+		Agents: map[string]configs.ModelPrice{
+			"translator": {PromptPer1K: 1, CompletionPer1K: 1, Minimum: 2},
+		},
+	}, "gpt-4")
+}
+
+func TestCostScalesWithUsage(t *testing.T) {
+	table := newTestTable()
+
+	// 短消息按最低消耗计费
+	assert.Equal(t, 1, table.Cost("gpt-4", "", 20, 10))
+	// 3000输入 + 4000输出：9 + 24 = 33
+	assert.Equal(t, 33, table.Cost("gpt-4", "", 3000, 4000))
+	// 不足一积分的部分向上取整
+	assert.Equal(t, 2, table.Cost("gpt-4", "", 200, 200))
+	// 便宜模型的长回复仍远低于默认价格
+	assert.Equal(t, 1, table.Cost("GPT-4o-mini", "", 1000, 4000))
+}
+
+func TestAgentAndModelResolution(t *testing.T) {
+	table := newTestTable()
+
+	assert.Equal(t, 2, table.Cost("gpt-4o-mini", "translator", 10, 10), "agent price overrides model price")
+	assert.Equal(t, "gpt-4o-mini", table.Model("gpt-4o-mini"))
+	assert.Equal(t, "gpt-4", table.Model("some-unpriced-model"))
+	assert.Equal(t, "gpt-4", table.Model(""))
+}
+
+func TestEstimateIsUpperBound(t *testing.T) {
+	table := newTestTable()
+	estimate := table.Estimate("gpt-4", "", 500, 1024)
+	assert.GreaterOrEqual(t, estimate, table.Cost("gpt-4", "", 500, 300))
+	assert.Equal(t, 8, estimate)
+}
@@ -0,0 +1,233 @@
+package privacy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+// exportUser 导出的账号信息，不包含密码等凭据
+type exportUser struct {
+	ID                  uint                    `json:"id"`
+	Username            string                  `json:"username"`
+	Email               string                  `json:"email"`
+	Credits             int                     `json:"credits"`
+	SubType             models.SubscriptionType `json:"subType"`
+	SubExpiresAt        *time.Time              `json:"subExpiresAt"`
+	SubAutoRenew        bool                    `json:"subAutoRenew"`
+	JoinDate            time.Time               `json:"joinDate"`
+	LastLogin           *time.Time              `json:"lastLogin"`
+	CreatedAt           time.Time               `json:"createdAt"`
+	UpdatedAt           time.Time               `json:"updatedAt"`
+	DeletionScheduledAt *time.Time              `json:"deletionScheduledAt,omitempty"`
+}
+
+// exportManifest 导出文件说明
+type exportManifest struct {
+	UserID      uint           `json:"userId"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Files       map[string]int `json:"files"` // 文件名 -> 记录数
+}
+
+// WriteExport 将用户的全部个人数据以JSON文件的形式写入ZIP
+func WriteExport(db *gorm.DB, userID uint, w io.Writer) error {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return fmt.Errorf("failed to load user: %v", err)
+	}
+
+	var profiles []models.UserProfile
+	if err := db.Preload("Interests").Preload("Tags").Where("user_id = ?", userID).Find(&profiles).Error; err != nil {
+		return fmt.Errorf("failed to load profile: %v", err)
+	}
+
+	var behaviors []models.UserBehavior
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&behaviors).Error; err != nil {
+		return fmt.Errorf("failed to load behaviors: %v", err)
+	}
+
+	var payments []models.Payment
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&payments).Error; err != nil {
+		return fmt.Errorf("failed to load payments: %v", err)
+	}
+
+	var refunds []models.Refund
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&refunds).Error; err != nil {
+		return fmt.Errorf("failed to load refunds: %v", err)
+	}
+
+	var redemptions []models.CouponRedemption
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&redemptions).Error; err != nil {
+		return fmt.Errorf("failed to load coupon redemptions: %v", err)
+	}
+
+	var invoiceList []models.Invoice
+	if err := db.Where("user_id = ?", userID).Order("issued_at, id").Find(&invoiceList).Error; err != nil {
+		return fmt.Errorf("failed to load invoices: %v", err)
+	}
+
+	var billingProfiles []models.BillingProfile
+	if err := db.Where("user_id = ?", userID).Find(&billingProfiles).Error; err != nil {
+		return fmt.Errorf("failed to load billing profile: %v", err)
+	}
+
+	var referralCodes []models.ReferralCode
+	if err := db.Where("user_id = ?", userID).Find(&referralCodes).Error; err != nil {
+		return fmt.Errorf("failed to load referral code: %v", err)
+	}
+
+	var referralList []models.Referral
+	if err := db.Where("referrer_id = ? OR invitee_id = ?", userID, userID).Order("created_at").Find(&referralList).Error; err != nil {
+		return fmt.Errorf("failed to load referrals: %v", err)
+	}
+
+	var creditHistory []models.CreditTransaction
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&creditHistory).Error; err != nil {
+		return fmt.Errorf("failed to load credit history: %v", err)
+	}
+
+	var subscriptions []models.Subscription
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&subscriptions).Error; err != nil {
+		return fmt.Errorf("failed to load subscriptions: %v", err)
+	}
+
+	var sessions []models.ChatSession
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+		return fmt.Errorf("failed to load chat sessions: %v", err)
+	}
+
+	var messages []models.ChatMessage
+	if err := db.Where("session_id IN (?)", db.Model(&models.ChatSession{}).Select("id").Where("user_id = ?", userID)).
+		Order("session_id, created_at").Find(&messages).Error; err != nil {
+		return fmt.Errorf("failed to load chat messages: %v", err)
+	}
+
+	// 陌生人会话中对方的消息只保留化名，导出文件不能泄露对方身份
+	strangerMeta := make(map[uint]models.StrangerMeta)
+	for i := range sessions {
+		if sessions[i].Type == models.SessionStranger {
+			sessions[i].RedactMeta()
+			strangerMeta[sessions[i].ID], _ = sessions[i].ParseStrangerMeta()
+		}
+	}
+	for i := range messages {
+		meta, ok := strangerMeta[messages[i].SessionID]
+		if ok && messages[i].UserID != 0 && messages[i].UserID != userID {
+			messages[i].UserID = 0
+			messages[i].SenderID = meta.PeerAlias.Handle
+		}
+	}
+
+	var lockouts []models.LockoutAudit
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&lockouts).Error; err != nil {
+		return fmt.Errorf("failed to load security events: %v", err)
+	}
+
+	var tokens []models.PersonalAccessToken
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&tokens).Error; err != nil {
+		return fmt.Errorf("failed to load access tokens: %v", err)
+	}
+
+	var friends []models.Friendship
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&friends).Error; err != nil {
+		return fmt.Errorf("failed to load friends: %v", err)
+	}
+
+	var blocks []models.UserBlock
+	if err := db.Where("blocker_id = ?", userID).Order("created_at").Find(&blocks).Error; err != nil {
+		return fmt.Errorf("failed to load blocks: %v", err)
+	}
+
+	var reports []models.Report
+	if err := db.Where("reporter_id = ?", userID).Order("created_at").Find(&reports).Error; err != nil {
+		return fmt.Errorf("failed to load reports: %v", err)
+	}
+
+	var sanctions []models.UserSanction
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&sanctions).Error; err != nil {
+		return fmt.Errorf("failed to load sanctions: %v", err)
+	}
+
+	files := []struct {
+		name  string
+		count int
+		data  interface{}
+	}{
+		{"account.json", 1, exportUser{
+			ID:                  user.ID,
+			Username:            user.Username,
+			Email:               user.Email,
+			Credits:             user.Credits,
+			SubType:             user.SubType,
+			SubExpiresAt:        user.SubExpiresAt,
+			SubAutoRenew:        user.SubAutoRenew,
+			JoinDate:            user.JoinDate,
+			LastLogin:           user.LastLogin,
+			CreatedAt:           user.CreatedAt,
+			UpdatedAt:           user.UpdatedAt,
+			DeletionScheduledAt: user.DeletionScheduledAt,
+		}},
+		{"profile.json", len(profiles), profiles},
+		{"behaviors.json", len(behaviors), behaviors},
+		{"payments.json", len(payments), payments},
+		{"refunds.json", len(refunds), refunds},
+		{"coupon_redemptions.json", len(redemptions), redemptions},
+		{"invoices.json", len(invoiceList), invoiceList},
+		{"billing_profile.json", len(billingProfiles), billingProfiles},
+		{"referral_code.json", len(referralCodes), referralCodes},
+		{"referrals.json", len(referralList), referralList},
+		{"credit_history.json", len(creditHistory), creditHistory},
+		{"subscriptions.json", len(subscriptions), subscriptions},
+		{"chat_sessions.json", len(sessions), sessions},
+		{"chat_messages.json", len(messages), messages},
+		{"security_events.json", len(lockouts), lockouts},
+		{"access_tokens.json", len(tokens), tokens},
+		{"friends.json", len(friends), friends},
+		{"blocks.json", len(blocks), blocks},
+		{"reports.json", len(reports), reports},
+		{"sanctions.json", len(sanctions), sanctions},
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{
+		UserID:      userID,
+		GeneratedAt: time.Now(),
+		Files:       make(map[string]int, len(files)),
+	}
+
+	for _, f := range files {
+		if err := writeJSONFile(zw, f.name, f.data); err != nil {
+			return err
+		}
+		manifest.Files[f.name] = f.count
+	}
+	if err := writeJSONFile(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeJSONFile(zw *zip.Writer, name string, data interface{}) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", name, err)
+	}
+
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
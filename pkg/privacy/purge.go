@@ -0,0 +1,164 @@
+package privacy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/avatar"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PurgeAccount 宽限期结束后清除账号的个人数据
+// 聊天、画像、开票信息、邀请码、用量汇总等个人数据被硬删除，邀请记录去除注册IP和设备，尚未入账的邀请奖励作废；支付、退款、优惠券核销、订阅和积分流水因财务需要保留，举报和处罚记录因安全审计需要保留，
+// 但关联的账号被匿名化，只剩下无法识别个人身份的用户ID；已开具的票据按财务要求原样保留购买方信息
+func (s *Service) PurgeAccount(userID uint) error {
+	var fileKeys []string
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		// 可能在等待锁期间被撤销
+		if user.DeletionScheduledAt == nil || user.DeletionScheduledAt.After(time.Now()) {
+			return nil
+		}
+
+		sessionIDs := tx.Model(&models.ChatSession{}).Unscoped().Select("id").Where("user_id = ?", userID)
+		if err := tx.Unscoped().Where("session_id IN (?)", sessionIDs).Delete(&models.ChatMessage{}).Error; err != nil {
+			return fmt.Errorf("failed to delete chat messages: %v", err)
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.ChatSession{}).Error; err != nil {
+			return fmt.Errorf("failed to delete chat sessions: %v", err)
+		}
+
+		// 对方会话中由该用户发送的消息保留内容，但去除发送者身份
+		if err := tx.Model(&models.ChatMessage{}).Unscoped().Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"user_id": 0, "sender_id": "deleted"}).Error; err != nil {
+			return fmt.Errorf("failed to anonymize sent messages: %v", err)
+		}
+
+		var profile models.UserProfile
+		if err := tx.Where("user_id = ?", userID).Limit(1).Find(&profile).Error; err != nil {
+			return err
+		}
+		if profile.ID != 0 {
+			if profile.AvatarKey != "" {
+				for _, size := range avatar.StandardSizes {
+					fileKeys = append(fileKeys, profile.AvatarKey+"_"+strconv.Itoa(size)+".jpg")
+				}
+			}
+			if err := tx.Model(&profile).Association("Interests").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Model(&profile).Association("Tags").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Delete(&profile).Error; err != nil {
+				return fmt.Errorf("failed to delete profile: %v", err)
+			}
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserBehavior{}).Error; err != nil {
+			return fmt.Errorf("failed to delete behaviors: %v", err)
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.LockoutAudit{}).Error; err != nil {
+			return fmt.Errorf("failed to delete security events: %v", err)
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete access tokens: %v", err)
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.BillingProfile{}).Error; err != nil {
+			return fmt.Errorf("failed to delete billing profile: %v", err)
+		}
+		if err := referrals.Forget(tx, userID); err != nil {
+			return fmt.Errorf("failed to forget referrals: %v", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UsageRollup{}).Error; err != nil {
+			return fmt.Errorf("failed to delete usage rollups: %v", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.CreditRollup{}).Error; err != nil {
+			return fmt.Errorf("failed to delete credit rollups: %v", err)
+		}
+
+		if err := tx.Unscoped().Where("user_id = ? OR friend_id = ?", userID, userID).Delete(&models.Friendship{}).Error; err != nil {
+			return fmt.Errorf("failed to delete friendships: %v", err)
+		}
+		if err := tx.Unscoped().Where("from_user_id = ? OR to_user_id = ?", userID, userID).Delete(&models.FriendRequest{}).Error; err != nil {
+			return fmt.Errorf("failed to delete friend requests: %v", err)
+		}
+		if err := tx.Unscoped().Where("blocker_id = ? OR blocked_id = ?", userID, userID).Delete(&models.UserBlock{}).Error; err != nil {
+			return fmt.Errorf("failed to delete blocks: %v", err)
+		}
+		// 举报和处罚记录与支付记录一样保留，用于社区安全审计
+
+		var exports []models.DataExport
+		tx.Unscoped().Where("user_id = ?", userID).Find(&exports)
+		for _, export := range exports {
+			if export.FileKey != "" {
+				fileKeys = append(fileKeys, export.FileKey)
+			}
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.DataExport{}).Error; err != nil {
+			return fmt.Errorf("failed to delete exports: %v", err)
+		}
+
+		// 订阅记录与支付记录一样保留，未结束的订阅随账号一起结束
+		if err := tx.Model(&models.Subscription{}).
+			Where("user_id = ? AND status <> ?", userID, models.SubscriptionExpired).
+			Updates(map[string]interface{}{
+				"status":        models.SubscriptionExpired,
+				"auto_renew":    false,
+				"next_retry_at": nil,
+				"ended_at":      time.Now(),
+			}).Error; err != nil {
+			return fmt.Errorf("failed to end subscriptions: %v", err)
+		}
+
+		// 积分清零记入流水，流水与支付记录一样保留
+		if _, err := credits.Apply(tx, credits.Entry{
+			UserID: userID,
+			Amount: -user.Credits,
+			Reason: models.CreditAccountDeleted,
+		}); err != nil {
+			return fmt.Errorf("failed to clear credits: %v", err)
+		}
+
+		// 匿名化账号，保留行以维持支付记录的外键
+		now := time.Now()
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"username":              fmt.Sprintf("deleted_%d", user.ID),
+			"email":                 fmt.Sprintf("deleted_%d@deleted.invalid", user.ID),
+			"password":              "",
+			"sub_type":              models.SubscriptionFree,
+			"sub_expires_at":        nil,
+			"sub_auto_renew":        false,
+			"last_login":            nil,
+			"deletion_scheduled_at": nil,
+			"anonymized_at":         &now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to anonymize user: %v", err)
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range fileKeys {
+		if err := s.store.Delete(context.Background(), key); err != nil {
+			log.Printf("Failed to delete file %s of purged account %d: %v", key, userID, err)
+		}
+	}
+	return nil
+}
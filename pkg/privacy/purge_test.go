@@ -0,0 +1,173 @@
+package privacy
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/avatar"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/referrals"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func putFile(t *testing.T, store storage.BlobStore, key string) {
+	t.Helper()
+	_, err := store.Put(context.Background(), key, strings.NewReader("data"), "application/octet-stream")
+	require.NoError(t, err)
+}
+
+// remaining 统计包括软删除在内的行数
+func remaining(t *testing.T, db *gorm.DB, model interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+	var n int64
+	require.NoError(t, db.Unscoped().Model(model).Where(query, args...).Count(&n).Error)
+	return n
+}
+
+func TestPurgeAccountDeletesOrAnonymizesPersonalData(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := storage.NewLocalStore(t.TempDir(), "")
+	require.NoError(t, err)
+	svc := NewService(db, store, Config{})
+
+	past := time.Now().Add(-time.Hour)
+	alice := models.User{Username: "alice", Email: "alice@example.com", Password: "hash", Credits: 300, DeletionScheduledAt: &past}
+	bob := models.User{Username: "bob", Email: "bob@example.com"}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&bob).Error)
+	id := alice.ID
+
+	// 聊天：自己的AI会话，以及与好友的会话中发给对方的消息
+	ai := models.ChatSession{UserID: id, Type: models.SessionAI}
+	own := models.ChatSession{UserID: id, Type: models.SessionDirect}
+	peer := models.ChatSession{UserID: bob.ID, Type: models.SessionDirect}
+	require.NoError(t, db.Create(&[]*models.ChatSession{&ai, &own, &peer}).Error)
+	require.NoError(t, db.Create(&models.ChatMessage{SessionID: ai.ID, UserID: id, SenderID: "user", Content: "hi"}).Error)
+	require.NoError(t, db.Create(&models.ChatMessage{SessionID: own.ID, UserID: id, SenderID: strconv.Itoa(int(id)), Content: "hello"}).Error)
+	sent := models.ChatMessage{SessionID: peer.ID, UserID: id, SenderID: strconv.Itoa(int(id)), Content: "hello"}
+	require.NoError(t, db.Create(&sent).Error)
+
+	profile := models.UserProfile{UserID: id, DisplayName: "Alice", AvatarKey: "avatars/alice", Tags: []models.Tag{{Name: "go"}}}
+	require.NoError(t, db.Create(&profile).Error)
+	for _, size := range avatar.StandardSizes {
+		putFile(t, store, profile.AvatarKey+"_"+strconv.Itoa(size)+".jpg")
+	}
+	require.NoError(t, db.Create(&models.UserBehavior{UserID: id, Type: "login"}).Error)
+	require.NoError(t, db.Create(&models.LockoutAudit{UserID: &id, Scope: "account", Account: "alice"}).Error)
+	require.NoError(t, db.Create(&models.PersonalAccessToken{UserID: id, Name: "cli", Prefix: "pat_", TokenHash: "hash"}).Error)
+	require.NoError(t, db.Create(&models.BillingProfile{UserID: id, Name: "Alice", TaxID: "TAX"}).Error)
+
+	// 邀请：自己的邀请码，以及被bob邀请的记录
+	_, err = referrals.CodeFor(db, id)
+	require.NoError(t, err)
+	bobCode, err := referrals.CodeFor(db, bob.ID)
+	require.NoError(t, err)
+	_, err = referrals.Register(db, &alice, bobCode.Code, "1.1.1.1", "device-a")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&[]models.UsageRollup{{UserID: id, Day: "2026-01-01"}, {UserID: bob.ID, Day: "2026-01-01"}}).Error)
+	require.NoError(t, db.Create(&[]models.CreditRollup{{UserID: id, Day: "2026-01-01", Reason: models.CreditRecharge}, {UserID: bob.ID, Day: "2026-01-01", Reason: models.CreditRecharge}}).Error)
+
+	require.NoError(t, db.Create(&[]models.Friendship{{UserID: id, FriendID: bob.ID, SessionID: own.ID}, {UserID: bob.ID, FriendID: id, SessionID: peer.ID}}).Error)
+	require.NoError(t, db.Create(&models.FriendRequest{FromUserID: bob.ID, ToUserID: id, SessionID: peer.ID, ToSessionID: own.ID}).Error)
+	require.NoError(t, db.Create(&models.UserBlock{BlockerID: bob.ID, BlockedID: id}).Error)
+
+	exportKey := "exports/alice.zip"
+	putFile(t, store, exportKey)
+	require.NoError(t, db.Create(&models.DataExport{UserID: id, Status: models.ExportReady, FileKey: exportKey}).Error)
+
+	// 财务和安全审计记录保留
+	periodEnd := time.Now().Add(24 * time.Hour)
+	require.NoError(t, db.Create(&models.Subscription{UserID: id, Plan: models.SubscriptionBasic, Status: models.SubscriptionActive, AutoRenew: true, PeriodEnd: &periodEnd}).Error)
+	require.NoError(t, db.Create(&models.Payment{UserID: id, OrderNo: "R1", Amount: money.Yuan(10), Status: models.PaymentCompleted}).Error)
+	require.NoError(t, db.Create(&models.Report{ReporterID: bob.ID, ReportedUserID: id, SessionID: peer.ID, Category: models.ReportSpam}).Error)
+	require.NoError(t, db.Create(&models.UserSanction{UserID: id, ModeratorID: bob.ID, Action: models.SanctionBan}).Error)
+
+	// 未申请注销的账号不受影响
+	require.NoError(t, svc.PurgeAccount(bob.ID))
+	assert.Equal(t, int64(1), remaining(t, db, &models.User{}, "id = ? AND deleted_at IS NULL", bob.ID))
+
+	require.NoError(t, svc.PurgeAccount(id))
+
+	for _, tc := range []struct {
+		model interface{}
+		query string
+	}{
+		{&models.ChatSession{}, "user_id = ?"},
+		{&models.ChatMessage{}, "user_id = ?"},
+		{&models.UserProfile{}, "user_id = ?"},
+		{&models.UserBehavior{}, "user_id = ?"},
+		{&models.LockoutAudit{}, "user_id = ?"},
+		{&models.PersonalAccessToken{}, "user_id = ?"},
+		{&models.BillingProfile{}, "user_id = ?"},
+		{&models.ReferralCode{}, "user_id = ?"},
+		{&models.UsageRollup{}, "user_id = ?"},
+		{&models.CreditRollup{}, "user_id = ?"},
+		{&models.Friendship{}, "user_id = ? OR friend_id = ?"},
+		{&models.FriendRequest{}, "from_user_id = ? OR to_user_id = ?"},
+		{&models.UserBlock{}, "blocker_id = ? OR blocked_id = ?"},
+		{&models.DataExport{}, "user_id = ?"},
+	} {
+		args := []interface{}{id}
+		if strings.Contains(tc.query, " OR ") {
+			args = append(args, id)
+		}
+		assert.Zero(t, remaining(t, db, tc.model, tc.query, args...), "%T", tc.model)
+	}
+	var tagLinks int64
+	require.NoError(t, db.Table("user_tags").Where("user_profile_id = ?", profile.ID).Count(&tagLinks).Error)
+	assert.Zero(t, tagLinks)
+
+	// 对方会话中的消息保留内容，去除发送者
+	var kept models.ChatMessage
+	require.NoError(t, db.First(&kept, sent.ID).Error)
+	assert.Equal(t, "hello", kept.Content)
+	assert.Zero(t, kept.UserID)
+	assert.Equal(t, "deleted", kept.SenderID)
+
+	var user models.User
+	require.NoError(t, db.Unscoped().First(&user, id).Error)
+	assert.True(t, user.DeletedAt.Valid)
+	assert.NotNil(t, user.AnonymizedAt)
+	assert.Equal(t, "deleted_"+strconv.Itoa(int(id)), user.Username)
+	assert.Equal(t, "deleted_"+strconv.Itoa(int(id))+"@deleted.invalid", user.Email)
+	assert.Empty(t, user.Password)
+	assert.Zero(t, user.Credits)
+	assert.Nil(t, user.DeletionScheduledAt)
+
+	var cleared models.CreditTransaction
+	require.NoError(t, db.Where("user_id = ? AND reason = ?", id, models.CreditAccountDeleted).First(&cleared).Error)
+	assert.Equal(t, -300, cleared.Amount)
+
+	var referral models.Referral
+	require.NoError(t, db.Where("invitee_id = ?", id).First(&referral).Error)
+	assert.Equal(t, models.ReferralCancelled, referral.Status)
+	assert.Empty(t, referral.SignupIP)
+	assert.Empty(t, referral.DeviceID)
+
+	var sub models.Subscription
+	require.NoError(t, db.Where("user_id = ?", id).First(&sub).Error)
+	assert.Equal(t, models.SubscriptionExpired, sub.Status)
+	assert.False(t, sub.AutoRenew)
+	assert.NotNil(t, sub.EndedAt)
+
+	assert.Equal(t, int64(1), remaining(t, db, &models.Payment{}, "user_id = ?", id))
+	assert.Equal(t, int64(1), remaining(t, db, &models.Report{}, "reported_user_id = ?", id))
+	assert.Equal(t, int64(1), remaining(t, db, &models.UserSanction{}, "user_id = ?", id))
+	assert.Equal(t, int64(1), remaining(t, db, &models.UsageRollup{}, "user_id = ?", bob.ID))
+	assert.Equal(t, int64(1), remaining(t, db, &models.ReferralCode{}, "user_id = ?", bob.ID))
+
+	for _, key := range []string{exportKey, profile.AvatarKey + "_64.jpg"} {
+		_, err := store.Get(context.Background(), key)
+		assert.ErrorIs(t, err, storage.ErrNotFound, key)
+	}
+}
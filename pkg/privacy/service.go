@@ -0,0 +1,299 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExportInProgress   = errors.New("privacy: an export is already in progress")
+	ErrExportNotReady     = errors.New("privacy: export is not ready")
+	ErrDeletionNotPending = errors.New("privacy: no pending account deletion")
+)
+
+// Config 隐私服务配置
+type Config struct {
+	GracePeriod time.Duration // 注销宽限期
+	ExportTTL   time.Duration // 导出文件保留时长
+	Interval    time.Duration // 后台任务执行间隔
+}
+
+// Service 个人数据导出与账号注销服务
+type Service struct {
+	db       *gorm.DB
+	store    storage.BlobStore
+	config   Config
+	stopChan chan struct{}
+}
+
+// NewService 创建隐私服务，store 存放导出文件，必须是不对外公开访问的存储
+func NewService(db *gorm.DB, store storage.BlobStore, config Config) *Service {
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = 30 * 24 * time.Hour
+	}
+	if config.ExportTTL <= 0 {
+		config.ExportTTL = 7 * 24 * time.Hour
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	return &Service{
+		db:       db,
+		store:    store,
+		config:   config,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// GracePeriod 获取注销宽限期
+func (s *Service) GracePeriod() time.Duration {
+	return s.config.GracePeriod
+}
+
+// RequestExport 创建数据导出任务并在后台生成文件
+func (s *Service) RequestExport(userID uint) (*models.DataExport, error) {
+	var inProgress int64
+	if err := s.db.Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", userID, []models.ExportStatus{models.ExportPending, models.ExportProcessing}).
+		Count(&inProgress).Error; err != nil {
+		return nil, err
+	}
+	if inProgress > 0 {
+		return nil, ErrExportInProgress
+	}
+
+	export := models.DataExport{
+		UserID: userID,
+		Status: models.ExportPending,
+	}
+	if err := s.db.Create(&export).Error; err != nil {
+		return nil, err
+	}
+
+	go s.runExport(export.ID)
+	return &export, nil
+}
+
+// OpenExport 打开已生成的导出文件
+func (s *Service) OpenExport(ctx context.Context, userID, exportID uint) (*models.DataExport, io.ReadCloser, error) {
+	var export models.DataExport
+	if err := s.db.Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error; err != nil {
+		return nil, nil, err
+	}
+	if export.Status != models.ExportReady || (export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt)) {
+		return &export, nil, ErrExportNotReady
+	}
+
+	rc, err := s.store.Get(ctx, export.FileKey)
+	if err != nil {
+		return &export, nil, err
+	}
+	return &export, rc, nil
+}
+
+// runExport 生成导出文件并写入对象存储
+func (s *Service) runExport(exportID uint) {
+	// 仅处理仍为pending的任务，避免重复执行
+	result := s.db.Model(&models.DataExport{}).
+		Where("id = ? AND status = ?", exportID, models.ExportPending).
+		Update("status", models.ExportProcessing)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	var export models.DataExport
+	if err := s.db.First(&export, exportID).Error; err != nil {
+		return
+	}
+
+	key := fmt.Sprintf("exports/%d/export-%d-%s.zip", export.UserID, export.ID, time.Now().Format("20060102150405"))
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteExport(s.db, export.UserID, pw))
+	}()
+
+	counter := &countingReader{r: pr}
+	_, err := s.store.Put(context.Background(), key, counter, "application/zip")
+	pr.CloseWithError(err)
+
+	now := time.Now()
+	updates := map[string]interface{}{}
+	if err != nil {
+		log.Printf("Failed to export data for user %d: %v", export.UserID, err)
+		updates["status"] = models.ExportFailed
+		updates["error"] = "export failed"
+	} else {
+		expiresAt := now.Add(s.config.ExportTTL)
+		updates["status"] = models.ExportReady
+		updates["file_key"] = key
+		updates["size"] = counter.n
+		updates["completed_at"] = &now
+		updates["expires_at"] = &expiresAt
+	}
+	if err := s.db.Model(&export).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update data export %d: %v", export.ID, err)
+	}
+}
+
+// ScheduleDeletion 申请注销账号，宽限期结束后清除个人数据
+// 只更新注销相关的列，不覆盖并发修改的其他字段
+func (s *Service) ScheduleDeletion(userID uint) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.DeletionScheduledAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		scheduledAt := now.Add(s.config.GracePeriod)
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"deletion_requested_at": &now,
+			"deletion_scheduled_at": &scheduledAt,
+			"sub_auto_renew":        false,
+		}).Error; err != nil {
+			return err
+		}
+		user.DeletionRequestedAt = &now
+		user.DeletionScheduledAt = &scheduledAt
+		user.SubAutoRenew = false
+
+		// 关闭自动续费，订阅在当前周期结束后不再扣款
+		if err := tx.Model(&models.Subscription{}).
+			Where("user_id = ? AND auto_renew = ?", userID, true).
+			Updates(map[string]interface{}{"auto_renew": false, "canceled_at": now}).Error; err != nil {
+			return err
+		}
+		// 申请注销后撤销所有个人访问令牌，只保留登录会话用于撤销注销
+		return tx.Model(&models.PersonalAccessToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CancelDeletion 在宽限期内撤销注销申请
+func (s *Service) CancelDeletion(userID uint) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.DeletionScheduledAt == nil {
+			return ErrDeletionNotPending
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"deletion_requested_at": nil,
+			"deletion_scheduled_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		user.DeletionRequestedAt = nil
+		user.DeletionScheduledAt = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Start 启动后台任务
+func (s *Service) Start() {
+	go s.loop()
+}
+
+// Stop 停止后台任务
+func (s *Service) Stop() {
+	close(s.stopChan)
+}
+
+func (s *Service) loop() {
+	s.RunOnce()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// RunOnce 执行一次后台任务：清除到期账号、清理过期导出、恢复中断的导出
+func (s *Service) RunOnce() {
+	now := time.Now()
+
+	var due []models.User
+	if err := s.db.Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", now).Find(&due).Error; err != nil {
+		log.Printf("Failed to query accounts due for deletion: %v", err)
+	}
+	for _, user := range due {
+		if err := s.PurgeAccount(user.ID); err != nil {
+			log.Printf("Failed to purge account %d: %v", user.ID, err)
+		}
+	}
+
+	var expired []models.DataExport
+	if err := s.db.Where("status = ? AND expires_at <= ?", models.ExportReady, now).Find(&expired).Error; err != nil {
+		log.Printf("Failed to query expired exports: %v", err)
+	}
+	for _, export := range expired {
+		s.expireExport(&export)
+	}
+
+	// 进程重启会中断正在生成的导出，重新排队
+	s.db.Model(&models.DataExport{}).
+		Where("status = ? AND updated_at <= ?", models.ExportProcessing, now.Add(-30*time.Minute)).
+		Update("status", models.ExportPending)
+
+	var pending []models.DataExport
+	s.db.Where("status = ? AND created_at <= ?", models.ExportPending, now.Add(-time.Minute)).Find(&pending)
+	for _, export := range pending {
+		s.runExport(export.ID)
+	}
+}
+
+func (s *Service) expireExport(export *models.DataExport) {
+	if export.FileKey != "" {
+		if err := s.store.Delete(context.Background(), export.FileKey); err != nil {
+			log.Printf("Failed to delete export file %s: %v", export.FileKey, err)
+			return
+		}
+	}
+	s.db.Model(export).Updates(map[string]interface{}{
+		"status":   models.ExportExpired,
+		"file_key": "",
+	})
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
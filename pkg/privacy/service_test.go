@@ -0,0 +1,50 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDeletionRequestsOnlyTouchDeletionColumns(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := storage.NewLocalStore(t.TempDir(), "")
+	require.NoError(t, err)
+	svc := NewService(db, store, Config{GracePeriod: time.Hour})
+
+	user := models.User{Username: "alice", Email: "alice@example.com", Credits: 300, SubAutoRenew: true}
+	require.NoError(t, db.Create(&user).Error)
+
+	// 每次更新用户前模拟一次并发充值，注销操作不能用读到的旧值覆盖积分
+	require.NoError(t, db.Callback().Update().Before("gorm:update").Register("test:concurrent_recharge", func(tx *gorm.DB) {
+		if tx.Statement.Table == "users" {
+			tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Exec("UPDATE users SET credits = credits + 100 WHERE id = ?", user.ID)
+		}
+	}))
+	defer db.Callback().Update().Remove("test:concurrent_recharge")
+
+	scheduled, err := svc.ScheduleDeletion(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, scheduled.DeletionScheduledAt)
+	assert.False(t, scheduled.SubAutoRenew)
+
+	canceled, err := svc.CancelDeletion(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, canceled.DeletionScheduledAt)
+	_, err = svc.CancelDeletion(user.ID)
+	assert.ErrorIs(t, err, ErrDeletionNotPending)
+
+	var stored models.User
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	assert.Equal(t, 500, stored.Credits)
+	assert.Nil(t, stored.DeletionRequestedAt)
+	assert.Nil(t, stored.DeletionScheduledAt)
+	assert.False(t, stored.SubAutoRenew)
+}
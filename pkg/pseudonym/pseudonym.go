@@ -0,0 +1,67 @@
+package pseudonym
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+var adjectives = []string{
+	"好奇的", "安静的", "勇敢的", "慵懒的", "快乐的", "害羞的", "机灵的", "温柔的",
+	"活泼的", "神秘的", "淡定的", "迷糊的", "热情的", "认真的", "调皮的", "优雅的",
+}
+
+// 动物名称与前端头像资源的对应关系
+var animals = []struct {
+	key  string
+	name string
+}{
+	{"otter", "水獭"}, {"fox", "狐狸"}, {"panda", "熊猫"}, {"owl", "猫头鹰"},
+	{"penguin", "企鹅"}, {"rabbit", "兔子"}, {"koala", "考拉"}, {"dolphin", "海豚"},
+	{"cat", "猫咪"}, {"deer", "小鹿"}, {"hedgehog", "刺猬"}, {"squirrel", "松鼠"},
+	{"whale", "鲸鱼"}, {"turtle", "乌龟"}, {"alpaca", "羊驼"}, {"seal", "海豹"},
+}
+
+var colors = []string{
+	"#E57373", "#F06292", "#BA68C8", "#7986CB", "#4FC3F7", "#4DB6AC",
+	"#81C784", "#DCE775", "#FFD54F", "#FFB74D", "#A1887F", "#90A4AE",
+}
+
+// Generate 随机生成一个化名
+func Generate() models.Pseudonym {
+	animal := animals[randomIndex(len(animals))]
+	return models.Pseudonym{
+		Handle: "anon-" + randomHex(4),
+		Name:   adjectives[randomIndex(len(adjectives))] + animal.name,
+		Avatar: animal.key,
+		Color:  colors[randomIndex(len(colors))],
+	}
+}
+
+// Pair 为一次匹配的双方生成互不相同的化名
+func Pair() (models.Pseudonym, models.Pseudonym) {
+	a := Generate()
+	b := Generate()
+	for b.Name == a.Name || b.Avatar == a.Avatar || b.Handle == a.Handle {
+		b = Generate()
+	}
+	return a, b
+}
+
+func randomIndex(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("pseudonym: failed to read random bytes: " + err.Error())
+	}
+	return int(v.Int64())
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("pseudonym: failed to read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
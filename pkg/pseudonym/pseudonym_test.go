@@ -0,0 +1,20 @@
+package pseudonym
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPairIsDistinct(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		a, b := Pair()
+
+		assert.NotEqual(t, a.Name, b.Name)
+		assert.NotEqual(t, a.Avatar, b.Avatar)
+		assert.NotEqual(t, a.Handle, b.Handle)
+		assert.True(t, strings.HasPrefix(a.Handle, "anon-"))
+		assert.NotEmpty(t, a.Color)
+	}
+}
@@ -0,0 +1,364 @@
+// Package referrals 邀请码和邀请奖励
+//
+// 被邀请人使用邀请码注册时记录邀请关系，并按注册IP和设备识别自己邀请自己，设备ID由客户端上报，只作为IP之外的补充；
+// 被邀请人首次支付完成后双方获得奖励，奖励先冻结一段时间，冻结期内首次支付退款则作废，
+// 冻结期结束后才写入积分流水，因此冻结中的奖励不能消费。
+package referrals
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/credits"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidCode = errors.New("referrals: invalid referral code")
+
+// Config 邀请奖励配置
+type Config struct {
+	ReferrerCredits int           // 邀请人获得的积分
+	InviteeCredits  int           // 被邀请人获得的积分
+	HoldPeriod      time.Duration // 奖励冻结期
+	MaxPerReferrer  int           // 每个邀请人可获得奖励的次数上限，0为不限
+	MinAmount       money.Amount  // 首次支付的最低实付金额，低于该金额的支付不使邀请生效
+	LinkBase        string        // 邀请链接前缀，邀请码拼接在其后，为空时不生成链接
+}
+
+func (c Config) withDefaults() Config {
+	if c.ReferrerCredits < 0 {
+		c.ReferrerCredits = 0
+	}
+	if c.InviteeCredits < 0 {
+		c.InviteeCredits = 0
+	}
+	if c.HoldPeriod <= 0 {
+		c.HoldPeriod = 7 * 24 * time.Hour
+	}
+	if c.MinAmount <= 0 {
+		c.MinAmount = money.Cents(1)
+	}
+	return c
+}
+
+// 邀请码字符集，去掉了容易混淆的0、O、1、I
+const codeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const codeLength = 8
+
+// NormalizeCode 邀请码不区分大小写，统一保存为大写
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CodeFor 获取用户的邀请码，没有时生成
+func CodeFor(db *gorm.DB, userID uint) (*models.ReferralCode, error) {
+	return ensureCode(db, userID, "", "")
+}
+
+// ensureCode 获取或生成用户的邀请码，新生成时记录注册IP和设备
+func ensureCode(db *gorm.DB, userID uint, ip, deviceID string) (*models.ReferralCode, error) {
+	var rc models.ReferralCode
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&rc).Error; err != nil {
+		return nil, err
+	}
+	if rc.UserID != 0 {
+		return &rc, nil
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code := randomCode()
+		var taken int64
+		if err := db.Model(&models.ReferralCode{}).Where("code = ?", code).Count(&taken).Error; err != nil {
+			return nil, err
+		}
+		if taken > 0 {
+			continue
+		}
+		rc = models.ReferralCode{UserID: userID, Code: code, SignupIP: ip, DeviceID: deviceID}
+		if err := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).
+			Create(&rc).Error; err != nil {
+			return nil, err
+		}
+		// 并发生成时以先写入的为准
+		if err := db.Where("user_id = ?", userID).First(&rc).Error; err != nil {
+			return nil, err
+		}
+		return &rc, nil
+	}
+	return nil, errors.New("referrals: failed to generate a unique code")
+}
+
+// Register 为新注册的用户生成邀请码，并在填写了邀请码时记录邀请关系
+// 与邀请人或该邀请人的其他被邀请人注册IP或设备相同的邀请直接标记为拒绝，不影响注册本身；
+// 设备ID可以伪造，不能单独作为依据，注册IP未知的邀请同样拒绝；
+// 邀请码不存在时返回ErrInvalidCode，在注册事务中调用时注册随之回滚
+func Register(tx *gorm.DB, invitee *models.User, code, ip, deviceID string) (*models.Referral, error) {
+	deviceID = strings.TrimSpace(deviceID)
+	if _, err := ensureCode(tx, invitee.ID, ip, deviceID); err != nil {
+		return nil, err
+	}
+	code = NormalizeCode(code)
+	if code == "" {
+		return nil, nil
+	}
+
+	var owner models.ReferralCode
+	err := tx.Where("code = ?", code).First(&owner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner.UserID == invitee.ID {
+		return nil, ErrInvalidCode
+	}
+
+	referral := &models.Referral{
+		ReferrerID: owner.UserID,
+		InviteeID:  invitee.ID,
+		Code:       code,
+		SignupIP:   ip,
+		DeviceID:   deviceID,
+		Status:     models.ReferralPending,
+	}
+	reason, err := suspicious(tx, &owner, ip, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		referral.Status = models.ReferralRejected
+		referral.Reason = reason
+	}
+	if err := tx.Create(referral).Error; err != nil {
+		return nil, err
+	}
+	return referral, nil
+}
+
+// suspicious 检查注册IP和设备是否与邀请人本人或该邀请人的其他被邀请人相同，返回拒绝原因
+// IP必须核验，设备只在上报时额外检查
+func suspicious(tx *gorm.DB, owner *models.ReferralCode, ip, deviceID string) (string, error) {
+	if strings.TrimSpace(ip) == "" {
+		return models.ReferralReasonUnknownIP, nil
+	}
+	checks := []struct {
+		column string
+		value  string
+		own    string
+		reason string
+	}{
+		{"signup_ip", ip, owner.SignupIP, models.ReferralReasonSameIP},
+		{"device_id", deviceID, owner.DeviceID, models.ReferralReasonSameDevice},
+	}
+	for _, check := range checks {
+		if check.value == "" {
+			continue
+		}
+		if check.value == check.own {
+			return check.reason, nil
+		}
+		var count int64
+		if err := tx.Model(&models.Referral{}).
+			Where("referrer_id = ? AND "+check.column+" = ?", owner.UserID, check.value).
+			Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return check.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// Qualify 被邀请人的支付完成时调用，首次实付金额不低于MinAmount的支付使邀请进入冻结期
+// 邀请人获得奖励的次数已达上限时邀请标记为拒绝；在支付履约的事务中执行
+func Qualify(tx *gorm.DB, cfg Config, p *models.Payment, at time.Time) error {
+	cfg = cfg.withDefaults()
+	if p.Amount < cfg.MinAmount {
+		return nil
+	}
+
+	var referral models.Referral
+	if err := tx.Where("invitee_id = ? AND status = ?", p.UserID, models.ReferralPending).
+		Limit(1).Find(&referral).Error; err != nil {
+		return err
+	}
+	if referral.ID == 0 {
+		return nil
+	}
+
+	// 锁定邀请人的邀请码，同一邀请人的多个邀请依次判断上限
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", referral.ReferrerID).First(&models.ReferralCode{}).Error; err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"payment_id": p.ID, "qualified_at": at}
+	if cfg.MaxPerReferrer > 0 {
+		var rewarded int64
+		if err := tx.Model(&models.Referral{}).
+			Where("referrer_id = ? AND status IN ?", referral.ReferrerID, []models.ReferralStatus{models.ReferralHeld, models.ReferralRewarded}).
+			Count(&rewarded).Error; err != nil {
+			return err
+		}
+		if rewarded >= int64(cfg.MaxPerReferrer) {
+			updates["status"] = models.ReferralRejected
+			updates["reason"] = models.ReferralReasonCapReached
+		}
+	}
+	if _, capped := updates["status"]; !capped {
+		updates["status"] = models.ReferralHeld
+		updates["referrer_reward"] = cfg.ReferrerCredits
+		updates["invitee_reward"] = cfg.InviteeCredits
+		updates["available_at"] = at.Add(cfg.HoldPeriod)
+	}
+	return tx.Model(&models.Referral{}).
+		Where("id = ? AND status = ?", referral.ID, models.ReferralPending).
+		Updates(updates).Error
+}
+
+// Cancel 冻结期内首次支付发生退款时作废奖励，在退款的事务中执行
+func Cancel(tx *gorm.DB, paymentID uint) error {
+	return tx.Model(&models.Referral{}).
+		Where("payment_id = ? AND status = ?", paymentID, models.ReferralHeld).
+		Updates(map[string]interface{}{"status": models.ReferralCancelled, "reason": models.ReferralReasonRefunded}).Error
+}
+
+// ReleaseDue 冻结期已结束的奖励写入双方的积分流水，返回入账的邀请数
+func ReleaseDue(db *gorm.DB, at time.Time) (int, error) {
+	var due []models.Referral
+	if err := db.Where("status = ? AND available_at <= ?", models.ReferralHeld, at).
+		Order("id").Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range due {
+		applied, err := release(db, &due[i], at)
+		if err != nil {
+			log.Printf("Failed to release referral reward %d: %v", due[i].ID, err)
+			continue
+		}
+		if applied {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// release 以状态为条件结束冻结并入账，已被作废或已入账的邀请不重复处理
+func release(db *gorm.DB, r *models.Referral, at time.Time) (bool, error) {
+	applied := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Referral{}).Where("id = ? AND status = ?", r.ID, models.ReferralHeld).
+			Updates(map[string]interface{}{"status": models.ReferralRewarded, "rewarded_at": at})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+
+		for _, reward := range []struct {
+			userID uint
+			amount int
+			note   string
+		}{
+			{r.ReferrerID, r.ReferrerReward, fmt.Sprintf("invited user %d", r.InviteeID)},
+			{r.InviteeID, r.InviteeReward, "signed up with referral code " + r.Code},
+		} {
+			if _, err := credits.Apply(tx, credits.Entry{
+				UserID:  reward.userID,
+				Amount:  reward.amount,
+				Reason:  models.CreditReferral,
+				RefType: models.CreditRefReferral,
+				RefID:   r.ID,
+				Note:    reward.note,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Forget 注销账号时删除邀请码，去除邀请记录中的IP和设备，尚未入账的奖励作废
+func Forget(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.ReferralCode{}).Error; err != nil {
+		return err
+	}
+	involved := tx.Model(&models.Referral{}).Unscoped().
+		Where("referrer_id = ? OR invitee_id = ?", userID, userID).Session(&gorm.Session{})
+	if err := involved.
+		Where("status IN ?", []models.ReferralStatus{models.ReferralPending, models.ReferralHeld}).
+		Updates(map[string]interface{}{"status": models.ReferralCancelled, "reason": models.ReferralReasonDeleted}).Error; err != nil {
+		return err
+	}
+	return involved.Where("invitee_id = ?", userID).
+		Updates(map[string]interface{}{"signup_ip": "", "device_id": ""}).Error
+}
+
+// Stats 邀请人的邀请码和邀请统计
+func Stats(db *gorm.DB, cfg Config, userID uint) (*models.ReferralStats, error) {
+	rc, err := CodeFor(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &models.ReferralStats{Code: rc.Code, MaxRewards: cfg.MaxPerReferrer}
+	if cfg.LinkBase != "" {
+		stats.Link = cfg.LinkBase + rc.Code
+	}
+
+	var rows []struct {
+		Status  models.ReferralStatus
+		Count   int64
+		Credits int
+	}
+	if err := db.Model(&models.Referral{}).Where("referrer_id = ?", userID).
+		Select("status, COUNT(*) AS count, COALESCE(SUM(referrer_reward), 0) AS credits").
+		Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		stats.Invited += row.Count
+		switch row.Status {
+		case models.ReferralPending:
+			stats.Pending = row.Count
+		case models.ReferralHeld:
+			stats.Held = row.Count
+			stats.HeldCredits = row.Credits
+		case models.ReferralRewarded:
+			stats.Rewarded = row.Count
+			stats.EarnedCredits = row.Credits
+		case models.ReferralRejected:
+			stats.Rejected = row.Count
+		case models.ReferralCancelled:
+			stats.Cancelled = row.Count
+		}
+	}
+	return stats, nil
+}
+
+func randomCode() string {
+	b := make([]byte, codeLength)
+	for i := range b {
+		v, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			panic("referrals: failed to read random bytes: " + err.Error())
+		}
+		b[i] = codeAlphabet[v.Int64()]
+	}
+	return string(b)
+}
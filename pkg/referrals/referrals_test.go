@@ -0,0 +1,245 @@
+package referrals
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+	"github.com/BinLe1988/multi-agent-chatter/pkg/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// signup 创建用户并按注册流程记录邀请关系
+func signup(t *testing.T, db *gorm.DB, name, code, ip, device string) (*models.User, *models.Referral) {
+	t.Helper()
+	user := &models.User{Username: name, Email: name + "@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	referral, err := Register(db, user, code, ip, device)
+	require.NoError(t, err)
+	return user, referral
+}
+
+var lastPaymentID uint
+
+// paid 已完成的支付，Qualify只用到订单的ID、用户和金额
+func paid(userID uint, amount money.Amount) *models.Payment {
+	lastPaymentID++
+	return &models.Payment{Model: gorm.Model{ID: lastPaymentID}, UserID: userID, Amount: amount}
+}
+
+func TestRegisterRejectsSameIPOrDevice(t *testing.T) {
+	db := testdb.Open(t)
+	referrer, none := signup(t, db, "referrer", "", "1.1.1.1", "device-a")
+	assert.Nil(t, none)
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	assert.Len(t, code.Code, codeLength)
+
+	again, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, code.Code, again.Code)
+
+	_, ok := signup(t, db, "friend", " "+code.Code+" ", "2.2.2.2", "device-b")
+	assert.Equal(t, models.ReferralPending, ok.Status)
+	assert.Equal(t, referrer.ID, ok.ReferrerID)
+
+	_, sameIP := signup(t, db, "alt1", code.Code, "1.1.1.1", "device-c")
+	assert.Equal(t, models.ReferralRejected, sameIP.Status)
+	assert.Equal(t, models.ReferralReasonSameIP, sameIP.Reason)
+
+	_, sameDevice := signup(t, db, "alt2", code.Code, "3.3.3.3", "device-b")
+	assert.Equal(t, models.ReferralRejected, sameDevice.Status)
+	assert.Equal(t, models.ReferralReasonSameDevice, sameDevice.Reason)
+
+	user := &models.User{Username: "typo", Email: "typo@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	_, err = Register(db, user, "NOSUCHCODE", "4.4.4.4", "")
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestRegisterAlwaysChecksSignupIP(t *testing.T) {
+	db := testdb.Open(t)
+	referrer, _ := signup(t, db, "referrer", "", "1.1.1.1", "device-a")
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	_, first := signup(t, db, "first", code.Code, "2.2.2.2", "")
+	assert.Equal(t, models.ReferralPending, first.Status)
+
+	// 伪造的新设备ID不能绕过IP检查，包括与其他被邀请人的IP相同
+	_, spoofed := signup(t, db, "spoofed", code.Code, "1.1.1.1", "device-fresh")
+	assert.Equal(t, models.ReferralRejected, spoofed.Status)
+	assert.Equal(t, models.ReferralReasonSameIP, spoofed.Reason)
+	_, sibling := signup(t, db, "sibling", code.Code, "2.2.2.2", "device-other")
+	assert.Equal(t, models.ReferralReasonSameIP, sibling.Reason)
+
+	// 无法核验IP时只凭设备不能放行
+	_, unknown := signup(t, db, "unknown", code.Code, " ", "device-new")
+	assert.Equal(t, models.ReferralRejected, unknown.Status)
+	assert.Equal(t, models.ReferralReasonUnknownIP, unknown.Reason)
+}
+
+func TestQualifyRequiresMinimumAmount(t *testing.T) {
+	db := testdb.Open(t)
+	cfg := Config{ReferrerCredits: 200, InviteeCredits: 50, HoldPeriod: time.Hour, MinAmount: money.Yuan(10)}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	referrer, _ := signup(t, db, "referrer", "", "1.1.1.1", "")
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	invitee, referral := signup(t, db, "invitee", code.Code, "2.2.2.2", "")
+
+	// 低于最低金额的支付不使邀请生效，之后达到金额的支付仍可以
+	for _, amount := range []money.Amount{money.Cents(10), money.MustParse("9.99")} {
+		require.NoError(t, Qualify(db, cfg, paid(invitee.ID, amount), at))
+		require.NoError(t, db.First(referral, referral.ID).Error)
+		assert.Equal(t, models.ReferralPending, referral.Status, amount.String())
+	}
+	qualifying := paid(invitee.ID, money.MustParse("10.00"))
+	require.NoError(t, Qualify(db, cfg, qualifying, at))
+	require.NoError(t, Qualify(db, cfg, paid(invitee.ID, money.Yuan(100)), at.Add(time.Minute)))
+	require.NoError(t, db.First(referral, referral.ID).Error)
+	assert.Equal(t, models.ReferralHeld, referral.Status)
+	assert.Equal(t, qualifying.ID, referral.PaymentID, "only the first qualifying payment counts")
+	assert.Equal(t, at.Add(time.Hour), referral.AvailableAt.UTC())
+
+	// 未配置时任何实付金额都可以
+	assert.Equal(t, money.Cents(1), Config{}.withDefaults().MinAmount)
+}
+
+func TestHeldRewardCancelledByRefundAndReleasedOnce(t *testing.T) {
+	db := testdb.Open(t)
+	cfg := Config{ReferrerCredits: 200, InviteeCredits: 50, HoldPeriod: time.Hour}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	referrer, _ := signup(t, db, "referrer", "", "1.1.1.1", "")
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	refunded, _ := signup(t, db, "refunded", code.Code, "2.2.2.2", "")
+	kept, _ := signup(t, db, "kept", code.Code, "3.3.3.3", "")
+	refundedPayment := paid(refunded.ID, money.Yuan(10))
+	keptPayment := paid(kept.ID, money.Yuan(10))
+	require.NoError(t, Qualify(db, cfg, refundedPayment, at))
+	require.NoError(t, Qualify(db, cfg, keptPayment, at))
+
+	// 冻结期内退款作废奖励，冻结中的奖励不入账
+	require.NoError(t, Cancel(db, refundedPayment.ID))
+	released, err := ReleaseDue(db, at.Add(time.Hour-time.Second))
+	require.NoError(t, err)
+	assert.Zero(t, released)
+	var rewards int64
+	db.Model(&models.CreditTransaction{}).Where("reason = ?", models.CreditReferral).Count(&rewards)
+	assert.Zero(t, rewards)
+
+	// 冻结期结束时入账，重复执行不会重复发放
+	for i := 0; i < 2; i++ {
+		released, err = ReleaseDue(db, at.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 1-i, released)
+	}
+	db.Model(&models.CreditTransaction{}).Where("reason = ?", models.CreditReferral).Count(&rewards)
+	assert.Equal(t, int64(2), rewards)
+
+	// 入账后的退款不再收回奖励
+	require.NoError(t, Cancel(db, keptPayment.ID))
+	var referrals []models.Referral
+	require.NoError(t, db.Order("id").Find(&referrals).Error)
+	assert.Equal(t, models.ReferralCancelled, referrals[0].Status)
+	assert.Equal(t, models.ReferralReasonRefunded, referrals[0].Reason)
+	assert.Equal(t, models.ReferralRewarded, referrals[1].Status)
+}
+
+func TestQualifyHoldsRewardAndAppliesCap(t *testing.T) {
+	db := testdb.Open(t)
+	cfg := Config{ReferrerCredits: 200, InviteeCredits: 50, HoldPeriod: time.Hour, MaxPerReferrer: 1}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	referrer, _ := signup(t, db, "referrer", "", "1.1.1.1", "")
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	first, _ := signup(t, db, "first", code.Code, "2.2.2.2", "")
+	second, _ := signup(t, db, "second", code.Code, "3.3.3.3", "")
+
+	// 免支付的订单不算首次支付
+	require.NoError(t, Qualify(db, cfg, paid(first.ID, 0), at))
+	require.NoError(t, Qualify(db, cfg, paid(first.ID, money.Yuan(10)), at))
+	require.NoError(t, Qualify(db, cfg, paid(second.ID, money.Yuan(10)), at))
+
+	var referrals []models.Referral
+	require.NoError(t, db.Order("id").Find(&referrals).Error)
+	assert.Equal(t, models.ReferralHeld, referrals[0].Status)
+	assert.Equal(t, 200, referrals[0].ReferrerReward)
+	assert.Equal(t, at.Add(time.Hour), referrals[0].AvailableAt.UTC())
+	assert.Equal(t, models.ReferralRejected, referrals[1].Status)
+	assert.Equal(t, models.ReferralReasonCapReached, referrals[1].Reason)
+
+	released, err := ReleaseDue(db, at.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, released)
+
+	stats, err := Stats(db, Config{MaxPerReferrer: 1, LinkBase: "https://example.com/r/"}, referrer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/r/"+code.Code, stats.Link)
+	assert.Equal(t, int64(2), stats.Invited)
+	assert.Equal(t, int64(1), stats.Held)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.Equal(t, 200, stats.HeldCredits)
+	assert.Zero(t, stats.EarnedCredits)
+
+	released, err = ReleaseDue(db, at.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, released)
+
+	var rewards []models.CreditTransaction
+	require.NoError(t, db.Where("reason = ?", models.CreditReferral).Order("id").Find(&rewards).Error)
+	require.Len(t, rewards, 2)
+	assert.Equal(t, []uint{referrer.ID, first.ID}, []uint{rewards[0].UserID, rewards[1].UserID})
+	assert.Equal(t, []int{200, 50}, []int{rewards[0].Amount, rewards[1].Amount})
+	assert.Equal(t, models.CreditRefReferral, rewards[0].RefType)
+	assert.Equal(t, referrals[0].ID, rewards[0].RefID)
+
+	stats, err = Stats(db, Config{}, referrer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Rewarded)
+	assert.Equal(t, 200, stats.EarnedCredits)
+}
+
+func TestForgetCancelsUnpaidRewards(t *testing.T) {
+	db := testdb.Open(t)
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	referrer, _ := signup(t, db, "referrer", "", "1.1.1.1", "")
+	code, err := CodeFor(db, referrer.ID)
+	require.NoError(t, err)
+	invitee, _ := signup(t, db, "invitee", code.Code, "2.2.2.2", "device-b")
+	p := paid(invitee.ID, money.Yuan(10))
+	require.NoError(t, Qualify(db, Config{ReferrerCredits: 10, InviteeCredits: 10}, p, at))
+
+	require.NoError(t, Forget(db, invitee.ID))
+
+	var referral models.Referral
+	require.NoError(t, db.First(&referral).Error)
+	assert.Equal(t, models.ReferralCancelled, referral.Status)
+	assert.Equal(t, models.ReferralReasonDeleted, referral.Reason)
+	assert.Empty(t, referral.SignupIP)
+	assert.Empty(t, referral.DeviceID)
+
+	var codes int64
+	db.Model(&models.ReferralCode{}).Where("user_id = ?", invitee.ID).Count(&codes)
+	assert.Zero(t, codes)
+
+	released, err := ReleaseDue(db, at.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.Zero(t, released)
+	assert.NoError(t, Cancel(db, p.ID), "cancelling a settled referral is a no-op")
+}
+
+func TestNormalizeCode(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"abcd2345", "ABCD2345"},
+		{"  xyz  ", "XYZ"},
+		{"", ""},
+	} {
+		assert.Equal(t, tc.want, NormalizeCode(tc.in), fmt.Sprintf("%q", tc.in))
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore 基于本地文件系统的BlobStore实现
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStore 创建本地文件存储，root为存储目录，baseURL为对外访问前缀
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if root == "" {
+		return nil, fmt.Errorf("storage root is required")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage root: %v", err)
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// Root 获取存储目录
+func (s *LocalStore) Root() string {
+	return s.root
+}
+
+// Put 实现BlobStore接口，先写临时文件再重命名，避免读到不完整的文件
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write object: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write object: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("failed to store object: %v", err)
+	}
+
+	return s.URL(key), nil
+}
+
+// Get 实现BlobStore接口
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete 实现BlobStore接口
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL 实现BlobStore接口
+func (s *LocalStore) URL(key string) string {
+	return s.baseURL + "/" + strings.TrimLeft(path.Clean("/"+key), "/")
+}
+
+// path 将对象键转换为文件路径，拒绝越出存储目录的键
+func (s *LocalStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir(), "https://cdn.example.com/files/")
+	require.NoError(t, err)
+
+	url, err := store.Put(ctx, "avatars/1/a_64.jpg", strings.NewReader("jpeg"), "image/jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/files/avatars/1/a_64.jpg", url)
+
+	r, err := store.Get(ctx, "avatars/1/a_64.jpg")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", string(data))
+
+	// 覆盖写入不留下临时文件
+	_, err = store.Put(ctx, "avatars/1/a_64.jpg", strings.NewReader("jpeg v2"), "image/jpeg")
+	require.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(store.Root(), "avatars", "1"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, store.Delete(ctx, "avatars/1/a_64.jpg"))
+	require.NoError(t, store.Delete(ctx, "avatars/1/a_64.jpg"), "deleting a missing object is not an error")
+	_, err = store.Get(ctx, "avatars/1/a_64.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStoreKeepsKeysInsideRoot(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	store, err := NewLocalStore(filepath.Join(parent, "root"), "")
+	require.NoError(t, err)
+
+	_, err = store.Put(ctx, "../../escape.txt", strings.NewReader("x"), "text/plain")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(parent, "escape.txt"))
+	assert.True(t, os.IsNotExist(err), "traversal must not leave the root")
+	_, err = os.Stat(filepath.Join(store.Root(), "escape.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/escape.txt", store.URL("../../escape.txt"))
+
+	for _, key := range []string{"", "/", "..", "a/../.."} {
+		_, err := store.Put(ctx, key, strings.NewReader("x"), "text/plain")
+		assert.Error(t, err, "%q", key)
+	}
+
+	_, err = NewLocalStore("", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// BlobStore 二进制对象存储接口，可替换为OSS、S3等实现
+type BlobStore interface {
+	// Put 写入对象，返回可访问的URL
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error)
+	// Get 读取对象
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// URL 获取对象的访问地址
+	URL(key string) string
+}
@@ -0,0 +1,171 @@
+package usage
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+var (
+	ErrInvalidRange = errors.New("usage: invalid date range")
+	ErrInvalidGroup = errors.New("usage: groupBy must be one of day, agent, model, session")
+)
+
+// 查询区间的默认长度和上限，单位为天
+const (
+	defaultDays = 30
+	maxDays     = 366
+)
+
+// 明细的分组方式对应的汇总表字段
+var groupColumns = map[string]string{
+	"day":     "day",
+	"agent":   "agent",
+	"model":   "model",
+	"session": "session_id",
+}
+
+// Report 统计用户在 [from, to] 内的AI消耗明细和积分收支，日期格式为 2006-01-02
+// from 和 to 为空时默认统计截至今天的最近30天；groupBy 为空时按天分组
+func (s *Service) Report(userID uint, from, to, groupBy string) (*models.UsageReport, error) {
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	column, ok := groupColumns[groupBy]
+	if !ok {
+		return nil, ErrInvalidGroup
+	}
+	start, end, err := s.parseRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.UsageReport{
+		From:      start.Format(dayLayout),
+		To:        end.Format(dayLayout),
+		GroupBy:   groupBy,
+		Breakdown: []models.UsageRow{},
+		Flows:     []models.CreditFlow{},
+	}
+
+	var rows []struct {
+		GroupKey         string
+		Messages         int
+		PromptTokens     int
+		CompletionTokens int
+		Credits          int
+	}
+	order := "credits DESC, group_key"
+	if groupBy == "day" {
+		order = "group_key"
+	}
+	if err := s.db.Model(&models.UsageRollup{}).
+		Select(column+" AS group_key, SUM(messages) AS messages, SUM(prompt_tokens) AS prompt_tokens, "+
+			"SUM(completion_tokens) AS completion_tokens, SUM(credits) AS credits").
+		Where("user_id = ? AND day >= ? AND day <= ?", userID, report.From, report.To).
+		Group(column).Order(order).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		report.Breakdown = append(report.Breakdown, models.UsageRow{
+			Key:              row.GroupKey,
+			Messages:         row.Messages,
+			PromptTokens:     row.PromptTokens,
+			CompletionTokens: row.CompletionTokens,
+			Credits:          row.Credits,
+		})
+		report.Messages += row.Messages
+		report.PromptTokens += row.PromptTokens
+		report.CompletionTokens += row.CompletionTokens
+		report.AICredits += row.Credits
+	}
+	if groupBy == "session" {
+		if err := s.labelSessions(report.Breakdown); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Model(&models.CreditRollup{}).
+		Select("reason, SUM(entries) AS entries, SUM(credits) AS credits").
+		Where("user_id = ? AND day >= ? AND day <= ?", userID, report.From, report.To).
+		Group("reason").Order("reason").Scan(&report.Flows).Error; err != nil {
+		return nil, err
+	}
+	for _, flow := range report.Flows {
+		switch flow.Reason {
+		case models.CreditSubscription:
+			report.SubscriptionCredits += flow.Credits
+		case models.CreditRecharge:
+			report.RechargeCredits += flow.Credits
+		}
+	}
+	return report, nil
+}
+
+// parseRange 解析查询区间，默认为截至今天的最近30天，区间不超过366天
+func (s *Service) parseRange(from, to string) (time.Time, time.Time, error) {
+	end := s.dayStart(s.now())
+	if to != "" {
+		parsed, err := time.ParseInLocation(dayLayout, to, s.config.Location)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidRange
+		}
+		end = parsed
+	}
+	start := end.AddDate(0, 0, -(defaultDays - 1))
+	if from != "" {
+		parsed, err := time.ParseInLocation(dayLayout, from, s.config.Location)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidRange
+		}
+		start = parsed
+	}
+	if start.After(end) || start.AddDate(0, 0, maxDays).Before(end) {
+		return time.Time{}, time.Time{}, ErrInvalidRange
+	}
+	return start, end, nil
+}
+
+// labelSessions 以会话标题作为按会话分组的明细的标签，已删除的会话同样显示
+func (s *Service) labelSessions(rows []models.UsageRow) error {
+	ids := make([]uint64, 0, len(rows))
+	for _, row := range rows {
+		if id, err := strconv.ParseUint(row.Key, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	var sessions []models.ChatSession
+	if err := s.db.Unscoped().Select("id", "title").Where("id IN ?", ids).Find(&sessions).Error; err != nil {
+		return err
+	}
+	titles := make(map[string]string, len(sessions))
+	for _, session := range sessions {
+		titles[strconv.FormatUint(uint64(session.ID), 10)] = session.Title
+	}
+	for i := range rows {
+		rows[i].Label = titles[rows[i].Key]
+	}
+	return nil
+}
+
+// WriteCSV 以CSV输出报表，section 列为明细的分组方式或 credits（积分收支，count 为流水条数）
+func WriteCSV(w io.Writer, report *models.UsageReport) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"section", "key", "label", "count", "prompt_tokens", "completion_tokens", "credits"}}
+	for _, row := range report.Breakdown {
+		records = append(records, []string{
+			report.GroupBy, row.Key, row.Label, strconv.Itoa(row.Messages),
+			strconv.Itoa(row.PromptTokens), strconv.Itoa(row.CompletionTokens), strconv.Itoa(row.Credits),
+		})
+	}
+	for _, flow := range report.Flows {
+		records = append(records, []string{
+			"credits", string(flow.Reason), "", strconv.Itoa(flow.Entries), "", "", strconv.Itoa(flow.Credits),
+		})
+	}
+	return cw.WriteAll(records)
+}
@@ -0,0 +1,235 @@
+// Package usage 用量统计
+//
+// AI回复的实际用量和积分流水按天物化为汇总表，查询只读汇总表；
+// 后台任务定期重算最近两天，汇总表为空时从最早的数据开始补算。
+package usage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"gorm.io/gorm"
+)
+
+const dayLayout = "2006-01-02"
+
+// Config 用量统计配置
+type Config struct {
+	Location *time.Location // 按该时区的自然日汇总，默认为本地时区
+	Interval time.Duration  // 后台任务执行间隔
+}
+
+// Service 用量统计服务
+type Service struct {
+	db       *gorm.DB
+	config   Config
+	now      func() time.Time
+	stopChan chan struct{}
+}
+
+// NewService 创建用量统计服务
+func NewService(db *gorm.DB, config Config) *Service {
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Minute
+	}
+	return &Service{
+		db:       db,
+		config:   config,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start 启动汇总任务
+func (s *Service) Start() {
+	go s.loop()
+}
+
+// Stop 停止汇总任务
+func (s *Service) Stop() {
+	close(s.stopChan)
+}
+
+func (s *Service) loop() {
+	s.RunOnce()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// RunOnce 重算上次汇总的前一天至今天的数据，前一天在零点前最后一次汇总之后仍可能有新数据
+func (s *Service) RunOnce() {
+	if err := s.Refresh(); err != nil {
+		log.Printf("Failed to refresh usage rollups: %v", err)
+	}
+}
+
+// Refresh 补算并重算汇总表直到今天
+func (s *Service) Refresh() error {
+	from, ok, err := s.resumeFrom()
+	if err != nil || !ok {
+		return err
+	}
+	today := s.dayStart(s.now())
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if err := s.RollupDay(day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeFrom 汇总的起始日：已有汇总时为最后汇总日的前一天，否则为最早的积分流水或AI回复所在的日期
+func (s *Service) resumeFrom() (time.Time, bool, error) {
+	var last sql.NullString
+	if err := s.db.Model(&models.CreditRollup{}).Select("MAX(day)").Scan(&last).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	if last.Valid && last.String != "" {
+		day, err := time.ParseInLocation(dayLayout, last.String, s.config.Location)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return day.AddDate(0, 0, -1), true, nil
+	}
+
+	var first models.CreditTransaction
+	if err := s.db.Unscoped().Order("created_at").Limit(1).Find(&first).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	var firstReply models.ChatMessage
+	if err := s.db.Unscoped().Where("sender_id = ?", "ai").Order("created_at").Limit(1).Find(&firstReply).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	switch {
+	case first.ID == 0 && firstReply.ID == 0:
+		return time.Time{}, false, nil
+	case first.ID == 0 || (firstReply.ID != 0 && firstReply.CreatedAt.Before(first.CreatedAt)):
+		return s.dayStart(firstReply.CreatedAt), true, nil
+	default:
+		return s.dayStart(first.CreatedAt), true, nil
+	}
+}
+
+// RollupDay 重算 day 所在自然日的汇总，已删除的会话和消息同样计入
+// 已注销并匿名化的账号不再汇总，注销时删除的汇总行不会被重建
+func (s *Service) RollupDay(day time.Time) error {
+	start := s.dayStart(day)
+	end := start.AddDate(0, 0, 1)
+	key := start.Format(dayLayout)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", key).Delete(&models.UsageRollup{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day = ?", key).Delete(&models.CreditRollup{}).Error; err != nil {
+			return err
+		}
+
+		var flows []models.CreditRollup
+		if err := tx.Model(&models.CreditTransaction{}).Unscoped().
+			Select("user_id, reason, COUNT(*) AS entries, SUM(amount) AS credits").
+			Where("created_at >= ? AND created_at < ?", start, end).
+			Where("user_id NOT IN (?)", anonymized(tx)).
+			Group("user_id, reason").Scan(&flows).Error; err != nil {
+			return err
+		}
+		for i := range flows {
+			flows[i].Day = key
+		}
+		if len(flows) > 0 {
+			if err := tx.CreateInBatches(flows, 200).Error; err != nil {
+				return err
+			}
+		}
+
+		rollups, err := aiUsage(tx, start, end)
+		if err != nil {
+			return err
+		}
+		for i := range rollups {
+			rollups[i].Day = key
+		}
+		if len(rollups) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rollups, 200).Error
+	})
+}
+
+// aiUsage 按用户、会话、智能体和模型汇总区间内AI回复元数据中的用量
+func aiUsage(tx *gorm.DB, start, end time.Time) ([]models.UsageRollup, error) {
+	rows, err := tx.Table("chat_messages AS m").
+		Select("s.user_id, m.session_id, m.metadata").
+		Joins("JOIN chat_sessions AS s ON s.id = m.session_id").
+		Where("s.type = ? AND m.sender_id = ? AND m.created_at >= ? AND m.created_at < ?", models.SessionAI, "ai", start, end).
+		Where("s.user_id NOT IN (?)", anonymized(tx)).
+		Order("m.id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type groupKey struct {
+		userID, sessionID uint
+		agent, model      string
+	}
+	index := map[groupKey]int{}
+	var rollups []models.UsageRollup
+	for rows.Next() {
+		var reply struct {
+			UserID    uint
+			SessionID uint
+			Metadata  sql.NullString
+		}
+		if err := tx.ScanRows(rows, &reply); err != nil {
+			return nil, err
+		}
+		// 没有用量元数据的旧回复只计条数
+		var usage models.AIUsage
+		if reply.Metadata.String != "" {
+			_ = json.Unmarshal([]byte(reply.Metadata.String), &usage)
+		}
+
+		k := groupKey{reply.UserID, reply.SessionID, usage.Agent, usage.Model}
+		i, ok := index[k]
+		if !ok {
+			i = len(rollups)
+			index[k] = i
+			rollups = append(rollups, models.UsageRollup{UserID: k.userID, SessionID: k.sessionID, Agent: k.agent, Model: k.model})
+		}
+		rollups[i].Messages++
+		rollups[i].PromptTokens += usage.PromptTokens
+		rollups[i].CompletionTokens += usage.CompletionTokens
+		rollups[i].Credits += usage.Cost
+	}
+	return rollups, rows.Err()
+}
+
+// anonymized 已匿名化账号ID的子查询
+func anonymized(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&models.User{}).
+		Select("id").Where("anonymized_at IS NOT NULL")
+}
+
+// dayStart t 所在自然日的零点
+func (s *Service) dayStart(t time.Time) time.Time {
+	t = t.In(s.config.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.config.Location)
+}
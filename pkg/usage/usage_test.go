@@ -0,0 +1,227 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/internal/testdb"
+	"github.com/BinLe1988/multi-agent-chatter/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var shanghai = time.FixedZone("CST", 8*3600)
+
+func newTestService(t *testing.T, now time.Time) *Service {
+	t.Helper()
+	db := testdb.Open(t)
+	svc := NewService(db, Config{Location: shanghai})
+	svc.now = func() time.Time { return now }
+	return svc
+}
+
+func session(t *testing.T, svc *Service, userID uint, title string) *models.ChatSession {
+	t.Helper()
+	s := &models.ChatSession{UserID: userID, Type: models.SessionAI, Title: title}
+	require.NoError(t, svc.db.Create(s).Error)
+	return s
+}
+
+// reply 写入一条AI回复及其扣费流水
+func reply(t *testing.T, svc *Service, s *models.ChatSession, at time.Time, usage models.AIUsage) {
+	t.Helper()
+	metadata, err := json.Marshal(usage)
+	require.NoError(t, err)
+	require.NoError(t, svc.db.Create(&models.ChatMessage{
+		Model: gorm.Model{CreatedAt: at}, SessionID: s.ID, SenderID: "ai", Content: "hi", Metadata: string(metadata),
+	}).Error)
+	ledger(t, svc, s.UserID, at, -usage.Cost, models.CreditChatUsage)
+}
+
+func ledger(t *testing.T, svc *Service, userID uint, at time.Time, amount int, reason models.CreditReason) {
+	t.Helper()
+	require.NoError(t, svc.db.Create(&models.CreditTransaction{
+		Model: gorm.Model{CreatedAt: at}, UserID: userID, Amount: amount, Reason: reason,
+	}).Error)
+}
+
+func TestRollupAndReportBreakdowns(t *testing.T) {
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, shanghai)
+	svc := newTestService(t, day.Add(72*time.Hour))
+	work := session(t, svc, 1, "工作")
+	fun := session(t, svc, 1, "闲聊")
+	other := session(t, svc, 2, "other")
+
+	// 上海时间3月10日00:30，UTC仍是3月9日，按配置时区计入10日
+	reply(t, svc, work, day.Add(30*time.Minute), models.AIUsage{Model: "gpt-4o", Agent: "coder", PromptTokens: 100, CompletionTokens: 50, Cost: 8})
+	reply(t, svc, work, day.Add(10*time.Hour), models.AIUsage{Model: "gpt-4o", Agent: "coder", PromptTokens: 10, CompletionTokens: 5, Cost: 2})
+	reply(t, svc, fun, day.Add(26*time.Hour), models.AIUsage{Model: "gpt-4o-mini", PromptTokens: 20, CompletionTokens: 20, Cost: 1})
+	reply(t, svc, other, day.Add(time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 99})
+	ledger(t, svc, 1, day.Add(2*time.Hour), 500, models.CreditRecharge)
+	ledger(t, svc, 1, day.Add(25*time.Hour), 1000, models.CreditSubscription)
+	require.NoError(t, svc.db.Delete(fun).Error)
+
+	require.NoError(t, svc.Refresh())
+
+	report, err := svc.Report(1, "2026-03-10", "2026-03-11", "")
+	require.NoError(t, err)
+	assert.Equal(t, "day", report.GroupBy)
+	assert.Equal(t, 3, report.Messages)
+	assert.Equal(t, 11, report.AICredits)
+	assert.Equal(t, 130, report.PromptTokens)
+	assert.Equal(t, 500, report.RechargeCredits)
+	assert.Equal(t, 1000, report.SubscriptionCredits)
+	require.Len(t, report.Breakdown, 2)
+	assert.Equal(t, models.UsageRow{Key: "2026-03-10", Messages: 2, PromptTokens: 110, CompletionTokens: 55, Credits: 10}, report.Breakdown[0])
+	assert.Equal(t, "2026-03-11", report.Breakdown[1].Key)
+
+	report, err = svc.Report(1, "2026-03-10", "2026-03-11", "model")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gpt-4o", "gpt-4o-mini"}, []string{report.Breakdown[0].Key, report.Breakdown[1].Key})
+
+	report, err = svc.Report(1, "2026-03-10", "2026-03-11", "session")
+	require.NoError(t, err)
+	require.Len(t, report.Breakdown, 2)
+	assert.Equal(t, "工作", report.Breakdown[0].Label)
+	assert.Equal(t, "闲聊", report.Breakdown[1].Label, "deleted sessions keep their usage")
+
+	// 只统计区间内的数据
+	report, err = svc.Report(1, "2026-03-11", "2026-03-11", "agent")
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.AICredits)
+	assert.Equal(t, []models.CreditFlow{
+		{Reason: models.CreditChatUsage, Entries: 1, Credits: -1},
+		{Reason: models.CreditSubscription, Entries: 1, Credits: 1000},
+	}, report.Flows)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, report))
+	assert.Equal(t, strings.Join([]string{
+		"section,key,label,count,prompt_tokens,completion_tokens,credits",
+		"agent,,,1,20,20,1",
+		"credits,chat_usage,,1,,,-1",
+		"credits,subscription,,1,,,1000",
+	}, "\n")+"\n", buf.String())
+}
+
+func TestRefreshRecomputesRecentDays(t *testing.T) {
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, shanghai)
+	now := day.Add(23 * time.Hour)
+	svc := newTestService(t, now)
+	svc.now = func() time.Time { return now }
+	s := session(t, svc, 1, "")
+
+	require.NoError(t, svc.Refresh(), "nothing to roll up yet")
+	reply(t, svc, s, day.Add(-48*time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 3})
+	reply(t, svc, s, day.Add(time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 4})
+	require.NoError(t, svc.Refresh())
+
+	report, err := svc.Report(1, "2026-03-01", "2026-03-10", "")
+	require.NoError(t, err)
+	assert.Equal(t, 7, report.AICredits, "backfills from the earliest data")
+
+	// 零点前最后一次汇总之后的数据在次日仍会计入
+	reply(t, svc, s, day.Add(23*time.Hour+50*time.Minute), models.AIUsage{Model: "gpt-4o", Cost: 5})
+	now = day.Add(25 * time.Hour)
+	require.NoError(t, svc.Refresh())
+	require.NoError(t, svc.Refresh())
+
+	report, err = svc.Report(1, "2026-03-01", "2026-03-11", "")
+	require.NoError(t, err)
+	assert.Equal(t, 12, report.AICredits)
+	var rows int64
+	svc.db.Model(&models.UsageRollup{}).Where("day = ?", "2026-03-10").Count(&rows)
+	assert.Equal(t, int64(1), rows, "recomputing a day replaces its rows")
+}
+
+func TestReportValidatesQuery(t *testing.T) {
+	svc := newTestService(t, time.Date(2026, 3, 10, 12, 0, 0, 0, shanghai))
+
+	report, err := svc.Report(1, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2026-02-09", report.From)
+	assert.Equal(t, "2026-03-10", report.To)
+	assert.Empty(t, report.Breakdown)
+
+	for _, tc := range []struct{ from, to, group string }{
+		{"2026-03-10", "2026-03-01", ""},
+		{"2025-01-01", "2026-03-01", ""},
+		{"03/01/2026", "", ""},
+	} {
+		_, err := svc.Report(1, tc.from, tc.to, tc.group)
+		assert.ErrorIs(t, err, ErrInvalidRange, tc.from)
+	}
+	_, err = svc.Report(1, "", "", "week")
+	assert.ErrorIs(t, err, ErrInvalidGroup)
+}
+
+func TestRollupDayBoundaries(t *testing.T) {
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, shanghai)
+	svc := newTestService(t, day.Add(48*time.Hour))
+	s := session(t, svc, 1, "")
+
+	// 零点属于新的一天，零点前一纳秒仍属于前一天
+	reply(t, svc, s, day.Add(-time.Nanosecond), models.AIUsage{Model: "gpt-4o", Cost: 1})
+	reply(t, svc, s, day, models.AIUsage{Model: "gpt-4o", Cost: 2})
+	reply(t, svc, s, day.Add(24*time.Hour-time.Nanosecond), models.AIUsage{Model: "gpt-4o", Cost: 4})
+	reply(t, svc, s, day.Add(24*time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 8})
+
+	// 传入一天中的任意时刻都重算整个自然日，与时间值所在的时区无关
+	require.NoError(t, svc.RollupDay(day.Add(13*time.Hour).UTC()))
+	var rollups []models.UsageRollup
+	require.NoError(t, svc.db.Find(&rollups).Error)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, "2026-03-10", rollups[0].Day)
+	assert.Equal(t, 2, rollups[0].Messages)
+	assert.Equal(t, 6, rollups[0].Credits)
+
+	var flows []models.CreditRollup
+	require.NoError(t, svc.db.Find(&flows).Error)
+	require.Len(t, flows, 1)
+	assert.Equal(t, 2, flows[0].Entries)
+	assert.Equal(t, -6, flows[0].Credits)
+
+	require.NoError(t, svc.Refresh())
+	report, err := svc.Report(1, "2026-03-09", "2026-03-11", "")
+	require.NoError(t, err)
+	require.Len(t, report.Breakdown, 3)
+	for i, want := range []int{1, 6, 8} {
+		assert.Equal(t, want, report.Breakdown[i].Credits, report.Breakdown[i].Key)
+	}
+}
+
+func TestRollupSkipsAnonymizedUsers(t *testing.T) {
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, shanghai)
+	svc := newTestService(t, day.Add(12*time.Hour))
+	users := []models.User{
+		{Username: "deleted_1", Email: "deleted_1@deleted.invalid"},
+		{Username: "bob", Email: "bob@example.com"},
+	}
+	require.NoError(t, svc.db.Create(&users).Error)
+	purged, active := users[0].ID, users[1].ID
+
+	reply(t, svc, session(t, svc, purged, ""), day.Add(time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 3})
+	reply(t, svc, session(t, svc, active, ""), day.Add(time.Hour), models.AIUsage{Model: "gpt-4o", Cost: 5})
+	ledger(t, svc, purged, day.Add(2*time.Hour), -97, models.CreditAccountDeleted)
+
+	// 注销保留积分流水和AI回复，匿名化后重算不再为该账号生成汇总
+	now := day.Add(2 * time.Hour)
+	require.NoError(t, svc.db.Model(&models.User{}).Where("id = ?", purged).Update("anonymized_at", &now).Error)
+	require.NoError(t, svc.db.Delete(&models.User{}, purged).Error)
+	require.NoError(t, svc.Refresh())
+
+	var count int64
+	require.NoError(t, svc.db.Model(&models.UsageRollup{}).Where("user_id = ?", purged).Count(&count).Error)
+	assert.Zero(t, count)
+	require.NoError(t, svc.db.Model(&models.CreditRollup{}).Where("user_id = ?", purged).Count(&count).Error)
+	assert.Zero(t, count)
+
+	report, err := svc.Report(active, "2026-03-10", "2026-03-10", "")
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.AICredits)
+}
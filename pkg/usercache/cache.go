@@ -0,0 +1,109 @@
+package usercache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/BinLe1988/multi-agent-chatter/models"
+)
+
+type entry struct {
+	user   models.User
+	expiry time.Time
+}
+
+// Cache 认证路径使用的用户缓存
+// 缓存的用户只适合读取身份信息，积分等需要最新状态的字段应在事务中重新读取
+type Cache struct {
+	mu         sync.RWMutex
+	entries    map[uint]entry
+	ttl        time.Duration
+	maxEntries int
+}
+
+// New 创建用户缓存
+func New(ttl time.Duration, maxEntries int) *Cache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &Cache{
+		entries:    make(map[uint]entry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// Get 获取缓存的用户
+func (c *Cache) Get(id uint) (models.User, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[id]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(e.expiry) {
+		return models.User{}, false
+	}
+	return e.user, true
+}
+
+// Set 缓存用户
+func (c *Cache) Set(user models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[user.ID] = entry{user: user, expiry: now.Add(c.ttl)}
+}
+
+// Invalidate 使指定用户的缓存失效
+func (c *Cache) Invalidate(ids ...uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		delete(c.entries, id)
+	}
+}
+
+// Flush 清空缓存
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[uint]entry)
+}
+
+// evict 清理过期条目，仍然超出容量时清空，调用方需持有锁
+func (c *Cache) evict(now time.Time) {
+	for id, e := range c.entries {
+		if now.After(e.expiry) {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.entries = make(map[uint]entry)
+	}
+}
+
+// 全局用户缓存
+var (
+	defaultMu    sync.RWMutex
+	defaultCache = New(0, 0)
+)
+
+// Init 初始化全局用户缓存
+func Init(ttl time.Duration, maxEntries int) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultCache = New(ttl, maxEntries)
+}
+
+// Default 获取全局用户缓存
+func Default() *Cache {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultCache
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/BinLe1988/multi-agent-chatter/configs"
@@ -9,14 +11,25 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// 全局JWT密钥
-var jwtSecret string
-var jwtExpiration int
+// 全局JWT密钥集
+var (
+	jwtMu         sync.RWMutex
+	jwtKeys       *KeySet
+	jwtExpiration int
+)
 
 // 初始化JWT配置
-func InitJWT(cfg *configs.Config) {
-	jwtSecret = cfg.JWT.Secret
+func InitJWT(cfg *configs.Config) error {
+	keys, err := LoadKeySet(cfg)
+	if err != nil {
+		return err
+	}
+
+	jwtMu.Lock()
+	defer jwtMu.Unlock()
+	jwtKeys = keys
 	jwtExpiration = cfg.JWT.ExpiresIn
+	return nil
 }
 
 // Claims JWT声明
@@ -27,27 +40,53 @@ type Claims struct {
 
 // GenerateToken 生成JWT令牌
 func GenerateToken(userID uint) (string, error) {
+	jwtMu.RLock()
+	keys, expiration := jwtKeys, jwtExpiration
+	jwtMu.RUnlock()
+	if keys == nil {
+		return "", errors.New("jwt is not initialized")
+	}
+
 	nowTime := time.Now()
-	expireTime := nowTime.Add(time.Duration(jwtExpiration) * time.Hour)
+	expireTime := nowTime.Add(time.Duration(expiration) * time.Hour)
 
 	claims := Claims{
 		UserID: userID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expireTime.Unix(),
+			IssuedAt:  nowTime.Unix(),
 			Issuer:    "multi-agent-chatter",
 		},
 	}
 
-	tokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, err := tokenClaims.SignedString([]byte(jwtSecret))
+	key := keys.signing
+	tokenClaims := jwt.NewWithClaims(key.method, claims)
+	tokenClaims.Header["kid"] = key.ID
+	token, err := tokenClaims.SignedString(key.signKey)
 
 	return token, err
 }
 
 // ParseToken 解析JWT令牌
 func ParseToken(token string) (*Claims, error) {
+	jwtMu.RLock()
+	keys := jwtKeys
+	jwtMu.RUnlock()
+	if keys == nil {
+		return nil, errors.New("jwt is not initialized")
+	}
+
 	tokenClaims, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// 算法必须与密钥声明的一致，防止算法混淆攻击
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
 	})
 
 	if err != nil {
@@ -60,3 +99,14 @@ func ParseToken(token string) (*Claims, error) {
 
 	return nil, errors.New("invalid token")
 }
+
+// JWKS 获取用于验证令牌的公钥集合
+func JWKS() JSONWebKeySet {
+	jwtMu.RLock()
+	keys := jwtKeys
+	jwtMu.RUnlock()
+	if keys == nil {
+		return JSONWebKeySet{Keys: []JSONWebKey{}}
+	}
+	return keys.JWKS()
+}
@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// legacyKeyID 未配置kid的旧版HS256密钥，也用于验证不带kid的旧令牌
+const legacyKeyID = "default"
+
+// minSecretLength HS256密钥的最小长度
+const minSecretLength = 32
+
+// 已知的占位密钥，生产环境禁止使用
+var placeholderSecrets = map[string]bool{
+	"your-secret-key-here":                true,
+	"multi-agent-chatter-secret-key-2024": true,
+	"change-me":                           true,
+	"changeme":                            true,
+	"secret":                              true,
+}
+
+// ErrPlaceholderSecret 使用了占位或过短的密钥
+var ErrPlaceholderSecret = errors.New("jwt secret is a placeholder or too short; configure a real secret or enable dev mode")
+
+// Key JWT签名或验证密钥
+type Key struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	signKey   interface{} // 为空表示仅用于验证
+	verifyKey interface{}
+}
+
+// KeySet JWT密钥集，一个签名密钥和多个验证密钥，用于无感轮换
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// LoadKeySet 根据配置加载密钥集
+func LoadKeySet(cfg *configs.Config) (*KeySet, error) {
+	jwtCfg := cfg.JWT
+	devMode := cfg.Server.DevMode
+	set := &KeySet{keys: make(map[string]*Key)}
+
+	if jwtCfg.Secret != "" {
+		key, err := loadKey(configs.JWTKey{ID: legacyKeyID, Algorithm: "HS256", Secret: jwtCfg.Secret}, devMode)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[key.ID] = key
+	}
+
+	for _, kc := range jwtCfg.Keys {
+		if kc.ID == "" {
+			return nil, errors.New("jwt key id (kid) is required")
+		}
+		if _, exists := set.keys[kc.ID]; exists {
+			return nil, fmt.Errorf("duplicate jwt key id %q", kc.ID)
+		}
+		key, err := loadKey(kc, devMode)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %v", kc.ID, err)
+		}
+		set.keys[key.ID] = key
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("no jwt keys configured")
+	}
+
+	// 选择签名密钥：优先使用配置指定的，其次是唯一可签名的密钥
+	signingID := jwtCfg.SigningKey
+	if signingID == "" {
+		for id, key := range set.keys {
+			if key.signKey == nil {
+				continue
+			}
+			if signingID != "" {
+				return nil, errors.New("multiple jwt keys can sign; set jwt.signing_key")
+			}
+			signingID = id
+		}
+	}
+	signing, ok := set.keys[signingID]
+	if !ok || signing.signKey == nil {
+		return nil, fmt.Errorf("jwt signing key %q not found or has no private key", signingID)
+	}
+	set.signing = signing
+
+	return set, nil
+}
+
+// SigningKeyID 获取当前签名密钥的kid
+func (s *KeySet) SigningKeyID() string {
+	return s.signing.ID
+}
+
+// lookup 根据kid查找验证密钥，不带kid的旧令牌使用旧版密钥
+func (s *KeySet) lookup(kid string) (*Key, bool) {
+	if kid == "" {
+		kid = legacyKeyID
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func loadKey(kc configs.JWTKey, devMode bool) (*Key, error) {
+	key := &Key{ID: kc.ID, Algorithm: kc.Algorithm}
+
+	switch kc.Algorithm {
+	case "HS256":
+		if kc.Secret == "" {
+			return nil, errors.New("secret is required for HS256")
+		}
+		if !devMode && (placeholderSecrets[strings.ToLower(kc.Secret)] || len(kc.Secret) < minSecretLength) {
+			return nil, ErrPlaceholderSecret
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(kc.Secret)
+		key.verifyKey = []byte(kc.Secret)
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		} else {
+			return nil, errors.New("private_key_file or public_key_file is required for RS256")
+		}
+
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("private key is not an Ed25519 key")
+			}
+			key.signKey = edPriv
+			key.verifyKey = edPriv.Public()
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		} else {
+			return nil, errors.New("private_key_file or public_key_file is required for EdDSA")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+
+	return key, nil
+}
+
+// JSONWebKey JWK格式的公钥
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet JWKS
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS 导出非对称密钥的公钥，对称密钥不会导出
+func (s *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range s.keys {
+		var jwk JSONWebKey
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk = JSONWebKey{
+				Kty: "RSA",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			}
+		case ed25519.PublicKey:
+			jwk = JSONWebKey{
+				Kty: "OKP",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			}
+		default:
+			continue
+		}
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Algorithm
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
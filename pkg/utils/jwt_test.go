@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BinLe1988/multi-agent-chatter/configs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func newRSAKeyFile(t *testing.T, dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+}
+
+func newEdKeyFiles(t *testing.T, dir string) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	return writePEM(t, dir, "ed.pem", "PRIVATE KEY", privDER),
+		writePEM(t, dir, "ed.pub.pem", "PUBLIC KEY", pubDER)
+}
+
+func TestPlaceholderSecretRejected(t *testing.T) {
+	cfg := &configs.Config{}
+	cfg.JWT.Secret = "change-me"
+
+	assert.ErrorIs(t, InitJWT(cfg), ErrPlaceholderSecret)
+
+	cfg.JWT.Secret = "short-secret"
+	assert.ErrorIs(t, InitJWT(cfg), ErrPlaceholderSecret)
+
+	// 开发模式允许占位密钥
+	cfg.Server.DevMode = true
+	cfg.JWT.Secret = "change-me"
+	assert.NoError(t, InitJWT(cfg))
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaFile := newRSAKeyFile(t, dir)
+
+	// 旧配置：仅HS256密钥，签发的令牌不带kid
+	oldCfg := &configs.Config{}
+	oldCfg.JWT.Secret = testSecret
+	oldCfg.JWT.ExpiresIn = 1
+	require.NoError(t, InitJWT(oldCfg))
+	oldToken, err := GenerateToken(42)
+	require.NoError(t, err)
+
+	// 轮换：新增RS256签名密钥，保留旧密钥用于验证
+	newCfg := &configs.Config{}
+	newCfg.JWT.Secret = testSecret
+	newCfg.JWT.ExpiresIn = 1
+	newCfg.JWT.SigningKey = "rsa-1"
+	newCfg.JWT.Keys = []configs.JWTKey{{ID: "rsa-1", Algorithm: "RS256", PrivateKeyFile: rsaFile}}
+	require.NoError(t, InitJWT(newCfg))
+
+	newToken, err := GenerateToken(7)
+	require.NoError(t, err)
+
+	claims, err := ParseToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(42), claims.UserID)
+
+	claims, err = ParseToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), claims.UserID)
+
+	// 移除旧密钥后旧令牌失效
+	newCfg.JWT.Secret = ""
+	require.NoError(t, InitJWT(newCfg))
+	_, err = ParseToken(oldToken)
+	assert.Error(t, err)
+	_, err = ParseToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestVerificationOnlyKeyAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaFile := newRSAKeyFile(t, dir)
+	edPriv, edPub := newEdKeyFiles(t, dir)
+
+	// 用EdDSA签发令牌
+	edCfg := &configs.Config{}
+	edCfg.JWT.ExpiresIn = 1
+	edCfg.JWT.Keys = []configs.JWTKey{{ID: "ed-1", Algorithm: "EdDSA", PrivateKeyFile: edPriv}}
+	require.NoError(t, InitJWT(edCfg))
+	edToken, err := GenerateToken(9)
+	require.NoError(t, err)
+
+	// 切换到RS256签名，EdDSA公钥仅用于验证
+	cfg := &configs.Config{}
+	cfg.JWT.Secret = testSecret
+	cfg.JWT.ExpiresIn = 1
+	cfg.JWT.SigningKey = "rsa-2"
+	cfg.JWT.Keys = []configs.JWTKey{
+		{ID: "rsa-2", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+		{ID: "ed-1", Algorithm: "EdDSA", PublicKeyFile: edPub},
+	}
+	require.NoError(t, InitJWT(cfg))
+
+	claims, err := ParseToken(edToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(9), claims.UserID)
+
+	// JWKS只包含非对称公钥
+	jwks := JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "ed-1", jwks.Keys[0].Kid)
+	assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+	assert.Equal(t, "rsa-2", jwks.Keys[1].Kid)
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+
+	// 仅有公钥的密钥不能作为签名密钥
+	cfg.JWT.SigningKey = "ed-1"
+	assert.Error(t, InitJWT(cfg))
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// PATPrefix 个人访问令牌前缀，用于和JWT区分
+const PATPrefix = "mac_pat_"
+
+// GenerateAccessToken 生成个人访问令牌，返回明文、展示用前缀和哈希
+func GenerateAccessToken() (token, prefix, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	token = PATPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	prefix = token[:len(PATPrefix)+6]
+	return token, prefix, HashAccessToken(token), nil
+}
+
+// HashAccessToken 计算令牌哈希，令牌本身已有足够熵，无需加盐慢哈希
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAccessToken 判断是否为个人访问令牌
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, PATPrefix)
+}